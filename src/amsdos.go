@@ -0,0 +1,297 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// amsdos.go - AMSDOS file header handling
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// AMSDOSHeaderSize is the size of the header AMSDOS places at the start of binary and BASIC files
+const AMSDOSHeaderSize = 128
+
+// amsdosChecksumLength is the number of header bytes covered by the checksum (bytes 0-66)
+const amsdosChecksumLength = 67
+
+// HasAMSDOSHeader reports whether data starts with an AMSDOS header with a valid checksum
+// A checksum of zero is not accepted as it also matches a run of zero bytes
+func HasAMSDOSHeader(data []byte) bool {
+	if len(data) < AMSDOSHeaderSize || !amsdosHeaderPlausible(data) {
+		return false
+	}
+	checksum := binary.LittleEndian.Uint16(data[67:69])
+	return checksum != 0 && amsdosChecksum(data) == checksum
+}
+
+// amsdosHeaderPlausible reports whether the header has a user number and a printable,
+// space-padded NAME.EXT as AMSDOS writes them
+func amsdosHeaderPlausible(header []byte) bool {
+	if header[0] > MaxUser || header[1] == ' ' {
+		return false
+	}
+	for _, part := range [][]byte{header[1:9], header[9:12]} {
+		padding := false
+		for _, c := range part {
+			switch {
+			case c < 0x20 || c > 0x7E:
+				return false
+			case c == ' ':
+				padding = true
+			case padding:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// amsdosChecksum returns the 16-bit sum of header bytes 0-66
+func amsdosChecksum(header []byte) uint16 {
+	var sum uint16
+	for _, b := range header[:amsdosChecksumLength] {
+		sum += uint16(b)
+	}
+	return sum
+}
+
+// amsdosFileLength returns the 24-bit file length (excluding the header) stored at bytes 64-66
+func amsdosFileLength(header []byte) int {
+	return int(header[64]) | int(header[65])<<8 | int(header[66])<<16
+}
+
+// AMSDOS file types stored at byte 18 of the header
+const (
+	AMSDOSTypeBASIC          = 0x00
+	AMSDOSTypeProtectedBASIC = 0x01
+	AMSDOSTypeBinary         = 0x02
+)
+
+// BuildAMSDOSHeader creates an AMSDOS header for a file of the given type and length
+func BuildAMSDOSHeader(user uint8, name string, fileType uint8, loadAddress uint16, execAddress uint16, length int) ([]byte, error) {
+	base, ext, err := SplitCPMName(name)
+	if err != nil {
+		return nil, err
+	}
+	if length > 0xFFFFFF {
+		return nil, fmt.Errorf("file too large for AMSDOS header: %d bytes", length)
+	}
+
+	header := make([]byte, AMSDOSHeaderSize)
+	header[0] = user
+	copy(header[1:12], padCPMName(base, ext))
+	header[18] = fileType
+	binary.LittleEndian.PutUint16(header[21:23], loadAddress)
+	header[23] = 0xFF // First block
+	binary.LittleEndian.PutUint16(header[24:26], uint16(length))
+	binary.LittleEndian.PutUint16(header[26:28], execAddress)
+	header[64] = uint8(length)
+	header[65] = uint8(length >> 8)
+	header[66] = uint8(length >> 16)
+	binary.LittleEndian.PutUint16(header[67:69], amsdosChecksum(header))
+	return header, nil
+}
+
+// amsdosTypeNames are the file types held in bits 1-3 of the type byte (bit 0 marks protected files)
+var amsdosTypeNames = []string{"BASIC", "binary", "screen", "ASCII"}
+
+// AMSDOSHeader is a decoded AMSDOS file header
+type AMSDOSHeader struct {
+	User          uint8
+	Name          string // NAME.EXT as stored in the header
+	Type          uint8
+	LoadAddress   uint16
+	ExecAddress   uint16
+	LogicalLength int // 16-bit length at bytes 24-25
+	Length        int // 24-bit length at bytes 64-66
+	Checksum      uint16
+	ChecksumValid bool
+}
+
+// ParseAMSDOSHeader decodes the AMSDOS header at the start of data
+// Headers with a bad checksum are still decoded when the rest of the header looks genuine
+// (a user number, a printable name and an unused area of zeros) so they can be repaired
+func ParseAMSDOSHeader(data []byte) *AMSDOSHeader {
+	if len(data) < AMSDOSHeaderSize {
+		return nil
+	}
+
+	header := &AMSDOSHeader{
+		User:          data[0],
+		Name:          cpmNamePart(data[1:9]),
+		Type:          data[18],
+		LoadAddress:   binary.LittleEndian.Uint16(data[21:23]),
+		LogicalLength: int(binary.LittleEndian.Uint16(data[24:26])),
+		ExecAddress:   binary.LittleEndian.Uint16(data[26:28]),
+		Length:        amsdosFileLength(data),
+		Checksum:      binary.LittleEndian.Uint16(data[67:69]),
+		ChecksumValid: HasAMSDOSHeader(data),
+	}
+	if ext := cpmNamePart(data[9:12]); ext != "" {
+		header.Name += "." + ext
+	}
+	if header.ChecksumValid {
+		return header
+	}
+
+	if !amsdosHeaderPlausible(data) || header.Type>>1&0x07 >= uint8(len(amsdosTypeNames)) {
+		return nil
+	}
+	for _, c := range data[69:AMSDOSHeaderSize] {
+		if c != 0 {
+			return nil
+		}
+	}
+	return header
+}
+
+// Protected reports whether the file is a protected (encrypted) BASIC or binary file
+func (h *AMSDOSHeader) Protected() bool {
+	return h.Type&0x01 != 0
+}
+
+// TypeName returns the name of the file type, e.g. "BASIC" or "protected binary"
+func (h *AMSDOSHeader) TypeName() string {
+	kind := int(h.Type >> 1 & 0x07)
+	if kind >= len(amsdosTypeNames) {
+		return fmt.Sprintf("type &%02X", h.Type)
+	}
+	if h.Protected() {
+		return "protected " + amsdosTypeNames[kind]
+	}
+	return amsdosTypeNames[kind]
+}
+
+// String describes the header in a single line for listings
+func (h *AMSDOSHeader) String() string {
+	description := fmt.Sprintf("AMSDOS %s load &%04X exec &%04X length %d", h.TypeName(), h.LoadAddress, h.ExecAddress, h.Length)
+	if !h.ChecksumValid {
+		description += " (bad checksum)"
+	}
+	return description
+}
+
+// FixAMSDOSHeader corrects the lengths and checksum of the AMSDOS header at the start of data,
+// returning a description of each change made
+// The 24-bit length is reset to the data following the header when it cannot be right - more than
+// is there or less than the last 128-byte record - and the 16-bit length is made to agree with it
+func FixAMSDOSHeader(data []byte) []string {
+	header := ParseAMSDOSHeader(data)
+	if header == nil {
+		return nil
+	}
+
+	changes := make([]string, 0)
+	available := len(data) - AMSDOSHeaderSize
+	length := header.Length
+	if length > available || length < available-127 {
+		length = available
+		if length > 0xFFFFFF {
+			length = 0xFFFFFF
+		}
+		data[64] = uint8(length)
+		data[65] = uint8(length >> 8)
+		data[66] = uint8(length >> 16)
+		changes = append(changes, fmt.Sprintf("length %d -> %d", header.Length, length))
+	}
+	if logical := length & 0xFFFF; length <= 0xFFFF && header.LogicalLength != logical {
+		binary.LittleEndian.PutUint16(data[24:26], uint16(logical))
+		changes = append(changes, fmt.Sprintf("logical length %d -> %d", header.LogicalLength, logical))
+	}
+	if checksum := amsdosChecksum(data); checksum != header.Checksum {
+		binary.LittleEndian.PutUint16(data[67:69], checksum)
+		changes = append(changes, fmt.Sprintf("checksum &%04X -> &%04X", header.Checksum, checksum))
+	}
+	return changes
+}
+
+// isASCIIText reports whether data looks like a text file (printable characters, tabs and line
+// breaks up to a ^Z end-of-file marker), as AMSDOS writes ASCII files without a header
+func isASCIIText(data []byte) bool {
+	if eof := bytes.IndexByte(data, cpmEOF); eof >= 0 {
+		data = data[:eof]
+	}
+	if len(data) == 0 {
+		return false
+	}
+	for _, c := range data {
+		if (c < 0x20 || c > 0x7E) && c != '\t' && c != '\r' && c != '\n' {
+			return false
+		}
+	}
+	return true
+}
+
+// AMSDOSHeaders prints the AMSDOS header of every file matching pattern, fixing bad lengths
+// and checksums on the disk when fix is set
+// Returns the number of files with a header still needing repair
+func (fs *CPMFileSystem) AMSDOSHeaders(pattern string, user int, fix bool) (int, error) {
+	files, err := fs.MatchFiles(pattern, user)
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, fmt.Errorf("no files match '%s'", pattern)
+	}
+
+	broken := 0
+	fmt.Println("User Name         Type                Load  Exec   Length Checksum")
+	for i := range files {
+		file := &files[i]
+		data, err := fs.ReadFile(file)
+		if err != nil {
+			return 0, err
+		}
+
+		header := ParseAMSDOSHeader(data)
+		if header == nil {
+			fmt.Printf("%4d %-12s %s\n", file.User, file.Name, DescribeFileHeader(data))
+			continue
+		}
+		status := "ok"
+		if !header.ChecksumValid {
+			status = "bad"
+		}
+		fmt.Printf("%4d %-12s %-18s &%04X &%04X %8d %s\n", file.User, file.Name, header.TypeName(),
+			header.LoadAddress, header.ExecAddress, header.Length, status)
+
+		changes := FixAMSDOSHeader(data)
+		if len(changes) == 0 {
+			continue
+		}
+		if !fix {
+			fmt.Printf("     needs fixing: %s\n", strings.Join(changes, ", "))
+			broken++
+			continue
+		}
+
+		// The header sits at the start of the first block
+		block, err := fs.ReadBlock(file.Blocks[0])
+		if err != nil {
+			return 0, err
+		}
+		copy(block, data[:AMSDOSHeaderSize])
+		if err := fs.WriteBlock(file.Blocks[0], block); err != nil {
+			return 0, err
+		}
+		fmt.Printf("     fixed: %s\n", strings.Join(changes, ", "))
+	}
+	return broken, nil
+}
+
+// StripAMSDOSHeader removes the AMSDOS header from data, even one with a bad checksum,
+// and trims the data to the length the header records
+func StripAMSDOSHeader(data []byte) ([]byte, error) {
+	header := ParseAMSDOSHeader(data)
+	if header == nil {
+		return nil, fmt.Errorf("no AMSDOS header found")
+	}
+	data = data[AMSDOSHeaderSize:]
+	if header.Length <= len(data) {
+		data = data[:header.Length]
+	}
+	return data, nil
+}
@@ -0,0 +1,193 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// amsdos_test.go - Unit tests for AMSDOS header handling
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func newTestAMSDOSFile(t *testing.T, fileType uint8, length int) []byte {
+	header, err := BuildAMSDOSHeader(0, "GAME.BIN", fileType, 0x4000, 0x4010, length)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return append(header, bytes.Repeat([]byte{0xAA}, length)...)
+}
+
+func TestParseAMSDOSHeader(t *testing.T) {
+	data := newTestAMSDOSFile(t, AMSDOSTypeBinary, 300)
+	header := ParseAMSDOSHeader(data)
+	if header == nil {
+		t.Fatalf("expected a header")
+	}
+	if header.Name != "GAME.BIN" || header.TypeName() != "binary" || header.LoadAddress != 0x4000 ||
+		header.ExecAddress != 0x4010 || header.Length != 300 || header.LogicalLength != 300 || !header.ChecksumValid {
+		t.Errorf("unexpected header %+v", header)
+	}
+
+	// A corrupted length leaves a header with a bad checksum that is still recognised
+	data[64] = 0
+	if header = ParseAMSDOSHeader(data); header == nil || header.ChecksumValid {
+		t.Errorf("expected a header with a bad checksum, got %+v", header)
+	}
+	if description := DescribeFileHeader(data); !strings.Contains(description, "bad checksum") {
+		t.Errorf("expected bad checksum in %q", description)
+	}
+
+	// Data that is not a header
+	if header = ParseAMSDOSHeader(bytes.Repeat([]byte{0xE5}, 256)); header != nil {
+		t.Errorf("expected no header, got %+v", header)
+	}
+	if header = ParseAMSDOSHeader(data[:100]); header != nil {
+		t.Errorf("expected no header for short data, got %+v", header)
+	}
+}
+
+func TestZeroLedFileHasNoAMSDOSHeader(t *testing.T) {
+	// A headerless screen whose top is blank starts with 2048 zero bytes
+	data := make([]byte, 6912)
+	for i := 2048; i < len(data); i++ {
+		data[i] = uint8(i)
+	}
+	original := append([]byte(nil), data...)
+
+	if HasAMSDOSHeader(data) || ParseAMSDOSHeader(data) != nil {
+		t.Errorf("expected zero bytes not to be taken as an AMSDOS header")
+	}
+	if changes := FixAMSDOSHeader(data); len(changes) != 0 || !bytes.Equal(data, original) {
+		t.Errorf("expected no fixes to a headerless file, got %v", changes)
+	}
+	if description := DescribeFileHeader(data); description != "no header" {
+		t.Errorf("expected no header, got %q", description)
+	}
+
+	// A name padded with something other than spaces is not a header either
+	header := newTestAMSDOSFile(t, AMSDOSTypeBinary, 100)
+	header[6] = 0
+	binary.LittleEndian.PutUint16(header[67:69], amsdosChecksum(header))
+	if HasAMSDOSHeader(header) {
+		t.Errorf("expected a header with a zero in the name to be rejected")
+	}
+}
+
+func TestAMSDOSTypeName(t *testing.T) {
+	tests := []struct {
+		fileType uint8
+		expected string
+	}{
+		{0x00, "BASIC"},
+		{0x01, "protected BASIC"},
+		{0x02, "binary"},
+		{0x03, "protected binary"},
+		{0x04, "screen"},
+		{0x16, "ASCII"},
+		{0x0E, "type &0E"},
+	}
+
+	for _, tt := range tests {
+		header := AMSDOSHeader{Type: tt.fileType}
+		if name := header.TypeName(); name != tt.expected {
+			t.Errorf("type %02X: expected %q, got %q", tt.fileType, tt.expected, name)
+		}
+	}
+}
+
+func TestFixAMSDOSHeader(t *testing.T) {
+	// File read from disk, padded to a whole number of records
+	data := newTestAMSDOSFile(t, AMSDOSTypeBinary, 300)
+	data = append(data, bytes.Repeat([]byte{0x1A}, 84)...)
+	if changes := FixAMSDOSHeader(data); len(changes) != 0 {
+		t.Errorf("expected no changes for a valid header, got %v", changes)
+	}
+
+	// Length larger than the file
+	data[65] = 0x10
+	changes := FixAMSDOSHeader(data)
+	if len(changes) != 3 || !strings.HasPrefix(changes[0], "length 4140 -> 384") {
+		t.Errorf("unexpected changes %v", changes)
+	}
+	header := ParseAMSDOSHeader(data)
+	if header.Length != 384 || header.LogicalLength != 384 || !header.ChecksumValid {
+		t.Errorf("unexpected header after fixing %+v", header)
+	}
+
+	// Checksum only
+	data = newTestAMSDOSFile(t, AMSDOSTypeBASIC, 300)
+	data[68] ^= 0xFF
+	if changes = FixAMSDOSHeader(data); len(changes) != 1 || !strings.HasPrefix(changes[0], "checksum") {
+		t.Errorf("unexpected changes %v", changes)
+	}
+	if !HasAMSDOSHeader(data) {
+		t.Errorf("expected a valid header after fixing the checksum")
+	}
+}
+
+func TestStripAMSDOSHeader(t *testing.T) {
+	data := newTestAMSDOSFile(t, AMSDOSTypeBinary, 300)
+	data = append(data, bytes.Repeat([]byte{0x1A}, 84)...)
+	data[67] ^= 0xFF
+
+	stripped, err := StripAMSDOSHeader(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(stripped, bytes.Repeat([]byte{0xAA}, 300)) {
+		t.Errorf("expected 300 bytes of data, got %d", len(stripped))
+	}
+
+	if _, err := StripAMSDOSHeader([]byte("10 PRINT \"HELLO\"\r\n")); err == nil {
+		t.Errorf("expected an error for a file without a header")
+	}
+}
+
+func TestDescribeFileHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"binary", newTestAMSDOSFile(t, AMSDOSTypeBinary, 200), "AMSDOS binary load &4000 exec &4010 length 200"},
+		{"ASCII", []byte("10 PRINT \"HELLO\"\r\n\x1a\x1a\x1a"), "ASCII (no header)"},
+		{"data", []byte{0xC3, 0x00, 0x40, 0x1A}, "no header"},
+	}
+
+	for _, tt := range tests {
+		if description := DescribeFileHeader(tt.data); description != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, description)
+		}
+	}
+}
+
+func TestAMSDOSHeadersFix(t *testing.T) {
+	fs, err := OpenCPMFileSystem(newTestDSK(FormatExtended, 40, 1, 0xC1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := newTestAMSDOSFile(t, AMSDOSTypeBinary, 2000)
+	data[67] ^= 0xFF
+	if err := fs.WriteFile("GAME.BIN", data, PutOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	broken, err := fs.AMSDOSHeaders("*.*", AnyUser, false)
+	if err != nil || broken != 1 {
+		t.Fatalf("expected 1 broken header, got %d (%v)", broken, err)
+	}
+	if _, err := fs.AMSDOSHeaders("*.*", AnyUser, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files, _ := fs.MatchFiles("GAME.BIN", AnyUser)
+	fixed, err := fs.ReadFile(&files[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !HasAMSDOSHeader(fixed) || !bytes.Equal(fixed[AMSDOSHeaderSize:2128], data[AMSDOSHeaderSize:]) {
+		t.Errorf("expected the header fixed and the data unchanged")
+	}
+}
@@ -0,0 +1,161 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// basic.go - BASIC program listings for the basic and unpack commands
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BASIC dialects: Locomotive BASIC on the CPC and Sinclair BASIC on the +3
+const (
+	DialectLocomotive = "locomotive"
+	DialectPlus3      = "plus3"
+)
+
+// IsBASICFile reports whether a file has a header marking it as a BASIC program
+func IsBASICFile(data []byte) bool {
+	if header := ParsePlus3DOSHeader(data); header != nil {
+		return header.Type == Plus3TypeProgram
+	}
+	if header := ParseAMSDOSHeader(data); header != nil {
+		return header.Type>>1&0x07 == AMSDOSTypeBASIC
+	}
+	return false
+}
+
+// BASICDialect returns the dialect of a BASIC program file from its header,
+// assuming Locomotive BASIC for files without a +3DOS header
+func BASICDialect(data []byte) string {
+	if ParsePlus3DOSHeader(data) != nil {
+		return DialectPlus3
+	}
+	return DialectLocomotive
+}
+
+// BASICListing converts a BASIC program file to a text listing
+// The dialect is taken from the file header when empty
+func BASICListing(data []byte, dialect string) (string, error) {
+	if dialect == "" {
+		dialect = BASICDialect(data)
+	}
+	if dialect == DialectPlus3 {
+		program, err := Plus3BASICProgram(data)
+		if err != nil {
+			return "", err
+		}
+		return DetokenizePlus3BASIC(program)
+	}
+
+	program, err := LocomotiveBASICProgram(data)
+	if err != nil {
+		return "", err
+	}
+	return DetokenizeLocomotiveBASIC(program)
+}
+
+// TokenizeBASIC converts a text listing to a BASIC program file, with a header unless
+// headerless is set. +3 programs start automatically at line when it is not zero
+func TokenizeBASIC(listing string, dialect string, name string, line int, headerless bool) ([]byte, error) {
+	if dialect == DialectPlus3 {
+		program, err := TokenizePlus3BASIC(listing)
+		if err != nil || headerless {
+			return program, err
+		}
+		autostart := uint16(Plus3NoAutostart)
+		if line > 0 {
+			autostart = uint16(line)
+		}
+		header, err := BuildPlus3DOSHeader(Plus3TypeProgram, len(program), autostart, uint16(len(program)))
+		if err != nil {
+			return nil, err
+		}
+		return append(header, program...), nil
+	}
+
+	program, err := TokenizeLocomotiveBASIC(listing)
+	if err != nil {
+		return nil, err
+	}
+	if headerless {
+		return program, nil
+	}
+	header, err := BuildAMSDOSHeader(0, name, AMSDOSTypeBASIC, LocomotiveBASICStart, 0, len(program))
+	if err != nil {
+		return nil, err
+	}
+	return append(header, program...), nil
+}
+
+// basicListingName returns the host filename for the listing of a BASIC file,
+// replacing a .BAS extension (or adding one) with .bas
+func basicListingName(name string) string {
+	if ext := filepath.Ext(name); ext == "" || strings.EqualFold(ext, ".BAS") {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name + ".bas"
+}
+
+// BASICListings converts every BASIC program matching pattern to a text listing
+// Files without a BASIC header are skipped, those that fail to convert are reported as
+// failures so the other programs are still listed
+func (fs *CPMFileSystem) BASICListings(pattern string, user int) ([]CPMFile, []string, []string, error) {
+	files, err := fs.MatchFiles(pattern, user)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	programs := make([]CPMFile, 0)
+	listings := make([]string, 0)
+	failures := make([]string, 0)
+	for i := range files {
+		data, err := fs.ReadFile(&files[i])
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", files[i].Name, err))
+			continue
+		}
+		if !IsBASICFile(data) {
+			continue
+		}
+		listing, err := BASICListing(data, "")
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", files[i].Name, err))
+			continue
+		}
+		programs = append(programs, files[i])
+		listings = append(listings, listing)
+	}
+	return programs, listings, failures, nil
+}
+
+// WriteBASICListings writes a .bas listing of every BASIC program on the disk to outputDir
+// Listings are placed in user-N subdirectories when programs come from more than one user area
+func (fs *CPMFileSystem) WriteBASICListings(pattern string, user int, outputDir string) ([]CPMFile, []string, error) {
+	programs, listings, failures, err := fs.BASICListings(pattern, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	users := make(map[uint8]bool)
+	for _, program := range programs {
+		users[program.User] = true
+	}
+
+	for i, program := range programs {
+		dir := outputDir
+		if len(users) > 1 {
+			dir = filepath.Join(outputDir, fmt.Sprintf("user-%d", program.User))
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, nil, fmt.Errorf("failed to create output directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, basicListingName(hostFileName(program.Name))), []byte(listings[i]), 0644); err != nil {
+			return nil, nil, fmt.Errorf("failed to write listing of %s: %v", program.Name, err)
+		}
+	}
+	return programs, failures, nil
+}
@@ -0,0 +1,68 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// basic_test.go - Unit tests for BASIC program listings
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBASICListingName(t *testing.T) {
+	tests := map[string]string{"DISC.BAS": "DISC.bas", "LOADER": "LOADER.bas", "GAME.BIN": "GAME.BIN.bas"}
+	for name, expected := range tests {
+		if result := basicListingName(name); result != expected {
+			t.Errorf("%s: expected %s, got %s", name, expected, result)
+		}
+	}
+}
+
+func TestWriteBASICListings(t *testing.T) {
+	fs, err := OpenCPMFileSystem(newTestDSK(FormatExtended, 40, 1, 0xC1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	program, err := TokenizeBASIC("10 PRINT \"DISC\"\n20 RUN \"GAME\"\n", DialectLocomotive, "DISC.BAS", 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := fs.WriteFile("DISC.BAS", program, PutOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	code, _ := AddFileHeader([]byte{0xC9}, "GAME.BIN", 0, HeaderOptions{Kind: "amsdos", Type: "binary", Line: -1})
+	if err := fs.WriteFile("GAME.BIN", code, PutOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A broken program is reported without stopping the others and zero-led data is not BASIC
+	broken := []byte{0x02, 0x00, 0x0A, 0x00}
+	header, _ := BuildAMSDOSHeader(0, "BAD.BAS", AMSDOSTypeBASIC, LocomotiveBASICStart, 0, len(broken))
+	if err := fs.WriteFile("BAD.BAS", append(header, broken...), PutOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := fs.WriteFile("ZEROS.BIN", make([]byte, 1024), PutOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dir := t.TempDir()
+	programs, failures, err := fs.WriteBASICListings("*.*", AnyUser, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(programs) != 1 || programs[0].Name != "DISC.BAS" {
+		t.Fatalf("expected only DISC.BAS to be listed, got %v", programs)
+	}
+	if len(failures) != 1 || !strings.HasPrefix(failures[0], "BAD.BAS: ") {
+		t.Errorf("expected BAD.BAS to be reported, got %v", failures)
+	}
+	listing, err := os.ReadFile(filepath.Join(dir, "DISC.bas"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(listing) != "10 PRINT \"DISC\"\n20 RUN \"GAME\"\n" {
+		t.Errorf("unexpected listing %q", listing)
+	}
+}
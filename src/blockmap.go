@@ -0,0 +1,151 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// blockmap.go - Block allocation map and free space of the CP/M filesystem
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+)
+
+// BlockUsage describes what an allocation block is used for
+type BlockUsage int
+
+const (
+	BlockFree BlockUsage = iota
+	BlockDirectory
+	BlockFile
+)
+
+// BlockInfo describes a single allocation block
+type BlockInfo struct {
+	Usage BlockUsage
+	File  int  // Index into the files of the map when Usage is BlockFile
+	Bad   bool // Lies on a missing sector or one read with FDC errors
+}
+
+// BlockMap is the allocation state of every block on the disk
+type BlockMap struct {
+	Format *DiskFormat
+	Files  []CPMFile
+	Blocks []BlockInfo
+}
+
+// blockMapFileSymbols are the characters used to mark each file's blocks in the printed map
+// (D and X are left out, in both cases, as they mark the directory and bad sectors)
+const blockMapFileSymbols = "ABCEFGHIJKLMNOPQRSTUVWYZabcefghijklmnopqrstuvwyz0123456789"
+
+// blockMapColumns is the number of blocks printed on each row of the map
+const blockMapColumns = 32
+
+// AllocationMap decodes the directory and works out the usage of every block
+// Blocks claimed by more than one file are attributed to the first
+func (fs *CPMFileSystem) AllocationMap() (*BlockMap, error) {
+	files, err := fs.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	blockMap := &BlockMap{
+		Format: fs.Format,
+		Files:  files,
+		Blocks: make([]BlockInfo, fs.Format.TotalBlocks()),
+	}
+	for block := range blockMap.Blocks {
+		if block < fs.Format.DirectoryBlocks() {
+			blockMap.Blocks[block].Usage = BlockDirectory
+		}
+		blockMap.Blocks[block].Bad = len(fs.checkBlockSectors("", block)) > 0
+	}
+	for i, file := range files {
+		for _, block := range file.Blocks {
+			if block < len(blockMap.Blocks) && blockMap.Blocks[block].Usage == BlockFree {
+				blockMap.Blocks[block].Usage = BlockFile
+				blockMap.Blocks[block].File = i
+			}
+		}
+	}
+	return blockMap, nil
+}
+
+// count returns the number of blocks with the given usage, optionally only the bad ones
+func (m *BlockMap) count(usage BlockUsage, badOnly bool) int {
+	count := 0
+	for _, block := range m.Blocks {
+		if block.Usage == usage && (block.Bad || !badOnly) {
+			count++
+		}
+	}
+	return count
+}
+
+// TotalKB returns the space available to files in kilobytes (excluding the directory)
+func (m *BlockMap) TotalKB() int {
+	return (len(m.Blocks) - m.count(BlockDirectory, false)) * m.Format.BlockSize / 1024
+}
+
+// UsedKB returns the space taken by files in kilobytes
+func (m *BlockMap) UsedKB() int {
+	return m.count(BlockFile, false) * m.Format.BlockSize / 1024
+}
+
+// FreeKB returns the unallocated space in kilobytes, as reported by CAT and STAT
+func (m *BlockMap) FreeKB() int {
+	return m.count(BlockFree, false) * m.Format.BlockSize / 1024
+}
+
+// symbol returns the character used to show a block in the printed map
+func (m *BlockMap) symbol(block BlockInfo) byte {
+	switch {
+	case block.Bad:
+		return 'X'
+	case block.Usage == BlockDirectory:
+		return 'D'
+	case block.Usage == BlockFile && block.File < len(blockMapFileSymbols):
+		return blockMapFileSymbols[block.File]
+	case block.Usage == BlockFile:
+		return '+'
+	default:
+		return '.'
+	}
+}
+
+// Print writes the map, a key to the files and the space summary to the console
+func (m *BlockMap) Print() {
+	fmt.Printf("Format: %s (%d blocks of %d bytes, %d directory entries)\n",
+		m.Format.Name, len(m.Blocks), m.Format.BlockSize, m.Format.DirEntries)
+	fmt.Println("Block map (D = directory, . = free, X = bad sector, letters = files)")
+	for row := 0; row < len(m.Blocks); row += blockMapColumns {
+		line := make([]byte, 0, blockMapColumns)
+		for block := row; block < row+blockMapColumns && block < len(m.Blocks); block++ {
+			line = append(line, m.symbol(m.Blocks[block]))
+		}
+		fmt.Printf("%4d %s\n", row, line)
+	}
+
+	if len(m.Files) > 0 {
+		fmt.Println("Files:")
+	}
+	for i, file := range m.Files {
+		symbol := byte('+')
+		if i < len(blockMapFileSymbols) {
+			symbol = blockMapFileSymbols[i]
+		}
+		bad := 0
+		for _, block := range file.Blocks {
+			if block < len(m.Blocks) && m.Blocks[block].Bad {
+				bad++
+			}
+		}
+		fmt.Printf("  %c %2d:%-12s %4d block(s)", symbol, file.User, file.Name, len(file.Blocks))
+		if bad > 0 {
+			fmt.Printf(", %d on bad sectors", bad)
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("Total %dK, used %dK, free %dK\n", m.TotalKB(), m.UsedKB(), m.FreeKB())
+	if bad := m.count(BlockFree, true); bad > 0 {
+		fmt.Printf("%dK of the free space is on bad sectors\n", bad*m.Format.BlockSize/1024)
+	}
+}
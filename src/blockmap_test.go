@@ -0,0 +1,48 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// blockmap_test.go - Unit tests for the block allocation map
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"testing"
+)
+
+func TestAllocationMap(t *testing.T) {
+	fs, err := OpenCPMFileSystem(newTestDSK(FormatExtended, 40, 1, 0xC1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeTestDirEntries(t, fs,
+		newTestDirEntry(0, "GAME", "BIN", 0, 24, 2, 3, 4),
+		newTestDirEntry(1, "LOADER", "BAS", 0, 8, 5),
+		newTestDirEntry(DeletedUser, "GONE", "BIN", 0, 8, 6),
+	)
+
+	// Block 10 is free but its first sector was read with a data error
+	sector, err := fs.sector(fs.blockSectors(10)[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sector.Info.FDCStatus1 = 0x20
+
+	blockMap, err := fs.AllocationMap()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "DDAAAB....X"
+	for i := range expected {
+		if got := blockMap.symbol(blockMap.Blocks[i]); got != expected[i] {
+			t.Errorf("block %d: expected %c, got %c", i, expected[i], got)
+		}
+	}
+
+	if blockMap.TotalKB() != 178 || blockMap.UsedKB() != 4 || blockMap.FreeKB() != 174 {
+		t.Errorf("expected 178K total, 4K used and 174K free, got %dK, %dK and %dK",
+			blockMap.TotalKB(), blockMap.UsedKB(), blockMap.FreeKB())
+	}
+	if bad := blockMap.count(BlockFree, true); bad != 1 {
+		t.Errorf("expected 1 bad free block, got %d", bad)
+	}
+}
@@ -0,0 +1,121 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// boot.go - Boot sector checksum and boot code installation
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BootCodeOffset is where boot code starts in the boot sector (immediately after the specification block)
+// The +3 loads the boot sector to FE00h and jumps to FE10h
+const BootCodeOffset = SpecificationLength
+
+// bootChecksumOffset is the byte within the specification block adjusted to make the sector sum correct
+const bootChecksumOffset = 15
+
+// BootTargets maps command line target names to the 8-bit sector sum that machine requires
+var BootTargets = map[string]uint8{
+	"plus3":   BootSumPlus3,
+	"pcw9512": BootSumPCW9512,
+	"pcw8256": BootSumPCW8256,
+}
+
+// ParseBootTarget converts a target machine name into the required sector sum
+func ParseBootTarget(name string) (uint8, error) {
+	sum, ok := BootTargets[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("invalid boot target '%s'. Must be one of: plus3, pcw9512, pcw8256", name)
+	}
+	return sum, nil
+}
+
+// BootStatus describes the boot sector of a disk
+type BootStatus struct {
+	SectorID         uint8  // ID of the boot sector (lowest ID on track 0 side 0)
+	HasSpecification bool   // Whether a valid specification block was found
+	Sum              uint8  // 8-bit sum of every byte in the sector
+	BootType         string // Machine that would boot this disk, empty if none
+}
+
+// BootStatus reports whether the disk would boot on a +3 or PCW
+func (d *DSK) BootStatus() (*BootStatus, error) {
+	sector := d.bootSector()
+	if sector == nil {
+		return nil, fmt.Errorf("no boot sector found on track 0 side 0")
+	}
+
+	sum := SectorSum(sector.Data)
+	return &BootStatus{
+		SectorID:         sector.Info.R,
+		HasSpecification: d.Specification != nil,
+		Sum:              sum,
+		BootType:         bootTypeForSum(sum),
+	}, nil
+}
+
+// writableBootSector returns the boot sector if it can hold a +3/PCW specification block and boot code
+func (d *DSK) writableBootSector() (*LogicalSector, error) {
+	sector := d.bootSector()
+	if sector == nil {
+		return nil, fmt.Errorf("no boot sector found on track 0 side 0")
+	}
+	switch sector.Info.R {
+	case 0x41:
+		return nil, fmt.Errorf("CPC SYSTEM disks boot via |CPM and have no boot checksum")
+	case 0xC1:
+		return nil, fmt.Errorf("CPC DATA disks are not bootable")
+	}
+	if d.Specification == nil {
+		return nil, fmt.Errorf("boot sector has no specification block: %s", d.specificationMissingReason())
+	}
+	if len(sector.Data) <= BootCodeOffset {
+		return nil, fmt.Errorf("boot sector too small: %d bytes", len(sector.Data))
+	}
+	return sector, nil
+}
+
+// FixBootChecksum adjusts the checksum byte of the specification block so the boot sector sums to target
+func (d *DSK) FixBootChecksum(target uint8) error {
+	sector, err := d.writableBootSector()
+	if err != nil {
+		return err
+	}
+
+	fixBootChecksum(sector.Data, target)
+	d.Specification = d.readSpecification()
+	return nil
+}
+
+// InstallBootCode copies boot code into the boot sector after the specification block
+// and then fixes the checksum so the sector sums to target
+func (d *DSK) InstallBootCode(code []byte, target uint8) error {
+	sector, err := d.writableBootSector()
+	if err != nil {
+		return err
+	}
+
+	maxCode := len(sector.Data) - BootCodeOffset
+	if len(code) > maxCode {
+		return fmt.Errorf("boot code too large: %d bytes (maximum %d)", len(code), maxCode)
+	}
+
+	// Clear the remainder of the sector so no stale code is left behind
+	bootArea := sector.Data[BootCodeOffset:]
+	for i := range bootArea {
+		bootArea[i] = 0
+	}
+	copy(bootArea, code)
+
+	fixBootChecksum(sector.Data, target)
+	d.Specification = d.readSpecification()
+	return nil
+}
+
+// fixBootChecksum sets the checksum byte so the 8-bit sum of the sector equals target
+func fixBootChecksum(sector []byte, target uint8) {
+	sector[bootChecksumOffset] = 0
+	sector[bootChecksumOffset] = target - SectorSum(sector)
+}
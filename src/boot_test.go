@@ -0,0 +1,66 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// boot_test.go - Unit tests for boot sector checksum tooling
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// newPlus3TestDSK builds a blank +3 180K disk with a specification block in track 0 sector 1
+func newPlus3TestDSK() *DSK {
+	dsk := newTestDSK(FormatExtended, 40, 1, 0x01)
+	copy(dsk.bootSector().Data, []byte{0, 0, 40, 9, 2, 1, 3, 2, 0x2A, 0x52, 0, 0, 0, 0, 0, 0})
+	dsk.Specification = dsk.readSpecification()
+	return dsk
+}
+
+func TestInstallBootCode(t *testing.T) {
+	dsk := newPlus3TestDSK()
+	code := []byte{0xF3, 0x31, 0x00, 0x80, 0xC9}
+
+	if err := dsk.InstallBootCode(code, BootSumPlus3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sector := dsk.bootSector().Data
+	if !bytes.Equal(sector[BootCodeOffset:BootCodeOffset+len(code)], code) {
+		t.Errorf("boot code not installed at offset %d", BootCodeOffset)
+	}
+	if sector[2] != 40 || sector[3] != 9 {
+		t.Errorf("specification bytes were not preserved")
+	}
+	if sum := SectorSum(sector); sum != BootSumPlus3 {
+		t.Errorf("sector sum: expected %02X, got %02X", BootSumPlus3, sum)
+	}
+
+	status, err := dsk.BootStatus()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.BootType != "Spectrum +3" {
+		t.Errorf("BootType: expected Spectrum +3, got %q", status.BootType)
+	}
+
+	if err := dsk.InstallBootCode(make([]byte, 500), BootSumPlus3); err == nil {
+		t.Errorf("expected error for oversized boot code")
+	}
+}
+
+func TestFixBootChecksum(t *testing.T) {
+	dsk := newPlus3TestDSK()
+	if err := dsk.FixBootChecksum(BootSumPCW8256); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum := SectorSum(dsk.bootSector().Data); sum != BootSumPCW8256 {
+		t.Errorf("sector sum: expected %02X, got %02X", BootSumPCW8256, sum)
+	}
+
+	cpc := newTestDSK(FormatExtended, 40, 1, 0xC1)
+	if err := cpc.FixBootChecksum(BootSumPlus3); err == nil || !strings.Contains(err.Error(), "CPC DATA") {
+		t.Errorf("expected CPC DATA error, got %v", err)
+	}
+}
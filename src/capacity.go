@@ -0,0 +1,172 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// capacity.go - Physical track capacity and overlong track analysis
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Track capacity statuses, worst last
+const (
+	CapacityOK         = "ok"
+	CapacityGap        = "unusual gap"
+	CapacityOverlap    = "overlapping"
+	CapacityImpossible = "impossible"
+)
+
+// trackLayout is the number of raw bytes the uPD765 writes around the sectors of a track
+type trackLayout struct {
+	Preamble int // GAP4a, sync, index address mark and GAP1
+	IDField  int // Sync, ID address mark, C H R N, CRC and GAP2
+	Data     int // Sync, data address mark and CRC around the sector data
+}
+
+var (
+	mfmLayout = trackLayout{Preamble: 80 + 12 + 4 + 50, IDField: 12 + 4 + 4 + 2 + 22, Data: 12 + 4 + 2}
+	fmLayout  = trackLayout{Preamble: 40 + 6 + 1 + 26, IDField: 6 + 1 + 4 + 2 + 11, Data: 6 + 1 + 2}
+)
+
+// layout returns the raw byte layout for the track's recording mode (MFM when unknown)
+func (h TrackHeader) layout() trackLayout {
+	if h.RecordingMode == RecordingModeFM {
+		return fmLayout
+	}
+	return mfmLayout
+}
+
+// TrackCapacity is the estimated raw size of a track compared with what one revolution holds
+type TrackCapacity struct {
+	Cylinder int
+	Head     int
+	Sectors  int
+	Gap3     int
+	Used     int      // Raw bytes with the track's GAP3 after every sector
+	Minimum  int      // Raw bytes with no GAP3 at all
+	Capacity int      // Raw bytes in one revolution at the track's data rate and recording mode
+	Status   string   // CapacityOK, CapacityGap, CapacityOverlap or CapacityImpossible
+	Notes    []string // Why the track is not ok
+}
+
+// Free returns how many raw bytes are left over, negative when the track is overlong
+func (c *TrackCapacity) Free() int {
+	return c.Capacity - c.Used
+}
+
+// AnalyzeCapacity estimates the raw size of every formatted track at 300 RPM and flags tracks
+// that cannot physically exist, rely on overlapping sectors or use the gaps unusually
+func AnalyzeCapacity(d *DSK) []TrackCapacity {
+	results := make([]TrackCapacity, 0, len(d.Tracks))
+	for i := range d.Tracks {
+		track := &d.Tracks[i]
+		if len(track.Sectors) == 0 {
+			continue
+		}
+		results = append(results, analyzeTrackCapacity(track))
+	}
+	return results
+}
+
+// analyzeTrackCapacity adds up the raw bytes of a track's sectors and decides its status
+func analyzeTrackCapacity(track *LogicalTrack) TrackCapacity {
+	layout := track.Header.layout()
+	gap3 := int(track.Header.Gap3Length)
+	result := TrackCapacity{
+		Cylinder: int(track.Header.TrackNum),
+		Head:     int(track.Header.SideNum),
+		Sectors:  len(track.Sectors),
+		Gap3:     gap3,
+		Capacity: track.Header.RawCapacity(),
+		Minimum:  layout.Preamble,
+	}
+
+	overlaps := make([]string, 0)
+	gaps := make([]string, 0)
+	for s := range track.Sectors {
+		sector := &track.Sectors[s]
+		result.Minimum += layout.IDField
+		switch kind := sector.Kind(); kind {
+		case SectorIDOnly:
+			continue
+		case SectorOversized:
+			// Only what fits before the index hole was read, the rest runs over the other sectors
+			result.Minimum += layout.Data + len(sector.Data)
+			overlaps = append(overlaps, fmt.Sprintf("sector #%02X (N=%d) is %d bytes, more than a track holds",
+				sector.Info.R, sector.Info.N, sector.Info.NaturalSize()))
+			continue
+		case SectorGap:
+			// Bytes read past the CRC and GAP3 reach into the next sector's ID field
+			extra := len(sector.Data) - sector.Info.NaturalSize()
+			if s < len(track.Sectors)-1 && extra > 2+gap3 {
+				overlaps = append(overlaps, fmt.Sprintf("sector #%02X reads %d bytes past its end, into sector #%02X",
+					sector.Info.R, extra, track.Sectors[s+1].Info.R))
+			} else {
+				gaps = append(gaps, fmt.Sprintf("sector #%02X keeps %d gap bytes", sector.Info.R, extra))
+			}
+		}
+		result.Minimum += layout.Data + sector.Info.NaturalSize()
+	}
+	result.Used = result.Minimum + gap3*len(track.Sectors)
+
+	switch {
+	case len(overlaps) > 0:
+		result.Status = CapacityOverlap
+		result.Notes = append(overlaps, gaps...)
+	case result.Minimum > result.Capacity:
+		result.Status = CapacityImpossible
+		result.Notes = []string{fmt.Sprintf("needs at least %d raw bytes without any GAP3, one revolution holds %d", result.Minimum, result.Capacity)}
+	case result.Used > result.Capacity:
+		result.Status = CapacityGap
+		largest := (result.Capacity - result.Minimum) / len(track.Sectors)
+		result.Notes = append([]string{fmt.Sprintf("GAP3 of %d does not fit, at most %d does", gap3, largest)}, gaps...)
+	case len(gaps) > 0:
+		result.Status = CapacityGap
+		result.Notes = gaps
+	default:
+		result.Status = CapacityOK
+	}
+	return result
+}
+
+// PrintCapacity writes the capacity of each track and a verdict to the console
+func PrintCapacity(results []TrackCapacity) {
+	fmt.Printf("%5s %4s %7s %4s %5s %5s %8s %5s  %s\n", "Track", "Side", "Sectors", "Gap3", "Used", "Min", "Capacity", "Free", "Status")
+	counts := make(map[string]int)
+	for i := range results {
+		c := &results[i]
+		counts[c.Status]++
+		fmt.Printf("   %02d %4d %7d %4d %5d %5d %8d %5d  %s\n",
+			c.Cylinder, c.Head, c.Sectors, c.Gap3, c.Used, c.Minimum, c.Capacity, c.Free(), c.Status)
+		for _, note := range c.Notes {
+			fmt.Printf("        ! %s\n", note)
+		}
+	}
+
+	fmt.Println("--------------------------------------------------")
+	fmt.Println(CapacitySummary(results))
+	if counts[CapacityImpossible] > 0 {
+		fmt.Println("Tracks that cannot fit on a real disk suggest a hand-made or badly converted image")
+	} else if counts[CapacityOverlap] > 0 {
+		fmt.Println("Overlapping sectors are a copy-protection trick and need to be dumped as they are")
+	}
+}
+
+// CapacitySummary returns a one line count of the tracks with each status
+func CapacitySummary(results []TrackCapacity) string {
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	parts := make([]string, 0)
+	for _, status := range []string{CapacityOK, CapacityGap, CapacityOverlap, CapacityImpossible} {
+		if counts[status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+	if len(parts) == 0 {
+		return "no formatted tracks"
+	}
+	return fmt.Sprintf("%d track(s): %s", len(results), strings.Join(parts, ", "))
+}
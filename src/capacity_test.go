@@ -0,0 +1,81 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// capacity_test.go - Unit tests for track capacity analysis
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAnalyzeCapacity(t *testing.T) {
+	extraSector := func(track *LogicalTrack, r uint8) {
+		track.Sectors = append(track.Sectors, LogicalSector{
+			Info: SectorInfo{R: r, N: 2, DataLength: 512},
+			Data: bytes.Repeat([]byte{0xE5}, 512),
+		})
+	}
+
+	tests := []struct {
+		name   string
+		change func(track *LogicalTrack)
+		status string
+		used   int
+	}{
+		{"standard", func(track *LogicalTrack) {}, CapacityOK, 146 + 9*(44+18+512+0x4E)},
+		{"ten sectors with a small gap", func(track *LogicalTrack) {
+			extraSector(track, 0xCA)
+			track.Header.Gap3Length = 0x20
+		}, CapacityOK, 146 + 10*(44+18+512+0x20)},
+		{"ten sectors with a standard gap", func(track *LogicalTrack) {
+			extraSector(track, 0xCA)
+		}, CapacityGap, 146 + 10*(44+18+512+0x4E)},
+		{"eleven sectors", func(track *LogicalTrack) {
+			extraSector(track, 0xCA)
+			extraSector(track, 0xCB)
+		}, CapacityImpossible, 146 + 11*(44+18+512+0x4E)},
+		{"recorded in FM", func(track *LogicalTrack) {
+			track.Header.RecordingMode = RecordingModeFM
+		}, CapacityImpossible, 73 + 9*(24+9+512+0x4E)},
+		{"id-only sector", func(track *LogicalTrack) {
+			track.Sectors[8].Data = nil
+			track.Sectors[8].Info.DataLength = 0
+		}, CapacityOK, 146 + 9*(44+0x4E) + 8*(18+512)},
+		{"gap bytes kept", func(track *LogicalTrack) {
+			track.Sectors[0].Data = append(track.Sectors[0].Data, bytes.Repeat([]byte{0x4E}, 18)...)
+		}, CapacityGap, 146 + 9*(44+18+512+0x4E)},
+		{"read into the next sector", func(track *LogicalTrack) {
+			track.Sectors[0].Data = append(track.Sectors[0].Data, bytes.Repeat([]byte{0x4E}, 100)...)
+		}, CapacityOverlap, 146 + 9*(44+18+512+0x4E)},
+		{"oversized", func(track *LogicalTrack) {
+			track.Sectors = track.Sectors[:1]
+			track.Sectors[0].Info.N = 6
+			track.Sectors[0].Data = bytes.Repeat([]byte{0xE5}, OversizedDataLimit)
+		}, CapacityOverlap, 146 + 44 + 18 + OversizedDataLimit + 0x4E},
+	}
+
+	for _, tt := range tests {
+		dsk := newTestDSK(FormatExtended, 1, 1, 0xC1)
+		tt.change(&dsk.Tracks[0])
+		results := AnalyzeCapacity(dsk)
+		if len(results) != 1 {
+			t.Fatalf("%s: expected one track, got %d", tt.name, len(results))
+		}
+		if result := results[0]; result.Status != tt.status || result.Used != tt.used {
+			t.Errorf("%s: expected %s using %d, got %s using %d %v", tt.name, tt.status, tt.used, result.Status, result.Used, result.Notes)
+		}
+	}
+}
+
+func TestCapacitySummary(t *testing.T) {
+	dsk := newTestDSK(FormatExtended, 3, 1, 0xC1)
+	dsk.Tracks[1].Sectors = nil
+	dsk.Tracks[2].Header.Gap3Length = 0xFF
+	if summary := CapacitySummary(AnalyzeCapacity(dsk)); summary != "2 track(s): 1 ok, 1 unusual gap" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+	if summary := CapacitySummary(nil); summary != "no formatted tracks" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
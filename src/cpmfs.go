@@ -0,0 +1,444 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// cpmfs.go - CP/M 2.2 filesystem access over a DSK image
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DirEntrySize is the size of a CP/M directory entry in bytes
+const DirEntrySize = 32
+
+// RecordSize is the size of a CP/M record in bytes
+const RecordSize = 128
+
+// DeletedUser is the user byte that marks an unused or erased directory entry
+const DeletedUser = 0xE5
+
+// MaxUser is the highest user area holding files (higher values are labels, timestamps etc.)
+const MaxUser = 15
+
+// CPMFileSystem provides access to the CP/M filesystem stored inside a DSK
+type CPMFileSystem struct {
+	DSK    *DSK
+	Format *DiskFormat
+}
+
+// DirEntry is a raw 32-byte CP/M directory entry
+// Layout:
+//
+//	0: User number (0xE5 = unused/deleted)
+//	1-8: Filename (bit 7 of each byte is an attribute flag)
+//	9-11: Extension (bit 7: 9=Read-only, 10=System, 11=Archive)
+//	12: EX - extent number (low bits)
+//	13: S1 - last record byte count (CP/M 3)
+//	14: S2 - extent number (high bits)
+//	15: RC - record count in the last logical extent
+//	16-31: Allocation block numbers (8-bit, or 16-bit little-endian on large disks)
+type DirEntry struct {
+	Index int // Position within the directory
+	Raw   [DirEntrySize]byte
+}
+
+// CPMFile is a file assembled from one or more directory entries (extents)
+type CPMFile struct {
+	User     uint8
+	Name     string // NAME.EXT without padding or attribute bits
+	ReadOnly bool
+	System   bool
+	Archive  bool
+	Records  int   // 128-byte records in the file
+	Blocks   []int // Allocation blocks in file order
+	Entries  []int // Directory entry indices in extent order
+
+	LastRecordBytes int // Bytes used in the last record on CP/M 3 formats (0 means all 128)
+}
+
+// OpenCPMFileSystem detects the disk format and returns a filesystem for the DSK
+func OpenCPMFileSystem(d *DSK) (*CPMFileSystem, error) {
+	format, err := DetectDiskFormat(d)
+	if err != nil {
+		return nil, err
+	}
+	return NewCPMFileSystem(d, format)
+}
+
+// NewCPMFileSystem returns a filesystem for the DSK using an explicit disk format
+func NewCPMFileSystem(d *DSK, format *DiskFormat) (*CPMFileSystem, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	return &CPMFileSystem{DSK: d, Format: format}, nil
+}
+
+// sectorLocation converts a logical sector number (counted from the start of the disk) to a physical location
+func (fs *CPMFileSystem) sectorLocation(logicalSector int) (cylinder int, head int, id uint8) {
+	f := fs.Format
+	logicalTrack := logicalSector / f.SectorsPerTrack
+	sectorIndex := logicalSector % f.SectorsPerTrack
+
+	switch {
+	case f.Sides == 1:
+		cylinder, head = logicalTrack, 0
+	case f.SideOrder == SideOrderSuccessive:
+		cylinder, head = logicalTrack%f.TracksPerSide, logicalTrack/f.TracksPerSide
+	default:
+		cylinder, head = logicalTrack/f.Sides, logicalTrack%f.Sides
+	}
+
+	return cylinder, head, f.FirstSectorID + uint8(f.skewTable()[sectorIndex])
+}
+
+// sector returns the LogicalSector for a logical sector number
+func (fs *CPMFileSystem) sector(logicalSector int) (*LogicalSector, error) {
+	cylinder, head, id := fs.sectorLocation(logicalSector)
+	track := fs.DSK.GetTrack(cylinder, head)
+	if track == nil {
+		return nil, fmt.Errorf("track %d side %d not found", cylinder, head)
+	}
+	sector := track.GetSector(id)
+	if sector == nil {
+		return nil, fmt.Errorf("sector %02X not found on track %d side %d", id, cylinder, head)
+	}
+	return sector, nil
+}
+
+// blockSectors returns the logical sector numbers making up an allocation block
+func (fs *CPMFileSystem) blockSectors(block int) []int {
+	f := fs.Format
+	sectorsPerBlock := f.BlockSize / f.SectorSize
+	first := f.OffsetSectors + f.ReservedTracks*f.SectorsPerTrack + block*sectorsPerBlock
+
+	sectors := make([]int, sectorsPerBlock)
+	for i := range sectors {
+		sectors[i] = first + i
+	}
+	return sectors
+}
+
+// ReadBlock returns the contents of an allocation block
+func (fs *CPMFileSystem) ReadBlock(block int) ([]byte, error) {
+	if block < 0 || block >= fs.Format.TotalBlocks() {
+		return nil, fmt.Errorf("block %d out of range (disk has %d blocks)", block, fs.Format.TotalBlocks())
+	}
+
+	data := make([]byte, 0, fs.Format.BlockSize)
+	for _, logicalSector := range fs.blockSectors(block) {
+		sector, err := fs.sector(logicalSector)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %v", block, err)
+		}
+		sectorData := make([]byte, fs.Format.SectorSize)
+		copy(sectorData, sector.Data)
+		data = append(data, sectorData...)
+	}
+	return data, nil
+}
+
+// WriteBlock replaces the contents of an allocation block, padding short data with 0xE5
+func (fs *CPMFileSystem) WriteBlock(block int, data []byte) error {
+	if block < 0 || block >= fs.Format.TotalBlocks() {
+		return fmt.Errorf("block %d out of range (disk has %d blocks)", block, fs.Format.TotalBlocks())
+	}
+	if len(data) > fs.Format.BlockSize {
+		return fmt.Errorf("block %d: data too large (%d bytes)", block, len(data))
+	}
+
+	for i, logicalSector := range fs.blockSectors(block) {
+		sector, err := fs.sector(logicalSector)
+		if err != nil {
+			return fmt.Errorf("block %d: %v", block, err)
+		}
+		for j := 0; j < fs.Format.SectorSize && j < len(sector.Data); j++ {
+			offset := i*fs.Format.SectorSize + j
+			if offset < len(data) {
+				sector.Data[j] = data[offset]
+			} else {
+				sector.Data[j] = 0xE5
+			}
+		}
+	}
+	return nil
+}
+
+// ReadDirectory returns every directory entry including unused ones
+func (fs *CPMFileSystem) ReadDirectory() ([]DirEntry, error) {
+	entries := make([]DirEntry, 0, fs.Format.DirEntries)
+	for block := 0; block < fs.Format.DirectoryBlocks(); block++ {
+		data, err := fs.ReadBlock(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory: %v", err)
+		}
+		for offset := 0; offset+DirEntrySize <= len(data) && len(entries) < fs.Format.DirEntries; offset += DirEntrySize {
+			entry := DirEntry{Index: len(entries)}
+			copy(entry.Raw[:], data[offset:offset+DirEntrySize])
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// WriteDirectory writes directory entries back to the directory blocks
+func (fs *CPMFileSystem) WriteDirectory(entries []DirEntry) error {
+	for block := 0; block < fs.Format.DirectoryBlocks(); block++ {
+		data, err := fs.ReadBlock(block)
+		if err != nil {
+			return fmt.Errorf("failed to read directory: %v", err)
+		}
+		first := block * fs.Format.BlockSize / DirEntrySize
+		for _, entry := range entries {
+			if entry.Index < first || entry.Index >= first+fs.Format.BlockSize/DirEntrySize {
+				continue
+			}
+			offset := (entry.Index - first) * DirEntrySize
+			copy(data[offset:offset+DirEntrySize], entry.Raw[:])
+		}
+		if err := fs.WriteBlock(block, data); err != nil {
+			return fmt.Errorf("failed to write directory: %v", err)
+		}
+	}
+	return nil
+}
+
+// User returns the user number (or 0xE5 for unused entries)
+func (e *DirEntry) User() uint8 {
+	return e.Raw[0]
+}
+
+// IsFile reports whether the entry belongs to a file in user areas 0-15
+func (e *DirEntry) IsFile() bool {
+	return e.Raw[0] <= MaxUser
+}
+
+// IsDeleted reports whether the entry is unused or has been erased
+func (e *DirEntry) IsDeleted() bool {
+	return e.Raw[0] == DeletedUser
+}
+
+// BaseName returns the filename part without padding or attribute bits
+func (e *DirEntry) BaseName() string {
+	return cpmNamePart(e.Raw[1:9])
+}
+
+// Extension returns the extension part without padding or attribute bits
+func (e *DirEntry) Extension() string {
+	return cpmNamePart(e.Raw[9:12])
+}
+
+// FileName returns NAME.EXT (or just NAME when there is no extension)
+func (e *DirEntry) FileName() string {
+	if ext := e.Extension(); ext != "" {
+		return e.BaseName() + "." + ext
+	}
+	return e.BaseName()
+}
+
+// ReadOnly reports whether the read-only attribute (bit 7 of the first extension byte) is set
+func (e *DirEntry) ReadOnly() bool {
+	return e.Raw[9]&0x80 != 0
+}
+
+// System reports whether the system/hidden attribute (bit 7 of the second extension byte) is set
+func (e *DirEntry) System() bool {
+	return e.Raw[10]&0x80 != 0
+}
+
+// Archive reports whether the archive attribute (bit 7 of the third extension byte) is set
+func (e *DirEntry) Archive() bool {
+	return e.Raw[11]&0x80 != 0
+}
+
+// ExtentNumber returns the logical extent number (S2 * 32 + EX)
+func (e *DirEntry) ExtentNumber() int {
+	return int(e.Raw[14])*32 + int(e.Raw[12]&0x1F)
+}
+
+// RecordCount returns RC, the number of records used in the last logical extent of this entry
+func (e *DirEntry) RecordCount() int {
+	return int(e.Raw[15])
+}
+
+// Blocks returns the non-zero allocation block numbers of the entry
+func (e *DirEntry) Blocks(wide bool) []int {
+	blocks := make([]int, 0, 16)
+	if wide {
+		for i := 16; i < DirEntrySize; i += 2 {
+			if block := int(e.Raw[i]) | int(e.Raw[i+1])<<8; block != 0 {
+				blocks = append(blocks, block)
+			}
+		}
+	} else {
+		for i := 16; i < DirEntrySize; i++ {
+			if block := int(e.Raw[i]); block != 0 {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	return blocks
+}
+
+// cpmNamePart strips attribute bits and trailing spaces from a filename or extension field
+func cpmNamePart(raw []byte) string {
+	name := make([]byte, len(raw))
+	for i, b := range raw {
+		name[i] = b & 0x7F
+	}
+	return strings.TrimRight(string(name), " ")
+}
+
+// fileKey identifies the directory entries belonging to the same file
+func fileKey(user uint8, name string) string {
+	return fmt.Sprintf("%02d:%s", user, name)
+}
+
+// Files assembles the directory entries into files sorted by user area and name
+func (fs *CPMFileSystem) Files() ([]CPMFile, error) {
+	entries, err := fs.ReadDirectory()
+	if err != nil {
+		return nil, err
+	}
+	return fs.filesFromEntries(entries), nil
+}
+
+// filesFromEntries groups live directory entries into files
+func (fs *CPMFileSystem) filesFromEntries(entries []DirEntry) []CPMFile {
+	grouped := make(map[string][]DirEntry)
+	for _, entry := range entries {
+		if !entry.IsFile() {
+			continue
+		}
+		key := fileKey(entry.User(), entry.FileName())
+		grouped[key] = append(grouped[key], entry)
+	}
+
+	files := make([]CPMFile, 0, len(grouped))
+	for _, extents := range grouped {
+		sort.SliceStable(extents, func(i, j int) bool {
+			return extents[i].ExtentNumber() < extents[j].ExtentNumber()
+		})
+
+		first := extents[0]
+		last := extents[len(extents)-1]
+		file := CPMFile{
+			User:     first.User(),
+			Name:     first.FileName(),
+			ReadOnly: first.ReadOnly(),
+			System:   first.System(),
+			Archive:  first.Archive(),
+			Records:  last.ExtentNumber()*RecordSize + last.RecordCount(),
+		}
+		if fs.Format.OS == "3" && last.Raw[13] <= RecordSize {
+			file.LastRecordBytes = int(last.Raw[13])
+		}
+		for _, extent := range extents {
+			file.Blocks = append(file.Blocks, extent.Blocks(fs.Format.WideBlockPointers())...)
+			file.Entries = append(file.Entries, extent.Index)
+		}
+		files = append(files, file)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].User != files[j].User {
+			return files[i].User < files[j].User
+		}
+		return files[i].Name < files[j].Name
+	})
+	return files
+}
+
+// Size returns the file size in bytes as recorded by the directory
+// (a multiple of 128 unless a CP/M 3 last record byte count is present)
+func (f *CPMFile) Size() int {
+	if f.LastRecordBytes > 0 && f.Records > 0 {
+		return (f.Records-1)*RecordSize + f.LastRecordBytes
+	}
+	return f.Records * RecordSize
+}
+
+// Attributes returns the attribute flags as a short string (e.g. "R/O SYS ARC")
+func (f *CPMFile) Attributes() string {
+	attrs := make([]string, 0, 3)
+	if f.ReadOnly {
+		attrs = append(attrs, "R/O")
+	}
+	if f.System {
+		attrs = append(attrs, "SYS")
+	}
+	if f.Archive {
+		attrs = append(attrs, "ARC")
+	}
+	return strings.Join(attrs, " ")
+}
+
+// ListFiles prints the CP/M directory of the DSK to the console
+func (fs *CPMFileSystem) ListFiles() error {
+	files, err := fs.Files()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Format: %s (%d blocks of %d bytes, %d directory entries)\n",
+		fs.Format.Name, fs.Format.TotalBlocks(), fs.Format.BlockSize, fs.Format.DirEntries)
+	fmt.Println("User Name          Size  Records  Attributes   Header")
+	for i := range files {
+		file := &files[i]
+		sizeK := len(file.Blocks) * fs.Format.BlockSize / 1024
+		header := "unreadable"
+		if data, err := fs.ReadFile(file); err == nil {
+			header = DescribeFileHeader(data)
+		}
+		fmt.Printf("%4d %-12s %4dK %8d  %-11s  %s\n", file.User, file.Name, sizeK, file.Records, file.Attributes(), header)
+	}
+	fmt.Printf("%d file(s)\n", len(files))
+	return nil
+}
+
+// ReadFile returns the contents of a file (a whole number of 128-byte records)
+func (fs *CPMFileSystem) ReadFile(file *CPMFile) ([]byte, error) {
+	data := make([]byte, 0, len(file.Blocks)*fs.Format.BlockSize)
+	for _, block := range file.Blocks {
+		blockData, err := fs.ReadBlock(block)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file.Name, err)
+		}
+		data = append(data, blockData...)
+	}
+
+	if size := file.Size(); size < len(data) {
+		data = data[:size]
+	}
+	return data, nil
+}
+
+// usedBlocks returns the allocation blocks taken by the directory and by live directory entries
+func (fs *CPMFileSystem) usedBlocks(entries []DirEntry) map[int]bool {
+	used := make(map[int]bool)
+	for block := 0; block < fs.Format.DirectoryBlocks(); block++ {
+		used[block] = true
+	}
+	for _, entry := range entries {
+		if !entry.IsFile() {
+			continue
+		}
+		for _, block := range entry.Blocks(fs.Format.WideBlockPointers()) {
+			used[block] = true
+		}
+	}
+	return used
+}
+
+// freeBlocks returns the unused allocation blocks in ascending order
+func (fs *CPMFileSystem) freeBlocks(entries []DirEntry) []int {
+	used := fs.usedBlocks(entries)
+	free := make([]int, 0)
+	for block := 0; block < fs.Format.TotalBlocks(); block++ {
+		if !used[block] {
+			free = append(free, block)
+		}
+	}
+	return free
+}
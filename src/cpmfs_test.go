@@ -0,0 +1,133 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// cpmfs_test.go - Unit tests for CP/M filesystem access
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"testing"
+)
+
+// newTestDirEntry builds a raw directory entry using 8-bit block pointers
+func newTestDirEntry(user uint8, name string, ext string, extent int, records int, blocks ...int) DirEntry {
+	var entry DirEntry
+	entry.Raw[0] = user
+	copy(entry.Raw[1:9], "        ")
+	copy(entry.Raw[1:9], name)
+	copy(entry.Raw[9:12], "   ")
+	copy(entry.Raw[9:12], ext)
+	entry.Raw[12] = uint8(extent % 32)
+	entry.Raw[14] = uint8(extent / 32)
+	entry.Raw[15] = uint8(records)
+	for i, block := range blocks {
+		entry.Raw[16+i] = uint8(block)
+	}
+	return entry
+}
+
+// writeTestDirEntries places entries in the first directory slots of the filesystem
+func writeTestDirEntries(t *testing.T, fs *CPMFileSystem, entries ...DirEntry) {
+	t.Helper()
+	for i := range entries {
+		entries[i].Index = i
+	}
+	if err := fs.WriteDirectory(entries); err != nil {
+		t.Fatalf("unexpected error writing directory: %v", err)
+	}
+}
+
+func TestDetectDiskFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		dsk      *DSK
+		expected string
+		blocks   int
+	}{
+		{"CPC DATA", newTestDSK(FormatExtended, 40, 1, 0xC1), "CPC DATA", 180},
+		{"CPC SYSTEM", newTestDSK(FormatExtended, 40, 1, 0x41), "CPC SYSTEM", 171},
+		{"+3", newPlus3TestDSK(), "+3/PCW (specification)", 175},
+		{"+3 without specification", newTestDSK(FormatExtended, 40, 1, 0x01), "+3 180K", 175},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := DetectDiskFormat(tt.dsk)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if format.Name != tt.expected {
+				t.Errorf("Name: expected %q, got %q", tt.expected, format.Name)
+			}
+			if format.TotalBlocks() != tt.blocks {
+				t.Errorf("TotalBlocks: expected %d, got %d", tt.blocks, format.TotalBlocks())
+			}
+		})
+	}
+
+	if _, err := DetectDiskFormat(newTestDSK(FormatExtended, 40, 1, 0x11)); err == nil {
+		t.Errorf("expected error for disk matching no format")
+	}
+}
+
+func TestCPMFiles(t *testing.T) {
+	fs, err := OpenCPMFileSystem(newTestDSK(FormatExtended, 40, 1, 0xC1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hidden := newTestDirEntry(0, "HIDDEN", "BIN", 0, 3, 5)
+	hidden.Raw[9] |= 0x80  // R/O
+	hidden.Raw[10] |= 0x80 // SYS
+	deleted := newTestDirEntry(DeletedUser, "GONE", "TXT", 0, 8, 6)
+
+	writeTestDirEntries(t, fs,
+		newTestDirEntry(0, "GAME", "BIN", 1, 0x10, 18, 19),
+		newTestDirEntry(0, "GAME", "BIN", 0, 0x80, 2, 3, 4, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 20, 21),
+		hidden,
+		deleted,
+		newTestDirEntry(3, "LOADER", "BAS", 0, 2, 22),
+	)
+
+	files, err := fs.Files()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(files))
+	}
+
+	game := files[0]
+	if game.Name != "GAME.BIN" || game.User != 0 {
+		t.Errorf("expected 0:GAME.BIN first, got %d:%s", game.User, game.Name)
+	}
+	if game.Records != 0x90 {
+		t.Errorf("GAME.BIN records: expected %d, got %d", 0x90, game.Records)
+	}
+	if len(game.Blocks) != 18 || game.Blocks[0] != 2 || game.Blocks[16] != 18 {
+		t.Errorf("GAME.BIN blocks not in extent order: %v", game.Blocks)
+	}
+
+	if files[1].Name != "HIDDEN.BIN" || files[1].Attributes() != "R/O SYS" {
+		t.Errorf("expected HIDDEN.BIN with R/O SYS, got %s with %q", files[1].Name, files[1].Attributes())
+	}
+	if files[2].User != 3 || files[2].Name != "LOADER.BAS" {
+		t.Errorf("expected 3:LOADER.BAS, got %d:%s", files[2].User, files[2].Name)
+	}
+}
+
+func TestSectorLocationDoubleSided(t *testing.T) {
+	format := DiskFormat{Name: "test", Sides: 2, TracksPerSide: 80, SectorsPerTrack: 9, SectorSize: 512,
+		FirstSectorID: 1, ReservedTracks: 1, BlockSize: 2048, DirEntries: 128}
+	fs := &CPMFileSystem{Format: &format}
+
+	cylinder, head, id := fs.sectorLocation(9*3 + 4)
+	if cylinder != 1 || head != 1 || id != 5 {
+		t.Errorf("alternate: expected 1/1/05, got %d/%d/%02X", cylinder, head, id)
+	}
+
+	format.SideOrder = SideOrderSuccessive
+	cylinder, head, id = fs.sectorLocation(9 * 81)
+	if cylinder != 1 || head != 1 || id != 1 {
+		t.Errorf("successive: expected 1/1/01, got %d/%d/%02X", cylinder, head, id)
+	}
+}
@@ -0,0 +1,397 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// detect.go - Disk format detection with confidence scoring
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Family names reported when a disk does not clearly belong to a machine
+const (
+	FamilyCustom  = "custom/protected"
+	FamilyUnknown = "unknown"
+)
+
+// detectConfidentScore is the score at which a format is taken as the disk's format
+const detectConfidentScore = 60
+
+// DetectionCandidate is a registered disk format scored against a DSK
+type DetectionCandidate struct {
+	Format  DiskFormat
+	Score   int      // Confidence from 0 to 100
+	Reasons []string // Evidence for (+) and against (-) the format
+}
+
+// DetectionReport is the result of detecting the format of a DSK
+type DetectionReport struct {
+	Family         string               // Machine of the best format, custom/protected or unknown
+	Candidates     []DetectionCandidate // Formats whose first sector ID matches, best first
+	Irregularities []string             // Signs of copy protection or a non-standard layout
+	Protected      bool                 // Whether the irregularities include the tricks copy protection relies on
+}
+
+// Best returns the highest scoring candidate or nil if no format matched at all
+func (r *DetectionReport) Best() *DetectionCandidate {
+	if len(r.Candidates) == 0 {
+		return nil
+	}
+	return &r.Candidates[0]
+}
+
+// DetectFormat scores every registered format against the disk using the sector IDs, sizes and
+// counts of each track, the specification block, the boot sector and the directory contents
+func DetectFormat(d *DSK) *DetectionReport {
+	report := &DetectionReport{}
+	report.Irregularities, report.Protected = d.irregularities()
+
+	for _, format := range DiskFormats {
+		if candidate := scoreFormat(d, format); candidate != nil {
+			report.Candidates = append(report.Candidates, *candidate)
+		}
+	}
+	sort.SliceStable(report.Candidates, func(i, j int) bool {
+		return report.Candidates[i].Score > report.Candidates[j].Score
+	})
+
+	best := report.Best()
+	switch {
+	case best != nil && best.Score >= detectConfidentScore && !report.Protected && best.Format.Machine != "":
+		report.Family = best.Format.Machine
+	case len(d.Tracks) > 0 && d.bootSector() != nil:
+		// Either no format fits well or the disk is laid out to defeat copying
+		report.Family = FamilyCustom
+	default:
+		report.Family = FamilyUnknown
+	}
+	return report
+}
+
+// scoreFormat rates how well the disk fits a format, returning nil when the boot sector ID rules it out
+// Points: boot sector ID 20, sides and tracks 10, track layout 25, specification or FAT boot sector 20,
+// directory or FAT parameters 25, then 5 more for a boot checksum belonging to the machine or 10 less
+// for one belonging to another machine
+func scoreFormat(d *DSK, format DiskFormat) *DetectionCandidate {
+	boot := d.bootSector()
+	if boot == nil || boot.Info.R != format.FirstSectorID {
+		return nil
+	}
+
+	candidate := &DetectionCandidate{Format: format, Score: 20}
+	reason := func(good bool, message string, args ...interface{}) {
+		sign := "-"
+		if good {
+			sign = "+"
+		}
+		candidate.Reasons = append(candidate.Reasons, sign+" "+fmt.Sprintf(message, args...))
+	}
+	reason(true, "first sector ID #%02X", format.FirstSectorID)
+
+	// Sides and tracks
+	tracks := int(d.Header.Tracks)
+	if int(d.Header.Sides) == format.Sides && tracks >= format.TracksPerSide-3 && tracks <= format.TracksPerSide+3 {
+		candidate.Score += 10
+		reason(true, "%d side(s) of %d tracks", d.Header.Sides, tracks)
+	} else {
+		reason(false, "%d side(s) of %d tracks, expected %d of %d", d.Header.Sides, tracks, format.Sides, format.TracksPerSide)
+	}
+
+	// Track layout
+	regular, formatted := 0, 0
+	for i := range d.Tracks {
+		track := &d.Tracks[i]
+		if len(track.Sectors) == 0 {
+			continue
+		}
+		formatted++
+		if track.hasLayout(format) {
+			regular++
+		}
+	}
+	if formatted > 0 {
+		candidate.Score += 25 * regular / formatted
+		reason(regular == formatted, "%d of %d tracks have sectors #%02X-#%02X of %d bytes", regular, formatted,
+			format.FirstSectorID, int(format.FirstSectorID)+format.SectorsPerTrack-1, format.SectorSize)
+	}
+
+	if format.FileSystem == FileSystemFAT12 {
+		scoreFATBootSector(boot.Data, format, candidate, reason)
+	} else {
+		scoreSpecification(d, format, candidate, reason)
+		scoreDirectory(d, format, candidate, reason)
+	}
+
+	// Boot checksum
+	if d.Specification != nil {
+		machine := ""
+		switch d.Specification.SectorSum {
+		case BootSumPlus3:
+			machine = "+3"
+		case BootSumPCW9512, BootSumPCW8256:
+			machine = "PCW"
+		}
+		switch {
+		case machine == "" || format.Machine == "":
+		case machine == format.Machine:
+			candidate.Score += 5
+			reason(true, "boot sector checksum is bootable on the %s", d.Specification.BootType())
+		default:
+			candidate.Score -= 10
+			reason(false, "boot sector checksum is bootable on the %s", d.Specification.BootType())
+		}
+	}
+
+	if candidate.Score > 100 {
+		candidate.Score = 100
+	}
+	return candidate
+}
+
+// hasLayout reports whether a track has exactly the sector IDs and size of the format
+func (t *LogicalTrack) hasLayout(format DiskFormat) bool {
+	if len(t.Sectors) != format.SectorsPerTrack {
+		return false
+	}
+	seen := make(map[uint8]bool)
+	for _, sector := range t.Sectors {
+		id := sector.Info.R
+		if id < format.FirstSectorID || int(id) >= int(format.FirstSectorID)+format.SectorsPerTrack ||
+			seen[id] || 128<<sector.Info.N != format.SectorSize {
+			return false
+		}
+		seen[id] = true
+	}
+	return true
+}
+
+// scoreSpecification compares the disk's specification block with the one the format would have
+func scoreSpecification(d *DSK, format DiskFormat, candidate *DetectionCandidate, reason func(bool, string, ...interface{})) {
+	expected := format.Specification()
+	switch {
+	case d.Specification == nil && (format.FirstSectorID == FormatCPCData.FirstSectorID || format.FirstSectorID == FormatCPCSystem.FirstSectorID):
+		candidate.Score += 20
+		reason(true, "no specification block, as expected")
+	case d.Specification == nil:
+		candidate.Score += 10
+		reason(false, "no specification block")
+	case expected == nil:
+		reason(false, "specification block present but the format cannot have one")
+	case format.matchesSpecification(d.Specification):
+		candidate.Score += 20
+		reason(true, "specification block matches (%s)", d.Specification.Format)
+	default:
+		reason(false, "specification block does not match")
+	}
+}
+
+// scoreDirectory reads the directory with the format and rates how plausible its entries are
+func scoreDirectory(d *DSK, format DiskFormat, candidate *DetectionCandidate, reason func(bool, string, ...interface{})) {
+	fs, err := NewCPMFileSystem(d, &format)
+	if err != nil {
+		reason(false, "unusable CP/M parameters: %v", err)
+		return
+	}
+	entries, err := fs.ReadDirectory()
+	if err != nil {
+		reason(false, "directory unreadable: %v", err)
+		return
+	}
+
+	valid, files := 0, 0
+	for i := range entries {
+		if entries[i].isPlausible(format) {
+			valid++
+			if entries[i].IsFile() {
+				files++
+			}
+		}
+	}
+	candidate.Score += 25 * valid / len(entries)
+	switch {
+	case valid < len(entries):
+		reason(false, "%d of %d directory entries are invalid", len(entries)-valid, len(entries))
+	case files == 0:
+		reason(true, "directory is empty")
+	default:
+		reason(true, "directory has %d valid entries", files)
+	}
+}
+
+// isPlausible reports whether a directory entry is unused or looks like a real file, label or timestamp
+func (e *DirEntry) isPlausible(format DiskFormat) bool {
+	if e.IsDeleted() {
+		return true
+	}
+	if e.User() > 0x21 {
+		return false
+	}
+	if e.User() == 0x21 {
+		return true // CP/M 3 timestamps
+	}
+	for _, b := range e.Raw[1:12] {
+		if c := b & 0x7F; c < ' ' || c > '~' {
+			return false
+		}
+	}
+	if !e.IsFile() {
+		return true // CP/M 3 password or disc label
+	}
+	if e.RecordCount() > 0x80 {
+		return false
+	}
+	for _, block := range e.Blocks(format.WideBlockPointers()) {
+		if block >= format.TotalBlocks() {
+			return false
+		}
+	}
+	return true
+}
+
+// scoreFATBootSector checks for a FAT boot sector with parameters matching the format
+func scoreFATBootSector(boot []byte, format DiskFormat, candidate *DetectionCandidate, reason func(bool, string, ...interface{})) {
+	if len(boot) < 0x1A || (boot[0] != 0xEB && boot[0] != 0xE9) {
+		reason(false, "boot sector does not start with a jump")
+		return
+	}
+	candidate.Score += 20
+	reason(true, "boot sector starts with a jump")
+
+	bytesPerSector := int(boot[0x0B]) | int(boot[0x0C])<<8
+	sectorsPerTrack := int(boot[0x18]) | int(boot[0x19])<<8
+	if bytesPerSector == format.SectorSize && sectorsPerTrack == format.SectorsPerTrack {
+		candidate.Score += 25
+		reason(true, "FAT parameters give %d sectors of %d bytes per track", sectorsPerTrack, bytesPerSector)
+	} else {
+		reason(false, "FAT parameters give %d sectors of %d bytes per track", sectorsPerTrack, bytesPerSector)
+	}
+}
+
+// irregularities lists features of the disk that standard formats never have and reports whether
+// any are structural tricks used by copy protection (repeated IDs, odd sizes, weak or oversized
+// sectors, or errors on more than one track) rather than the odd bad sector of a worn disk
+func (d *DSK) irregularities() ([]string, bool) {
+	counts := make(map[int]int)
+	sizes := make(map[int]int)
+	for _, track := range d.Tracks {
+		if len(track.Sectors) > 0 {
+			counts[len(track.Sectors)]++
+		}
+		for _, sector := range track.Sectors {
+			sizes[int(sector.Info.N)]++
+		}
+	}
+	commonCount, commonSize := mostCommon(counts), mostCommon(sizes)
+
+	unusualCount, unusualSize, errors, badTracks, duplicates, lengths, shifted, tricks := 0, 0, 0, 0, 0, 0, 0, 0
+	firstID := -1
+	if boot := d.bootSector(); boot != nil {
+		firstID = int(boot.Info.R)
+	}
+	for _, track := range d.Tracks {
+		if len(track.Sectors) == 0 {
+			continue
+		}
+		if len(track.Sectors) != commonCount {
+			unusualCount++
+		}
+		seen := make(map[uint8]bool)
+		lowest := 256
+		duplicated, bad := false, false
+		for s := range track.Sectors {
+			sector := &track.Sectors[s]
+			if int(sector.Info.N) != commonSize {
+				unusualSize++
+			}
+			if sector.Info.FDCStatus1 != 0 || sector.Info.FDCStatus2 != 0 {
+				errors++
+				bad = true
+			}
+			if kind := sector.Kind(); kind == SectorWeak || kind == SectorOversized {
+				tricks++
+			}
+			if seen[sector.Info.R] {
+				duplicated = true
+			}
+			seen[sector.Info.R] = true
+			if int(sector.Info.R) < lowest {
+				lowest = int(sector.Info.R)
+			}
+			if sector.Info.N < 6 && len(sector.Data) != 128<<sector.Info.N {
+				lengths++
+			}
+		}
+		if duplicated {
+			duplicates++
+		}
+		if bad {
+			badTracks++
+		}
+		if lowest != firstID {
+			shifted++
+		}
+	}
+
+	found := make([]string, 0)
+	add := func(count int, message string, args ...interface{}) {
+		if count > 0 {
+			found = append(found, fmt.Sprintf(message, args...))
+		}
+	}
+	add(unusualCount, "%d track(s) do not have the usual %d sectors", unusualCount, commonCount)
+	add(unusualSize, "%d sector(s) are not the usual %d bytes", unusualSize, 128<<commonSize)
+	add(errors, "%d sector(s) have FDC error flags", errors)
+	add(duplicates, "%d track(s) repeat a sector ID", duplicates)
+	add(lengths, "%d sector(s) hold more or less data than their size", lengths)
+	add(shifted, "%d track(s) start at a sector ID other than #%02X", shifted, firstID)
+	return found, duplicates > 0 || unusualSize > 0 || tricks > 0 || badTracks > 1
+}
+
+// mostCommon returns the key with the highest count (the lowest key on a tie)
+func mostCommon(counts map[int]int) int {
+	best, bestCount := 0, -1
+	for key, count := range counts {
+		if count > bestCount || (count == bestCount && key < best) {
+			best, bestCount = key, count
+		}
+	}
+	return best
+}
+
+// Print writes the detection report to the console
+func (r *DetectionReport) Print() {
+	best := r.Best()
+	if best == nil {
+		fmt.Printf("Family     : %s (no known format has this first sector ID)\n", r.Family)
+	} else {
+		fmt.Printf("Family     : %s\n", r.Family)
+		fmt.Printf("Format     : %s (%d%% confidence)\n", best.Format.Name, best.Score)
+		for _, reason := range best.Reasons {
+			fmt.Printf("  %s\n", reason)
+		}
+	}
+
+	if len(r.Irregularities) > 0 {
+		fmt.Println("Irregularities:")
+		for _, irregularity := range r.Irregularities {
+			fmt.Printf("  ! %s\n", irregularity)
+		}
+	}
+
+	if len(r.Candidates) > 1 {
+		fmt.Println("Other candidates:")
+		for _, candidate := range r.Candidates[1:] {
+			fmt.Printf("  %-13s %3d%%\n", candidate.Format.Name, candidate.Score)
+		}
+	}
+}
+
+// Summary returns a one line description of the detection for listing many disks
+func (r *DetectionReport) Summary() string {
+	best := r.Best()
+	if best == nil {
+		return r.Family
+	}
+	return fmt.Sprintf("%s, %s (%d%%)", r.Family, best.Format.Name, best.Score)
+}
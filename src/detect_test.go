@@ -0,0 +1,122 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// detect_test.go - Unit tests for disk format detection
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	plus3 := newPlus3TestDSK()
+	if err := plus3.FixBootChecksum(BootSumPlus3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plus3.Specification = plus3.readSpecification()
+
+	pcw := newPlus3TestDSK()
+	if err := pcw.FixBootChecksum(BootSumPCW8256); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pcw.Specification = pcw.readSpecification()
+
+	msx := newTestDSK(FormatExtended, 80, 2, 0x01)
+	copy(msx.bootSector().Data, []byte{0xEB, 0xFE, 0x90, 'M', 'S', 'X', ' ', ' ', ' ', ' ', ' ', 0x00, 0x02})
+	msx.bootSector().Data[0x18], msx.bootSector().Data[0x19] = 9, 0
+
+	tests := []struct {
+		name     string
+		dsk      *DSK
+		family   string
+		format   string
+		minScore int
+	}{
+		{"CPC DATA", newTestDSK(FormatExtended, 40, 1, 0xC1), "CPC", "CPC DATA", 100},
+		{"CPC SYSTEM", newTestDSK(FormatExtended, 40, 1, 0x41), "CPC", "CPC SYSTEM", 100},
+		{"+3 bootable", plus3, "+3", "+3 180K", 100},
+		{"PCW bootable", pcw, "PCW", "PCW 180K", 100},
+		{"MSX-DOS", msx, "MSX", "MSX-DOS", 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := DetectFormat(tt.dsk)
+			best := report.Best()
+			if best == nil {
+				t.Fatalf("expected a candidate")
+			}
+			if report.Family != tt.family || best.Format.Name != tt.format || best.Score < tt.minScore {
+				t.Errorf("expected %s %s (%d%%+), got %s", tt.family, tt.format, tt.minScore, report.Summary())
+				for _, reason := range best.Reasons {
+					t.Log(reason)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectFormatDirectory(t *testing.T) {
+	dsk := newTestDSK(FormatExtended, 40, 1, 0xC1)
+	fs, err := OpenCPMFileSystem(dsk)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Half of the directory holds garbage instead of entries
+	garbage := newTestDirEntry(0x7F, "\x01\x02", "", 0, 0xFF, 250)
+	entries := make([]DirEntry, 32)
+	for i := range entries {
+		entries[i] = garbage
+	}
+	writeTestDirEntries(t, fs, entries...)
+
+	best := DetectFormat(dsk).Best()
+	if best.Score > 90 || !strings.Contains(strings.Join(best.Reasons, "\n"), "32 of 64 directory entries are invalid") {
+		t.Errorf("expected a lower score for a garbage directory, got %d%% %v", best.Score, best.Reasons)
+	}
+}
+
+func TestDetectFormatProtected(t *testing.T) {
+	dsk := newTestDSK(FormatExtended, 40, 1, 0xC1)
+	track := dsk.GetTrack(5, 0)
+	track.Sectors = track.Sectors[:1]
+	track.Sectors[0].Info.N = 6
+	track.Sectors[0].Info.FDCStatus2 = FDCStatus2DataErrorInDataField
+
+	report := DetectFormat(dsk)
+	if report.Family != FamilyCustom {
+		t.Errorf("expected %s, got %s", FamilyCustom, report.Family)
+	}
+	irregularities := strings.Join(report.Irregularities, "\n")
+	for _, expected := range []string{"1 track(s) do not have the usual 9 sectors", "1 sector(s) are not the usual 512 bytes", "1 sector(s) have FDC error flags"} {
+		if !strings.Contains(irregularities, expected) {
+			t.Errorf("expected irregularity %q in %q", expected, irregularities)
+		}
+	}
+	if best := report.Best(); best == nil || best.Format.Name != "CPC DATA" || best.Score == 100 {
+		t.Errorf("expected CPC DATA below 100%%, got %s", report.Summary())
+	}
+}
+
+func TestDetectFormatBadSector(t *testing.T) {
+	dsk := newTestDSK(FormatExtended, 40, 1, 0xC1)
+	sector := &dsk.GetTrack(12, 0).Sectors[3]
+	sector.Info.FDCStatus1 = FDCStatus1DataError
+	sector.Info.FDCStatus2 = FDCStatus2DataErrorInDataField
+
+	report := DetectFormat(dsk)
+	if report.Family != "CPC" || report.Protected {
+		t.Errorf("expected a worn CPC disk, got %s (protected %v)", report.Summary(), report.Protected)
+	}
+	if irregularities := strings.Join(report.Irregularities, "\n"); !strings.Contains(irregularities, "1 sector(s) have FDC error flags") {
+		t.Errorf("expected the bad sector as an irregularity, got %q", irregularities)
+	}
+
+	// Errors spread over several tracks are more likely to be deliberate
+	dsk.GetTrack(13, 0).Sectors[3].Info = sector.Info
+	if report := DetectFormat(dsk); report.Family != FamilyCustom {
+		t.Errorf("expected %s, got %s", FamilyCustom, report.Summary())
+	}
+}
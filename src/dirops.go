@@ -0,0 +1,160 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// dirops.go - Delete, rename and attribute changes on CP/M directory entries
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Attribute flags used when changing file attributes
+const (
+	AttrReadOnly uint8 = 1 << iota
+	AttrSystem
+	AttrArchive
+)
+
+// attributeBytes maps each attribute flag to the directory entry byte holding it in bit 7
+var attributeBytes = map[uint8]int{
+	AttrReadOnly: 9,
+	AttrSystem:   10,
+	AttrArchive:  11,
+}
+
+// ParseAttributeChange converts +ro/-ro, +sys/-sys or +arc/-arc into the flag and whether to set or clear it
+func ParseAttributeChange(change string) (uint8, bool, error) {
+	if len(change) < 2 || (change[0] != '+' && change[0] != '-') {
+		return 0, false, fmt.Errorf("invalid attribute change '%s'. Use +ro, -ro, +sys, -sys, +arc or -arc", change)
+	}
+
+	var flag uint8
+	switch strings.ToLower(change[1:]) {
+	case "ro", "r":
+		flag = AttrReadOnly
+	case "sys", "s":
+		flag = AttrSystem
+	case "arc", "a":
+		flag = AttrArchive
+	default:
+		return 0, false, fmt.Errorf("invalid attribute '%s'. Must be one of: ro, sys, arc", change[1:])
+	}
+	return flag, change[0] == '+', nil
+}
+
+// DeleteFiles erases every file matching pattern in the user area by marking its directory entries unused
+// Read-only files are refused unless force is set
+func (fs *CPMFileSystem) DeleteFiles(pattern string, user int, force bool) ([]CPMFile, error) {
+	files, err := fs.MatchFiles(pattern, user)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match '%s'", pattern)
+	}
+
+	for _, file := range files {
+		if file.ReadOnly && !force {
+			return nil, fmt.Errorf("%d:%s is read-only", file.User, file.Name)
+		}
+	}
+
+	entries, err := fs.ReadDirectory()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		for _, index := range file.Entries {
+			entries[index].Raw[0] = DeletedUser
+		}
+	}
+
+	return files, fs.WriteDirectory(entries)
+}
+
+// RenameFile renames a file and/or moves it to another user area
+// Read-only files are refused unless force is set
+func (fs *CPMFileSystem) RenameFile(name string, user uint8, newName string, newUser uint8, force bool) error {
+	base, ext, err := SplitCPMName(newName)
+	if err != nil {
+		return err
+	}
+	if newUser > MaxUser {
+		return fmt.Errorf("invalid user %d. Must be 0-15", newUser)
+	}
+
+	files, err := fs.Files()
+	if err != nil {
+		return err
+	}
+
+	var source *CPMFile
+	targetName := base
+	if ext != "" {
+		targetName += "." + ext
+	}
+	for i := range files {
+		if files[i].User == user && files[i].Name == strings.ToUpper(name) {
+			source = &files[i]
+		}
+	}
+	if source == nil {
+		return fmt.Errorf("%d:%s not found", user, strings.ToUpper(name))
+	}
+	if source.ReadOnly && !force {
+		return fmt.Errorf("%d:%s is read-only", source.User, source.Name)
+	}
+	if source.User != newUser || source.Name != targetName {
+		for _, file := range files {
+			if file.User == newUser && file.Name == targetName {
+				return fmt.Errorf("%d:%s already exists", newUser, targetName)
+			}
+		}
+	}
+
+	entries, err := fs.ReadDirectory()
+	if err != nil {
+		return err
+	}
+	for _, index := range source.Entries {
+		entries[index].Raw[0] = newUser
+		entries[index].setName(base, ext)
+	}
+
+	return fs.WriteDirectory(entries)
+}
+
+// SetAttributes sets and clears attribute flags on every file matching pattern in the user area
+func (fs *CPMFileSystem) SetAttributes(pattern string, user int, set uint8, clear uint8) ([]CPMFile, error) {
+	files, err := fs.MatchFiles(pattern, user)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match '%s'", pattern)
+	}
+
+	entries, err := fs.ReadDirectory()
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		for _, index := range files[i].Entries {
+			for flag, offset := range attributeBytes {
+				if set&flag != 0 {
+					entries[index].Raw[offset] |= 0x80
+				}
+				if clear&flag != 0 {
+					entries[index].Raw[offset] &^= 0x80
+				}
+			}
+		}
+		first := entries[files[i].Entries[0]]
+		files[i].ReadOnly = first.ReadOnly()
+		files[i].System = first.System()
+		files[i].Archive = first.Archive()
+	}
+
+	return files, fs.WriteDirectory(entries)
+}
@@ -0,0 +1,115 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// dirops_test.go - Unit tests for delete, rename and attribute changes
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// newTestFileSystem returns a CPC DATA filesystem holding the named empty-ish files in user 0
+func newTestFileSystem(t *testing.T, names ...string) *CPMFileSystem {
+	t.Helper()
+	fs, err := OpenCPMFileSystem(newTestDSK(FormatExtended, 40, 1, 0xC1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range names {
+		if err := fs.WriteFile(name, []byte(name), PutOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return fs
+}
+
+func TestDeleteFiles(t *testing.T) {
+	fs := newTestFileSystem(t, "ONE.BAS", "TWO.BAS", "THREE.BIN")
+	before, _ := fs.DSK.Bytes()
+
+	if _, err := fs.SetAttributes("TWO.BAS", AnyUser, AttrReadOnly, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := fs.DeleteFiles("*.BAS", AnyUser, false); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("expected read-only error, got %v", err)
+	}
+
+	deleted, err := fs.DeleteFiles("*.BAS", AnyUser, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deleted) != 2 {
+		t.Errorf("expected 2 deleted files, got %d", len(deleted))
+	}
+
+	files, _ := fs.Files()
+	if len(files) != 1 || files[0].Name != "THREE.BIN" {
+		t.Errorf("expected only THREE.BIN to remain, got %v", files)
+	}
+
+	// Only the directory (the first two blocks of track 0) may change
+	after, _ := fs.DSK.Bytes()
+	directoryEnd := HeaderSize + 0x100 + 2*1024
+	if !bytes.Equal(before[directoryEnd:], after[directoryEnd:]) {
+		t.Errorf("data outside the directory was changed")
+	}
+}
+
+func TestRenameFile(t *testing.T) {
+	fs := newTestFileSystem(t, "ONE.BAS", "TWO.BAS")
+
+	if err := fs.RenameFile("one.bas", 0, "TWO.BAS", 0, false); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected already exists error, got %v", err)
+	}
+	if err := fs.RenameFile("ONE.BAS", 0, "TWO.BAS", 7, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := fs.RenameFile("TWO.BAS", 0, "LOADER", 0, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := fs.RenameFile("MISSING.BAS", 0, "OTHER", 0, false); err == nil {
+		t.Errorf("expected not found error")
+	}
+
+	if _, err := fs.SetAttributes("LOADER", 0, AttrReadOnly, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := fs.RenameFile("LOADER", 0, "LOADER", 3, false); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("expected read-only error, got %v", err)
+	}
+	if err := fs.RenameFile("LOADER", 0, "MENU", 0, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files, _ := fs.Files()
+	if len(files) != 2 || files[0].Name != "MENU" || files[1].User != 7 || files[1].Name != "TWO.BAS" {
+		t.Errorf("unexpected files after rename: %+v", files)
+	}
+}
+
+func TestSetAttributes(t *testing.T) {
+	fs := newTestFileSystem(t, "ONE.BAS")
+
+	files, err := fs.SetAttributes("ONE.BAS", 0, AttrReadOnly|AttrSystem|AttrArchive, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if files[0].Attributes() != "R/O SYS ARC" {
+		t.Errorf("expected R/O SYS ARC, got %q", files[0].Attributes())
+	}
+
+	files, err = fs.SetAttributes("ONE.BAS", 0, 0, AttrSystem)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if files[0].Attributes() != "R/O ARC" {
+		t.Errorf("expected R/O ARC, got %q", files[0].Attributes())
+	}
+
+	// Name must still match after attribute bits are set
+	if matches, _ := fs.MatchFiles("ONE.BAS", 0); len(matches) != 1 {
+		t.Errorf("file no longer found by name after attribute change")
+	}
+}
@@ -0,0 +1,178 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// disasm.go - Z80 disassembly of boot sectors, raw sectors and binary files
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Boot sector load addresses, with the boot code running from the entry offset
+const (
+	Plus3BootOrigin = 0xFE00 // +3 boot sectors are loaded to FE00h and entered at FE10h
+	PCWBootOrigin   = 0xF000 // PCW boot sectors are loaded to F000h and entered at F010h
+	CPCBootOrigin   = 0x0100 // |CPM loads the first sector of a SYSTEM disk to 0100h and enters it there
+)
+
+// CodeOrigin returns the code in a file (without its header) and the address it loads at, using an
+// AMSDOS or +3DOS CODE header. Files without either header are assumed to load at 0
+func CodeOrigin(data []byte) ([]byte, uint16) {
+	if header := ParsePlus3DOSHeader(data); header != nil {
+		code := data[Plus3DOSHeaderSize:]
+		if header.Length <= len(code) {
+			code = code[:header.Length]
+		}
+		if header.Type == Plus3TypeCode {
+			return code, header.Param1
+		}
+		return code, 0
+	}
+	if header := ParseAMSDOSHeader(data); header != nil {
+		code := data[AMSDOSHeaderSize:]
+		if header.Length <= len(code) {
+			code = code[:header.Length]
+		}
+		return code, header.LoadAddress
+	}
+	return data, 0
+}
+
+// BootCode returns the boot sector, the address the machine loads it to and the offset of the
+// first instruction run
+func (d *DSK) BootCode() ([]byte, uint16, int, error) {
+	sector := d.bootSector()
+	if sector == nil {
+		return nil, 0, 0, fmt.Errorf("no boot sector found on track 0 side 0")
+	}
+
+	switch {
+	case sector.Info.R == 0x41:
+		return sector.Data, CPCBootOrigin, 0, nil
+	case strings.HasPrefix(bootTypeForSum(SectorSum(sector.Data)), "PCW"):
+		return sector.Data, PCWBootOrigin, BootCodeOffset, nil
+	case bootTypeForSum(SectorSum(sector.Data)) != "":
+		return sector.Data, Plus3BootOrigin, BootCodeOffset, nil
+	}
+	return nil, 0, 0, fmt.Errorf("the disk is not bootable (use --track and --sector with --origin instead)")
+}
+
+// ReadSectors returns the data of count sectors, starting with sector id on the given track and
+// continuing in ID order, then on to the following tracks of the same side
+func (d *DSK) ReadSectors(cylinder int, head int, id uint8, count int) ([]byte, error) {
+	track := d.GetTrack(cylinder, head)
+	if track == nil {
+		return nil, fmt.Errorf("track %d side %d not found", cylinder, head)
+	}
+	if track.GetSector(id) == nil {
+		return nil, fmt.Errorf("sector #%02X not found on track %d side %d", id, cylinder, head)
+	}
+
+	data := make([]byte, 0, count*512)
+	for read := 0; read < count; cylinder++ {
+		track := d.GetTrack(cylinder, head)
+		if track == nil {
+			return nil, fmt.Errorf("only %d of %d sectors found before track %d", read, count, cylinder)
+		}
+
+		sectors := make([]*LogicalSector, 0, len(track.Sectors))
+		for i := range track.Sectors {
+			if read > 0 || track.Sectors[i].Info.R >= id {
+				sectors = append(sectors, &track.Sectors[i])
+			}
+		}
+		sort.SliceStable(sectors, func(i, j int) bool { return sectors[i].Info.R < sectors[j].Info.R })
+
+		for _, sector := range sectors {
+			if read == count {
+				break
+			}
+			data = append(data, sector.Data...)
+			read++
+		}
+	}
+	return data, nil
+}
+
+// DisassembleZ80 returns a listing of code loaded at origin, with instructions starting at the
+// entry offset (bytes before it are listed as data)
+// Jump and call destinations within the code are given labels and each line ends with the
+// instruction bytes as ASCII
+func DisassembleZ80(code []byte, origin uint16, entry int) string {
+	entry = min(max(entry, 0), len(code))
+
+	instructions := make([]Z80Instruction, 0, len(code))
+	for offset := 0; offset < entry; offset += 4 {
+		instructions = append(instructions, defbInstruction(code[offset:min(offset+4, entry)], origin+uint16(offset)))
+	}
+	for offset := entry; offset < len(code); {
+		instruction := DecodeZ80(code[offset:], origin+uint16(offset))
+		instructions = append(instructions, instruction)
+		offset += len(instruction.Bytes)
+	}
+
+	// Only destinations that start an instruction can be labelled
+	starts := make(map[int]bool, len(instructions))
+	for _, instruction := range instructions {
+		starts[int(instruction.Address)] = true
+	}
+	labels := make(map[int]bool)
+	for _, instruction := range instructions {
+		if instruction.Branch && starts[instruction.Target] {
+			labels[instruction.Target] = true
+		}
+	}
+
+	var listing strings.Builder
+	fmt.Fprintf(&listing, "%-27s%-6s#%04X\n", "", "ORG", origin)
+	for _, instruction := range instructions {
+		hex := make([]string, len(instruction.Bytes))
+		ascii := make([]byte, len(instruction.Bytes))
+		for i, b := range instruction.Bytes {
+			hex[i] = fmt.Sprintf("%02X", b)
+			ascii[i] = '.'
+			if b >= 0x20 && b < 0x7F {
+				ascii[i] = b
+			}
+		}
+
+		label := ""
+		if labels[int(instruction.Address)] {
+			label = fmt.Sprintf("L%04X:", instruction.Address)
+		}
+		operands := instruction.Operands
+		if instruction.Target >= 0 {
+			target := fmt.Sprintf("#%04X", instruction.Target)
+			if labels[instruction.Target] {
+				target = fmt.Sprintf("L%04X", instruction.Target)
+			}
+			operands = strings.Replace(operands, "@", target, 1)
+		}
+
+		line := fmt.Sprintf("%04X  %-12s %-7s %-5s %-18s %s", instruction.Address, strings.Join(hex, " "), label,
+			instruction.Mnemonic, operands, ascii)
+		listing.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return listing.String()
+}
+
+// CodeRange narrows code loaded at origin to the addresses start to end inclusive (either may be -1
+// for the first or last byte), returning the new origin and entry offset
+func CodeRange(code []byte, origin uint16, entry int, start int, end int) ([]byte, uint16, int, error) {
+	last := int(origin) + len(code) - 1
+	if start < 0 {
+		start = int(origin)
+	}
+	if end < 0 || end > last {
+		end = last
+	}
+	if start < int(origin) || start > last {
+		return nil, 0, 0, fmt.Errorf("start address #%04X is outside the code at #%04X-#%04X", start, origin, last)
+	}
+	if end < start {
+		return nil, 0, 0, fmt.Errorf("end address #%04X is before the start #%04X", end, start)
+	}
+	return code[start-int(origin) : end-int(origin)+1], uint16(start), entry - (start - int(origin)), nil
+}
@@ -0,0 +1,112 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// disasm_test.go - Unit tests for Z80 disassembly listings and code sources
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDisassembleZ80(t *testing.T) {
+	code := []byte{
+		'H', 'I', // Data before the entry point
+		0x06, 0x03, // LD B,3
+		0x3E, 0x41, // LD A,'A'
+		0x10, 0xFC, // DJNZ back to LD A
+		0xCD, 0x00, 0x90, // CALL outside the code
+		0x21, 0x04, 0x80, // LD HL pointing at the labelled instruction
+		0xC9, // RET
+	}
+	listing := DisassembleZ80(code, 0x8000, 2)
+
+	expected := []string{
+		"                           ORG   #8000",
+		"8000  48 49                DEFB  #48,#49            HI",
+		"8002  06 03                LD    B,#03              ..",
+		"8004  3E 41        L8004:  LD    A,#41              >A",
+		"8006  10 FC                DJNZ  L8004              ..",
+		"8008  CD 00 90             CALL  #9000              ...",
+		"800B  21 04 80             LD    HL,L8004           !..",
+		"800E  C9                   RET                      .",
+	}
+	if lines := strings.Split(strings.TrimRight(listing, "\n"), "\n"); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), listing)
+	}
+}
+
+func TestCodeOrigin(t *testing.T) {
+	header, _ := BuildAMSDOSHeader(0, "GAME.BIN", AMSDOSTypeBinary, 0x4000, 0x4000, 3)
+	code, origin := CodeOrigin(append(header, 0xC3, 0x00, 0x40, 0x1A))
+	if origin != 0x4000 || !bytes.Equal(code, []byte{0xC3, 0x00, 0x40}) {
+		t.Errorf("expected 3 bytes at #4000, got % X at #%04X", code, origin)
+	}
+
+	header, _ = BuildPlus3DOSHeader(Plus3TypeCode, 1, 32768, 0)
+	if code, origin = CodeOrigin(append(header, 0xC9)); origin != 32768 || len(code) != 1 {
+		t.Errorf("expected 1 byte at #8000, got % X at #%04X", code, origin)
+	}
+
+	if code, origin = CodeOrigin([]byte{0xC9}); origin != 0 || len(code) != 1 {
+		t.Errorf("expected a headerless file at 0, got % X at #%04X", code, origin)
+	}
+}
+
+func TestBootCode(t *testing.T) {
+	dsk := newPlus3TestDSK()
+	if err := dsk.InstallBootCode([]byte{0xF3, 0xC9}, BootSumPlus3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	code, origin, entry, err := dsk.BootCode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if origin != Plus3BootOrigin || entry != BootCodeOffset || code[entry] != 0xF3 {
+		t.Errorf("expected code at #FE10, got #%02X at #%04X", code[entry], int(origin)+entry)
+	}
+
+	if _, _, _, err := newTestDSK(FormatExtended, 40, 1, 0xC1).BootCode(); err == nil {
+		t.Errorf("expected an error for a data disk")
+	}
+}
+
+func TestReadSectors(t *testing.T) {
+	dsk := newTestDSK(FormatExtended, 2, 1, 0xC1)
+	dsk.GetTrack(0, 0).GetSector(0xC9).Data[0] = 0x11
+	dsk.GetTrack(1, 0).GetSector(0xC1).Data[0] = 0x22
+
+	data, err := dsk.ReadSectors(0, 0, 0xC9, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(data) != 1024 || data[0] != 0x11 || data[512] != 0x22 {
+		t.Errorf("expected the last sector of track 0 then the first of track 1")
+	}
+
+	if _, err := dsk.ReadSectors(1, 0, 0xC9, 2); err == nil {
+		t.Errorf("expected an error reading past the last track")
+	}
+	if _, err := dsk.ReadSectors(0, 0, 0x01, 1); err == nil {
+		t.Errorf("expected an error for a missing sector")
+	}
+}
+
+func TestCodeRange(t *testing.T) {
+	code := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+	narrowed, origin, entry, err := CodeRange(code, 0x8000, 4, 0x8002, 0x8005)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if origin != 0x8002 || entry != 2 || !bytes.Equal(narrowed, []byte{2, 3, 4, 5}) {
+		t.Errorf("expected 02-05 at #8002 entry 2, got % X at #%04X entry %d", narrowed, origin, entry)
+	}
+
+	if _, _, _, err := CodeRange(code, 0x8000, 0, 0x9000, -1); err == nil {
+		t.Errorf("expected an error for a start outside the code")
+	}
+	if _, _, _, err := CodeRange(code, 0x8000, 0, -1, 0x100); err == nil {
+		t.Errorf("expected an error for an end before the origin")
+	}
+}
@@ -0,0 +1,257 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// diskdefs.go - cpmtools diskdefs parsing
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DiskDef is a disk definition from a cpmtools diskdefs file
+// diskdefs describe tracks over the whole disk, so the sides are taken from the DSK it is used with
+type DiskDef struct {
+	Name            string
+	SectorSize      int    // seclen
+	Tracks          int    // tracks (counted over all sides)
+	SectorsPerTrack int    // sectrk
+	BlockSize       int    // blocksize
+	MaxDir          int    // maxdir
+	Skew            int    // skew
+	SkewTable       []int  // skewtab (0-based physical sector for each logical sector)
+	BootTracks      int    // boottrk
+	Offset          int    // offset, in the unit below
+	OffsetUnit      byte   // 0 for bytes, 'T' for tracks or 'S' for sectors
+	OS              string // os: 2.2, 3, isx, p2dos or zsys
+}
+
+// ParseDiskDefs reads every "diskdef ... end" block from cpmtools diskdefs text
+func ParseDiskDefs(r io.Reader) ([]DiskDef, error) {
+	defs := make([]DiskDef, 0)
+	var current *DiskDef
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if comment := strings.IndexByte(text, '#'); comment >= 0 {
+			text = text[:comment]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		keyword := strings.ToLower(fields[0])
+
+		if current == nil {
+			if keyword != "diskdef" || len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: expected 'diskdef <name>', got '%s'", line, strings.TrimSpace(text))
+			}
+			current = &DiskDef{Name: fields[1], OS: "2.2"}
+			continue
+		}
+
+		if keyword == "end" {
+			if err := current.validate(); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			defs = append(defs, *current)
+			current = nil
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected '<keyword> <value>', got '%s'", line, strings.TrimSpace(text))
+		}
+		if err := current.set(keyword, fields[1]); err != nil {
+			return nil, fmt.Errorf("line %d: diskdef %s: %v", line, current.Name, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		return nil, fmt.Errorf("line %d: diskdef %s has no 'end'", line, current.Name)
+	}
+	return defs, nil
+}
+
+// LoadDiskDefs reads a cpmtools diskdefs file
+func LoadDiskDefs(filename string) ([]DiskDef, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	defs, err := ParseDiskDefs(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return defs, nil
+}
+
+// set applies a single keyword from a diskdef block, ignoring keywords that do not affect the filesystem
+func (def *DiskDef) set(keyword string, value string) error {
+	number := func() (int, error) {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid %s '%s'", keyword, value)
+		}
+		return n, nil
+	}
+
+	var err error
+	switch keyword {
+	case "seclen":
+		def.SectorSize, err = number()
+	case "tracks":
+		def.Tracks, err = number()
+	case "sectrk":
+		def.SectorsPerTrack, err = number()
+	case "blocksize":
+		def.BlockSize, err = number()
+	case "maxdir":
+		def.MaxDir, err = number()
+	case "skew":
+		def.Skew, err = number()
+	case "boottrk":
+		def.BootTracks, err = number()
+	case "skewtab":
+		def.SkewTable = nil
+		for _, part := range strings.Split(value, ",") {
+			physical, convErr := strconv.Atoi(part)
+			if convErr != nil || physical < 0 {
+				return fmt.Errorf("invalid skewtab entry '%s'", part)
+			}
+			def.SkewTable = append(def.SkewTable, physical)
+		}
+	case "offset":
+		digits := value
+		def.OffsetUnit = 0
+		switch unit := strings.ToUpper(value[len(value)-1:]); unit {
+		case "T", "S":
+			def.OffsetUnit = unit[0]
+			digits = value[:len(value)-1]
+		}
+		def.Offset, err = strconv.Atoi(digits)
+		if err != nil || def.Offset < 0 {
+			return fmt.Errorf("invalid offset '%s'", value)
+		}
+	case "os":
+		switch value {
+		case "2.2", "3", "isx", "p2dos", "zsys":
+			def.OS = value
+		default:
+			return fmt.Errorf("invalid os '%s'. Must be 2.2, 3, isx, p2dos or zsys", value)
+		}
+	default:
+		// Other keywords (bootsec, density, libdsk:format, logicalextents...) only matter to cpmtools
+	}
+	return err
+}
+
+// validate checks a diskdef has everything needed to describe a filesystem
+func (def *DiskDef) validate() error {
+	if def.SectorSize == 0 || def.Tracks == 0 || def.SectorsPerTrack == 0 || def.BlockSize == 0 || def.MaxDir == 0 {
+		return fmt.Errorf("diskdef %s: seclen, tracks, sectrk, blocksize and maxdir are required", def.Name)
+	}
+	if def.SkewTable != nil && len(def.SkewTable) != def.SectorsPerTrack {
+		return fmt.Errorf("diskdef %s: skewtab has %d entries for %d sectors", def.Name, len(def.SkewTable), def.SectorsPerTrack)
+	}
+	if def.OffsetUnit == 0 && def.Offset%def.SectorSize != 0 {
+		return fmt.Errorf("diskdef %s: offset %d is not a whole number of sectors", def.Name, def.Offset)
+	}
+	return nil
+}
+
+// DiskFormat builds the disk format for using the definition with a DSK
+// The number of sides comes from the DSK header (logical tracks alternate between sides)
+// and the first sector ID is the lowest found on track 0
+func (def *DiskDef) DiskFormat(d *DSK) (*DiskFormat, error) {
+	sides := int(d.Header.Sides)
+	if sides < 1 || sides > 2 || def.Tracks%sides != 0 {
+		return nil, fmt.Errorf("diskdef %s: %d tracks cannot be split over %d sides", def.Name, def.Tracks, sides)
+	}
+	sector := d.bootSector()
+	if sector == nil {
+		return nil, fmt.Errorf("diskdef %s: track 0 has no sectors", def.Name)
+	}
+
+	offsetSectors := def.Offset / def.SectorSize
+	switch def.OffsetUnit {
+	case 'T':
+		offsetSectors = def.Offset * def.SectorsPerTrack
+	case 'S':
+		offsetSectors = def.Offset
+	}
+
+	// isx, p2dos and zsys directories are read like CP/M 2.2
+	version := "2.2"
+	if def.OS == "3" {
+		version = "3"
+	}
+
+	format := &DiskFormat{
+		Name:            def.Name,
+		Sides:           sides,
+		TracksPerSide:   def.Tracks / sides,
+		SectorsPerTrack: def.SectorsPerTrack,
+		SectorSize:      def.SectorSize,
+		FirstSectorID:   sector.Info.R,
+		ReservedTracks:  def.BootTracks,
+		BlockSize:       def.BlockSize,
+		DirEntries:      def.MaxDir,
+		Skew:            def.Skew,
+		SkewTable:       def.SkewTable,
+		OffsetSectors:   offsetSectors,
+		OS:              version,
+	}
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	return format, nil
+}
+
+// SelectDiskFormat returns the format to use for a DSK: the named diskdef or registered profile,
+// or the detected format when no name is given
+func SelectDiskFormat(d *DSK, name string, defs []DiskDef) (*DiskFormat, error) {
+	if name == "" {
+		return DetectDiskFormat(d)
+	}
+
+	for i := range defs {
+		if defs[i].Name == name {
+			return defs[i].DiskFormat(d)
+		}
+	}
+	for i := range defs {
+		if normaliseFormatName(defs[i].Name) == normaliseFormatName(name) {
+			return defs[i].DiskFormat(d)
+		}
+	}
+	return FindDiskFormat(name)
+}
+
+// ListDiskDefs prints diskdefs to the console
+func ListDiskDefs(defs []DiskDef) {
+	fmt.Println("Name             Tracks Sectors  Size Block  Dir Boot Skew Offset OS")
+	for _, def := range defs {
+		skew := strconv.Itoa(def.Skew)
+		if def.SkewTable != nil {
+			skew = "tab"
+		}
+		offset := strconv.Itoa(def.Offset)
+		if def.OffsetUnit != 0 {
+			offset += string(def.OffsetUnit)
+		}
+		fmt.Printf("%-16s %6d %7d %5d %5d %4d %4d %4s %6s %s\n",
+			def.Name, def.Tracks, def.SectorsPerTrack, def.SectorSize, def.BlockSize, def.MaxDir,
+			def.BootTracks, skew, offset, def.OS)
+	}
+}
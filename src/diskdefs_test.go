@@ -0,0 +1,145 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// diskdefs_test.go - Unit tests for cpmtools diskdefs parsing
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testDiskDefs = `
+# Tatung Einstein
+diskdef einstein
+  seclen 512
+  tracks 40
+  sectrk 10
+  blocksize 1024
+  maxdir 64
+  skew 1
+  boottrk 2
+  os 2.2
+  bootsec 128
+  density dd
+end
+
+diskdef memotech-type50
+  seclen 512
+  tracks 80
+  sectrk 9
+  blocksize 2048
+  maxdir 128
+  skewtab 0,2,4,6,8,1,3,5,7
+  boottrk 1
+  offset 9S
+  os 3
+  libdsk:format memotech
+end
+`
+
+func TestParseDiskDefs(t *testing.T) {
+	defs, err := ParseDiskDefs(strings.NewReader(testDiskDefs))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(defs) != 2 {
+		t.Fatalf("expected 2 diskdefs, got %d", len(defs))
+	}
+
+	einstein := defs[0]
+	if einstein.Name != "einstein" || einstein.SectorsPerTrack != 10 || einstein.BootTracks != 2 || einstein.OS != "2.2" {
+		t.Errorf("unexpected einstein diskdef: %+v", einstein)
+	}
+
+	memotech := defs[1]
+	if !reflect.DeepEqual(memotech.SkewTable, []int{0, 2, 4, 6, 8, 1, 3, 5, 7}) {
+		t.Errorf("unexpected skew table: %v", memotech.SkewTable)
+	}
+	if memotech.Offset != 9 || memotech.OffsetUnit != 'S' || memotech.OS != "3" {
+		t.Errorf("unexpected offset %d%c or os %s", memotech.Offset, memotech.OffsetUnit, memotech.OS)
+	}
+}
+
+func TestParseDiskDefsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		errorMsg string
+	}{
+		{"bad number", "diskdef x\n  seclen 512\n  tracks forty\nend\n", "line 3: diskdef x: invalid tracks 'forty'"},
+		{"missing end", "diskdef x\n  seclen 512\n", "has no 'end'"},
+		{"missing geometry", "diskdef x\n  seclen 512\nend\n", "are required"},
+		{"bad os", "diskdef x\n  os 4\nend\n", "invalid os"},
+		{"short skewtab", "diskdef x\n  seclen 512\n  tracks 40\n  sectrk 9\n  blocksize 1024\n  maxdir 64\n  skewtab 0,1,2\nend\n", "skewtab has 3 entries"},
+		{"text outside diskdef", "seclen 512\n", "expected 'diskdef <name>'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDiskDefs(strings.NewReader(tt.text))
+			if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errorMsg, err)
+			}
+		})
+	}
+}
+
+func TestDiskDefFormat(t *testing.T) {
+	defs, err := ParseDiskDefs(strings.NewReader(testDiskDefs))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Memotech: 80 tracks over 2 sides, 9 sectors skipped before the boot track
+	dsk := newTestDSK(FormatExtended, 40, 2, 0x01)
+	format, err := SelectDiskFormat(dsk, "memotech-type50", defs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if format.Sides != 2 || format.TracksPerSide != 40 || format.FirstSectorID != 0x01 || format.OffsetSectors != 9 || format.OS != "3" {
+		t.Errorf("unexpected format: %+v", format)
+	}
+	if blocks := (80*9 - 9 - 9) * 512 / 2048; format.TotalBlocks() != blocks {
+		t.Errorf("expected %d blocks, got %d", blocks, format.TotalBlocks())
+	}
+
+	fs, err := NewCPMFileSystem(dsk, format)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Block 0 starts at logical sector 18: track 2 is cylinder 1 side 0, logical sector 0 is physical sector 0
+	if cylinder, head, id := fs.sectorLocation(fs.blockSectors(0)[0]); cylinder != 1 || head != 0 || id != 0x01 {
+		t.Errorf("expected block 0 at 1/0/01, got %d/%d/%02X", cylinder, head, id)
+	}
+	// Logical sector 1 of a track is physical sector 2 through the skew table
+	if _, _, id := fs.sectorLocation(fs.blockSectors(0)[1]); id != 0x03 {
+		t.Errorf("expected the second sector of block 0 to be 03, got %02X", id)
+	}
+
+	if _, err := SelectDiskFormat(dsk, "CPC DATA", defs); err != nil {
+		t.Errorf("expected fallback to built-in profile, got %v", err)
+	}
+	defs[0].Tracks = 41
+	if _, err := SelectDiskFormat(dsk, "einstein", defs); err == nil {
+		t.Errorf("expected error for 41 tracks over 2 sides")
+	}
+}
+
+func TestCPM3LastRecordBytes(t *testing.T) {
+	fs, err := NewCPMFileSystem(newTestDSK(FormatExtended, 40, 1, 0xC1), &DiskFormat{Name: "cpm3", Sides: 1,
+		TracksPerSide: 40, SectorsPerTrack: 9, SectorSize: 512, FirstSectorID: 0xC1, BlockSize: 1024, DirEntries: 64, OS: "3"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry := newTestDirEntry(0, "TEXT", "TXT", 0, 3, 2)
+	entry.Raw[13] = 10 // 10 bytes used in the last record
+	writeTestDirEntries(t, fs, entry)
+
+	files, _ := fs.Files()
+	if len(files) != 1 || files[0].Size() != 2*128+10 {
+		t.Errorf("expected 266 bytes, got %v", files)
+	}
+}
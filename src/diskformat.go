@@ -0,0 +1,324 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// diskformat.go - Disk geometry and CP/M disk parameter blocks
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import "fmt"
+
+// SideOrder describes how logical tracks are laid out over the sides of a double-sided disk
+type SideOrder int
+
+const (
+	// SideOrderAlternate alternates sides for each cylinder (0/0, 0/1, 1/0, 1/1...)
+	SideOrderAlternate SideOrder = iota
+	// SideOrderSuccessive uses every cylinder on side 0 and then every cylinder on side 1
+	SideOrderSuccessive
+)
+
+// String returns a human-readable name for the side order
+func (o SideOrder) String() string {
+	switch o {
+	case SideOrderAlternate:
+		return "alternate"
+	case SideOrderSuccessive:
+		return "successive"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(o))
+	}
+}
+
+// FileSystemType identifies the filesystem a disk format carries
+type FileSystemType int
+
+const (
+	// FileSystemCPM is a CP/M 2.2 or 3 directory and allocation blocks (AMSDOS, +3DOS and friends)
+	FileSystemCPM FileSystemType = iota
+	// FileSystemFAT12 is an MS-DOS style FAT12 filesystem (MSX-DOS)
+	FileSystemFAT12
+)
+
+// String returns a human-readable name for the filesystem type
+func (t FileSystemType) String() string {
+	switch t {
+	case FileSystemCPM:
+		return "CP/M"
+	case FileSystemFAT12:
+		return "FAT12"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(t))
+	}
+}
+
+// DiskFormat describes the geometry of a disk and the CP/M disk parameter block used to read it
+type DiskFormat struct {
+	Name            string
+	Machine         string // Family of machines that use the format (CPC, +3, PCW, MSX)
+	Sides           int
+	TracksPerSide   int
+	SectorsPerTrack int
+	SectorSize      int
+	FirstSectorID   uint8
+	SideOrder       SideOrder
+	ReservedTracks  int
+	BlockSize       int
+	DirEntries      int
+	Skew            int    // Logical sector skew within a track (0 or 1 for none)
+	SkewTable       []int  // Explicit physical sector index for each logical sector (overrides Skew)
+	OffsetSectors   int    // Sectors to skip at the start of the disk before the reserved tracks
+	OS              string // CP/M version of the directory: "2.2" (default) or "3"
+	FileSystem      FileSystemType
+	GapReadWrite    uint8 // GAP#3 length for read/write (0 when unknown)
+	GapFormat       uint8 // GAP#3 length for format (0 when unknown)
+}
+
+// Built-in formats used when no specification block is present
+var (
+	// FormatCPCData is the AMSDOS DATA format (178K, sector IDs #C1-#C9, no reserved tracks)
+	FormatCPCData = DiskFormat{
+		Name:            "CPC DATA",
+		Machine:         "CPC",
+		Sides:           1,
+		TracksPerSide:   40,
+		SectorsPerTrack: 9,
+		SectorSize:      512,
+		FirstSectorID:   0xC1,
+		ReservedTracks:  0,
+		BlockSize:       1024,
+		DirEntries:      64,
+		GapReadWrite:    0x2A,
+		GapFormat:       0x52,
+	}
+
+	// FormatCPCSystem is the AMSDOS SYSTEM format (169K, sector IDs #41-#49, 2 reserved tracks for CP/M)
+	FormatCPCSystem = DiskFormat{
+		Name:            "CPC SYSTEM",
+		Machine:         "CPC",
+		Sides:           1,
+		TracksPerSide:   40,
+		SectorsPerTrack: 9,
+		SectorSize:      512,
+		FirstSectorID:   0x41,
+		ReservedTracks:  2,
+		BlockSize:       1024,
+		DirEntries:      64,
+		GapReadWrite:    0x2A,
+		GapFormat:       0x52,
+	}
+)
+
+// TotalBlocks returns the number of allocation blocks on the disk (DSM + 1)
+func (f *DiskFormat) TotalBlocks() int {
+	dataSectors := (f.TracksPerSide*f.Sides-f.ReservedTracks)*f.SectorsPerTrack - f.OffsetSectors
+	return dataSectors * f.SectorSize / f.BlockSize
+}
+
+// DirectoryBlocks returns the number of allocation blocks reserved for the directory
+func (f *DiskFormat) DirectoryBlocks() int {
+	return (f.DirEntries*DirEntrySize + f.BlockSize - 1) / f.BlockSize
+}
+
+// WideBlockPointers reports whether directory entries use 16-bit block numbers (more than 256 blocks)
+func (f *DiskFormat) WideBlockPointers() bool {
+	return f.TotalBlocks() > 256
+}
+
+// ExtentMask returns the CP/M EXM value - the number of extra 16K logical extents each directory entry holds
+func (f *DiskFormat) ExtentMask() int {
+	pointers := 16
+	if f.WideBlockPointers() {
+		pointers = 8
+	}
+	return f.BlockSize*pointers/16384 - 1
+}
+
+// Validate checks the format describes a usable geometry
+func (f *DiskFormat) Validate() error {
+	if f.FileSystem != FileSystemCPM {
+		return fmt.Errorf("format %s: %s filesystems are not supported", f.Name, f.FileSystem)
+	}
+	if f.Sides < 1 || f.Sides > 2 {
+		return fmt.Errorf("format %s: invalid number of sides: %d", f.Name, f.Sides)
+	}
+	if f.TracksPerSide < 1 || f.SectorsPerTrack < 1 || f.SectorSize < 128 {
+		return fmt.Errorf("format %s: invalid geometry %dx%dx%d", f.Name, f.TracksPerSide, f.SectorsPerTrack, f.SectorSize)
+	}
+	if f.BlockSize < 1024 || f.BlockSize&(f.BlockSize-1) != 0 {
+		return fmt.Errorf("format %s: invalid block size: %d", f.Name, f.BlockSize)
+	}
+	if f.WideBlockPointers() && f.BlockSize == 1024 {
+		// CP/M has no extent mask for 1K blocks with 16-bit block pointers
+		return fmt.Errorf("format %s: 1K blocks cannot address %d blocks, at most 256", f.Name, f.TotalBlocks())
+	}
+	if f.ReservedTracks >= f.TracksPerSide*f.Sides {
+		return fmt.Errorf("format %s: too many reserved tracks: %d", f.Name, f.ReservedTracks)
+	}
+	if f.DirEntries < 1 || f.DirectoryBlocks() > 16 || f.DirectoryBlocks() >= f.TotalBlocks() {
+		return fmt.Errorf("format %s: invalid number of directory entries: %d", f.Name, f.DirEntries)
+	}
+	if f.Skew < 0 || f.Skew >= f.SectorsPerTrack {
+		return fmt.Errorf("format %s: invalid skew: %d", f.Name, f.Skew)
+	}
+	if f.SkewTable != nil {
+		seen := make(map[int]bool)
+		for _, physical := range f.SkewTable {
+			if physical < 0 || physical >= f.SectorsPerTrack || seen[physical] {
+				return fmt.Errorf("format %s: skew table must list each sector 0-%d once", f.Name, f.SectorsPerTrack-1)
+			}
+			seen[physical] = true
+		}
+		if len(f.SkewTable) != f.SectorsPerTrack {
+			return fmt.Errorf("format %s: skew table has %d entries for %d sectors", f.Name, len(f.SkewTable), f.SectorsPerTrack)
+		}
+	}
+	if f.OffsetSectors < 0 {
+		return fmt.Errorf("format %s: invalid offset: %d sectors", f.Name, f.OffsetSectors)
+	}
+	if f.OS != "" && f.OS != "2.2" && f.OS != "3" {
+		return fmt.Errorf("format %s: invalid os '%s'. Must be 2.2 or 3", f.Name, f.OS)
+	}
+	return nil
+}
+
+// skewTable returns the physical sector index for each logical sector index within a track
+// using the CP/M skew rule (each sector is Skew on from the last, moving to the next unused one)
+func (f *DiskFormat) skewTable() []int {
+	if f.SkewTable != nil {
+		return f.SkewTable
+	}
+	table := make([]int, f.SectorsPerTrack)
+	if f.Skew <= 1 {
+		for i := range table {
+			table[i] = i
+		}
+		return table
+	}
+
+	used := make([]bool, f.SectorsPerTrack)
+	physical := 0
+	for i := range table {
+		for used[physical] {
+			physical = (physical + 1) % f.SectorsPerTrack
+		}
+		table[i] = physical
+		used[physical] = true
+		physical = (physical + f.Skew) % f.SectorsPerTrack
+	}
+	return table
+}
+
+// Specification returns the +3/PCW specification block describing the format
+// nil is returned when the format cannot be expressed by one
+func (f *DiskFormat) Specification() *Specification {
+	sizeCode := 0
+	for 128<<sizeCode < f.SectorSize {
+		sizeCode++
+	}
+	blockShift := 0
+	for 128<<blockShift < f.BlockSize {
+		blockShift++
+	}
+	if f.FileSystem != FileSystemCPM || f.Sides < 1 || f.Sides > 2 || 128<<sizeCode != f.SectorSize ||
+		128<<blockShift != f.BlockSize || f.TracksPerSide > 255 || f.SectorsPerTrack > 255 {
+		return nil
+	}
+
+	spec := &Specification{
+		Format:          SpecFormatPCW_SS,
+		Side:            SpecSideSingle,
+		Track:           SpecTrackSingle,
+		TracksPerSide:   uint8(f.TracksPerSide),
+		SectorsPerTrack: uint8(f.SectorsPerTrack),
+		SectorSize:      uint16(f.SectorSize),
+		ReservedTracks:  uint8(f.ReservedTracks),
+		BlockShift:      uint8(blockShift),
+		DirectoryBlocks: uint8(f.DirectoryBlocks()),
+		GapReadWrite:    f.GapReadWrite,
+		GapFormat:       f.GapFormat,
+	}
+	switch {
+	case f.FirstSectorID == FormatCPCSystem.FirstSectorID:
+		spec.Format = SpecFormatCPC_System
+	case f.FirstSectorID == FormatCPCData.FirstSectorID:
+		spec.Format = SpecFormatCPC_Data
+	case f.Sides == 2:
+		spec.Format = SpecFormatPCW_DS
+	}
+	if f.Sides == 2 {
+		spec.Side = SpecSideDoubleAlternate
+		if f.SideOrder == SideOrderSuccessive {
+			spec.Side = SpecSideDoubleSuccessive
+		}
+	}
+	if f.TracksPerSide > 42 {
+		spec.Track = SpecTrackDouble
+	}
+	return spec
+}
+
+// formatFromSpecification builds a disk format from a +3/PCW specification block
+func formatFromSpecification(spec *Specification) *DiskFormat {
+	format := &DiskFormat{
+		Name:            "+3/PCW (specification)",
+		Sides:           1,
+		TracksPerSide:   int(spec.TracksPerSide),
+		SectorsPerTrack: int(spec.SectorsPerTrack),
+		SectorSize:      int(spec.SectorSize),
+		FirstSectorID:   1,
+		ReservedTracks:  int(spec.ReservedTracks),
+		BlockSize:       spec.BlockSize(),
+		DirEntries:      int(spec.DirectoryBlocks) * spec.BlockSize() / DirEntrySize,
+	}
+
+	switch spec.Side {
+	case SpecSideDoubleAlternate:
+		format.Sides = 2
+	case SpecSideDoubleSuccessive:
+		format.Sides = 2
+		format.SideOrder = SideOrderSuccessive
+	}
+
+	switch spec.Format {
+	case SpecFormatCPC_System:
+		format.FirstSectorID = FormatCPCSystem.FirstSectorID
+	case SpecFormatCPC_Data:
+		format.FirstSectorID = FormatCPCData.FirstSectorID
+	}
+
+	return format
+}
+
+// DetectDiskFormat picks the disk format for a DSK from its sector IDs and specification block
+// Sector IDs #41 mean CPC SYSTEM, #C1 mean CPC DATA and a valid specification block means +3/PCW,
+// otherwise the first CP/M format in the registry matching the geometry is used
+func DetectDiskFormat(d *DSK) (*DiskFormat, error) {
+	sector := d.bootSector()
+	if sector == nil {
+		return nil, fmt.Errorf("unable to detect disk format: track 0 has no sectors")
+	}
+
+	switch sector.Info.R {
+	case 0x41:
+		format := FormatCPCSystem
+		return &format, nil
+	case 0xC1:
+		format := FormatCPCData
+		return &format, nil
+	}
+
+	if d.Specification != nil {
+		format := formatFromSpecification(d.Specification)
+		if err := format.Validate(); err != nil {
+			return nil, fmt.Errorf("unable to use specification block: %v", err)
+		}
+		return format, nil
+	}
+
+	for _, format := range MatchDiskFormats(d) {
+		if format.FileSystem == FileSystemCPM {
+			return &format, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unable to detect disk format: %s", d.specificationMissingReason())
+}
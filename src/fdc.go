@@ -0,0 +1,137 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// fdc.go - uPD765 FDC status register ST1/ST2 flag names
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// FDCFlag is a bit of an FDC status register
+type FDCFlag struct {
+	Bit         uint8
+	Code        string // Abbreviation used by the uPD765 datasheet
+	Name        string // Name used in the sector meta files
+	Description string
+}
+
+// Status register bits that together mark a sector read with a data CRC error
+const (
+	FDCStatus1DataError            uint8 = 0x20 // DE
+	FDCStatus2DataErrorInDataField uint8 = 0x20 // DD
+)
+
+// FDCStatus1Flags are the bits of status register 1 (bits 3 and 6 are unused)
+var FDCStatus1Flags = []FDCFlag{
+	{0x80, "EN", "end_of_cylinder", "End of Cylinder"},
+	{FDCStatus1DataError, "DE", "data_error", "Data Error (CRC)"},
+	{0x10, "OR", "overrun", "Overrun"},
+	{0x04, "ND", "no_data", "No Data"},
+	{0x02, "NW", "not_writable", "Not Writable"},
+	{0x01, "MA", "missing_address_mark", "Missing Address Mark"},
+}
+
+// FDCStatus2Flags are the bits of status register 2 (bit 7 is unused)
+var FDCStatus2Flags = []FDCFlag{
+	{0x40, "CM", "control_mark", "Control Mark (deleted data)"},
+	{FDCStatus2DataErrorInDataField, "DD", "data_error_in_data_field", "Data Error in Data Field"},
+	{0x10, "WC", "wrong_cylinder", "Wrong Cylinder"},
+	{0x08, "SH", "scan_equal_hit", "Scan Equal Hit"},
+	{0x04, "SN", "scan_not_satisfied", "Scan Not Satisfied"},
+	{0x02, "BC", "bad_cylinder", "Bad Cylinder"},
+	{0x01, "MD", "missing_data_address_mark", "Missing Address Mark in Data Field"},
+}
+
+// FDCFlagNames returns the meta names of the bits set in a status register
+// Unused bits are named bit_N so that every value can be written back
+func FDCFlagNames(value uint8, flags []FDCFlag) []string {
+	names := make([]string, 0)
+	for bit := 7; bit >= 0; bit-- {
+		mask := uint8(1 << bit)
+		if value&mask == 0 {
+			continue
+		}
+		name := fmt.Sprintf("bit_%d", bit)
+		for _, flag := range flags {
+			if flag.Bit == mask {
+				name = flag.Name
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// DescribeFDCStatus returns the descriptions of the bits set in a status register, comma separated
+func DescribeFDCStatus(value uint8, flags []FDCFlag) string {
+	descriptions := make([]string, 0)
+	for _, name := range FDCFlagNames(value, flags) {
+		description := name
+		for _, flag := range flags {
+			if flag.Name == name {
+				description = flag.Description
+			}
+		}
+		descriptions = append(descriptions, description)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// ParseFDCFlags converts flag names (meta names, datasheet abbreviations or bit_N, in any case)
+// back to a status register value
+func ParseFDCFlags(names []string, flags []FDCFlag) (uint8, error) {
+	value := uint8(0)
+	for _, name := range names {
+		if bit, ok := strings.CutPrefix(strings.ToLower(name), "bit_"); ok {
+			if len(bit) != 1 || bit[0] < '0' || bit[0] > '7' {
+				return 0, fmt.Errorf("invalid FDC flag '%s'. Unnamed bits must be bit_0 to bit_7", name)
+			}
+			value |= 1 << (bit[0] - '0')
+			continue
+		}
+
+		found := false
+		for _, flag := range flags {
+			if strings.EqualFold(name, flag.Name) || strings.EqualFold(name, flag.Code) {
+				value |= flag.Bit
+				found = true
+			}
+		}
+		if !found {
+			known := make([]string, len(flags))
+			for i, flag := range flags {
+				known[i] = flag.Name
+			}
+			return 0, fmt.Errorf("unknown FDC flag '%s'. Must be one of: %s", name, strings.Join(known, ", "))
+		}
+	}
+	return value, nil
+}
+
+// parseFDCStatusMeta reads a status register from sector meta, which may be a number (as written
+// by older versions) or an array of flag names
+func parseFDCStatusMeta(value interface{}, flags []FDCFlag) (uint8, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		if v < 0 || v > 0xFF || v != math.Trunc(v) {
+			return 0, fmt.Errorf("FDC status must be a whole number from 0 to 255, got %v", v)
+		}
+		return uint8(v), nil
+	case []interface{}:
+		names := make([]string, len(v))
+		for i, name := range v {
+			s, ok := name.(string)
+			if !ok {
+				return 0, fmt.Errorf("FDC flags must be names, got %v", name)
+			}
+			names[i] = s
+		}
+		return ParseFDCFlags(names, flags)
+	}
+	return 0, fmt.Errorf("FDC status must be a number or an array of flag names, got %v", value)
+}
@@ -0,0 +1,106 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// fdc_test.go - Unit tests for FDC status register flag names
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFDCFlagNames(t *testing.T) {
+	if names := FDCFlagNames(0xA0, FDCStatus1Flags); !reflect.DeepEqual(names, []string{"end_of_cylinder", "data_error"}) {
+		t.Errorf("expected end_of_cylinder and data_error, got %v", names)
+	}
+	if names := FDCFlagNames(0x00, FDCStatus2Flags); len(names) != 0 {
+		t.Errorf("expected no flags, got %v", names)
+	}
+	// Unused bits still have a name so the value can be written back
+	if names := FDCFlagNames(0x48, FDCStatus1Flags); !reflect.DeepEqual(names, []string{"bit_6", "bit_3"}) {
+		t.Errorf("expected bit_6 and bit_3, got %v", names)
+	}
+	if description := DescribeFDCStatus(0x41, FDCStatus2Flags); description != "Control Mark (deleted data), Missing Address Mark in Data Field" {
+		t.Errorf("unexpected description '%s'", description)
+	}
+}
+
+func TestParseFDCFlags(t *testing.T) {
+	for value := 0; value < 0x100; value++ {
+		for _, flags := range [][]FDCFlag{FDCStatus1Flags, FDCStatus2Flags} {
+			parsed, err := ParseFDCFlags(FDCFlagNames(uint8(value), flags), flags)
+			if err != nil || parsed != uint8(value) {
+				t.Fatalf("%02X: round trip gave %02X, %v", value, parsed, err)
+			}
+		}
+	}
+
+	if value, err := ParseFDCFlags([]string{"DE", "No_Data"}, FDCStatus1Flags); err != nil || value != 0x24 {
+		t.Errorf("expected 24, got %02X, %v", value, err)
+	}
+	if _, err := ParseFDCFlags([]string{"control_mark"}, FDCStatus1Flags); err == nil {
+		t.Errorf("expected an error for an ST2 flag in ST1")
+	}
+	for _, name := range []string{"bit_3x", "bit_8", "bit_-1", "bit_+3", "bit_"} {
+		if _, err := ParseFDCFlags([]string{name}, FDCStatus1Flags); err == nil {
+			t.Errorf("expected an error for %s", name)
+		}
+	}
+}
+
+func TestParseFDCStatusMeta(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       interface{}
+		expected    uint8
+		expectError bool
+	}{
+		{"missing", nil, 0x00, false},
+		{"number", float64(0x40), 0x40, false},
+		{"too large", float64(0x100), 0, true},
+		{"negative", float64(-1), 0, true},
+		{"fraction", 1.5, 0, true},
+		{"names", []interface{}{"control_mark", "MD"}, 0x41, false},
+		{"empty", []interface{}{}, 0x00, false},
+		{"unknown name", []interface{}{"wobbly"}, 0, true},
+		{"not a name", []interface{}{float64(1)}, 0, true},
+		{"string", "control_mark", 0, true},
+	}
+	for _, tt := range tests {
+		value, err := parseFDCStatusMeta(tt.value, FDCStatus2Flags)
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil || value != tt.expected {
+			t.Errorf("%s: expected %02X, got %02X, %v", tt.name, tt.expected, value, err)
+		}
+	}
+}
+
+func TestUnpackFDCStatus(t *testing.T) {
+	dsk := newTestDSK(FormatExtended, 1, 1, 0xC1)
+	sectors := dsk.GetTrack(0, 0).Sectors
+	sectors[2].Info.FDCStatus1 = 0xA0
+	sectors[2].Info.FDCStatus2 = 0x60
+	sectors[5].Info.FDCStatus1 = 0x08
+
+	dir := t.TempDir()
+	if err := dsk.Unpack("test.dsk", dir, "binary"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	packed, err := ReadUnpacked(filepath.Join(dir, "test"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, sector := range sectors {
+		result := packed.GetTrack(0, 0).GetSector(sector.Info.R)
+		if result.Info.FDCStatus1 != sector.Info.FDCStatus1 || result.Info.FDCStatus2 != sector.Info.FDCStatus2 {
+			t.Errorf("sector %02X: expected %02X %02X, got %02X %02X", sector.Info.R,
+				sector.Info.FDCStatus1, sector.Info.FDCStatus2, result.Info.FDCStatus1, result.Info.FDCStatus2)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// formats.go - Registry of named disk format profiles
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FormatsEnvironmentVariable names a JSON file of custom profiles loaded at startup
+const FormatsEnvironmentVariable = "MAGNEATO_FORMATS"
+
+// DiskFormats is the registry of known disk formats, searched in order when matching a disk
+var DiskFormats = []DiskFormat{
+	FormatCPCData,
+	FormatCPCSystem,
+	{
+		Name: "CPC IBM", Sides: 1, TracksPerSide: 40, SectorsPerTrack: 8, SectorSize: 512, FirstSectorID: 0x01,
+		Machine: "CPC", ReservedTracks: 1, BlockSize: 1024, DirEntries: 64, GapReadWrite: 0x2A, GapFormat: 0x50,
+	},
+	{
+		Name: "+3 180K", Sides: 1, TracksPerSide: 40, SectorsPerTrack: 9, SectorSize: 512, FirstSectorID: 0x01,
+		Machine: "+3", ReservedTracks: 1, BlockSize: 1024, DirEntries: 64, GapReadWrite: 0x2A, GapFormat: 0x52,
+	},
+	{
+		Name: "PCW 180K", Sides: 1, TracksPerSide: 40, SectorsPerTrack: 9, SectorSize: 512, FirstSectorID: 0x01,
+		Machine: "PCW", ReservedTracks: 1, BlockSize: 1024, DirEntries: 64, GapReadWrite: 0x2A, GapFormat: 0x52,
+	},
+	{
+		Name: "PCW 720K", Sides: 2, TracksPerSide: 80, SectorsPerTrack: 9, SectorSize: 512, FirstSectorID: 0x01,
+		Machine: "PCW", ReservedTracks: 1, BlockSize: 2048, DirEntries: 256, GapReadWrite: 0x2A, GapFormat: 0x52,
+	},
+	{
+		Name: "Vortex", Sides: 2, TracksPerSide: 80, SectorsPerTrack: 9, SectorSize: 512, FirstSectorID: 0x01,
+		Machine: "CPC", ReservedTracks: 2, BlockSize: 4096, DirEntries: 256, GapReadWrite: 0x2A, GapFormat: 0x52,
+	},
+	{
+		Name: "ROMDOS D1", Sides: 2, TracksPerSide: 80, SectorsPerTrack: 9, SectorSize: 512, FirstSectorID: 0x01,
+		Machine: "CPC", ReservedTracks: 0, BlockSize: 2048, DirEntries: 128, GapReadWrite: 0x2A, GapFormat: 0x52,
+	},
+	{
+		Name: "ROMDOS D2", Sides: 2, TracksPerSide: 80, SectorsPerTrack: 9, SectorSize: 512, FirstSectorID: 0x21,
+		Machine: "CPC", ReservedTracks: 0, BlockSize: 4096, DirEntries: 256, GapReadWrite: 0x2A, GapFormat: 0x52,
+	},
+	{
+		Name: "Parados 80", Sides: 1, TracksPerSide: 80, SectorsPerTrack: 10, SectorSize: 512, FirstSectorID: 0x91,
+		Machine: "CPC", ReservedTracks: 0, BlockSize: 2048, DirEntries: 128, GapReadWrite: 0x2A, GapFormat: 0x52,
+	},
+	{
+		Name: "MSX-DOS", Sides: 2, TracksPerSide: 80, SectorsPerTrack: 9, SectorSize: 512, FirstSectorID: 0x01,
+		Machine: "MSX", FileSystem: FileSystemFAT12, GapReadWrite: 0x2A, GapFormat: 0x50,
+	},
+}
+
+// normaliseFormatName reduces a format name to lower case letters and digits so that
+// "CPC DATA", "cpc-data" and "cpcdata" all refer to the same profile
+func normaliseFormatName(name string) string {
+	var normalised strings.Builder
+	for _, c := range strings.ToLower(name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			normalised.WriteRune(c)
+		}
+	}
+	return normalised.String()
+}
+
+// FindDiskFormat returns a copy of the registered format with the given name
+func FindDiskFormat(name string) (*DiskFormat, error) {
+	for _, format := range DiskFormats {
+		if normaliseFormatName(format.Name) == normaliseFormatName(name) {
+			found := format
+			return &found, nil
+		}
+	}
+
+	names := make([]string, len(DiskFormats))
+	for i, format := range DiskFormats {
+		names[i] = format.Name
+	}
+	return nil, fmt.Errorf("unknown disk format '%s'. Must be one of: %s", name, strings.Join(names, ", "))
+}
+
+// RegisterDiskFormat adds a format to the registry, replacing any existing format with the same name
+func RegisterDiskFormat(format DiskFormat) {
+	for i := range DiskFormats {
+		if normaliseFormatName(DiskFormats[i].Name) == normaliseFormatName(format.Name) {
+			DiskFormats[i] = format
+			return
+		}
+	}
+	DiskFormats = append(DiskFormats, format)
+}
+
+// formatProfileJSON is a custom profile as stored in a JSON formats file
+// Fields left out are taken from the "base" profile (if given)
+type formatProfileJSON struct {
+	Name            string  `json:"name"`
+	Base            string  `json:"base"`
+	Machine         *string `json:"machine"`
+	Sides           *int    `json:"sides"`
+	TracksPerSide   *int    `json:"tracks_per_side"`
+	SectorsPerTrack *int    `json:"sectors_per_track"`
+	SectorSize      *int    `json:"sector_size"`
+	FirstSectorID   *int    `json:"first_sector_id"`
+	SideOrder       *string `json:"side_order"`
+	ReservedTracks  *int    `json:"reserved_tracks"`
+	BlockSize       *int    `json:"block_size"`
+	DirEntries      *int    `json:"dir_entries"`
+	Skew            *int    `json:"skew"`
+	FileSystem      *string `json:"filesystem"`
+	GapReadWrite    *int    `json:"gap_read_write"`
+	GapFormat       *int    `json:"gap_format"`
+}
+
+// diskFormat converts the JSON profile to a disk format
+func (p *formatProfileJSON) diskFormat() (DiskFormat, error) {
+	if p.Name == "" {
+		return DiskFormat{}, fmt.Errorf("profile has no name")
+	}
+
+	var format DiskFormat
+	if p.Base != "" {
+		base, err := FindDiskFormat(p.Base)
+		if err != nil {
+			return DiskFormat{}, fmt.Errorf("profile %s: %v", p.Name, err)
+		}
+		format = *base
+	}
+	format.Name = p.Name
+
+	ints := []struct {
+		value  *int
+		target *int
+	}{
+		{p.Sides, &format.Sides},
+		{p.TracksPerSide, &format.TracksPerSide},
+		{p.SectorsPerTrack, &format.SectorsPerTrack},
+		{p.SectorSize, &format.SectorSize},
+		{p.ReservedTracks, &format.ReservedTracks},
+		{p.BlockSize, &format.BlockSize},
+		{p.DirEntries, &format.DirEntries},
+		{p.Skew, &format.Skew},
+	}
+	for _, field := range ints {
+		if field.value != nil {
+			*field.target = *field.value
+		}
+	}
+
+	bytes := []struct {
+		name   string
+		value  *int
+		target *uint8
+	}{
+		{"first_sector_id", p.FirstSectorID, &format.FirstSectorID},
+		{"gap_read_write", p.GapReadWrite, &format.GapReadWrite},
+		{"gap_format", p.GapFormat, &format.GapFormat},
+	}
+	for _, field := range bytes {
+		if field.value != nil {
+			if *field.value < 0 || *field.value > 255 {
+				return DiskFormat{}, fmt.Errorf("profile %s: invalid %s: %d", p.Name, field.name, *field.value)
+			}
+			*field.target = uint8(*field.value)
+		}
+	}
+
+	if p.Machine != nil {
+		format.Machine = *p.Machine
+	}
+	if p.SideOrder != nil {
+		switch strings.ToLower(*p.SideOrder) {
+		case "alternate":
+			format.SideOrder = SideOrderAlternate
+		case "successive":
+			format.SideOrder = SideOrderSuccessive
+		default:
+			return DiskFormat{}, fmt.Errorf("profile %s: invalid side_order '%s'. Must be alternate or successive", p.Name, *p.SideOrder)
+		}
+	}
+	if p.FileSystem != nil {
+		switch strings.ToLower(*p.FileSystem) {
+		case "cpm", "cp/m":
+			format.FileSystem = FileSystemCPM
+		case "fat12":
+			format.FileSystem = FileSystemFAT12
+		default:
+			return DiskFormat{}, fmt.Errorf("profile %s: invalid filesystem '%s'. Must be cpm or fat12", p.Name, *p.FileSystem)
+		}
+	}
+
+	if format.FileSystem == FileSystemCPM {
+		if err := format.Validate(); err != nil {
+			return DiskFormat{}, err
+		}
+	}
+	return format, nil
+}
+
+// LoadDiskFormats reads custom profiles from a JSON file (an array of profiles) into the registry
+// Profiles with the name of an existing format replace it
+func LoadDiskFormats(filename string) ([]DiskFormat, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []formatProfileJSON
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	loaded := make([]DiskFormat, 0, len(profiles))
+	for i := range profiles {
+		format, err := profiles[i].diskFormat()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		RegisterDiskFormat(format)
+		loaded = append(loaded, format)
+	}
+	return loaded, nil
+}
+
+// matches reports whether the disk looks like it was formatted with this format
+// The boot sector ID, sector count and size, sides and track count must agree and,
+// when the disk has a specification block, so must the specification
+func (f *DiskFormat) matches(d *DSK) bool {
+	sector := d.bootSector()
+	track := d.GetTrack(0, 0)
+	if sector == nil || track == nil {
+		return false
+	}
+
+	if sector.Info.R != f.FirstSectorID || len(track.Sectors) != f.SectorsPerTrack ||
+		128<<sector.Info.N != f.SectorSize || int(d.Header.Sides) != f.Sides {
+		return false
+	}
+	if tracks := int(d.Header.Tracks); tracks < f.TracksPerSide-3 || tracks > f.TracksPerSide+3 {
+		return false
+	}
+
+	if f.FileSystem == FileSystemFAT12 {
+		// FAT boot sectors start with a jump instruction
+		return len(sector.Data) > 0 && (sector.Data[0] == 0xEB || sector.Data[0] == 0xE9)
+	}
+
+	return d.Specification == nil || f.matchesSpecification(d.Specification)
+}
+
+// matchesSpecification reports whether a specification block describes this format's geometry and DPB
+func (f *DiskFormat) matchesSpecification(actual *Specification) bool {
+	spec := f.Specification()
+	return spec != nil && spec.Side == actual.Side && spec.TracksPerSide == actual.TracksPerSide &&
+		spec.SectorsPerTrack == actual.SectorsPerTrack && spec.SectorSize == actual.SectorSize &&
+		spec.ReservedTracks == actual.ReservedTracks && spec.BlockShift == actual.BlockShift &&
+		spec.DirectoryBlocks == actual.DirectoryBlocks
+}
+
+// MatchDiskFormats returns the registered formats the disk matches, in registry order
+// Formats sharing a geometry (such as +3 180K and PCW 180K) are all returned
+func MatchDiskFormats(d *DSK) []DiskFormat {
+	matched := make([]DiskFormat, 0)
+	for _, format := range DiskFormats {
+		if format.matches(d) {
+			matched = append(matched, format)
+		}
+	}
+	return matched
+}
+
+// ListDiskFormats prints the registered formats to the console
+func ListDiskFormats() {
+	fmt.Println("Name          Machine Sides Tracks Sectors  Size First Order      Reserved Block  Dir Skew FS")
+	for _, f := range DiskFormats {
+		fmt.Printf("%-13s %-7s %5d %6d %7d %5d   #%02X %-10s %8d %5d %4d %4d %s\n",
+			f.Name, f.Machine, f.Sides, f.TracksPerSide, f.SectorsPerTrack, f.SectorSize, f.FirstSectorID, f.SideOrder,
+			f.ReservedTracks, f.BlockSize, f.DirEntries, f.Skew, f.FileSystem)
+	}
+}
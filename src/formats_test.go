@@ -0,0 +1,136 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// formats_test.go - Unit tests for the disk format registry
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindDiskFormat(t *testing.T) {
+	for _, name := range []string{"CPC DATA", "cpc-data", "cpcdata", "Cpc_Data"} {
+		format, err := FindDiskFormat(name)
+		if err != nil || format.Name != "CPC DATA" {
+			t.Errorf("%q: expected CPC DATA, got %v %v", name, format, err)
+		}
+	}
+	if _, err := FindDiskFormat("amiga"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestDiskFormatSpecification(t *testing.T) {
+	format, _ := FindDiskFormat("PCW 720K")
+	spec := format.Specification()
+	if spec == nil {
+		t.Fatalf("expected a specification")
+	}
+	if spec.Format != SpecFormatPCW_DS || spec.Side != SpecSideDoubleAlternate || spec.Track != SpecTrackDouble {
+		t.Errorf("unexpected format/side/track: %v %v %v", spec.Format, spec.Side, spec.Track)
+	}
+	if spec.BlockShift != 4 || spec.DirectoryBlocks != 4 || spec.ReservedTracks != 1 {
+		t.Errorf("unexpected block shift %d, directory blocks %d, reserved %d",
+			spec.BlockShift, spec.DirectoryBlocks, spec.ReservedTracks)
+	}
+
+	msx, _ := FindDiskFormat("MSX-DOS")
+	if msx.Specification() != nil {
+		t.Errorf("expected no specification for a FAT12 format")
+	}
+}
+
+func TestSkewTable(t *testing.T) {
+	format := DiskFormat{SectorsPerTrack: 9, Skew: 2}
+	expected := []int{0, 2, 4, 6, 8, 1, 3, 5, 7}
+	if table := format.skewTable(); !reflect.DeepEqual(table, expected) {
+		t.Errorf("expected %v, got %v", expected, table)
+	}
+
+	// Skew 3 over 9 sectors revisits sector 0 and must move on to the next unused one
+	format.Skew = 3
+	expected = []int{0, 3, 6, 1, 4, 7, 2, 5, 8}
+	if table := format.skewTable(); !reflect.DeepEqual(table, expected) {
+		t.Errorf("expected %v, got %v", expected, table)
+	}
+}
+
+func TestMatchDiskFormats(t *testing.T) {
+	names := func(formats []DiskFormat) []string {
+		result := make([]string, len(formats))
+		for i, format := range formats {
+			result[i] = format.Name
+		}
+		return result
+	}
+
+	if matched := names(MatchDiskFormats(newPlus3TestDSK())); !reflect.DeepEqual(matched, []string{"+3 180K", "PCW 180K"}) {
+		t.Errorf("expected +3 180K and PCW 180K, got %v", matched)
+	}
+	if matched := names(MatchDiskFormats(newTestDSK(FormatExtended, 42, 1, 0xC1))); !reflect.DeepEqual(matched, []string{"CPC DATA"}) {
+		t.Errorf("expected CPC DATA, got %v", matched)
+	}
+
+	msx := newTestDSK(FormatExtended, 80, 2, 0x01)
+	msx.bootSector().Data[0] = 0xEB
+	if matched := names(MatchDiskFormats(msx)); !reflect.DeepEqual(matched, []string{"PCW 720K", "Vortex", "ROMDOS D1", "MSX-DOS"}) {
+		t.Errorf("expected the 720K formats including MSX-DOS, got %v", matched)
+	}
+}
+
+func TestLoadDiskFormats(t *testing.T) {
+	saved := append([]DiskFormat(nil), DiskFormats...)
+	defer func() { DiskFormats = saved }()
+
+	filename := filepath.Join(t.TempDir(), "formats.json")
+	profiles := `[
+		{"name": "CPC DATA 42", "base": "cpc data", "tracks_per_side": 42},
+		{"name": "PCW 180K", "base": "+3 180K", "skew": 2, "side_order": "successive"}
+	]`
+	if err := os.WriteFile(filename, []byte(profiles), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded, err := LoadDiskFormats(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(loaded) != 2 || len(DiskFormats) != len(saved)+1 {
+		t.Errorf("expected 1 new and 1 replaced format, got %d loaded and %d registered", len(loaded), len(DiskFormats))
+	}
+
+	custom, err := FindDiskFormat("CPC DATA 42")
+	if err != nil || custom.TracksPerSide != 42 || custom.FirstSectorID != 0xC1 || custom.TotalBlocks() != 189 {
+		t.Errorf("unexpected custom format: %+v %v", custom, err)
+	}
+	pcw, _ := FindDiskFormat("PCW 180K")
+	if pcw.Skew != 2 || pcw.SideOrder != SideOrderSuccessive {
+		t.Errorf("expected PCW 180K to be replaced, got %+v", pcw)
+	}
+
+	if err := os.WriteFile(filename, []byte(`[{"name": "Bad", "base": "cpc data", "side_order": "sideways"}]`), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := LoadDiskFormats(filename); err == nil {
+		t.Errorf("expected error for invalid side order")
+	}
+}
+
+func TestValidateWideOneKBlocks(t *testing.T) {
+	format, err := FindDiskFormat("CPC DATA")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wide := *format
+	wide.Sides, wide.TracksPerSide = 2, 80
+	if err := wide.Validate(); err == nil {
+		t.Errorf("expected 1K blocks with 16-bit pointers to be rejected, extent mask %d", wide.ExtentMask())
+	}
+	wide.BlockSize = 2048
+	if err := wide.Validate(); err != nil || wide.ExtentMask() != 0 {
+		t.Errorf("expected 2K blocks to be valid with extent mask 0, got %d %v", wide.ExtentMask(), err)
+	}
+}
@@ -320,10 +320,20 @@ func GetFormatWriter(format string) (FormatWriter, error) {
 // DetectFormatFromFile detects the format of a sector data file by checking which files exist
 // Returns the format name, file path, and error
 func DetectFormatFromFile(trackDir string, sectorNum uint8) (string, string, error) {
-	binPath := filepath.Join(trackDir, fmt.Sprintf("sector-%d.bin", sectorNum))
-	hexPath := filepath.Join(trackDir, fmt.Sprintf("sector-%d.hex", sectorNum))
-	quotedPath := filepath.Join(trackDir, fmt.Sprintf("sector-%d.quoted", sectorNum))
-	asciihexPath := filepath.Join(trackDir, fmt.Sprintf("sector-%d.asciihex", sectorNum))
+	return detectFormatFromBase(trackDir, fmt.Sprintf("sector-%d", sectorNum))
+}
+
+// DetectCopyFormatFromFile detects the format of the data file of one copy of a weak sector
+func DetectCopyFormatFromFile(trackDir string, sectorNum uint8, copyNum int) (string, string, error) {
+	return detectFormatFromBase(trackDir, fmt.Sprintf("sector-%d.copy-%d", sectorNum, copyNum))
+}
+
+// detectFormatFromBase finds the one data file with the base name and a format extension
+func detectFormatFromBase(trackDir string, base string) (string, string, error) {
+	binPath := filepath.Join(trackDir, base+".bin")
+	hexPath := filepath.Join(trackDir, base+".hex")
+	quotedPath := filepath.Join(trackDir, base+".quoted")
+	asciihexPath := filepath.Join(trackDir, base+".asciihex")
 
 	var existingFiles []string
 	var sectorDataPath string
@@ -359,11 +369,11 @@ func DetectFormatFromFile(trackDir string, sectorNum uint8) (string, string, err
 	}
 
 	if len(existingFiles) == 0 {
-		return "", "", fmt.Errorf("no sector data file found for sector %d (expected sector-%d.bin, sector-%d.hex, sector-%d.quoted, or sector-%d.asciihex)", sectorNum, sectorNum, sectorNum, sectorNum, sectorNum)
+		return "", "", fmt.Errorf("no sector data file found for %s (expected %s.bin, %s.hex, %s.quoted, or %s.asciihex)", base, base, base, base, base)
 	}
 
 	if len(existingFiles) > 1 {
-		return "", "", fmt.Errorf("multiple sector data files found for sector %d: %v (only one format should exist)", sectorNum, existingFiles)
+		return "", "", fmt.Errorf("multiple sector data files found for %s: %v (only one format should exist)", base, existingFiles)
 	}
 
 	return dataFormat, sectorDataPath, nil
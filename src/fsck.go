@@ -0,0 +1,372 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// fsck.go - Consistency checking and repair of the CP/M directory
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProblemKind identifies a class of filesystem inconsistency
+type ProblemKind string
+
+const (
+	ProblemIllegalName   ProblemKind = "illegal-name"
+	ProblemOutOfRange    ProblemKind = "out-of-range"
+	ProblemCrossLinked   ProblemKind = "cross-linked"
+	ProblemRecordCount   ProblemKind = "record-count"
+	ProblemDuplicate     ProblemKind = "duplicate"
+	ProblemMissingExtent ProblemKind = "missing-extent"
+	ProblemBadSector     ProblemKind = "bad-sector"
+)
+
+// directoryOwner labels problems found in the directory blocks themselves
+const directoryOwner = "directory"
+
+// FsckProblem is a single inconsistency found in the filesystem
+type FsckProblem struct {
+	Kind       ProblemKind
+	File       string // U:NAME.EXT of the affected file or "directory"
+	Entry      int    // Directory entry index, -1 when not tied to a single entry
+	Block      int    // Allocation block involved, -1 when none
+	Message    string
+	Repairable bool // Can be fixed by Repair without losing file data
+}
+
+// FsckReport is the result of checking a filesystem
+type FsckReport struct {
+	Files    int
+	Entries  int
+	Problems []FsckProblem
+}
+
+// Repairable returns the number of problems Repair can fix
+func (r *FsckReport) Repairable() int {
+	count := 0
+	for _, problem := range r.Problems {
+		if problem.Repairable {
+			count++
+		}
+	}
+	return count
+}
+
+// DamagedFiles returns the files affected by at least one problem
+func (r *FsckReport) DamagedFiles() []string {
+	seen := make(map[string]bool)
+	files := make([]string, 0)
+	for _, problem := range r.Problems {
+		if problem.File != directoryOwner && !seen[problem.File] {
+			seen[problem.File] = true
+			files = append(files, problem.File)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Print writes the report to the console
+func (r *FsckReport) Print() {
+	for _, problem := range r.Problems {
+		location := problem.File
+		if problem.Entry >= 0 {
+			location += fmt.Sprintf(" entry %d", problem.Entry)
+		}
+		repair := ""
+		if problem.Repairable {
+			repair = " (repairable)"
+		}
+		fmt.Printf("[%s] %s: %s%s\n", problem.Kind, location, problem.Message, repair)
+	}
+
+	fmt.Printf("%d file(s) in %d entries checked: %d problem(s), %d repairable\n",
+		r.Files, r.Entries, len(r.Problems), r.Repairable())
+	if damaged := r.DamagedFiles(); len(damaged) > 0 {
+		fmt.Printf("Damaged files: %s\n", strings.Join(damaged, ", "))
+	}
+}
+
+// entryLabel returns U:NAME.EXT for a directory entry
+func entryLabel(entry *DirEntry) string {
+	return fmt.Sprintf("%d:%s", entry.User(), entry.FileName())
+}
+
+// legalNameBytes returns the 11 name bytes with illegal characters replaced by '_' and
+// lower case letters upper-cased, keeping the attribute bits and trailing space padding
+func legalNameBytes(entry *DirEntry) [11]byte {
+	var fixed [11]byte
+	for _, bounds := range [][2]int{{1, 9}, {9, 12}} {
+		raw := entry.Raw[bounds[0]:bounds[1]]
+		end := len(raw)
+		for end > 0 && raw[end-1]&0x7F == ' ' {
+			end--
+		}
+		for i, b := range raw {
+			c := b & 0x7F
+			switch {
+			case i >= end:
+				c = ' '
+			case c >= 'a' && c <= 'z':
+				c -= 'a' - 'A'
+			case c <= ' ' || c > '~' || strings.IndexByte(invalidCPMNameChars, c) >= 0:
+				c = '_'
+			}
+			fixed[bounds[0]-1+i] = c | b&0x80
+		}
+	}
+	return fixed
+}
+
+// Check examines the directory and the sectors holding each file and reports every inconsistency
+func (fs *CPMFileSystem) Check() (*FsckReport, error) {
+	entries, err := fs.ReadDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FsckReport{Files: len(fs.filesFromEntries(entries))}
+	add := func(problem FsckProblem) {
+		report.Problems = append(report.Problems, problem)
+	}
+
+	f := fs.Format
+	wide := f.WideBlockPointers()
+	extentsPerEntry := f.ExtentMask() + 1
+
+	existing := make(map[string]bool)
+	for i := range entries {
+		if entries[i].IsFile() {
+			existing[fileKey(entries[i].User(), entries[i].FileName())] = true
+		}
+	}
+
+	owners := make(map[int]int) // block -> entry index, -1 for the directory
+	for block := 0; block < f.DirectoryBlocks(); block++ {
+		owners[block] = -1
+	}
+	seenExtents := make(map[string]int)
+	renamed := make(map[string]string)
+
+	for i := range entries {
+		entry := &entries[i]
+		if !entry.IsFile() {
+			continue
+		}
+		report.Entries++
+		label := entryLabel(entry)
+
+		// Names
+		fixed := legalNameBytes(entry)
+		if entry.BaseName() == "" {
+			add(FsckProblem{ProblemIllegalName, label, i, -1, "name is blank", false})
+		} else if !equalNameBytes(entry, fixed) {
+			var fixedEntry DirEntry
+			fixedEntry.Raw[0] = entry.User()
+			copy(fixedEntry.Raw[1:12], fixed[:])
+			newKey := fileKey(entry.User(), fixedEntry.FileName())
+			oldKey := fileKey(entry.User(), entry.FileName())
+			clash := newKey != oldKey && existing[newKey] || (renamed[newKey] != "" && renamed[newKey] != oldKey)
+			renamed[newKey] = oldKey
+			message := fmt.Sprintf("illegal characters in name %q", string(entry.Raw[1:12]))
+			if clash {
+				message += fmt.Sprintf(", %s already exists", fixedEntry.FileName())
+			} else {
+				message += fmt.Sprintf(", can be renamed to %s", fixedEntry.FileName())
+			}
+			add(FsckProblem{ProblemIllegalName, label, i, -1, message, !clash})
+		}
+
+		// Duplicate extents
+		extentKey := fmt.Sprintf("%s/%d", fileKey(entry.User(), entry.FileName()), entry.ExtentNumber()/extentsPerEntry)
+		if first, ok := seenExtents[extentKey]; ok {
+			add(FsckProblem{ProblemDuplicate, label, i, -1,
+				fmt.Sprintf("extent %d duplicates entry %d", entry.ExtentNumber(), first), false})
+		} else {
+			seenExtents[extentKey] = i
+		}
+
+		// Block pointers
+		validBlocks := 0
+		for _, block := range entry.Blocks(wide) {
+			if block >= f.TotalBlocks() {
+				add(FsckProblem{ProblemOutOfRange, label, i, block,
+					fmt.Sprintf("block %d is beyond the last block %d", block, f.TotalBlocks()-1), true})
+				continue
+			}
+			validBlocks++
+			if owner, ok := owners[block]; ok {
+				other := directoryOwner
+				if owner >= 0 {
+					other = entryLabel(&entries[owner])
+					if owner == i {
+						other = "this entry"
+					}
+				}
+				add(FsckProblem{ProblemCrossLinked, label, i, block,
+					fmt.Sprintf("block %d is also allocated to %s", block, other), false})
+				continue
+			}
+			owners[block] = i
+		}
+
+		// Records against allocated blocks
+		records := (entry.ExtentNumber()&f.ExtentMask())*128 + entry.RecordCount()
+		capacity := validBlocks * f.BlockSize / RecordSize
+		needed := (records*RecordSize + f.BlockSize - 1) / f.BlockSize
+		switch {
+		case entry.RecordCount() > 0x80:
+			add(FsckProblem{ProblemRecordCount, label, i, -1,
+				fmt.Sprintf("record count %d is more than 128", entry.RecordCount()), true})
+		case records > capacity:
+			add(FsckProblem{ProblemRecordCount, label, i, -1,
+				fmt.Sprintf("%d records need %d blocks but %d are allocated", records, needed, validBlocks), true})
+		case validBlocks > needed:
+			add(FsckProblem{ProblemRecordCount, label, i, -1,
+				fmt.Sprintf("%d blocks are allocated but %d records only need %d", validBlocks, records, needed), false})
+		}
+	}
+
+	// Missing extents and damaged sectors, file by file
+	for _, file := range fs.filesFromEntries(entries) {
+		label := fmt.Sprintf("%d:%s", file.User, file.Name)
+		present := make(map[int]bool)
+		last := 0
+		for _, index := range file.Entries {
+			extent := entries[index].ExtentNumber() / extentsPerEntry
+			present[extent] = true
+			if extent > last {
+				last = extent
+			}
+		}
+		if missing := last + 1 - len(present); missing > 0 {
+			add(FsckProblem{ProblemMissingExtent, label, -1, -1,
+				fmt.Sprintf("%d of %d directory entries are missing", missing, last+1), false})
+		}
+
+		for _, block := range file.Blocks {
+			if block < f.TotalBlocks() {
+				report.Problems = append(report.Problems, fs.checkBlockSectors(label, block)...)
+			}
+		}
+	}
+
+	for block := 0; block < f.DirectoryBlocks(); block++ {
+		report.Problems = append(report.Problems, fs.checkBlockSectors(directoryOwner, block)...)
+	}
+
+	return report, nil
+}
+
+// equalNameBytes reports whether the entry already has the given name bytes
+func equalNameBytes(entry *DirEntry, name [11]byte) bool {
+	for i, b := range name {
+		if entry.Raw[1+i] != b {
+			return false
+		}
+	}
+	return true
+}
+
+// checkBlockSectors reports sectors of a block that are missing or were read with FDC errors
+func (fs *CPMFileSystem) checkBlockSectors(label string, block int) []FsckProblem {
+	problems := make([]FsckProblem, 0)
+	for _, logicalSector := range fs.blockSectors(block) {
+		sector, err := fs.sector(logicalSector)
+		if err != nil {
+			problems = append(problems, FsckProblem{ProblemBadSector, label, -1, block,
+				fmt.Sprintf("block %d: %v", block, err), false})
+			continue
+		}
+		if sector.Info.FDCStatus1 != 0 || sector.Info.FDCStatus2 != 0 {
+			problems = append(problems, FsckProblem{ProblemBadSector, label, -1, block,
+				fmt.Sprintf("block %d: sector %02X on track %d side %d has FDC status ST1=%02X ST2=%02X",
+					block, sector.Info.R, sector.Info.C, sector.Info.H, sector.Info.FDCStatus1, sector.Info.FDCStatus2), false})
+		}
+	}
+	return problems
+}
+
+// Repair fixes the repairable problems in a report and returns the problems that were fixed
+// Only directory entries are changed: out of range block pointers are cleared, record counts are
+// reduced to what the allocated blocks can hold and illegal name characters are replaced
+func (fs *CPMFileSystem) Repair(report *FsckReport) ([]FsckProblem, error) {
+	entries, err := fs.ReadDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	fixed := make([]FsckProblem, 0)
+	for _, problem := range report.Problems {
+		if !problem.Repairable {
+			continue
+		}
+		entry := &entries[problem.Entry]
+		switch problem.Kind {
+		case ProblemIllegalName:
+			name := legalNameBytes(entry)
+			copy(entry.Raw[1:12], name[:])
+		case ProblemOutOfRange:
+			fs.clearBlockPointer(entry, problem.Block)
+		case ProblemRecordCount:
+			fs.clampRecords(entry)
+		default:
+			continue
+		}
+		fixed = append(fixed, problem)
+	}
+
+	if len(fixed) == 0 {
+		return fixed, nil
+	}
+	return fixed, fs.WriteDirectory(entries)
+}
+
+// clearBlockPointer zeroes every pointer to block in the entry
+func (fs *CPMFileSystem) clearBlockPointer(entry *DirEntry, block int) {
+	if fs.Format.WideBlockPointers() {
+		for i := 16; i < DirEntrySize; i += 2 {
+			if int(entry.Raw[i])|int(entry.Raw[i+1])<<8 == block {
+				entry.Raw[i], entry.Raw[i+1] = 0, 0
+			}
+		}
+		return
+	}
+	for i := 16; i < DirEntrySize; i++ {
+		if int(entry.Raw[i]) == block {
+			entry.Raw[i] = 0
+		}
+	}
+}
+
+// clampRecords reduces the extent and record count of an entry to what its in range blocks can hold
+func (fs *CPMFileSystem) clampRecords(entry *DirEntry) {
+	f := fs.Format
+	validBlocks := 0
+	for _, block := range entry.Blocks(f.WideBlockPointers()) {
+		if block < f.TotalBlocks() {
+			validBlocks++
+		}
+	}
+
+	recordCount := entry.RecordCount()
+	if recordCount > 0x80 {
+		recordCount = 0x80
+	}
+	records := (entry.ExtentNumber()&f.ExtentMask())*128 + recordCount
+	if capacity := validBlocks * f.BlockSize / RecordSize; records > capacity {
+		records = capacity
+	}
+
+	extent := entry.ExtentNumber() &^ f.ExtentMask()
+	recordCount = 0
+	if records > 0 {
+		extent += (records - 1) / 128
+		recordCount = records - (records-1)/128*128
+	}
+	entry.Raw[12] = entry.Raw[12]&0xE0 | uint8(extent%32)
+	entry.Raw[14] = uint8(extent / 32)
+	entry.Raw[15] = uint8(recordCount)
+}
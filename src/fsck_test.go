@@ -0,0 +1,115 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// fsck_test.go - Unit tests for CP/M filesystem checking and repair
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"testing"
+)
+
+// newDamagedTestFileSystem returns a CPC DATA filesystem with one of each kind of problem
+func newDamagedTestFileSystem(t *testing.T) *CPMFileSystem {
+	t.Helper()
+	fs, err := OpenCPMFileSystem(newTestDSK(FormatExtended, 40, 1, 0xC1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeTestDirEntries(t, fs,
+		newTestDirEntry(0, "GOOD", "BIN", 0, 8, 2),
+		newTestDirEntry(0, "CROSS", "BIN", 0, 8, 2),      // shares block 2 with GOOD.BIN
+		newTestDirEntry(0, "RANGE", "BIN", 0, 8, 3, 200), // block 200 is past the end
+		newTestDirEntry(0, "SHORT", "BIN", 0, 40, 4),     // 40 records in one 1K block
+		newTestDirEntry(0, "DUP", "BIN", 0, 8, 5),
+		newTestDirEntry(0, "DUP", "BIN", 0, 8, 6),
+		newTestDirEntry(0, "BAD?", "BIN", 0, 8, 7),
+		newTestDirEntry(0, "TAIL", "BIN", 1, 8, 8), // extent 0 is missing
+		newTestDirEntry(0, "ERROR", "BIN", 0, 8, 9),
+	)
+
+	// Block 9 starts at logical sector 18 (track 2, sector C1)
+	sector, err := fs.sector(fs.blockSectors(9)[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sector.Info.FDCStatus1 = 0x20
+	sector.Info.FDCStatus2 = 0x20
+	return fs
+}
+
+func TestCheck(t *testing.T) {
+	fs := newDamagedTestFileSystem(t)
+	report, err := fs.Check()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[ProblemKind]string{
+		ProblemCrossLinked:   "0:CROSS.BIN",
+		ProblemOutOfRange:    "0:RANGE.BIN",
+		ProblemRecordCount:   "0:SHORT.BIN",
+		ProblemDuplicate:     "0:DUP.BIN",
+		ProblemIllegalName:   "0:BAD?.BIN",
+		ProblemMissingExtent: "0:TAIL.BIN",
+		ProblemBadSector:     "0:ERROR.BIN",
+	}
+	found := make(map[ProblemKind]string)
+	for _, problem := range report.Problems {
+		if _, ok := found[problem.Kind]; ok {
+			t.Errorf("unexpected extra %s problem: %+v", problem.Kind, problem)
+		}
+		found[problem.Kind] = problem.File
+	}
+	for kind, file := range expected {
+		if found[kind] != file {
+			t.Errorf("%s: expected %s, got %q", kind, file, found[kind])
+		}
+	}
+
+	if report.Files != 8 || report.Entries != 9 {
+		t.Errorf("expected 8 files in 9 entries, got %d in %d", report.Files, report.Entries)
+	}
+	if report.Repairable() != 3 {
+		t.Errorf("expected 3 repairable problems, got %d", report.Repairable())
+	}
+	if len(report.DamagedFiles()) != 7 {
+		t.Errorf("expected 7 damaged files, got %v", report.DamagedFiles())
+	}
+}
+
+func TestRepair(t *testing.T) {
+	fs := newDamagedTestFileSystem(t)
+	report, err := fs.Check()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fixed, err := fs.Repair(report)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fixed) != 3 {
+		t.Errorf("expected 3 fixed problems, got %d", len(fixed))
+	}
+
+	after, err := fs.Check()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if after.Repairable() != 0 || len(after.Problems) != len(report.Problems)-3 {
+		t.Errorf("expected only unrepairable problems to remain, got %+v", after.Problems)
+	}
+
+	files, _ := fs.MatchFiles("*.*", 0)
+	records := make(map[string]int)
+	for _, file := range files {
+		records[file.Name] = file.Records
+	}
+	if records["SHORT.BIN"] != 8 || records["RANGE.BIN"] != 8 {
+		t.Errorf("expected record counts clamped to 8, got %v", records)
+	}
+	if _, ok := records["BAD_.BIN"]; !ok {
+		t.Errorf("expected BAD?.BIN renamed to BAD_.BIN, got %v", records)
+	}
+}
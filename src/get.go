@@ -0,0 +1,191 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// get.go - Extraction of files from the CP/M filesystem
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// AnyUser selects files from every user area
+const AnyUser = -1
+
+// cpmEOF is the ^Z character marking the end of a CP/M text file
+const cpmEOF = 0x1A
+
+// GetOptions controls how files are converted when extracted to the host
+type GetOptions struct {
+	StripHeader bool // Remove an AMSDOS/+3DOS header and trim to the length it records
+	Text        bool // Trim at the first ^Z end-of-file marker
+}
+
+// MatchFiles returns files whose NAME.EXT matches a CP/M style wildcard pattern (* and ?) in the given user area
+func (fs *CPMFileSystem) MatchFiles(pattern string, user int) ([]CPMFile, error) {
+	files, err := fs.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	pattern = strings.ToUpper(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+	}
+
+	matches := make([]CPMFile, 0)
+	for _, file := range files {
+		if user != AnyUser && int(file.User) != user {
+			continue
+		}
+		if matchCPMName(pattern, file.Name) {
+			matches = append(matches, file)
+		}
+	}
+	return matches, nil
+}
+
+// matchCPMName matches a filename against a pattern the way CP/M does, comparing the space-padded
+// 8.3 forms so ? also matches padding (GAME?.BIN matches GAME.BIN) and *.* matches everything
+func matchCPMName(pattern string, name string) bool {
+	if matched, _ := path.Match(pattern, name); matched {
+		return true
+	}
+	patternBase, patternExt, _ := strings.Cut(pattern, ".")
+	nameBase, nameExt, _ := strings.Cut(name, ".")
+	return matchCPMNamePart(patternBase, nameBase, 8) && matchCPMNamePart(patternExt, nameExt, 3)
+}
+
+// matchCPMNamePart matches one space-padded part of a name, where * fills the rest of the part with ?
+func matchCPMNamePart(pattern string, name string, width int) bool {
+	if star := strings.IndexByte(pattern, '*'); star >= 0 {
+		pattern = pattern[:star] + strings.Repeat("?", max(width-star, 0))
+	}
+	if len(pattern) > width || len(name) > width {
+		return false
+	}
+	pattern = fmt.Sprintf("%-*s", width, pattern)
+	name = fmt.Sprintf("%-*s", width, name)
+	for i := 0; i < width; i++ {
+		if pattern[i] != '?' && pattern[i] != name[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hostFileName makes a name from a disk directory safe to use as a single host file name,
+// replacing path separators, control characters and .. so it cannot escape the output directory
+func hostFileName(name string) string {
+	safe := []byte(name)
+	for i, c := range safe {
+		if c == '/' || c == '\\' || c < 0x20 || c == 0x7F {
+			safe[i] = '_'
+		}
+	}
+	name = strings.ReplaceAll(string(safe), "..", "__")
+	if name == "" || name == "." {
+		return "_"
+	}
+	return name
+}
+
+// ConvertForHost applies the extraction options to the raw contents of a file
+func ConvertForHost(data []byte, options GetOptions) []byte {
+	if options.StripHeader {
+		data = StripFileHeader(data)
+	}
+	if options.Text {
+		if eof := bytes.IndexByte(data, cpmEOF); eof >= 0 {
+			data = data[:eof]
+		}
+	}
+	return data
+}
+
+// StripFileHeader removes an AMSDOS or +3DOS header (if present) and trims the data to the length it records
+func StripFileHeader(data []byte) []byte {
+	switch {
+	case HasPlus3DOSHeader(data):
+		length := plus3DOSFileLength(data)
+		if length >= Plus3DOSHeaderSize && length <= len(data) {
+			return data[Plus3DOSHeaderSize:length]
+		}
+		return data[Plus3DOSHeaderSize:]
+	case HasAMSDOSHeader(data):
+		length := amsdosFileLength(data)
+		if length <= len(data)-AMSDOSHeaderSize {
+			return data[AMSDOSHeaderSize : AMSDOSHeaderSize+length]
+		}
+		return data[AMSDOSHeaderSize:]
+	default:
+		return data
+	}
+}
+
+// DescribeFileHeader returns a one-line description of the header at the start of a file,
+// or how the file looks when it has none
+func DescribeFileHeader(data []byte) string {
+	if header := ParsePlus3DOSHeader(data); header != nil {
+		return header.String()
+	}
+	if header := ParseAMSDOSHeader(data); header != nil {
+		return header.String()
+	}
+	if isASCIIText(data) {
+		return "ASCII (no header)"
+	}
+	return "no header"
+}
+
+// ExtractedFile is a file written to the host along with a description of its header
+type ExtractedFile struct {
+	CPMFile
+	Header string
+}
+
+// ExtractFiles writes every file matching pattern in the user area to outputDir
+// Files are placed in user-N subdirectories when they come from more than one user area
+func (fs *CPMFileSystem) ExtractFiles(pattern string, user int, outputDir string, options GetOptions) ([]ExtractedFile, error) {
+	files, err := fs.MatchFiles(pattern, user)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match '%s'", pattern)
+	}
+
+	users := make(map[uint8]bool)
+	for _, file := range files {
+		users[file.User] = true
+	}
+
+	extracted := make([]ExtractedFile, 0, len(files))
+	for i := range files {
+		file := &files[i]
+		data, err := fs.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		extracted = append(extracted, ExtractedFile{CPMFile: *file, Header: DescribeFileHeader(data)})
+		data = ConvertForHost(data, options)
+
+		dir := outputDir
+		if len(users) > 1 {
+			dir = filepath.Join(outputDir, fmt.Sprintf("user-%d", file.User))
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %v", err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, hostFileName(file.Name)), data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %v", file.Name, err)
+		}
+	}
+
+	return extracted, nil
+}
@@ -0,0 +1,143 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// get_test.go - Unit tests for file extraction
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	fs, err := OpenCPMFileSystem(newTestDSK(FormatExtended, 40, 1, 0xC1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	content := bytes.Repeat([]byte("0123456789ABCDEF"), 80) // 1280 bytes = 10 records over 2 blocks
+	if err := fs.WriteBlock(5, content[:1024]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := fs.WriteBlock(3, content[1024:]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeTestDirEntries(t, fs, newTestDirEntry(0, "DATA", "", 0, 10, 5, 3))
+
+	files, err := fs.MatchFiles("*.*", AnyUser)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d (%v)", len(files), err)
+	}
+	data, err := fs.ReadFile(&files[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("file content mismatch: got %d bytes", len(data))
+	}
+}
+
+func TestMatchCPMName(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"*.*", "GAME.BIN", true},
+		{"*.*", "README", true},
+		{"*.BAS", "DISC.BAS", true},
+		{"*.BAS", "DISC.BIN", false},
+		{"GAME?.BIN", "GAME1.BIN", true},
+		{"GAME?.BIN", "GAME.BIN", true},
+		{"GAME?.BIN", "GAME12.BIN", false},
+		{"GA*.B?", "GAME.BIN", false},
+		{"GA*.B??", "GAME.BIN", true},
+		{"*", "README", true},
+		{"DISC", "DISC", true},
+		{"DISC", "DISC.BAS", false},
+		{"TOOLONGNAME.*", "TOOLONGN.BAS", false},
+	}
+
+	for _, tt := range tests {
+		if result := matchCPMName(tt.pattern, tt.name); result != tt.expected {
+			t.Errorf("matchCPMName(%q, %q): expected %t, got %t", tt.pattern, tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestConvertForHost(t *testing.T) {
+	// AMSDOS header recording a 5 byte file followed by record padding
+	amsdos := make([]byte, AMSDOSHeaderSize+RecordSize)
+	copy(amsdos[1:12], "HELLO   TXT")
+	amsdos[64] = 5
+	binary.LittleEndian.PutUint16(amsdos[67:69], amsdosChecksum(amsdos))
+	copy(amsdos[AMSDOSHeaderSize:], "HELLO")
+
+	if data := ConvertForHost(amsdos, GetOptions{StripHeader: true}); string(data) != "HELLO" {
+		t.Errorf("AMSDOS strip: expected HELLO, got %q", data)
+	}
+	if data := ConvertForHost(amsdos, GetOptions{}); len(data) != len(amsdos) {
+		t.Errorf("keep header: expected %d bytes, got %d", len(amsdos), len(data))
+	}
+
+	// Headerless data starting with zeros sums to a zero checksum but is not a header
+	screen := make([]byte, 6912)
+	screen[6144] = 0x38
+	if data := ConvertForHost(screen, GetOptions{StripHeader: true}); len(data) != len(screen) {
+		t.Errorf("zero-led data: expected %d bytes kept, got %d", len(screen), len(data))
+	}
+
+	// +3DOS header recording a 4 byte file (132 bytes including the header)
+	plus3 := make([]byte, Plus3DOSHeaderSize+RecordSize)
+	copy(plus3, plus3DOSSignature)
+	binary.LittleEndian.PutUint32(plus3[11:15], Plus3DOSHeaderSize+4)
+	plus3[127] = plus3DOSChecksum(plus3)
+	copy(plus3[Plus3DOSHeaderSize:], "ZX+3")
+
+	if data := ConvertForHost(plus3, GetOptions{StripHeader: true}); string(data) != "ZX+3" {
+		t.Errorf("+3DOS strip: expected ZX+3, got %q", data)
+	}
+
+	text := append([]byte("10 PRINT\r\n"), cpmEOF, cpmEOF, 0xE5)
+	if data := ConvertForHost(text, GetOptions{Text: true}); string(data) != "10 PRINT\r\n" {
+		t.Errorf("text: expected trimmed at ^Z, got %q", data)
+	}
+}
+
+func TestHostFileName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"GAME.BIN", "GAME.BIN"},
+		{"../../X", "______X"},
+		{"A\\B.\x01", "A_B._"},
+		{"..", "__"},
+		{"", "_"},
+	}
+
+	for _, tt := range tests {
+		if result := hostFileName(tt.name); result != tt.expected {
+			t.Errorf("hostFileName(%q): expected %q, got %q", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestExtractFilesStaysInOutputDir(t *testing.T) {
+	fs, err := OpenCPMFileSystem(newTestDSK(FormatExtended, 40, 1, 0xC1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeTestDirEntries(t, fs, newTestDirEntry(0, "..\\..\\X", "", 0, 1, 2))
+
+	dir := filepath.Join(t.TempDir(), "out")
+	if _, err := fs.ExtractFiles("*.*", AnyUser, dir, GetOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "______X")); err != nil {
+		t.Errorf("expected the file to be written inside the output directory: %v", err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"strings"
 )
 
 // DumpInfo prints the DSK structure to console
@@ -28,10 +29,14 @@ func (d *DSK) DumpInfo() {
 		fmt.Printf("Track Size: %d bytes\n", d.StandardTrackSize)
 	}
 	fmt.Println("--------------------------------------------------")
+	d.dumpDiskFormat()
+	d.dumpSpecification()
+	fmt.Println("--------------------------------------------------")
 
 	for i, t := range d.Tracks {
-		fmt.Printf("LogTrack #%02d | Cyl: %02d | Head: %d | SecCount: %02d | Gap3: %02d\n",
-			i, t.Header.TrackNum, t.Header.SideNum, t.Header.SectorCount, t.Header.Gap3Length)
+		fmt.Printf("LogTrack #%02d | Cyl: %02d | Head: %d | SecCount: %02d | Gap3: %02d | Rate: %s | Mode: %s\n",
+			i, t.Header.TrackNum, t.Header.SideNum, t.Header.SectorCount, t.Header.Gap3Length,
+			t.Header.DataRateName(), t.Header.RecordingModeName())
 
 		for _, s := range t.Sectors {
 			dataPreview := ""
@@ -43,8 +48,89 @@ func (d *DSK) DumpInfo() {
 
 			fmt.Printf("   [SEC] ID: %02X | N: %d (%d bytes) | ST1: %02X ST2: %02X | Data: %s\n",
 				s.Info.R, s.Info.N, len(s.Data), s.Info.FDCStatus1, s.Info.FDCStatus2, dataPreview)
+			if kind := s.Kind(); kind != SectorNormal && kind != SectorWeak {
+				fmt.Printf("         Kind: %s\n", s.DescribeKind())
+			}
+			if s.Info.FDCStatus1 != 0 {
+				fmt.Printf("         ST1: %s\n", DescribeFDCStatus(s.Info.FDCStatus1, FDCStatus1Flags))
+			}
+			if s.Info.FDCStatus2 != 0 {
+				fmt.Printf("         ST2: %s\n", DescribeFDCStatus(s.Info.FDCStatus2, FDCStatus2Flags))
+			}
+			if s.IsWeak() {
+				ranges := s.WeakRanges()
+				fmt.Printf("         Weak: %d copies of %d bytes, differing at %s\n",
+					s.CopyCount(), len(s.Data)/s.CopyCount(), describeWeakRanges(ranges))
+				for k, data := range s.Copies() {
+					fmt.Printf("         Copy %d: %s\n", k+1, weakPreview(data, ranges))
+				}
+			}
 		}
 		fmt.Println("- - - - - - - - - - - - - - - - - - - - - - - - -")
 	}
 }
 
+
+// weakPreview shows 16 bytes of a weak sector copy from the first differing byte, with the
+// bytes that differ between copies in brackets
+func weakPreview(data []byte, ranges []ByteRange) string {
+	start := 0
+	if len(ranges) > 0 {
+		start = ranges[0].Start
+	}
+	end := min(start+16, len(data))
+
+	parts := make([]string, 0, end-start)
+	for offset := start; offset < end; offset++ {
+		part := fmt.Sprintf("%02x", data[offset])
+		for _, r := range ranges {
+			if offset >= r.Start && offset <= r.End {
+				part = "[" + part + "]"
+			}
+		}
+		parts = append(parts, part)
+	}
+	text := fmt.Sprintf("%04X: %s", start, strings.Join(parts, " "))
+	if end < len(data) {
+		text += " ..."
+	}
+	return text
+}
+
+// dumpDiskFormat prints the names of the registered disk formats the disk matches
+func (d *DSK) dumpDiskFormat() {
+	matched := MatchDiskFormats(d)
+	if len(matched) == 0 {
+		fmt.Println("Disk Format   : unknown")
+		return
+	}
+	names := make([]string, len(matched))
+	for i, format := range matched {
+		names[i] = format.Name
+	}
+	fmt.Printf("Disk Format   : %s\n", strings.Join(names, " / "))
+}
+
+// dumpSpecification prints the disk specification block (if present)
+func (d *DSK) dumpSpecification() {
+	spec := d.Specification
+	if spec == nil {
+		fmt.Printf("Specification : none - %s\n", d.specificationMissingReason())
+		return
+	}
+
+	fmt.Printf("Specification : %s\n", spec.Format)
+	fmt.Printf("  Sides       : %s\n", spec.Side)
+	fmt.Printf("  Tracks      : %d per side (%s track)\n", spec.TracksPerSide, spec.Track)
+	fmt.Printf("  Sectors     : %d per track, %d bytes\n", spec.SectorsPerTrack, spec.SectorSize)
+	fmt.Printf("  Reserved    : %d tracks\n", spec.ReservedTracks)
+	fmt.Printf("  Block Shift : %d (%d byte blocks)\n", spec.BlockShift, spec.BlockSize())
+	fmt.Printf("  Dir Blocks  : %d\n", spec.DirectoryBlocks)
+	fmt.Printf("  Gap R/W     : %02X\n", spec.GapReadWrite)
+	fmt.Printf("  Gap Format  : %02X\n", spec.GapFormat)
+	bootType := spec.BootType()
+	if bootType == "" {
+		bootType = "not bootable"
+	}
+	fmt.Printf("  Checksum    : %02X (sector sum %02X, %s)\n", spec.Checksum, spec.SectorSum, bootType)
+}
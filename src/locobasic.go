@@ -0,0 +1,672 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// locobasic.go - Locomotive BASIC (CPC) detokenizer and tokenizer
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LocomotiveBASICStart is the address BASIC programs are loaded to
+const LocomotiveBASICStart = 0x0170
+
+// locomotiveKeywords are the keyword tokens &80-&FE (empty where unused)
+// &DD-&E1 were added in BASIC 1.1
+var locomotiveKeywords = [0x7F]string{
+	"AFTER", "AUTO", "BORDER", "CALL", "CAT", "CHAIN", "CLEAR", "CLG", // &80
+	"CLOSEIN", "CLOSEOUT", "CLS", "CONT", "DATA", "DEF", "DEFINT", "DEFREAL", // &88
+	"DEFSTR", "DEG", "DELETE", "DIM", "DRAW", "DRAWR", "EDIT", "ELSE", // &90
+	"END", "ENT", "ENV", "ERASE", "ERROR", "EVERY", "FOR", "GOSUB", // &98
+	"GOTO", "IF", "INK", "INPUT", "KEY", "LET", "LINE", "LIST", // &A0
+	"LOAD", "LOCATE", "MEMORY", "MERGE", "MID$", "MODE", "MOVE", "MOVER", // &A8
+	"NEXT", "NEW", "ON", "ON BREAK", "ON ERROR GOTO", "ON SQ", "OPENIN", "OPENOUT", // &B0
+	"ORIGIN", "OUT", "PAPER", "PEN", "PLOT", "PLOTR", "POKE", "PRINT", // &B8
+	"'", "RAD", "RANDOMIZE", "READ", "RELEASE", "REM", "RENUM", "RESTORE", // &C0
+	"RESUME", "RETURN", "RUN", "SAVE", "SOUND", "SPEED", "STOP", "SYMBOL", // &C8
+	"TAG", "TAGOFF", "TROFF", "TRON", "WAIT", "WEND", "WHILE", "WIDTH", // &D0
+	"WINDOW", "WRITE", "ZONE", "DI", "EI", "FILL", "GRAPHICS", "MASK", // &D8
+	"FRAME", "CURSOR", "", "ERL", "FN", "SPC", "STEP", "SWAP", // &E0
+	"", "", "TAB", "THEN", "TO", "USING", ">", "=", // &E8
+	">=", "<", "<>", "<=", "+", "-", "*", "/", // &F0
+	"^", "\\", "AND", "MOD", "OR", "XOR", "NOT", // &F8
+}
+
+// locomotiveFunctions are the function tokens that follow an &FF prefix
+var locomotiveFunctions = map[byte]string{
+	0x00: "ABS", 0x01: "ASC", 0x02: "ATN", 0x03: "CHR$", 0x04: "CINT", 0x05: "COS", 0x06: "CREAL", 0x07: "EXP",
+	0x08: "FIX", 0x09: "FRE", 0x0A: "INKEY", 0x0B: "INP", 0x0C: "INT", 0x0D: "JOY", 0x0E: "LEN", 0x0F: "LOG",
+	0x10: "LOG10", 0x11: "LOWER$", 0x12: "PEEK", 0x13: "REMAIN", 0x14: "SGN", 0x15: "SIN", 0x16: "SPACE$", 0x17: "SQ",
+	0x18: "SQR", 0x19: "STR$", 0x1A: "TAN", 0x1B: "UNT", 0x1C: "UPPER$", 0x1D: "VAL",
+	0x40: "EOF", 0x41: "ERR", 0x42: "HIMEM", 0x43: "INKEY$", 0x44: "PI", 0x45: "RND", 0x46: "TIME", 0x47: "XPOS",
+	0x48: "YPOS", 0x49: "DERR",
+	0x71: "BIN$", 0x72: "DEC$", 0x73: "HEX$", 0x74: "INSTR", 0x75: "LEFT$", 0x76: "MAX", 0x77: "MIN", 0x78: "POS",
+	0x79: "RIGHT$", 0x7A: "ROUND", 0x7B: "STRING$", 0x7C: "TEST", 0x7D: "TESTR", 0x7E: "COPYCHR$", 0x7F: "VPOS",
+}
+
+// Locomotive BASIC tokens below &80
+const (
+	locoEndOfLine     = 0x00
+	locoSeparator     = 0x01 // Statement separator, also placed before ELSE and '
+	locoIntegerVar    = 0x02 // Variable with a % suffix
+	locoStringVar     = 0x03 // Variable with a $ suffix
+	locoRealVar       = 0x04 // Variable with a ! suffix
+	locoVar           = 0x0D // Variable without a suffix (&0B and &0C are also used)
+	locoDigit         = 0x0E // &0E-&17 are the constants 0-9
+	locoByte          = 0x19
+	locoWord          = 0x1A
+	locoBinary        = 0x1B
+	locoHex           = 0x1C
+	locoLinePointer   = 0x1D // Line address, only present in memory after the program has run
+	locoLineNumber    = 0x1E
+	locoFloat         = 0x1F
+	locoRSX           = 0x7C
+	locoFunction      = 0xFF
+	locoTokenElse     = 0x97
+	locoTokenData     = 0x8C
+	locoTokenApos     = 0xC0
+	locoTokenRem      = 0xC5
+	locoTokenFN       = 0xE4
+	locoFirstOperator = 0xEE
+)
+
+// locomotiveLineKeywords are followed by line numbers rather than numeric values
+var locomotiveLineKeywords = map[string]bool{
+	"AUTO": true, "DELETE": true, "EDIT": true, "ELSE": true, "GOSUB": true, "GOTO": true, "LIST": true,
+	"ON ERROR GOTO": true, "RENUM": true, "RESTORE": true, "RESUME": true, "RUN": true, "THEN": true,
+}
+
+// locomotiveProtectionKeys are XORed over protected (SAVE "name",P) programs
+var locomotiveProtectionKeys = [2][]byte{
+	{0xE2, 0x9D, 0xDB, 0x1A, 0x42, 0x29, 0x39, 0xC6, 0xB3, 0xC6, 0x90, 0x45, 0x8A},
+	{0x49, 0xB1, 0x36, 0xF0, 0x2E, 0x1E, 0x06, 0x2A, 0x28, 0x19, 0xEA},
+}
+
+// DecryptLocomotiveBASIC decrypts (or encrypts, the scheme is symmetric) a protected program
+func DecryptLocomotiveBASIC(data []byte) []byte {
+	decrypted := make([]byte, len(data))
+	for i, b := range data {
+		decrypted[i] = b ^ locomotiveProtectionKeys[0][i%13] ^ locomotiveProtectionKeys[1][i%11]
+	}
+	return decrypted
+}
+
+// LocomotiveBASICProgram returns the tokenized program from a file, removing the AMSDOS
+// header (if present) and decrypting protected programs
+func LocomotiveBASICProgram(data []byte) ([]byte, error) {
+	header := ParseAMSDOSHeader(data)
+	if header == nil {
+		return data, nil
+	}
+	if header.Type>>1&0x07 != AMSDOSTypeBASIC {
+		return nil, fmt.Errorf("not a BASIC program (AMSDOS %s)", header.TypeName())
+	}
+
+	program := data[AMSDOSHeaderSize:]
+	if header.Length <= len(program) {
+		program = program[:header.Length]
+	}
+	if header.Protected() {
+		program = DecryptLocomotiveBASIC(program)
+	}
+	return program, nil
+}
+
+// DetokenizeLocomotiveBASIC converts a tokenized Locomotive BASIC program to a text listing
+func DetokenizeLocomotiveBASIC(program []byte) (string, error) {
+	// Line addresses are needed to resolve line pointers left in programs saved after running
+	lineAddresses := make(map[int]uint16)
+	for offset := 0; offset+4 <= len(program); {
+		length := int(binary.LittleEndian.Uint16(program[offset:]))
+		if length == 0 {
+			break
+		}
+		lineAddresses[LocomotiveBASICStart+offset] = binary.LittleEndian.Uint16(program[offset+2:])
+		offset += length
+	}
+
+	var listing strings.Builder
+	for offset := 0; ; {
+		if offset+2 > len(program) {
+			// Some programs are saved without the end marker
+			break
+		}
+		length := int(binary.LittleEndian.Uint16(program[offset:]))
+		if length == 0 {
+			break
+		}
+		if length < 5 || offset+length > len(program) {
+			return "", fmt.Errorf("line at offset %d has an invalid length %d", offset, length)
+		}
+		number := binary.LittleEndian.Uint16(program[offset+2:])
+		text, err := detokenizeLocomotiveLine(program[offset+4:offset+length], lineAddresses)
+		if err != nil {
+			return "", fmt.Errorf("line %d: %v", number, err)
+		}
+		fmt.Fprintf(&listing, "%d %s\n", number, text)
+		offset += length
+	}
+	return listing.String(), nil
+}
+
+// detokenizeLocomotiveLine converts the tokens of a single line (after the line number) to text
+func detokenizeLocomotiveLine(tokens []byte, lineAddresses map[int]uint16) (string, error) {
+	var text strings.Builder
+	need := func(i int, count int) error {
+		if i+count >= len(tokens) {
+			return fmt.Errorf("token &%02X is truncated", tokens[i])
+		}
+		return nil
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch {
+		case token == locoEndOfLine:
+			return text.String(), nil
+
+		case token == locoSeparator:
+			// ELSE and ' are stored with a separator in front that is not listed
+			if i+1 < len(tokens) && (tokens[i+1] == locoTokenElse || tokens[i+1] == locoTokenApos) {
+				continue
+			}
+			text.WriteByte(':')
+
+		case token >= locoIntegerVar && token <= locoRealVar || token >= 0x0B && token <= locoVar:
+			// Two bytes of offset into the variables area then the name with bit 7 set on the last character
+			if err := need(i, 3); err != nil {
+				return "", err
+			}
+			i += 3
+			for ; i < len(tokens); i++ {
+				text.WriteByte(tokens[i] & 0x7F)
+				if tokens[i]&0x80 != 0 {
+					break
+				}
+			}
+			switch token {
+			case locoIntegerVar:
+				text.WriteByte('%')
+			case locoStringVar:
+				text.WriteByte('$')
+			case locoRealVar:
+				text.WriteByte('!')
+			}
+
+		case token >= locoDigit && token < locoDigit+10:
+			text.WriteByte('0' + token - locoDigit)
+
+		case token == locoByte:
+			if err := need(i, 1); err != nil {
+				return "", err
+			}
+			text.WriteString(strconv.Itoa(int(tokens[i+1])))
+			i++
+
+		case token == locoWord || token == locoLineNumber || token == locoBinary || token == locoHex || token == locoLinePointer:
+			if err := need(i, 2); err != nil {
+				return "", err
+			}
+			value := binary.LittleEndian.Uint16(tokens[i+1:])
+			switch token {
+			case locoBinary:
+				fmt.Fprintf(&text, "&X%b", value)
+			case locoHex:
+				fmt.Fprintf(&text, "&%X", value)
+			case locoLinePointer:
+				// The pointer addresses the byte before the line
+				line, found := lineAddresses[int(value)+1]
+				if !found {
+					return "", fmt.Errorf("line pointer &%04X does not point to a line", value)
+				}
+				text.WriteString(strconv.Itoa(int(line)))
+			default:
+				text.WriteString(strconv.Itoa(int(value)))
+			}
+			i += 2
+
+		case token == locoFloat:
+			if err := need(i, 5); err != nil {
+				return "", err
+			}
+			text.WriteString(formatLocomotiveFloat(decodeLocomotiveFloat(tokens[i+1 : i+6])))
+			i += 5
+
+		case token == '"':
+			end := bytes.IndexByte(tokens[i+1:], '"')
+			if end < 0 {
+				// Unterminated strings run to the end of the line
+				end = bytes.IndexByte(tokens[i+1:], locoEndOfLine)
+				if end < 0 {
+					end = len(tokens) - i - 1
+				}
+				text.Write(tokens[i : i+1+end])
+				i += end
+				continue
+			}
+			text.Write(tokens[i : i+end+2])
+			i += end + 1
+
+		case token == locoRSX:
+			// A byte giving the length of the name then the name with bit 7 set on the last character
+			if err := need(i, 2); err != nil {
+				return "", err
+			}
+			text.WriteByte('|')
+			for i += 2; i < len(tokens); i++ {
+				text.WriteByte(tokens[i] & 0x7F)
+				if tokens[i]&0x80 != 0 {
+					break
+				}
+			}
+
+		case token == locoFunction:
+			if err := need(i, 1); err != nil {
+				return "", err
+			}
+			name, found := locomotiveFunctions[tokens[i+1]]
+			if !found {
+				return "", fmt.Errorf("unknown function token &FF &%02X", tokens[i+1])
+			}
+			text.WriteString(name)
+			i++
+
+		case token >= 0x80:
+			keyword := locomotiveKeywords[token-0x80]
+			if keyword == "" {
+				return "", fmt.Errorf("unknown token &%02X", token)
+			}
+			text.WriteString(keyword)
+			if token == locoTokenRem || token == locoTokenApos {
+				// Comments are stored as they were typed
+				end := bytes.IndexByte(tokens[i+1:], locoEndOfLine)
+				if end < 0 {
+					end = len(tokens) - i - 1
+				}
+				text.Write(tokens[i+1 : i+1+end])
+				return text.String(), nil
+			}
+
+		case token >= 0x20:
+			text.WriteByte(token)
+
+		default:
+			return "", fmt.Errorf("unknown token &%02X", token)
+		}
+	}
+	return text.String(), nil
+}
+
+// decodeLocomotiveFloat converts the 5-byte floating point format (a 32-bit mantissa, little-endian
+// with the sign in bit 7 of the top byte and an implied leading 1, then an exponent biased by 128)
+func decodeLocomotiveFloat(value []byte) float64 {
+	exponent := int(value[4])
+	if exponent == 0 {
+		return 0
+	}
+	mantissa := binary.LittleEndian.Uint32(value) | 0x80000000
+	result := math.Ldexp(float64(mantissa), exponent-128-32)
+	if value[3]&0x80 != 0 {
+		result = -result
+	}
+	return result
+}
+
+// encodeLocomotiveFloat converts a number to the 5-byte floating point format
+func encodeLocomotiveFloat(number float64) ([]byte, error) {
+	encoded := make([]byte, 5)
+	if number == 0 {
+		return encoded, nil
+	}
+
+	fraction, exponent := math.Frexp(math.Abs(number))
+	mantissa := uint64(math.Round(math.Ldexp(fraction, 32)))
+	if mantissa > math.MaxUint32 {
+		mantissa >>= 1
+		exponent++
+	}
+	if exponent+128 > 255 {
+		return nil, fmt.Errorf("number %g is too large", number)
+	}
+	if exponent+128 <= 0 {
+		return encoded, nil
+	}
+
+	binary.LittleEndian.PutUint32(encoded, uint32(mantissa)&0x7FFFFFFF)
+	if number < 0 {
+		encoded[3] |= 0x80
+	}
+	encoded[4] = uint8(exponent + 128)
+	return encoded, nil
+}
+
+// formatLocomotiveFloat formats a number to the 9 significant digits BASIC lists
+func formatLocomotiveFloat(number float64) string {
+	return strings.Replace(strconv.FormatFloat(number, 'G', 9, 64), "E+0", "E+", 1)
+}
+
+// TokenizeLocomotiveBASIC converts a text listing to a tokenized Locomotive BASIC program
+// Lines must start with a line number and be in ascending order
+func TokenizeLocomotiveBASIC(listing string) ([]byte, error) {
+	program := make([]byte, 0, len(listing))
+	previous := -1
+
+	for i, line := range strings.Split(listing, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		line = strings.TrimLeft(line, " \t")
+		digits := 0
+		for digits < len(line) && line[digits] >= '0' && line[digits] <= '9' {
+			digits++
+		}
+		number, err := strconv.Atoi(line[:digits])
+		if err != nil || number < 1 || number > 65535 {
+			return nil, fmt.Errorf("line %d: expected a line number 1-65535", i+1)
+		}
+		if number <= previous {
+			return nil, fmt.Errorf("line %d: line number %d is not after %d", i+1, number, previous)
+		}
+		previous = number
+
+		tokens, err := tokenizeLocomotiveLine(strings.TrimPrefix(line[digits:], " "))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		length := 4 + len(tokens) + 1
+		if length > 0xFFFF {
+			return nil, fmt.Errorf("line %d is too long", i+1)
+		}
+		program = binary.LittleEndian.AppendUint16(program, uint16(length))
+		program = binary.LittleEndian.AppendUint16(program, uint16(number))
+		program = append(program, tokens...)
+		program = append(program, locoEndOfLine)
+	}
+	return append(program, 0x00, 0x00), nil
+}
+
+// locomotiveKeywordTokens maps keywords and functions to their tokens (functions have the &FF prefix)
+var locomotiveKeywordTokens = func() map[string][]byte {
+	tokens := make(map[string][]byte)
+	for i, keyword := range locomotiveKeywords {
+		if keyword != "" {
+			tokens[keyword] = []byte{byte(0x80 + i)}
+		}
+	}
+	for code, function := range locomotiveFunctions {
+		tokens[function] = []byte{locoFunction, code}
+	}
+	return tokens
+}()
+
+// isLocomotiveNameChar reports whether c can be part of a keyword or variable name
+func isLocomotiveNameChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.'
+}
+
+// isDigitInBase reports whether c is a digit of the given base (up to 16)
+func isDigitInBase(c byte, base int) bool {
+	_, err := strconv.ParseUint(string(c), base, 8)
+	return err == nil
+}
+
+// matchLocomotiveWords returns the length of the keyword of several words (separated by at
+// least one space) at the start of text, or 0 if it is not there
+func matchLocomotiveWords(text string, keyword string) int {
+	matched := 0
+	for n, word := range strings.Fields(keyword) {
+		if n > 0 {
+			spaces := len(text[matched:]) - len(strings.TrimLeft(text[matched:], " "))
+			if spaces == 0 {
+				return 0
+			}
+			matched += spaces
+		}
+		if !strings.HasPrefix(strings.ToUpper(text[matched:]), word) {
+			return 0
+		}
+		matched += len(word)
+	}
+	if matched < len(text) && isLocomotiveNameChar(text[matched]) {
+		return 0
+	}
+	return matched
+}
+
+// appendLocomotiveName appends a name with bit 7 set on the last character
+func appendLocomotiveName(tokens []byte, name string) []byte {
+	tokens = append(tokens, name[:len(name)-1]...)
+	return append(tokens, name[len(name)-1]|0x80)
+}
+
+// tokenizeLocomotiveLine converts the text of a single line (after the line number) to tokens
+func tokenizeLocomotiveLine(line string) ([]byte, error) {
+	tokens := make([]byte, 0, len(line))
+	lineNumbers := false // Numbers are line numbers (after GOTO, GOSUB etc)
+	inData := false      // DATA items are stored as typed
+
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == '"':
+			end := strings.IndexByte(line[i+1:], '"')
+			if end < 0 {
+				end = len(line) - i - 2
+			}
+			tokens = append(tokens, line[i:i+end+2]...)
+			i += end + 2
+			lineNumbers = false
+
+		case c == ':':
+			tokens = append(tokens, locoSeparator)
+			i++
+			lineNumbers, inData = false, false
+
+		case inData:
+			tokens = append(tokens, c)
+			i++
+
+		case c == '\'':
+			tokens = append(tokens, locoSeparator, locoTokenApos)
+			return append(tokens, line[i+1:]...), nil
+
+		case c == '|':
+			end := i + 1
+			for end < len(line) && isLocomotiveNameChar(line[end]) {
+				end++
+			}
+			if end == i+1 {
+				tokens = append(tokens, c)
+				i++
+				break
+			}
+			tokens = append(tokens, locoRSX, 0)
+			tokens = appendLocomotiveName(tokens, strings.ToUpper(line[i+1:end]))
+			i = end
+			lineNumbers = false
+
+		case c == '&':
+			base, start := 16, i+1
+			if start < len(line) && (line[start] == 'X' || line[start] == 'x') {
+				base, start = 2, start+1
+			} else if start < len(line) && (line[start] == 'H' || line[start] == 'h') {
+				start++
+			}
+			end := start
+			for end < len(line) && isDigitInBase(line[end], base) {
+				end++
+			}
+			if end == start {
+				tokens = append(tokens, c)
+				i++
+				break
+			}
+			value, err := strconv.ParseUint(line[start:end], base, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number '%s'", line[i:end])
+			}
+			token := byte(locoHex)
+			if base == 2 {
+				token = locoBinary
+			}
+			tokens = append(tokens, token)
+			tokens = binary.LittleEndian.AppendUint16(tokens, uint16(value))
+			i = end
+			lineNumbers = false
+
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9':
+			encoded, length, err := tokenizeLocomotiveNumber(line[i:], lineNumbers)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, encoded...)
+			i += length
+
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			end := i
+			for end < len(line) && isLocomotiveNameChar(line[end]) {
+				end++
+			}
+			word := strings.ToUpper(line[i:end])
+			if end < len(line) && line[end] == '$' {
+				if _, found := locomotiveKeywordTokens[word+"$"]; found {
+					word += "$"
+					end++
+				}
+			}
+
+			// Multiple word keywords
+			if word == "ON" {
+				for _, keyword := range []string{"ON BREAK", "ON ERROR GOTO", "ON SQ"} {
+					if matched := matchLocomotiveWords(line[i:], keyword); matched > 0 {
+						word, end = keyword, i+matched
+						break
+					}
+				}
+			}
+
+			token, found := locomotiveKeywordTokens[word]
+			switch {
+			case found:
+				if token[0] == locoTokenElse {
+					tokens = append(tokens, locoSeparator)
+				}
+				tokens = append(tokens, token...)
+				i = end
+				lineNumbers = locomotiveLineKeywords[word]
+				switch token[0] {
+				case locoTokenRem:
+					return append(tokens, line[i:]...), nil
+				case locoTokenData:
+					inData = true
+				}
+
+			case strings.HasPrefix(word, "FN") && len(word) > 2:
+				// User defined functions are FN followed by the name without a space
+				tokens = append(tokens, locoTokenFN)
+				tokens, i = appendLocomotiveVariable(tokens, line, i+2, end)
+				lineNumbers = false
+
+			default:
+				tokens, i = appendLocomotiveVariable(tokens, line, i, end)
+				lineNumbers = false
+			}
+
+		default:
+			operator := ""
+			for _, candidate := range locomotiveKeywords[locoFirstOperator-0x80:] {
+				if len(candidate) > len(operator) && !isLocomotiveNameChar(candidate[0]) && strings.HasPrefix(line[i:], candidate) {
+					operator = candidate
+				}
+			}
+			if operator != "" {
+				tokens = append(tokens, locomotiveKeywordTokens[operator]...)
+				i += len(operator)
+				// Line number ranges such as LIST 10-20
+				lineNumbers = lineNumbers && operator == "-"
+				break
+			}
+			tokens = append(tokens, c)
+			i++
+			lineNumbers = lineNumbers && (c == ' ' || c == ',')
+		}
+	}
+	return tokens, nil
+}
+
+// appendLocomotiveVariable appends the tokens for the variable named line[start:end] and its
+// type suffix (if any), returning the position after it
+func appendLocomotiveVariable(tokens []byte, line string, start int, end int) ([]byte, int) {
+	token := byte(locoVar)
+	if end < len(line) {
+		switch line[end] {
+		case '%':
+			token = locoIntegerVar
+		case '$':
+			token = locoStringVar
+		case '!':
+			token = locoRealVar
+		}
+	}
+	tokens = append(tokens, token, 0, 0)
+	tokens = appendLocomotiveName(tokens, line[start:end])
+	if token != locoVar {
+		end++
+	}
+	return tokens, end
+}
+
+// tokenizeLocomotiveNumber tokenizes the number at the start of text, returning the tokens
+// and the number of characters used
+func tokenizeLocomotiveNumber(text string, lineNumber bool) ([]byte, int, error) {
+	end := 0
+	isInteger := true
+	for end < len(text) && text[end] >= '0' && text[end] <= '9' {
+		end++
+	}
+	if end < len(text) && text[end] == '.' {
+		isInteger = false
+		for end++; end < len(text) && text[end] >= '0' && text[end] <= '9'; end++ {
+		}
+	}
+	if end < len(text) && (text[end] == 'E' || text[end] == 'e') {
+		exponent := end + 1
+		if exponent < len(text) && (text[exponent] == '+' || text[exponent] == '-') {
+			exponent++
+		}
+		if exponent < len(text) && text[exponent] >= '0' && text[exponent] <= '9' {
+			isInteger = false
+			for end = exponent; end < len(text) && text[end] >= '0' && text[end] <= '9'; end++ {
+			}
+		}
+	}
+
+	number, err := strconv.ParseFloat(text[:end], 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid number '%s'", text[:end])
+	}
+
+	switch {
+	case lineNumber && isInteger && number <= 65535:
+		return binary.LittleEndian.AppendUint16([]byte{locoLineNumber}, uint16(number)), end, nil
+	case isInteger && number <= 9:
+		return []byte{locoDigit + byte(number)}, end, nil
+	case isInteger && number <= 255:
+		return []byte{locoByte, byte(number)}, end, nil
+	case isInteger && number <= 32767:
+		return binary.LittleEndian.AppendUint16([]byte{locoWord}, uint16(number)), end, nil
+	}
+
+	encoded, err := encodeLocomotiveFloat(number)
+	if err != nil {
+		return nil, 0, err
+	}
+	return append([]byte{locoFloat}, encoded...), end, nil
+}
@@ -0,0 +1,144 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// locobasic_test.go - Unit tests for the Locomotive BASIC detokenizer and tokenizer
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTokenizeLocomotiveBASIC(t *testing.T) {
+	program, err := TokenizeLocomotiveBASIC("10 PRINT \"HI\";a%:GOTO 10\r\n20 x=&C000 ELSE 10 'done\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []byte{
+		0x16, 0x00, 0x0A, 0x00, // Length and line 10
+		0xBF, 0x20, '"', 'H', 'I', '"', ';', 0x02, 0x00, 0x00, 'a' | 0x80, 0x01, 0xA0, 0x20, 0x1E, 0x0A, 0x00, 0x00,
+		0x1B, 0x00, 0x14, 0x00, // Length and line 20
+		0x0D, 0x00, 0x00, 'x' | 0x80, 0xEF, 0x1C, 0x00, 0xC0, 0x20, 0x01, 0x97, 0x20, 0x1E, 0x0A, 0x00, 0x20,
+		0x01, 0xC0, 'd', 'o', 'n', 'e', 0x00,
+		0x00, 0x00, // End of program
+	}
+	if !bytes.Equal(program, expected) {
+		t.Errorf("expected\n% X\ngot\n% X", expected, program)
+	}
+}
+
+func TestTokenizeLocomotiveBASICErrors(t *testing.T) {
+	tests := map[string]string{
+		"no line number": "PRINT 1",
+		"out of order":   "20 PRINT\n10 PRINT",
+		"line zero":      "0 PRINT",
+		"bad hex":        "10 PRINT &10000",
+	}
+	for name, listing := range tests {
+		if _, err := TokenizeLocomotiveBASIC(listing); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLocomotiveBASICRoundTrip(t *testing.T) {
+	listing := `10 MODE 1:BORDER 0:INK 0,0
+20 FOR i=1 TO 10 STEP 2:PRINT "Hello";i%;a$(i);b!:NEXT
+30 IF x>=3 AND y<>4 THEN 10 ELSE GOTO 40 ' comment
+40 DATA 1,2,"a:b",hello:REM stuff 12
+50 p=3.14159265:b=40000:c=&FF+&X101-1.5E-05:d=1E+10:e=0.5
+60 ON ERROR GOTO 100:ON BREAK GOSUB 200:ON SQ(1) GOSUB 300
+70 |DIR,"*.BAS":CALL &BC00:PRINT CHR$(65);LEFT$(a$,2);INKEY$;HEX$(PEEK(&4000),2)
+80 DEF FNsq(x)=x*x:PRINT FNsq(3) MOD 4
+90 LIST 10-20:ON x GOTO 10,20,30
+100 RESUME NEXT
+`
+	program, err := TokenizeLocomotiveBASIC(listing)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, err := DetokenizeLocomotiveBASIC(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != listing {
+		t.Errorf("expected\n%s\ngot\n%s", listing, result)
+	}
+}
+
+func TestLocomotiveFloat(t *testing.T) {
+	tests := []struct {
+		encoded []byte
+		number  float64
+		text    string
+	}{
+		{[]byte{0x00, 0x00, 0x00, 0x00, 0x81}, 1, "1"},
+		{[]byte{0x00, 0x00, 0x00, 0x00, 0x80}, 0.5, "0.5"},
+		{[]byte{0x00, 0x00, 0x00, 0x80, 0x81}, -1, "-1"},
+		{[]byte{0x00, 0x00, 0x40, 0x1C, 0x90}, 40000, "40000"},
+		{[]byte{0x00, 0x00, 0x00, 0x00, 0x00}, 0, "0"},
+	}
+
+	for _, tt := range tests {
+		if number := decodeLocomotiveFloat(tt.encoded); number != tt.number {
+			t.Errorf("decode % X: expected %g, got %g", tt.encoded, tt.number, number)
+		}
+		encoded, err := encodeLocomotiveFloat(tt.number)
+		if err != nil || !bytes.Equal(encoded, tt.encoded) {
+			t.Errorf("encode %g: expected % X, got % X (%v)", tt.number, tt.encoded, encoded, err)
+		}
+		if text := formatLocomotiveFloat(tt.number); text != tt.text {
+			t.Errorf("format %g: expected %q, got %q", tt.number, tt.text, text)
+		}
+	}
+}
+
+func TestDetokenizeLocomotiveLinePointer(t *testing.T) {
+	// GOTO with the line address BASIC leaves in memory after running (the byte before line 10)
+	program := []byte{
+		0x06, 0x00, 0x0A, 0x00, 0xBF, 0x00,
+		0x09, 0x00, 0x14, 0x00, 0xA0, 0x20, 0x1D, 0x6F, 0x01, 0x00,
+		0x00, 0x00,
+	}
+	listing, err := DetokenizeLocomotiveBASIC(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if listing != "10 PRINT\n20 GOTO 10\n" {
+		t.Errorf("unexpected listing %q", listing)
+	}
+
+	if _, err := DetokenizeLocomotiveBASIC([]byte{0x20, 0x00, 0x0A, 0x00, 0xBF, 0x00}); err == nil {
+		t.Errorf("expected an error for a truncated line")
+	}
+}
+
+func TestProtectedLocomotiveBASIC(t *testing.T) {
+	data, err := TokenizeBASIC("10 PRINT \"SECRET\"\n", DialectLocomotive, "SECRET.BAS", 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Protect it the way SAVE "SECRET",P does
+	protected := append([]byte{}, data...)
+	protected[18] = AMSDOSTypeProtectedBASIC
+	copy(protected[AMSDOSHeaderSize:], DecryptLocomotiveBASIC(data[AMSDOSHeaderSize:]))
+	FixAMSDOSHeader(protected)
+	if bytes.Equal(protected[AMSDOSHeaderSize:], data[AMSDOSHeaderSize:]) {
+		t.Fatalf("expected the program to be encrypted")
+	}
+
+	listing, err := BASICListing(protected, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if listing != "10 PRINT \"SECRET\"\n" {
+		t.Errorf("unexpected listing %q", listing)
+	}
+
+	binary, _ := BuildAMSDOSHeader(0, "CODE.BIN", AMSDOSTypeBinary, 0x4000, 0x4000, 1)
+	if _, err := BASICListing(append(binary, 0xC9), ""); err == nil {
+		t.Errorf("expected an error for a binary file")
+	}
+}
@@ -6,8 +6,12 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // UnpackArgs represents parsed arguments for the unpack command
@@ -15,22 +19,25 @@ type UnpackArgs struct {
 	Filename   string
 	OutputDir  string
 	DataFormat string
+	Basic      bool
 }
 
 // ParseUnpackArgs parses command line arguments for the unpack command
 func ParseUnpackArgs(args []string) (UnpackArgs, error) {
     fmt.Printf("Magneato v0.1.0 - https://github.com/damieng/magneato\n")
 	
-	if len(args) < 1 {
+	// args[0] is the command name
+	if len(args) < 2 {
 		return UnpackArgs{}, fmt.Errorf("insufficient arguments")
 	}
 	
-	filename := args[0]
+	filename := args[1]
 	var outputDir string
 	dataFormat := "binary" // default
+	basic := false
 	
 	// Parse arguments
-	for i := 1; i < len(args); i++ {
+	for i := 2; i < len(args); i++ {
 		if args[i] == "--data-format" {
 			if i+1 >= len(args) {
 				return UnpackArgs{}, fmt.Errorf("--data-format requires a value (binary, hex, quoted, or asciihex)")
@@ -40,6 +47,8 @@ func ParseUnpackArgs(args []string) (UnpackArgs, error) {
 				return UnpackArgs{}, fmt.Errorf("invalid data format '%s'. Must be one of: binary, hex, quoted, asciihex", dataFormat)
 			}
 			i++ // skip the value
+		} else if args[i] == "--basic" {
+			basic = true
 		} else if outputDir == "" {
 			outputDir = args[i]
 		}
@@ -49,27 +58,987 @@ func ParseUnpackArgs(args []string) (UnpackArgs, error) {
 		Filename:   filename,
 		OutputDir:  outputDir,
 		DataFormat: dataFormat,
+		Basic:      basic,
 	}, nil
 }
 
+// PackArgs represents parsed arguments for the pack command
+type PackArgs struct {
+	UnpackedDir string
+	OutputFile  string
+	OffsetInfo  bool
+}
+
+// ParsePackArgs parses command line arguments for the pack command
+func ParsePackArgs(args []string) (PackArgs, error) {
+	// args[0] is the command name
+	packArgs := PackArgs{}
+	for i := 1; i < len(args); i++ {
+		switch {
+		case args[i] == "--offset-info":
+			packArgs.OffsetInfo = true
+		case strings.HasPrefix(args[i], "--"):
+			return PackArgs{}, fmt.Errorf("unknown argument '%s'", args[i])
+		case packArgs.UnpackedDir == "":
+			packArgs.UnpackedDir = args[i]
+		case packArgs.OutputFile == "":
+			packArgs.OutputFile = args[i]
+		default:
+			return PackArgs{}, fmt.Errorf("unexpected argument '%s'", args[i])
+		}
+	}
+
+	if packArgs.OutputFile == "" {
+		return PackArgs{}, fmt.Errorf("insufficient arguments")
+	}
+
+	return packArgs, nil
+}
+
+// BootArgs represents parsed arguments for the boot command
+type BootArgs struct {
+	Filename   string
+	OutputFile string
+	Fix        bool
+	InstallBin string
+	Target     string
+}
+
+// ParseBootArgs parses command line arguments for the boot command
+func ParseBootArgs(args []string) (BootArgs, error) {
+	// args[0] is the command name
+	if len(args) < 2 {
+		return BootArgs{}, fmt.Errorf("insufficient arguments")
+	}
+
+	bootArgs := BootArgs{
+		Filename: args[1],
+		Target:   "plus3", // default
+	}
+
+	for i := 2; i < len(args); i++ {
+		switch args[i] {
+		case "--fix":
+			bootArgs.Fix = true
+		case "--install", "--target", "--output":
+			if i+1 >= len(args) {
+				return BootArgs{}, fmt.Errorf("%s requires a value", args[i])
+			}
+			switch args[i] {
+			case "--install":
+				bootArgs.InstallBin = args[i+1]
+			case "--target":
+				if _, err := ParseBootTarget(args[i+1]); err != nil {
+					return BootArgs{}, err
+				}
+				bootArgs.Target = args[i+1]
+			case "--output":
+				bootArgs.OutputFile = args[i+1]
+			}
+			i++ // skip the value
+		default:
+			return BootArgs{}, fmt.Errorf("unknown option '%s'", args[i])
+		}
+	}
+
+	return bootArgs, nil
+}
+
+// GetArgs represents parsed arguments for the get command
+type GetArgs struct {
+	Filename    string
+	Pattern     string
+	OutputDir   string
+	User        int
+	StripHeader bool
+	Text        bool
+}
+
+// ParseGetArgs parses command line arguments for the get command
+func ParseGetArgs(args []string) (GetArgs, error) {
+	// args[0] is the command name
+	if len(args) < 3 {
+		return GetArgs{}, fmt.Errorf("insufficient arguments")
+	}
+
+	getArgs := GetArgs{
+		Filename: args[1],
+		Pattern:  args[2],
+		User:     AnyUser,
+	}
+
+	for i := 3; i < len(args); i++ {
+		switch args[i] {
+		case "--user":
+			if i+1 >= len(args) {
+				return GetArgs{}, fmt.Errorf("--user requires a value (0-15)")
+			}
+			user, err := strconv.Atoi(args[i+1])
+			if err != nil || user < 0 || user > MaxUser {
+				return GetArgs{}, fmt.Errorf("invalid user '%s'. Must be 0-15", args[i+1])
+			}
+			getArgs.User = user
+			i++ // skip the value
+		case "--strip-header":
+			getArgs.StripHeader = true
+		case "--keep-header":
+			getArgs.StripHeader = false
+		case "--text":
+			getArgs.Text = true
+		default:
+			if strings.HasPrefix(args[i], "--") {
+				return GetArgs{}, fmt.Errorf("unknown option '%s'", args[i])
+			}
+			if getArgs.OutputDir == "" {
+				getArgs.OutputDir = args[i]
+			}
+		}
+	}
+
+	return getArgs, nil
+}
+
+// PutArgs represents parsed arguments for the put command
+type PutArgs struct {
+	Filename   string
+	HostFile   string
+	CPMName    string
+	OutputFile string
+	User       uint8
+	Overwrite  bool
+	Header     HeaderOptions
+}
+
+// ParsePutArgs parses command line arguments for the put command
+func ParsePutArgs(args []string) (PutArgs, error) {
+	// args[0] is the command name
+	if len(args) < 3 {
+		return PutArgs{}, fmt.Errorf("insufficient arguments")
+	}
+
+	putArgs := PutArgs{
+		Filename: args[1],
+		HostFile: args[2],
+		Header:   HeaderOptions{Type: "binary", Line: -1},
+	}
+
+	for i := 3; i < len(args); i++ {
+		switch args[i] {
+		case "--overwrite":
+			putArgs.Overwrite = true
+		case "--user", "--header", "--type", "--load", "--exec", "--line", "--output":
+			if i+1 >= len(args) {
+				return PutArgs{}, fmt.Errorf("%s requires a value", args[i])
+			}
+			value := args[i+1]
+			switch args[i] {
+			case "--user":
+				user, err := strconv.Atoi(value)
+				if err != nil || user < 0 || user > MaxUser {
+					return PutArgs{}, fmt.Errorf("invalid user '%s'. Must be 0-15", value)
+				}
+				putArgs.User = uint8(user)
+			case "--header":
+				if value != "amsdos" && value != "plus3dos" {
+					return PutArgs{}, fmt.Errorf("invalid header '%s'. Must be one of: amsdos, plus3dos", value)
+				}
+				putArgs.Header.Kind = value
+			case "--type":
+				if value == "code" {
+					value = "binary"
+				}
+				if value != "binary" && value != "basic" {
+					return PutArgs{}, fmt.Errorf("invalid type '%s'. Must be one of: binary, code, basic", value)
+				}
+				putArgs.Header.Type = value
+			case "--load", "--exec":
+				address, err := ParseAddress(value)
+				if err != nil {
+					return PutArgs{}, err
+				}
+				if args[i] == "--load" {
+					putArgs.Header.LoadAddress = address
+				} else {
+					putArgs.Header.ExecAddress = address
+				}
+			case "--line":
+				line, err := strconv.Atoi(value)
+				if err != nil || line < 0 || line > 9999 {
+					return PutArgs{}, fmt.Errorf("invalid line '%s'. Must be 0-9999", value)
+				}
+				putArgs.Header.Line = line
+			case "--output":
+				putArgs.OutputFile = value
+			}
+			i++ // skip the value
+		default:
+			if strings.HasPrefix(args[i], "--") {
+				return PutArgs{}, fmt.Errorf("unknown option '%s'", args[i])
+			}
+			if putArgs.CPMName == "" {
+				putArgs.CPMName = args[i]
+			}
+		}
+	}
+
+	if putArgs.CPMName == "" {
+		putArgs.CPMName = filepath.Base(putArgs.HostFile)
+	}
+
+	return putArgs, nil
+}
+
+// FileOpArgs represents parsed arguments for the rm, ren and attrib commands
+type FileOpArgs struct {
+	Filename   string
+	Names      []string // Pattern (rm, attrib) or old and new names (ren)
+	OutputFile string
+	User       int
+	ToUser     int
+	Force      bool
+	Set        uint8 // Attribute flags to set (attrib)
+	Clear      uint8 // Attribute flags to clear (attrib)
+}
+
+// ParseFileOpArgs parses command line arguments for the rm, ren and attrib commands
+func ParseFileOpArgs(args []string) (FileOpArgs, error) {
+	// args[0] is the command name
+	if len(args) < 3 {
+		return FileOpArgs{}, fmt.Errorf("insufficient arguments")
+	}
+
+	command := args[0]
+	opArgs := FileOpArgs{
+		Filename: args[1],
+		User:     AnyUser,
+		ToUser:   AnyUser,
+	}
+
+	for i := 2; i < len(args); i++ {
+		switch {
+		case args[i] == "--force":
+			opArgs.Force = true
+		case args[i] == "--user" || args[i] == "--to-user":
+			if i+1 >= len(args) {
+				return FileOpArgs{}, fmt.Errorf("%s requires a value (0-15)", args[i])
+			}
+			user, err := strconv.Atoi(args[i+1])
+			if err != nil || user < 0 || user > MaxUser {
+				return FileOpArgs{}, fmt.Errorf("invalid user '%s'. Must be 0-15", args[i+1])
+			}
+			if args[i] == "--user" {
+				opArgs.User = user
+			} else {
+				opArgs.ToUser = user
+			}
+			i++ // skip the value
+		case args[i] == "--output":
+			if i+1 >= len(args) {
+				return FileOpArgs{}, fmt.Errorf("--output requires a value")
+			}
+			opArgs.OutputFile = args[i+1]
+			i++ // skip the value
+		case command == "attrib" && (strings.HasPrefix(args[i], "+") || strings.HasPrefix(args[i], "-") && !strings.HasPrefix(args[i], "--")):
+			flag, set, err := ParseAttributeChange(args[i])
+			if err != nil {
+				return FileOpArgs{}, err
+			}
+			if set {
+				opArgs.Set |= flag
+				opArgs.Clear &^= flag
+			} else {
+				opArgs.Clear |= flag
+				opArgs.Set &^= flag
+			}
+		case strings.HasPrefix(args[i], "--"):
+			return FileOpArgs{}, fmt.Errorf("unknown option '%s'", args[i])
+		default:
+			opArgs.Names = append(opArgs.Names, args[i])
+		}
+	}
+
+	expectedNames := 1
+	if command == "ren" {
+		expectedNames = 2
+	}
+	if len(opArgs.Names) != expectedNames {
+		return FileOpArgs{}, fmt.Errorf("%s expects %d filename argument(s), got %d", command, expectedNames, len(opArgs.Names))
+	}
+	if command == "attrib" && opArgs.Set == 0 && opArgs.Clear == 0 {
+		return FileOpArgs{}, fmt.Errorf("attrib requires at least one attribute change (+ro, -ro, +sys, -sys, +arc, -arc)")
+	}
+
+	return opArgs, nil
+}
+
+// UndeleteArgs represents parsed arguments for the undelete command
+type UndeleteArgs struct {
+	Filename   string
+	Name       string // Deleted file to recover, empty to list
+	OutputFile string
+	ExtractDir string
+	User       int
+	Entry      int
+	Force      bool
+}
+
+// ParseUndeleteArgs parses command line arguments for the undelete command
+func ParseUndeleteArgs(args []string) (UndeleteArgs, error) {
+	// args[0] is the command name
+	if len(args) < 2 {
+		return UndeleteArgs{}, fmt.Errorf("insufficient arguments")
+	}
+
+	undeleteArgs := UndeleteArgs{
+		Filename: args[1],
+		Entry:    -1,
+	}
+
+	for i := 2; i < len(args); i++ {
+		switch args[i] {
+		case "--force":
+			undeleteArgs.Force = true
+		case "--user", "--entry", "--extract", "--output":
+			if i+1 >= len(args) {
+				return UndeleteArgs{}, fmt.Errorf("%s requires a value", args[i])
+			}
+			value := args[i+1]
+			switch args[i] {
+			case "--user":
+				user, err := strconv.Atoi(value)
+				if err != nil || user < 0 || user > MaxUser {
+					return UndeleteArgs{}, fmt.Errorf("invalid user '%s'. Must be 0-15", value)
+				}
+				undeleteArgs.User = user
+			case "--entry":
+				entry, err := strconv.Atoi(value)
+				if err != nil || entry < 0 {
+					return UndeleteArgs{}, fmt.Errorf("invalid entry '%s'", value)
+				}
+				undeleteArgs.Entry = entry
+			case "--extract":
+				undeleteArgs.ExtractDir = value
+			case "--output":
+				undeleteArgs.OutputFile = value
+			}
+			i++ // skip the value
+		default:
+			if strings.HasPrefix(args[i], "--") {
+				return UndeleteArgs{}, fmt.Errorf("unknown option '%s'", args[i])
+			}
+			undeleteArgs.Name = args[i]
+		}
+	}
+
+	if undeleteArgs.Name == "" && (undeleteArgs.ExtractDir != "" || undeleteArgs.Entry >= 0) {
+		return UndeleteArgs{}, fmt.Errorf("a deleted file name is required to recover or extract")
+	}
+
+	return undeleteArgs, nil
+}
+
+// FsckArgs represents parsed arguments for the fsck command
+type FsckArgs struct {
+	Filename   string
+	OutputFile string
+	Repair     bool
+}
+
+// ParseFsckArgs parses command line arguments for the fsck command
+func ParseFsckArgs(args []string) (FsckArgs, error) {
+	// args[0] is the command name
+	if len(args) < 2 {
+		return FsckArgs{}, fmt.Errorf("insufficient arguments")
+	}
+
+	fsckArgs := FsckArgs{Filename: args[1]}
+
+	for i := 2; i < len(args); i++ {
+		switch args[i] {
+		case "--repair":
+			fsckArgs.Repair = true
+		case "--output":
+			if i+1 >= len(args) {
+				return FsckArgs{}, fmt.Errorf("--output requires a value")
+			}
+			fsckArgs.OutputFile = args[i+1]
+			i++ // skip the value
+		default:
+			return FsckArgs{}, fmt.Errorf("unknown argument '%s'", args[i])
+		}
+	}
+
+	if fsckArgs.OutputFile != "" && !fsckArgs.Repair {
+		return FsckArgs{}, fmt.Errorf("--output can only be used with --repair")
+	}
+
+	return fsckArgs, nil
+}
+
+// AmsdosArgs represents parsed arguments for the amsdos command
+type AmsdosArgs struct {
+	Filename   string
+	Pattern    string // Files to show on a disk, or the name to put in a header added to a host file
+	OutputFile string
+	User       int
+	Fix        bool
+	Add        bool
+	Strip      bool
+	Header     HeaderOptions
+}
+
+// ParseAmsdosArgs parses command line arguments for the amsdos command
+func ParseAmsdosArgs(args []string) (AmsdosArgs, error) {
+	// args[0] is the command name
+	if len(args) < 2 {
+		return AmsdosArgs{}, fmt.Errorf("insufficient arguments")
+	}
+
+	amsdosArgs := AmsdosArgs{
+		Filename: args[1],
+		User:     AnyUser,
+		Header:   HeaderOptions{Kind: "amsdos", Type: "binary", Line: -1},
+	}
+	headerOption := ""
+
+	for i := 2; i < len(args); i++ {
+		switch args[i] {
+		case "--fix":
+			amsdosArgs.Fix = true
+		case "--add":
+			amsdosArgs.Add = true
+		case "--strip":
+			amsdosArgs.Strip = true
+		case "--user", "--type", "--load", "--exec", "--output":
+			if i+1 >= len(args) {
+				return AmsdosArgs{}, fmt.Errorf("%s requires a value", args[i])
+			}
+			value := args[i+1]
+			switch args[i] {
+			case "--user":
+				user, err := strconv.Atoi(value)
+				if err != nil || user < 0 || user > MaxUser {
+					return AmsdosArgs{}, fmt.Errorf("invalid user '%s'. Must be 0-15", value)
+				}
+				amsdosArgs.User = user
+			case "--type":
+				if value != "binary" && value != "basic" {
+					return AmsdosArgs{}, fmt.Errorf("invalid type '%s'. Must be one of: binary, basic", value)
+				}
+				amsdosArgs.Header.Type = value
+				headerOption = args[i]
+			case "--load", "--exec":
+				address, err := ParseAddress(value)
+				if err != nil {
+					return AmsdosArgs{}, err
+				}
+				if args[i] == "--load" {
+					amsdosArgs.Header.LoadAddress = address
+				} else {
+					amsdosArgs.Header.ExecAddress = address
+				}
+				headerOption = args[i]
+			case "--output":
+				amsdosArgs.OutputFile = value
+			}
+			i++ // skip the value
+		default:
+			if strings.HasPrefix(args[i], "--") {
+				return AmsdosArgs{}, fmt.Errorf("unknown option '%s'", args[i])
+			}
+			if amsdosArgs.Pattern == "" {
+				amsdosArgs.Pattern = args[i]
+			}
+		}
+	}
+
+	actions := 0
+	for _, set := range []bool{amsdosArgs.Fix, amsdosArgs.Add, amsdosArgs.Strip} {
+		if set {
+			actions++
+		}
+	}
+	if actions > 1 {
+		return AmsdosArgs{}, fmt.Errorf("only one of --fix, --add and --strip can be used")
+	}
+	if headerOption != "" && !amsdosArgs.Add {
+		return AmsdosArgs{}, fmt.Errorf("%s can only be used with --add", headerOption)
+	}
+	if amsdosArgs.OutputFile != "" && actions == 0 {
+		return AmsdosArgs{}, fmt.Errorf("--output can only be used with --fix, --add or --strip")
+	}
+
+	return amsdosArgs, nil
+}
+
+// BasicArgs represents parsed arguments for the basic command
+type BasicArgs struct {
+	Filename   string
+	Pattern    string // Files to list on a disk, or the name to put in the header when tokenizing
+	OutputFile string // Directory for listings from a disk, otherwise a file
+	User       int
+	Dialect    string // locomotive or plus3, taken from the file header when empty
+	Line       int    // Autostart line when tokenizing +3 BASIC (0 for none)
+	Tokenize   bool
+	Headerless bool
+}
+
+// ParseBasicArgs parses command line arguments for the basic command
+func ParseBasicArgs(args []string) (BasicArgs, error) {
+	// args[0] is the command name
+	if len(args) < 2 {
+		return BasicArgs{}, fmt.Errorf("insufficient arguments")
+	}
+
+	basicArgs := BasicArgs{
+		Filename: args[1],
+		User:     AnyUser,
+	}
+
+	for i := 2; i < len(args); i++ {
+		switch args[i] {
+		case "--tokenize":
+			basicArgs.Tokenize = true
+		case "--no-header":
+			basicArgs.Headerless = true
+		case "--user", "--output", "--dialect", "--line":
+			if i+1 >= len(args) {
+				return BasicArgs{}, fmt.Errorf("%s requires a value", args[i])
+			}
+			switch args[i] {
+			case "--user":
+				user, err := strconv.Atoi(args[i+1])
+				if err != nil || user < 0 || user > MaxUser {
+					return BasicArgs{}, fmt.Errorf("invalid user '%s'. Must be 0-15", args[i+1])
+				}
+				basicArgs.User = user
+			case "--dialect":
+				dialect := strings.ToLower(args[i+1])
+				if dialect != DialectLocomotive && dialect != DialectPlus3 {
+					return BasicArgs{}, fmt.Errorf("invalid dialect '%s'. Must be one of: locomotive, plus3", args[i+1])
+				}
+				basicArgs.Dialect = dialect
+			case "--line":
+				line, err := strconv.Atoi(args[i+1])
+				if err != nil || line < 1 || line > sinclairMaxLine {
+					return BasicArgs{}, fmt.Errorf("invalid line '%s'. Must be 1-%d", args[i+1], sinclairMaxLine)
+				}
+				basicArgs.Line = line
+			default:
+				basicArgs.OutputFile = args[i+1]
+			}
+			i++ // skip the value
+		default:
+			if strings.HasPrefix(args[i], "--") {
+				return BasicArgs{}, fmt.Errorf("unknown option '%s'", args[i])
+			}
+			if basicArgs.Pattern == "" {
+				basicArgs.Pattern = args[i]
+			}
+		}
+	}
+
+	if basicArgs.Tokenize && basicArgs.OutputFile == "" {
+		return BasicArgs{}, fmt.Errorf("--tokenize requires --output")
+	}
+	if basicArgs.Headerless && !basicArgs.Tokenize {
+		return BasicArgs{}, fmt.Errorf("--no-header can only be used with --tokenize")
+	}
+	if basicArgs.Line != 0 && (!basicArgs.Tokenize || basicArgs.Dialect != DialectPlus3) {
+		return BasicArgs{}, fmt.Errorf("--line can only be used with --tokenize --dialect plus3")
+	}
+
+	return basicArgs, nil
+}
+
+// ScreenArgs represents parsed arguments for the screen command
+type ScreenArgs struct {
+	Filename   string
+	Pattern    string // Files to scan on a disk, or the name to put in the header of a screen file
+	OutputFile string // Directory for the images from a disk, otherwise a file
+	User       int
+	Mode       int   // CPC screen mode
+	Palette    []int // CPC firmware inks, nil for the default (or chosen from the image when converting a PNG)
+	Headerless bool
+}
+
+// ParseScreenArgs parses command line arguments for the screen command
+func ParseScreenArgs(args []string) (ScreenArgs, error) {
+	// args[0] is the command name
+	if len(args) < 2 {
+		return ScreenArgs{}, fmt.Errorf("insufficient arguments")
+	}
+
+	screenArgs := ScreenArgs{
+		Filename: args[1],
+		User:     AnyUser,
+		Mode:     1,
+	}
+
+	palette := ""
+	for i := 2; i < len(args); i++ {
+		switch args[i] {
+		case "--no-header":
+			screenArgs.Headerless = true
+		case "--user", "--output", "--mode", "--palette":
+			if i+1 >= len(args) {
+				return ScreenArgs{}, fmt.Errorf("%s requires a value", args[i])
+			}
+			switch args[i] {
+			case "--user":
+				user, err := strconv.Atoi(args[i+1])
+				if err != nil || user < 0 || user > MaxUser {
+					return ScreenArgs{}, fmt.Errorf("invalid user '%s'. Must be 0-15", args[i+1])
+				}
+				screenArgs.User = user
+			case "--mode":
+				mode, err := strconv.Atoi(args[i+1])
+				if err != nil || mode < 0 || mode > 2 {
+					return ScreenArgs{}, fmt.Errorf("invalid mode '%s'. Must be 0, 1 or 2", args[i+1])
+				}
+				screenArgs.Mode = mode
+			case "--palette":
+				palette = args[i+1]
+			default:
+				screenArgs.OutputFile = args[i+1]
+			}
+			i++ // skip the value
+		default:
+			if strings.HasPrefix(args[i], "--") {
+				return ScreenArgs{}, fmt.Errorf("unknown option '%s'", args[i])
+			}
+			if screenArgs.Pattern == "" {
+				screenArgs.Pattern = args[i]
+			}
+		}
+	}
+
+	if screenArgs.OutputFile == "" {
+		return ScreenArgs{}, fmt.Errorf("--output is required")
+	}
+	if palette != "" {
+		inks, err := ParseCPCPalette(palette, screenArgs.Mode)
+		if err != nil {
+			return ScreenArgs{}, err
+		}
+		screenArgs.Palette = inks
+	}
+
+	return screenArgs, nil
+}
+
+// DisasmArgs represents parsed arguments for the disasm command
+type DisasmArgs struct {
+	Filename string
+	Name     string // File on the disk to disassemble
+	User     int
+	Boot     bool
+	Track    int // Track, side and sector to disassemble (Sector is -1 when not given)
+	Side     int
+	Sector   int
+	Count    int // Sectors to read from Sector onwards
+	Origin   int // Load address, -1 to take it from the header or boot sector
+	Start    int // Address range to disassemble, -1 for the whole code
+	End      int
+}
+
+// ParseDisasmArgs parses command line arguments for the disasm command
+func ParseDisasmArgs(args []string) (DisasmArgs, error) {
+	// args[0] is the command name
+	if len(args) < 2 {
+		return DisasmArgs{}, fmt.Errorf("insufficient arguments")
+	}
+
+	disasmArgs := DisasmArgs{
+		Filename: args[1],
+		User:     AnyUser,
+		Track:    -1,
+		Sector:   -1,
+		Count:    1,
+		Origin:   -1,
+		Start:    -1,
+		End:      -1,
+	}
+
+	for i := 2; i < len(args); i++ {
+		switch args[i] {
+		case "--boot":
+			disasmArgs.Boot = true
+		case "--user", "--track", "--side", "--sector", "--count", "--origin", "--start", "--end":
+			if i+1 >= len(args) {
+				return DisasmArgs{}, fmt.Errorf("%s requires a value", args[i])
+			}
+			value := args[i+1]
+			switch args[i] {
+			case "--user":
+				user, err := strconv.Atoi(value)
+				if err != nil || user < 0 || user > MaxUser {
+					return DisasmArgs{}, fmt.Errorf("invalid user '%s'. Must be 0-15", value)
+				}
+				disasmArgs.User = user
+			case "--track", "--count":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 || args[i] == "--count" && n < 1 {
+					return DisasmArgs{}, fmt.Errorf("invalid %s '%s'", strings.TrimPrefix(args[i], "--"), value)
+				}
+				if args[i] == "--track" {
+					disasmArgs.Track = n
+				} else {
+					disasmArgs.Count = n
+				}
+			case "--side":
+				if value != "0" && value != "1" {
+					return DisasmArgs{}, fmt.Errorf("invalid side '%s'. Must be 0 or 1", value)
+				}
+				disasmArgs.Side = int(value[0] - '0')
+			case "--sector":
+				id, err := ParseAddress(value)
+				if err != nil || id > 0xFF {
+					return DisasmArgs{}, fmt.Errorf("invalid sector ID '%s'", value)
+				}
+				disasmArgs.Sector = int(id)
+			default:
+				address, err := ParseAddress(value)
+				if err != nil {
+					return DisasmArgs{}, err
+				}
+				switch args[i] {
+				case "--origin":
+					disasmArgs.Origin = int(address)
+				case "--start":
+					disasmArgs.Start = int(address)
+				default:
+					disasmArgs.End = int(address)
+				}
+			}
+			i++ // skip the value
+		default:
+			if strings.HasPrefix(args[i], "--") {
+				return DisasmArgs{}, fmt.Errorf("unknown option '%s'", args[i])
+			}
+			if disasmArgs.Name == "" {
+				disasmArgs.Name = args[i]
+			}
+		}
+	}
+
+	if (disasmArgs.Track >= 0) != (disasmArgs.Sector >= 0) {
+		return DisasmArgs{}, fmt.Errorf("--track and --sector must be used together")
+	}
+	sources := 0
+	for _, given := range []bool{disasmArgs.Name != "", disasmArgs.Boot, disasmArgs.Sector >= 0} {
+		if given {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return DisasmArgs{}, fmt.Errorf("only one of a file name, --boot or --track/--sector can be used")
+	}
+	if disasmArgs.Count > 1 && disasmArgs.Sector < 0 {
+		return DisasmArgs{}, fmt.Errorf("--count can only be used with --track and --sector")
+	}
+	if disasmArgs.Start >= 0 && disasmArgs.End >= 0 && disasmArgs.End < disasmArgs.Start {
+		return DisasmArgs{}, fmt.Errorf("--end must not be before --start")
+	}
+
+	return disasmArgs, nil
+}
+
+// FormatOptions selects the disk format used by the filesystem commands
+type FormatOptions struct {
+	Format   string // Profile or diskdef name, empty to detect the format
+	DiskDefs string // cpmtools diskdefs file to load definitions from
+}
+
+// ParseFormatOptions removes --format and --diskdefs (which any command accepts) from the arguments
+func ParseFormatOptions(args []string) (FormatOptions, []string, error) {
+	var options FormatOptions
+	rest := make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--format", "--diskdefs":
+			if i+1 >= len(args) {
+				return FormatOptions{}, nil, fmt.Errorf("%s requires a value", args[i])
+			}
+			if args[i] == "--format" {
+				options.Format = args[i+1]
+			} else {
+				options.DiskDefs = args[i+1]
+			}
+			i++ // skip the value
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	if options.DiskDefs != "" && options.Format == "" && (len(rest) < 2 || rest[1] != "formats") {
+		return FormatOptions{}, nil, fmt.Errorf("--diskdefs requires --format to choose a definition")
+	}
+
+	return options, rest, nil
+}
+
+// loadDiskDefs reads the diskdefs file named in the options, exiting on failure
+func loadDiskDefs(options FormatOptions) []DiskDef {
+	if options.DiskDefs == "" {
+		return nil
+	}
+	defs, err := LoadDiskDefs(options.DiskDefs)
+	if err != nil {
+		log.Fatalf("Error loading diskdefs: %v", err)
+	}
+	return defs
+}
+
+// openFileSystem parses a DSK and opens the CP/M filesystem inside it, exiting on failure
+func openFileSystem(filename string, options FormatOptions) *CPMFileSystem {
+	dsk, err := ParseDSK(filename)
+	if err != nil {
+		log.Fatalf("Error parsing DSK: %v", err)
+	}
+
+	format, err := SelectDiskFormat(dsk, options.Format, loadDiskDefs(options))
+	if err != nil {
+		log.Fatalf("Error reading filesystem: %v", err)
+	}
+	fs, err := NewCPMFileSystem(dsk, format)
+	if err != nil {
+		log.Fatalf("Error reading filesystem: %v", err)
+	}
+	return fs
+}
+
+// isDSKFile reports whether a file starts with a standard or extended DSK signature
+func isDSKFile(filename string) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	signature := make([]byte, 8)
+	if _, err := io.ReadFull(file, signature); err != nil {
+		return false
+	}
+	return string(signature) == "EXTENDED" || string(signature) == "MV - CPC"
+}
+
+// saveDSK writes a modified DSK to outputFile, or back over filename if no output file was given
+func saveDSK(dsk *DSK, filename string, outputFile string) string {
+	if outputFile == "" {
+		outputFile = filename
+	}
+	if err := dsk.Save(outputFile); err != nil {
+		log.Fatalf("Error writing DSK: %v", err)
+	}
+	return outputFile
+}
+
 func main() {
 	var command string = "magneato"
-	if len(os.Args) < 3 {
+
+	formatOptions, args, err := ParseFormatOptions(os.Args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	os.Args = args
+
+	if len(os.Args) < 3 && (len(os.Args) < 2 || os.Args[1] != "formats") {
 		fmt.Println("Usage:")
 		fmt.Println("  " + command + " info <filename.dsk>")
-		fmt.Println("  " + command + " unpack <filename.dsk> [output_directory] [--data-format binary|hex|quoted|asciihex]")
-		fmt.Println("  " + command + " pack <unpacked_directory> <output.dsk>")
+		fmt.Println("  " + command + " unpack <filename.dsk> [output_directory] [--data-format binary|hex|quoted|asciihex] [--basic]")
+		fmt.Println("  " + command + " pack <unpacked_directory> <output.dsk> [--offset-info]")
+		fmt.Println("  " + command + " boot <filename.dsk> [--fix] [--install <bootcode.bin>] [--target plus3|pcw9512|pcw8256] [--output <output.dsk>]")
+		fmt.Println("  " + command + " ls <filename.dsk>")
+		fmt.Println("  " + command + " get <filename.dsk> <pattern> [output_directory] [--user N] [--strip-header|--keep-header] [--text]")
+		fmt.Println("  " + command + " put <filename.dsk> <host_file> [cpm_name] [--user N] [--overwrite] [--header amsdos|plus3dos] [--type binary|code|basic] [--load ADDR] [--exec ADDR] [--line N] [--output <output.dsk>]")
+		fmt.Println("  " + command + " rm <filename.dsk> <pattern> [--user N] [--force] [--output <output.dsk>]")
+		fmt.Println("  " + command + " ren <filename.dsk> <old_name> <new_name> [--user N] [--to-user N] [--force] [--output <output.dsk>]")
+		fmt.Println("  " + command + " attrib <filename.dsk> <pattern> [+ro|-ro] [+sys|-sys] [+arc|-arc] [--user N] [--output <output.dsk>]")
+		fmt.Println("  " + command + " undelete <filename.dsk> [name] [--user N] [--entry N] [--extract <directory>] [--force] [--output <output.dsk>]")
+		fmt.Println("  " + command + " fsck <filename.dsk> [--repair] [--output <output.dsk>]")
+		fmt.Println("  " + command + " map <filename.dsk>")
+		fmt.Println("  " + command + " formats [profiles.json] [--diskdefs <diskdefs>]")
+		fmt.Println("  " + command + " detect <filename.dsk> [<filename.dsk>...]")
+		fmt.Println("  " + command + " protection <filename.dsk> [<filename.dsk>...]")
+		fmt.Println("  " + command + " capacity <filename.dsk> [<filename.dsk>...]")
+		fmt.Println("  " + command + " amsdos <filename.dsk> [pattern] [--user N] [--fix] [--output <output.dsk>]")
+		fmt.Println("  " + command + " basic <filename.dsk> [pattern] [--user N] [--output <directory>]")
+		fmt.Println("  " + command + " basic <program_file> [--dialect locomotive|plus3] [--output <listing.bas>]")
+		fmt.Println("  " + command + " basic <listing.bas> [cpm_name] --tokenize --output <program_file> [--dialect locomotive|plus3] [--line N] [--no-header]")
+		fmt.Println("  " + command + " amsdos <host_file> [cpm_name] [--fix|--strip|--add [--type binary|basic] [--load ADDR] [--exec ADDR]] [--output <file>]")
+		fmt.Println("  " + command + " screen <screen_file> [--mode 0|1|2] [--palette INKS] --output <image.png>")
+		fmt.Println("  " + command + " screen <filename.dsk> [pattern] [--user N] [--mode 0|1|2] [--palette INKS] --output <directory>")
+		fmt.Println("  " + command + " screen <image.png> [cpm_name] [--mode 0|1|2] [--palette INKS] [--no-header] --output <screen_file>")
+		fmt.Println("  " + command + " disasm <filename.dsk> <cpm_name>|--boot|--track N [--side N] --sector ID [--count N] [--user N] [--origin ADDR] [--start ADDR] [--end ADDR]")
+		fmt.Println("  " + command + " disasm <binary_file> [--origin ADDR] [--start ADDR] [--end ADDR]")
 		fmt.Println("Commands:")
 		fmt.Println("  info    - Display DSK file information")
 		fmt.Println("  unpack  - Extract DSK to directory structure")
 		fmt.Println("           (if output_directory is omitted, creates folder in current directory)")
 		fmt.Println("           --data-format: binary (default), hex, quoted (quoted-printable), or asciihex")
+		fmt.Println("           --basic: also write .bas listings of the BASIC programs to a basic folder")
 		fmt.Println("  pack    - Reconstruct DSK from unpacked directory")
+		fmt.Println("           --offset-info: add an Offset-Info block of track offsets (kept anyway if the original had one)")
+		fmt.Println("  boot    - Report or fix the +3/PCW boot sector checksum")
+		fmt.Println("           --fix: adjust the checksum byte so the disk boots on --target (default plus3)")
+		fmt.Println("           --install: copy boot code into track 0 sector 1 after the specification block")
+		fmt.Println("           --output: write the changed image here instead of overwriting the original")
+		fmt.Println("  ls      - List files in the CP/M directory (any CP/M format listed by formats)")
+		fmt.Println("  get     - Extract files matching a wildcard pattern (e.g. *.BAS) from the CP/M filesystem")
+		fmt.Println("           --user: only extract from this user area (default all)")
+		fmt.Println("           --strip-header: remove AMSDOS/+3DOS headers (default --keep-header)")
+		fmt.Println("           --text: trim CP/M text files at the ^Z end-of-file marker")
+		fmt.Println("  put     - Write a host file into the CP/M filesystem")
+		fmt.Println("           --header: add an AMSDOS or +3DOS header (--type, --load, --exec and --line fill it in)")
+		fmt.Println("           e.g. --header plus3dos --load 32768 for CODE or --type basic --line 10 for a BASIC loader")
+		fmt.Println("           --overwrite: replace an existing file with the same name")
+		fmt.Println("  rm      - Delete files (--force also deletes read-only files)")
+		fmt.Println("  ren     - Rename a file and/or move it to another user area with --to-user (--force also renames read-only files)")
+		fmt.Println("  attrib  - Set (+) or clear (-) the read-only, system and archive attributes")
+		fmt.Println("  undelete - List erased files, recover one to --user (default 0) or --extract it to the host")
+		fmt.Println("           --entry: choose between deleted files with the same name by directory entry")
+		fmt.Println("           --force: recover even if some blocks have been reused by other files")
+		fmt.Println("  fsck    - Check the CP/M directory for cross-linked, out of range and unreadable blocks,")
+		fmt.Println("           record count mismatches, duplicate extents and illegal names")
+		fmt.Println("           --repair: fix the safe cases (pointers, record counts and names)")
+		fmt.Println("  map     - Show which blocks hold the directory, each file, free space and bad sectors")
+		fmt.Println("  formats - List the known disk format profiles (after loading custom profiles from a JSON file)")
+		fmt.Println("           custom profiles are also loaded for every command from $" + FormatsEnvironmentVariable)
+		fmt.Println("  detect  - Work out the most likely format with a confidence score and the reasons for it")
+		fmt.Println("           (with several files, prints one summary line per file)")
+		fmt.Println("  protection - Identify copy-protection schemes from loader signatures and track layouts,")
+		fmt.Println("           the evidence for them and whether the image has what the scheme needs to load")
+		fmt.Println("  capacity - Estimate the raw bytes each track takes at 300 RPM and flag tracks that cannot")
+		fmt.Println("           fit on a real disk, rely on overlapping sectors or use the gaps unusually")
+		fmt.Println("  amsdos  - Decode the AMSDOS headers of files on a disk, or of a single host file")
+		fmt.Println("           --fix: correct bad lengths and checksums")
+		fmt.Println("           --add/--strip: add a header to, or remove one from, a host file")
+		fmt.Println("  basic   - List Locomotive BASIC (including protected) and +3 BASIC programs as text")
+		fmt.Println("           from a disk or a host file, or --tokenize a text listing")
+		fmt.Println("           --dialect: for files without a header (or when tokenizing), default locomotive")
+		fmt.Println("           --line: the line a tokenized +3 program starts at when loaded")
+		fmt.Println("  screen  - Render CPC screens (16K) and Spectrum SCREEN$ (6912 bytes) as PNG, export every screen")
+		fmt.Println("           on a disk, or convert a PNG back to a screen file (the image size chooses the machine)")
+		fmt.Println("           --palette: comma separated firmware inks (0-26) for the CPC pens")
+		fmt.Println("  disasm  - Disassemble Z80 code from a file, the boot sector or a run of sectors")
+		fmt.Println("           the origin comes from the file header or boot load address unless --origin is given")
+		fmt.Println("Filesystem commands also accept:")
+		fmt.Println("  --format <name>        use this profile or diskdef instead of detecting the format")
+		fmt.Println("  --diskdefs <diskdefs>  load cpmtools disk definitions for --format to choose from")
 		os.Exit(1)
 	}
 
 	command = os.Args[1]
 
+	if formatsFile := os.Getenv(FormatsEnvironmentVariable); formatsFile != "" {
+		if _, err := LoadDiskFormats(formatsFile); err != nil {
+			log.Fatalf("Error loading disk formats: %v", err)
+		}
+	}
+
 	switch command {
 	case "info":
 		if len(os.Args) < 3 {
@@ -88,11 +1057,11 @@ func main() {
 
 	case "unpack":
 		if len(os.Args) < 3 {
-			fmt.Println("Usage: go run . unpack <filename.dsk> [output_directory] [--data-format binary|hex|quoted|asciihex]")
+			fmt.Println("Usage: go run . unpack <filename.dsk> [output_directory] [--data-format binary|hex|quoted|asciihex] [--basic]")
 			os.Exit(1)
 		}
 		
-		unpackArgs, err := ParseUnpackArgs(os.Args[2:])
+		unpackArgs, err := ParseUnpackArgs(os.Args[1:])
 		if err != nil {
 			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
@@ -110,21 +1079,673 @@ func main() {
 			log.Fatalf("Error unpacking DSK: %v", err)
 		}
 
+		// The unpack has already succeeded so BASIC listing problems are only warnings
+		if unpackArgs.Basic {
+			format, err := SelectDiskFormat(dsk, formatOptions.Format, loadDiskDefs(formatOptions))
+			var fs *CPMFileSystem
+			if err == nil {
+				fs, err = NewCPMFileSystem(dsk, format)
+			}
+			if err != nil {
+				fmt.Printf("Warning: no BASIC listings, cannot read the filesystem: %v\n", err)
+				break
+			}
+			basicDir := filepath.Join(UnpackRootDir(unpackArgs.Filename, unpackArgs.OutputDir), "basic")
+			programs, failures, err := fs.WriteBASICListings("*.*", AnyUser, basicDir)
+			if err != nil {
+				fmt.Printf("Warning: failed to list BASIC programs: %v\n", err)
+				break
+			}
+			for _, failure := range failures {
+				fmt.Printf("Warning: failed to list %s\n", failure)
+			}
+			fmt.Printf("listed %d BASIC program(s) in %s\n", len(programs), basicDir)
+		}
+
 	case "pack":
 		if len(os.Args) < 4 {
-			fmt.Println("Usage: go run . pack <unpacked_directory> <output.dsk>")
+			fmt.Println("Usage: go run . pack <unpacked_directory> <output.dsk> [--offset-info]")
 			os.Exit(1)
 		}
-		unpackedDir := os.Args[2]
-		outputFile := os.Args[3]
-		fmt.Printf("packing processing %s\n", unpackedDir)
-		if err := Pack(unpackedDir, outputFile); err != nil {
+		packArgs, err := ParsePackArgs(os.Args[1:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("packing processing %s\n", packArgs.UnpackedDir)
+		if err := Pack(packArgs.UnpackedDir, packArgs.OutputFile, packArgs.OffsetInfo); err != nil {
 			log.Fatalf("Error packing DSK: %v", err)
 		}
 
+	case "boot":
+		bootArgs, err := ParseBootArgs(os.Args[1:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		dsk, err := ParseDSK(bootArgs.Filename)
+		if err != nil {
+			log.Fatalf("Error parsing DSK: %v", err)
+		}
+
+		target, _ := ParseBootTarget(bootArgs.Target)
+		modified := false
+		if bootArgs.InstallBin != "" {
+			code, err := os.ReadFile(bootArgs.InstallBin)
+			if err != nil {
+				log.Fatalf("Error reading boot code: %v", err)
+			}
+			if err := dsk.InstallBootCode(code, target); err != nil {
+				log.Fatalf("Error installing boot code: %v", err)
+			}
+			fmt.Printf("installed %d bytes of boot code from %s\n", len(code), bootArgs.InstallBin)
+			modified = true
+		} else if bootArgs.Fix {
+			if err := dsk.FixBootChecksum(target); err != nil {
+				log.Fatalf("Error fixing boot checksum: %v", err)
+			}
+			modified = true
+		}
+
+		status, err := dsk.BootStatus()
+		if err != nil {
+			log.Fatalf("Error reading boot sector: %v", err)
+		}
+		fmt.Printf("Boot sector   : ID %02X\n", status.SectorID)
+		fmt.Printf("Specification : %t\n", status.HasSpecification)
+		fmt.Printf("Sector sum    : %02X\n", status.Sum)
+		if status.BootType != "" {
+			fmt.Printf("Bootable      : yes (%s)\n", status.BootType)
+		} else {
+			fmt.Println("Bootable      : no")
+		}
+
+		if modified {
+			outputFile := saveDSK(dsk, bootArgs.Filename, bootArgs.OutputFile)
+			fmt.Printf("Successfully wrote DSK to: %s\n", outputFile)
+		}
+
+	case "ls":
+		fs := openFileSystem(os.Args[2], formatOptions)
+		if err := fs.ListFiles(); err != nil {
+			log.Fatalf("Error listing files: %v", err)
+		}
+
+	case "get":
+		getArgs, err := ParseGetArgs(os.Args[1:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fs := openFileSystem(getArgs.Filename, formatOptions)
+
+		outputDir := getArgs.OutputDir
+		if outputDir == "" {
+			outputDir = "."
+		}
+		options := GetOptions{StripHeader: getArgs.StripHeader, Text: getArgs.Text}
+		files, err := fs.ExtractFiles(getArgs.Pattern, getArgs.User, outputDir, options)
+		if err != nil {
+			log.Fatalf("Error extracting files: %v", err)
+		}
+		for _, file := range files {
+			fmt.Printf("extracted %d:%s (%s)\n", file.User, file.Name, file.Header)
+		}
+
+	case "put":
+		putArgs, err := ParsePutArgs(os.Args[1:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fs := openFileSystem(putArgs.Filename, formatOptions)
+
+		data, err := os.ReadFile(putArgs.HostFile)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", putArgs.HostFile, err)
+		}
+		if putArgs.Header.Kind != "" {
+			if data, err = AddFileHeader(data, putArgs.CPMName, putArgs.User, putArgs.Header); err != nil {
+				log.Fatalf("Error creating header: %v", err)
+			}
+		}
+
+		options := PutOptions{User: putArgs.User, Overwrite: putArgs.Overwrite}
+		if err := fs.WriteFile(putArgs.CPMName, data, options); err != nil {
+			log.Fatalf("Error writing file: %v", err)
+		}
+
+		outputFile := saveDSK(fs.DSK, putArgs.Filename, putArgs.OutputFile)
+		fmt.Printf("put %s as %d:%s (%d bytes) in %s\n", putArgs.HostFile, putArgs.User, strings.ToUpper(putArgs.CPMName), len(data), outputFile)
+
+	case "rm", "ren", "attrib":
+		opArgs, err := ParseFileOpArgs(os.Args[1:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fs := openFileSystem(opArgs.Filename, formatOptions)
+
+		switch command {
+		case "rm":
+			files, err := fs.DeleteFiles(opArgs.Names[0], opArgs.User, opArgs.Force)
+			if err != nil {
+				log.Fatalf("Error deleting files: %v", err)
+			}
+			for _, file := range files {
+				fmt.Printf("deleted %d:%s\n", file.User, file.Name)
+			}
+		case "ren":
+			user := uint8(0)
+			if opArgs.User != AnyUser {
+				user = uint8(opArgs.User)
+			}
+			toUser := user
+			if opArgs.ToUser != AnyUser {
+				toUser = uint8(opArgs.ToUser)
+			}
+			if err := fs.RenameFile(opArgs.Names[0], user, opArgs.Names[1], toUser, opArgs.Force); err != nil {
+				log.Fatalf("Error renaming file: %v", err)
+			}
+			fmt.Printf("renamed %d:%s to %d:%s\n", user, strings.ToUpper(opArgs.Names[0]), toUser, strings.ToUpper(opArgs.Names[1]))
+		case "attrib":
+			files, err := fs.SetAttributes(opArgs.Names[0], opArgs.User, opArgs.Set, opArgs.Clear)
+			if err != nil {
+				log.Fatalf("Error changing attributes: %v", err)
+			}
+			for _, file := range files {
+				fmt.Printf("%d:%-12s %s\n", file.User, file.Name, file.Attributes())
+			}
+		}
+
+		saveDSK(fs.DSK, opArgs.Filename, opArgs.OutputFile)
+
+	case "undelete":
+		undeleteArgs, err := ParseUndeleteArgs(os.Args[1:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fs := openFileSystem(undeleteArgs.Filename, formatOptions)
+
+		if undeleteArgs.Name == "" {
+			if err := fs.ListDeletedFiles(); err != nil {
+				log.Fatalf("Error listing deleted files: %v", err)
+			}
+			break
+		}
+
+		file, err := fs.FindDeletedFile(undeleteArgs.Name, undeleteArgs.Entry)
+		if err != nil {
+			log.Fatalf("Error finding deleted file: %v", err)
+		}
+
+		if undeleteArgs.ExtractDir != "" {
+			data, err := fs.ReadFile(&file.CPMFile)
+			if err != nil {
+				log.Fatalf("Error reading deleted file: %v", err)
+			}
+			if err := os.MkdirAll(undeleteArgs.ExtractDir, 0755); err != nil {
+				log.Fatalf("Error creating output directory: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(undeleteArgs.ExtractDir, hostFileName(file.Name)), data, 0644); err != nil {
+				log.Fatalf("Error writing %s: %v", file.Name, err)
+			}
+			fmt.Printf("extracted deleted %s (%s)\n", file.Name, file.Status())
+			break
+		}
+
+		if err := fs.Undelete(file, uint8(undeleteArgs.User), undeleteArgs.Force); err != nil {
+			log.Fatalf("Error recovering file: %v", err)
+		}
+		saveDSK(fs.DSK, undeleteArgs.Filename, undeleteArgs.OutputFile)
+		fmt.Printf("recovered %s to user %d (%s)\n", file.Name, undeleteArgs.User, file.Status())
+
+	case "fsck":
+		fsckArgs, err := ParseFsckArgs(os.Args[1:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fs := openFileSystem(fsckArgs.Filename, formatOptions)
+		report, err := fs.Check()
+		if err != nil {
+			log.Fatalf("Error checking filesystem: %v", err)
+		}
+		fmt.Printf("Format: %s (%d blocks of %d bytes, %d directory entries)\n",
+			fs.Format.Name, fs.Format.TotalBlocks(), fs.Format.BlockSize, fs.Format.DirEntries)
+		report.Print()
+
+		remaining := len(report.Problems)
+		if fsckArgs.Repair && report.Repairable() > 0 {
+			fixed, err := fs.Repair(report)
+			if err != nil {
+				log.Fatalf("Error repairing filesystem: %v", err)
+			}
+			outputFile := saveDSK(fs.DSK, fsckArgs.Filename, fsckArgs.OutputFile)
+			remaining -= len(fixed)
+			fmt.Printf("Repaired %d problem(s), written to %s\n", len(fixed), outputFile)
+		}
+		if remaining > 0 {
+			os.Exit(1)
+		}
+
+	case "map":
+		fs := openFileSystem(os.Args[2], formatOptions)
+		blockMap, err := fs.AllocationMap()
+		if err != nil {
+			log.Fatalf("Error reading allocation map: %v", err)
+		}
+		blockMap.Print()
+
+	case "formats":
+		if len(os.Args) > 2 {
+			loaded, err := LoadDiskFormats(os.Args[2])
+			if err != nil {
+				log.Fatalf("Error loading disk formats: %v", err)
+			}
+			fmt.Printf("loaded %d profile(s) from %s\n", len(loaded), os.Args[2])
+		}
+		ListDiskFormats()
+		if defs := loadDiskDefs(formatOptions); defs != nil {
+			fmt.Printf("\ndiskdefs from %s:\n", formatOptions.DiskDefs)
+			ListDiskDefs(defs)
+		}
+
+	case "detect":
+		filenames := os.Args[2:]
+		for _, filename := range filenames {
+			dsk, err := ParseDSK(filename)
+			if err != nil {
+				if len(filenames) == 1 {
+					log.Fatalf("Error parsing DSK: %v", err)
+				}
+				fmt.Printf("%s: error: %v\n", filename, err)
+				continue
+			}
+
+			report := DetectFormat(dsk)
+			if len(filenames) == 1 {
+				report.Print()
+			} else {
+				fmt.Printf("%s: %s\n", filename, report.Summary())
+			}
+		}
+
+	case "protection":
+		filenames := os.Args[2:]
+		for _, filename := range filenames {
+			dsk, err := ParseDSK(filename)
+			if err != nil {
+				if len(filenames) == 1 {
+					log.Fatalf("Error parsing DSK: %v", err)
+				}
+				fmt.Printf("%s: error: %v\n", filename, err)
+				continue
+			}
+
+			matches := DetectProtection(dsk)
+			if len(filenames) == 1 {
+				PrintProtection(matches, dsk.DescribeProtectionFeatures())
+			} else {
+				fmt.Printf("%s: %s\n", filename, ProtectionSummary(matches))
+			}
+		}
+
+	case "capacity":
+		filenames := os.Args[2:]
+		for _, filename := range filenames {
+			dsk, err := ParseDSK(filename)
+			if err != nil {
+				if len(filenames) == 1 {
+					log.Fatalf("Error parsing DSK: %v", err)
+				}
+				fmt.Printf("%s: error: %v\n", filename, err)
+				continue
+			}
+
+			results := AnalyzeCapacity(dsk)
+			if len(filenames) == 1 {
+				PrintCapacity(results)
+			} else {
+				fmt.Printf("%s: %s\n", filename, CapacitySummary(results))
+			}
+		}
+
+	case "amsdos":
+		amsdosArgs, err := ParseAmsdosArgs(os.Args[1:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if isDSKFile(amsdosArgs.Filename) {
+			if amsdosArgs.Add || amsdosArgs.Strip {
+				log.Fatalf("Error: --add and --strip only apply to host files")
+			}
+			pattern := amsdosArgs.Pattern
+			if pattern == "" {
+				pattern = "*.*"
+			}
+			fs := openFileSystem(amsdosArgs.Filename, formatOptions)
+			broken, err := fs.AMSDOSHeaders(pattern, amsdosArgs.User, amsdosArgs.Fix)
+			if err != nil {
+				log.Fatalf("Error reading headers: %v", err)
+			}
+			if amsdosArgs.Fix {
+				outputFile := saveDSK(fs.DSK, amsdosArgs.Filename, amsdosArgs.OutputFile)
+				fmt.Printf("Successfully wrote DSK to: %s\n", outputFile)
+			} else if broken > 0 {
+				os.Exit(1)
+			}
+			break
+		}
+
+		data, err := os.ReadFile(amsdosArgs.Filename)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", amsdosArgs.Filename, err)
+		}
+		if !amsdosArgs.Add && !amsdosArgs.Strip && !amsdosArgs.Fix {
+			fmt.Printf("%s: %s\n", amsdosArgs.Filename, DescribeFileHeader(data))
+			if header := ParseAMSDOSHeader(data); header != nil && !header.ChecksumValid {
+				os.Exit(1)
+			}
+			break
+		}
+
+		switch {
+		case amsdosArgs.Add:
+			if ParseAMSDOSHeader(data) != nil {
+				log.Fatalf("Error: %s already has an AMSDOS header", amsdosArgs.Filename)
+			}
+			name := amsdosArgs.Pattern
+			if name == "" {
+				name = filepath.Base(amsdosArgs.Filename)
+			}
+			user := uint8(0)
+			if amsdosArgs.User != AnyUser {
+				user = uint8(amsdosArgs.User)
+			}
+			if data, err = AddFileHeader(data, name, user, amsdosArgs.Header); err != nil {
+				log.Fatalf("Error creating header: %v", err)
+			}
+		case amsdosArgs.Strip:
+			if data, err = StripAMSDOSHeader(data); err != nil {
+				log.Fatalf("Error: %s: %v", amsdosArgs.Filename, err)
+			}
+		case amsdosArgs.Fix:
+			if ParseAMSDOSHeader(data) == nil {
+				log.Fatalf("Error: %s: no AMSDOS header found", amsdosArgs.Filename)
+			}
+			if changes := FixAMSDOSHeader(data); len(changes) > 0 {
+				fmt.Printf("fixed: %s\n", strings.Join(changes, ", "))
+			}
+		}
+
+		outputFile := amsdosArgs.OutputFile
+		if outputFile == "" {
+			outputFile = amsdosArgs.Filename
+		}
+		if err := os.WriteFile(outputFile, data, 0644); err != nil {
+			log.Fatalf("Error writing %s: %v", outputFile, err)
+		}
+		fmt.Printf("%s: %s\n", outputFile, DescribeFileHeader(data))
+
+	case "basic":
+		basicArgs, err := ParseBasicArgs(os.Args[1:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if basicArgs.Tokenize {
+			listing, err := os.ReadFile(basicArgs.Filename)
+			if err != nil {
+				log.Fatalf("Error reading %s: %v", basicArgs.Filename, err)
+			}
+			name := basicArgs.Pattern
+			if name == "" {
+				name = strings.ToUpper(filepath.Base(basicArgs.OutputFile))
+			}
+			data, err := TokenizeBASIC(string(listing), basicArgs.Dialect, name, basicArgs.Line, basicArgs.Headerless)
+			if err != nil {
+				log.Fatalf("Error tokenizing %s: %v", basicArgs.Filename, err)
+			}
+			if err := os.WriteFile(basicArgs.OutputFile, data, 0644); err != nil {
+				log.Fatalf("Error writing %s: %v", basicArgs.OutputFile, err)
+			}
+			fmt.Printf("tokenized %s to %s (%d bytes)\n", basicArgs.Filename, basicArgs.OutputFile, len(data))
+			break
+		}
+
+		if !isDSKFile(basicArgs.Filename) {
+			data, err := os.ReadFile(basicArgs.Filename)
+			if err != nil {
+				log.Fatalf("Error reading %s: %v", basicArgs.Filename, err)
+			}
+			listing, err := BASICListing(data, basicArgs.Dialect)
+			if err != nil {
+				log.Fatalf("Error listing %s: %v", basicArgs.Filename, err)
+			}
+			if basicArgs.OutputFile == "" {
+				fmt.Print(listing)
+				break
+			}
+			if err := os.WriteFile(basicArgs.OutputFile, []byte(listing), 0644); err != nil {
+				log.Fatalf("Error writing %s: %v", basicArgs.OutputFile, err)
+			}
+			break
+		}
+
+		pattern := basicArgs.Pattern
+		if pattern == "" {
+			pattern = "*.*"
+		}
+		fs := openFileSystem(basicArgs.Filename, formatOptions)
+		if basicArgs.OutputFile != "" {
+			programs, failures, err := fs.WriteBASICListings(pattern, basicArgs.User, basicArgs.OutputFile)
+			if err != nil {
+				log.Fatalf("Error listing BASIC programs: %v", err)
+			}
+			for _, program := range programs {
+				fmt.Printf("listed %d:%s\n", program.User, program.Name)
+			}
+			for _, failure := range failures {
+				fmt.Printf("Error listing %s\n", failure)
+			}
+			break
+		}
+		programs, listings, failures, err := fs.BASICListings(pattern, basicArgs.User)
+		if err != nil {
+			log.Fatalf("Error listing BASIC programs: %v", err)
+		}
+		for _, failure := range failures {
+			fmt.Printf("Error listing %s\n", failure)
+		}
+		if len(programs) == 0 && len(failures) == 0 {
+			log.Fatalf("Error: no BASIC programs match '%s'", pattern)
+		}
+		for i, program := range programs {
+			if len(programs) > 1 {
+				fmt.Printf("%d:%s\n", program.User, program.Name)
+			}
+			fmt.Print(listings[i])
+			if len(programs) > 1 && i < len(programs)-1 {
+				fmt.Println()
+			}
+		}
+
+	case "screen":
+		screenArgs, err := ParseScreenArgs(os.Args[1:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if isDSKFile(screenArgs.Filename) {
+			pattern := screenArgs.Pattern
+			if pattern == "" {
+				pattern = "*.*"
+			}
+			palette := screenArgs.Palette
+			if palette == nil {
+				palette = DefaultCPCPalette(screenArgs.Mode)
+			}
+			fs := openFileSystem(screenArgs.Filename, formatOptions)
+			files, kinds, err := fs.ExportScreens(pattern, screenArgs.User, screenArgs.OutputFile, screenArgs.Mode, palette)
+			if err != nil {
+				log.Fatalf("Error exporting screens: %v", err)
+			}
+			if len(files) == 0 {
+				log.Fatalf("Error: no screen files match '%s'", pattern)
+			}
+			for i, file := range files {
+				fmt.Printf("exported %d:%s (%s)\n", file.User, file.Name, kinds[i])
+			}
+			break
+		}
+
+		data, err := os.ReadFile(screenArgs.Filename)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", screenArgs.Filename, err)
+		}
+
+		if isPNGFile(data) {
+			img, err := ReadPNG(screenArgs.Filename)
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			kind, mode := ScreenFormatForImage(img)
+			if kind == "" {
+				log.Fatalf("Error: %s is %dx%d, screens must be 256x192 (Spectrum) or 160, 320 or 640 by 200 (CPC modes 0-2)",
+					screenArgs.Filename, img.Bounds().Dx(), img.Bounds().Dy())
+			}
+
+			var screen []byte
+			if kind == ScreenSpectrum {
+				screen, err = EncodeSpectrumScreen(img)
+			} else {
+				var palette []int
+				if screenArgs.Palette != nil && len(screenArgs.Palette) != CPCPens(mode) {
+					log.Fatalf("Error: the image is mode %d, add --mode %d to give its --palette", mode, mode)
+				}
+				screen, palette, err = EncodeCPCScreen(img, mode, screenArgs.Palette)
+				if err == nil {
+					fmt.Printf("mode %d palette %s\n", mode, formatCPCPalette(palette))
+				}
+			}
+			if err != nil {
+				log.Fatalf("Error converting %s: %v", screenArgs.Filename, err)
+			}
+
+			if !screenArgs.Headerless {
+				name := screenArgs.Pattern
+				if name == "" {
+					name = strings.ToUpper(filepath.Base(screenArgs.OutputFile))
+				}
+				if screen, err = ScreenFile(kind, screen, name); err != nil {
+					log.Fatalf("Error: %v", err)
+				}
+			}
+			if err := os.WriteFile(screenArgs.OutputFile, screen, 0644); err != nil {
+				log.Fatalf("Error writing %s: %v", screenArgs.OutputFile, err)
+			}
+			fmt.Printf("converted %s to %s screen %s (%d bytes)\n", screenArgs.Filename, kind, screenArgs.OutputFile, len(screen))
+			break
+		}
+
+		kind, screen := ScreenKind(data)
+		if kind == "" {
+			log.Fatalf("Error: %s is not a screen (%d or %d bytes after any header)", screenArgs.Filename, SpectrumScreenSize, CPCScreenSize)
+		}
+		palette := screenArgs.Palette
+		if palette == nil {
+			palette = DefaultCPCPalette(screenArgs.Mode)
+		}
+		img, err := RenderScreen(kind, screen, screenArgs.Mode, palette)
+		if err != nil {
+			log.Fatalf("Error rendering %s: %v", screenArgs.Filename, err)
+		}
+		if err := WritePNG(screenArgs.OutputFile, img); err != nil {
+			log.Fatalf("Error writing %s: %v", screenArgs.OutputFile, err)
+		}
+		fmt.Printf("rendered %s screen %s to %s\n", kind, screenArgs.Filename, screenArgs.OutputFile)
+
+	case "disasm":
+		disasmArgs, err := ParseDisasmArgs(os.Args[1:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		var code []byte
+		var origin uint16
+		entry := 0
+		switch {
+		case !isDSKFile(disasmArgs.Filename):
+			if disasmArgs.Boot || disasmArgs.Sector >= 0 {
+				log.Fatalf("Error: %s is not a DSK file", disasmArgs.Filename)
+			}
+			data, err := os.ReadFile(disasmArgs.Filename)
+			if err != nil {
+				log.Fatalf("Error reading %s: %v", disasmArgs.Filename, err)
+			}
+			fmt.Printf("; %s: %s\n", disasmArgs.Filename, DescribeFileHeader(data))
+			code, origin = CodeOrigin(data)
+
+		case disasmArgs.Name != "":
+			fs := openFileSystem(disasmArgs.Filename, formatOptions)
+			files, err := fs.MatchFiles(disasmArgs.Name, disasmArgs.User)
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			if len(files) != 1 {
+				log.Fatalf("Error: '%s' matches %d files, it must match one", disasmArgs.Name, len(files))
+			}
+			data, err := fs.ReadFile(&files[0])
+			if err != nil {
+				log.Fatalf("Error reading %s: %v", files[0].Name, err)
+			}
+			fmt.Printf("; %d:%s: %s\n", files[0].User, files[0].Name, DescribeFileHeader(data))
+			code, origin = CodeOrigin(data)
+
+		case disasmArgs.Boot || disasmArgs.Sector >= 0:
+			dsk, err := ParseDSK(disasmArgs.Filename)
+			if err != nil {
+				log.Fatalf("Error parsing DSK: %v", err)
+			}
+			if disasmArgs.Boot {
+				code, origin, entry, err = dsk.BootCode()
+			} else {
+				code, err = dsk.ReadSectors(disasmArgs.Track, disasmArgs.Side, uint8(disasmArgs.Sector), disasmArgs.Count)
+			}
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+
+		default:
+			fmt.Println("Error: specify a file on the disk, --boot or --track and --sector")
+			os.Exit(1)
+		}
+
+		if disasmArgs.Origin >= 0 {
+			origin = uint16(disasmArgs.Origin)
+		}
+		code, origin, entry, err = CodeRange(code, origin, entry, disasmArgs.Start, disasmArgs.End)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Print(DisassembleZ80(code, origin, entry))
+
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
-		fmt.Println("Commands: info, unpack, pack")
+		fmt.Println("Commands: info, unpack, pack, boot, ls, get, put, rm, ren, attrib, undelete, fsck, map, formats, detect, protection, capacity, amsdos, basic, screen, disasm")
 		os.Exit(1)
 	}
 }
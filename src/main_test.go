@@ -5,6 +5,7 @@
 package main
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -37,6 +38,17 @@ func TestParseUnpackArgs(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name: "BASIC listings",
+			args: []string{"unpack", "test.dsk", "output", "--basic"},
+			expected: UnpackArgs{
+				Filename:   "test.dsk",
+				OutputDir:  "output",
+				DataFormat: "binary",
+				Basic:      true,
+			},
+			expectError: false,
+		},
 		{
 			name: "filename with data format binary",
 			args: []string{"unpack", "test.dsk", "--data-format", "binary"},
@@ -153,3 +165,760 @@ func TestParseUnpackArgs(t *testing.T) {
 	}
 }
 
+
+func TestParseBootArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expected    BootArgs
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:     "report only",
+			args:     []string{"boot", "test.dsk"},
+			expected: BootArgs{Filename: "test.dsk", Target: "plus3"},
+		},
+		{
+			name:     "fix with target and output",
+			args:     []string{"boot", "test.dsk", "--fix", "--target", "pcw9512", "--output", "out.dsk"},
+			expected: BootArgs{Filename: "test.dsk", OutputFile: "out.dsk", Fix: true, Target: "pcw9512"},
+		},
+		{
+			name:     "install boot code",
+			args:     []string{"boot", "test.dsk", "--install", "boot.bin"},
+			expected: BootArgs{Filename: "test.dsk", InstallBin: "boot.bin", Target: "plus3"},
+		},
+		{
+			name:        "insufficient arguments",
+			args:        []string{"boot"},
+			expectError: true,
+			errorMsg:    "insufficient arguments",
+		},
+		{
+			name:        "invalid target",
+			args:        []string{"boot", "test.dsk", "--target", "zx81"},
+			expectError: true,
+			errorMsg:    "invalid boot target",
+		},
+		{
+			name:        "install missing value",
+			args:        []string{"boot", "test.dsk", "--install"},
+			expectError: true,
+			errorMsg:    "--install requires a value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseBootArgs(tt.args)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+					return
+				}
+				if tt.errorMsg != "" && !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("expected error message to contain '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if result != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseGetArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expected    GetArgs
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:     "filename and pattern",
+			args:     []string{"get", "test.dsk", "*.BAS"},
+			expected: GetArgs{Filename: "test.dsk", Pattern: "*.BAS", User: AnyUser},
+		},
+		{
+			name:     "all options",
+			args:     []string{"get", "test.dsk", "*.*", "out", "--user", "3", "--strip-header", "--text"},
+			expected: GetArgs{Filename: "test.dsk", Pattern: "*.*", OutputDir: "out", User: 3, StripHeader: true, Text: true},
+		},
+		{
+			name:     "keep header overrides strip header",
+			args:     []string{"get", "test.dsk", "*.*", "--strip-header", "--keep-header"},
+			expected: GetArgs{Filename: "test.dsk", Pattern: "*.*", User: AnyUser},
+		},
+		{
+			name:        "missing pattern",
+			args:        []string{"get", "test.dsk"},
+			expectError: true,
+			errorMsg:    "insufficient arguments",
+		},
+		{
+			name:        "invalid user",
+			args:        []string{"get", "test.dsk", "*.*", "--user", "16"},
+			expectError: true,
+			errorMsg:    "invalid user",
+		},
+		{
+			name:        "unknown option",
+			args:        []string{"get", "test.dsk", "*.*", "--binary"},
+			expectError: true,
+			errorMsg:    "unknown option",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseGetArgs(tt.args)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+					return
+				}
+				if tt.errorMsg != "" && !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("expected error message to contain '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if result != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseFileOpArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expected    FileOpArgs
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:     "rm with user and force",
+			args:     []string{"rm", "test.dsk", "*.BAK", "--user", "2", "--force"},
+			expected: FileOpArgs{Filename: "test.dsk", Names: []string{"*.BAK"}, User: 2, ToUser: AnyUser, Force: true},
+		},
+		{
+			name:     "ren to another user",
+			args:     []string{"ren", "test.dsk", "OLD.BAS", "NEW.BAS", "--to-user", "3", "--output", "out.dsk"},
+			expected: FileOpArgs{Filename: "test.dsk", Names: []string{"OLD.BAS", "NEW.BAS"}, OutputFile: "out.dsk", User: AnyUser, ToUser: 3},
+		},
+		{
+			name:     "attrib set and clear",
+			args:     []string{"attrib", "test.dsk", "*.*", "+ro", "-sys", "+arc"},
+			expected: FileOpArgs{Filename: "test.dsk", Names: []string{"*.*"}, User: AnyUser, ToUser: AnyUser, Set: AttrReadOnly | AttrArchive, Clear: AttrSystem},
+		},
+		{
+			name:        "ren missing new name",
+			args:        []string{"ren", "test.dsk", "OLD.BAS"},
+			expectError: true,
+			errorMsg:    "expects 2 filename",
+		},
+		{
+			name:        "attrib without changes",
+			args:        []string{"attrib", "test.dsk", "*.*"},
+			expectError: true,
+			errorMsg:    "requires at least one attribute change",
+		},
+		{
+			name:        "attrib invalid attribute",
+			args:        []string{"attrib", "test.dsk", "*.*", "+hidden"},
+			expectError: true,
+			errorMsg:    "invalid attribute",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseFileOpArgs(tt.args)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+					return
+				}
+				if tt.errorMsg != "" && !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("expected error message to contain '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseUndeleteArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expected    UndeleteArgs
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:     "list only",
+			args:     []string{"undelete", "test.dsk"},
+			expected: UndeleteArgs{Filename: "test.dsk", Entry: -1},
+		},
+		{
+			name:     "recover with entry and user",
+			args:     []string{"undelete", "test.dsk", "GAME.BIN", "--entry", "4", "--user", "2", "--force"},
+			expected: UndeleteArgs{Filename: "test.dsk", Name: "GAME.BIN", User: 2, Entry: 4, Force: true},
+		},
+		{
+			name:     "extract",
+			args:     []string{"undelete", "test.dsk", "GAME.BIN", "--extract", "out"},
+			expected: UndeleteArgs{Filename: "test.dsk", Name: "GAME.BIN", ExtractDir: "out", Entry: -1},
+		},
+		{
+			name:        "extract without name",
+			args:        []string{"undelete", "test.dsk", "--extract", "out"},
+			expectError: true,
+			errorMsg:    "name is required",
+		},
+		{
+			name:        "invalid user",
+			args:        []string{"undelete", "test.dsk", "GAME.BIN", "--user", "16"},
+			expectError: true,
+			errorMsg:    "invalid user",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseUndeleteArgs(tt.args)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+					return
+				}
+				if tt.errorMsg != "" && !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("expected error message to contain '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseBasicArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expected    BasicArgs
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:     "list disk",
+			args:     []string{"basic", "test.dsk"},
+			expected: BasicArgs{Filename: "test.dsk", User: AnyUser},
+		},
+		{
+			name:     "list pattern to directory",
+			args:     []string{"basic", "test.dsk", "*.BAS", "--user", "2", "--output", "listings"},
+			expected: BasicArgs{Filename: "test.dsk", Pattern: "*.BAS", OutputFile: "listings", User: 2},
+		},
+		{
+			name:     "tokenize",
+			args:     []string{"basic", "loader.txt", "DISC.BAS", "--tokenize", "--output", "disc.bas", "--no-header"},
+			expected: BasicArgs{Filename: "loader.txt", Pattern: "DISC.BAS", OutputFile: "disc.bas", User: AnyUser, Tokenize: true, Headerless: true},
+		},
+		{
+			name:     "tokenize +3 with autostart",
+			args:     []string{"basic", "loader.txt", "--tokenize", "--dialect", "PLUS3", "--line", "10", "--output", "disk"},
+			expected: BasicArgs{Filename: "loader.txt", OutputFile: "disk", User: AnyUser, Dialect: DialectPlus3, Line: 10, Tokenize: true},
+		},
+		{
+			name:        "unknown dialect",
+			args:        []string{"basic", "loader.bas", "--dialect", "bbc"},
+			expectError: true,
+			errorMsg:    "invalid dialect 'bbc'",
+		},
+		{
+			name:        "line without +3 dialect",
+			args:        []string{"basic", "loader.txt", "--tokenize", "--line", "10", "--output", "disk"},
+			expectError: true,
+			errorMsg:    "--line can only be used with --tokenize --dialect plus3",
+		},
+		{
+			name:        "tokenize without output",
+			args:        []string{"basic", "loader.txt", "--tokenize"},
+			expectError: true,
+			errorMsg:    "--tokenize requires --output",
+		},
+		{
+			name:        "no header without tokenize",
+			args:        []string{"basic", "test.dsk", "--no-header"},
+			expectError: true,
+			errorMsg:    "only be used with --tokenize",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseBasicArgs(tt.args)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+					return
+				}
+				if tt.errorMsg != "" && !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("expected error message to contain '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseAmsdosArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expected    AmsdosArgs
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name: "list disk",
+			args: []string{"amsdos", "test.dsk"},
+			expected: AmsdosArgs{Filename: "test.dsk", User: AnyUser,
+				Header: HeaderOptions{Kind: "amsdos", Type: "binary", Line: -1}},
+		},
+		{
+			name: "fix pattern to output",
+			args: []string{"amsdos", "test.dsk", "*.BIN", "--user", "1", "--fix", "--output", "fixed.dsk"},
+			expected: AmsdosArgs{Filename: "test.dsk", Pattern: "*.BIN", OutputFile: "fixed.dsk", User: 1, Fix: true,
+				Header: HeaderOptions{Kind: "amsdos", Type: "binary", Line: -1}},
+		},
+		{
+			name: "add header",
+			args: []string{"amsdos", "game.bin", "GAME.BIN", "--add", "--load", "0x4000", "--exec", "&4010"},
+			expected: AmsdosArgs{Filename: "game.bin", Pattern: "GAME.BIN", User: AnyUser, Add: true,
+				Header: HeaderOptions{Kind: "amsdos", Type: "binary", LoadAddress: 0x4000, ExecAddress: 0x4010, Line: -1}},
+		},
+		{
+			name:        "add and strip",
+			args:        []string{"amsdos", "game.bin", "--add", "--strip"},
+			expectError: true,
+			errorMsg:    "only one of",
+		},
+		{
+			name:        "load without add",
+			args:        []string{"amsdos", "game.bin", "--load", "0x4000"},
+			expectError: true,
+			errorMsg:    "--load can only be used with --add",
+		},
+		{
+			name:        "output without action",
+			args:        []string{"amsdos", "test.dsk", "--output", "fixed.dsk"},
+			expectError: true,
+			errorMsg:    "--output can only be used",
+		},
+		{
+			name:        "invalid type",
+			args:        []string{"amsdos", "game.bin", "--add", "--type", "screen"},
+			expectError: true,
+			errorMsg:    "invalid type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseAmsdosArgs(tt.args)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+					return
+				}
+				if tt.errorMsg != "" && !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("expected error message to contain '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParsePackArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expected    PackArgs
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:     "directory and output",
+			args:     []string{"pack", "disk", "disk.dsk"},
+			expected: PackArgs{UnpackedDir: "disk", OutputFile: "disk.dsk"},
+		},
+		{
+			name:     "with Offset-Info",
+			args:     []string{"pack", "--offset-info", "disk", "disk.dsk"},
+			expected: PackArgs{UnpackedDir: "disk", OutputFile: "disk.dsk", OffsetInfo: true},
+		},
+		{
+			name:        "missing output",
+			args:        []string{"pack", "disk"},
+			expectError: true,
+			errorMsg:    "insufficient arguments",
+		},
+		{
+			name:        "unknown argument",
+			args:        []string{"pack", "disk", "disk.dsk", "--offsets"},
+			expectError: true,
+			errorMsg:    "unknown argument",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParsePackArgs(tt.args)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+					return
+				}
+				if tt.errorMsg != "" && !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("expected error message to contain '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseFsckArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expected    FsckArgs
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:     "check only",
+			args:     []string{"fsck", "test.dsk"},
+			expected: FsckArgs{Filename: "test.dsk"},
+		},
+		{
+			name:     "repair to output",
+			args:     []string{"fsck", "test.dsk", "--repair", "--output", "fixed.dsk"},
+			expected: FsckArgs{Filename: "test.dsk", OutputFile: "fixed.dsk", Repair: true},
+		},
+		{
+			name:        "output without repair",
+			args:        []string{"fsck", "test.dsk", "--output", "fixed.dsk"},
+			expectError: true,
+			errorMsg:    "only be used with --repair",
+		},
+		{
+			name:        "unknown argument",
+			args:        []string{"fsck", "test.dsk", "--fix"},
+			expectError: true,
+			errorMsg:    "unknown argument",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseFsckArgs(tt.args)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+					return
+				}
+				if tt.errorMsg != "" && !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("expected error message to contain '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseFormatOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expected    FormatOptions
+		rest        []string
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:     "no options",
+			args:     []string{"magneato", "ls", "test.dsk"},
+			expected: FormatOptions{},
+			rest:     []string{"magneato", "ls", "test.dsk"},
+		},
+		{
+			name:     "format and diskdefs anywhere",
+			args:     []string{"magneato", "--diskdefs", "diskdefs", "get", "test.dsk", "--format", "einstein", "*.COM"},
+			expected: FormatOptions{Format: "einstein", DiskDefs: "diskdefs"},
+			rest:     []string{"magneato", "get", "test.dsk", "*.COM"},
+		},
+		{
+			name:     "diskdefs for formats listing",
+			args:     []string{"magneato", "formats", "--diskdefs", "diskdefs"},
+			expected: FormatOptions{DiskDefs: "diskdefs"},
+			rest:     []string{"magneato", "formats"},
+		},
+		{
+			name:        "diskdefs without format",
+			args:        []string{"magneato", "ls", "test.dsk", "--diskdefs", "diskdefs"},
+			expectError: true,
+			errorMsg:    "requires --format",
+		},
+		{
+			name:        "format missing value",
+			args:        []string{"magneato", "ls", "test.dsk", "--format"},
+			expectError: true,
+			errorMsg:    "requires a value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, rest, err := ParseFormatOptions(tt.args)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+					return
+				}
+				if tt.errorMsg != "" && !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("expected error message to contain '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+			if !reflect.DeepEqual(rest, tt.rest) {
+				t.Errorf("expected remaining arguments %v, got %v", tt.rest, rest)
+			}
+		})
+	}
+}
+
+func TestParseDisasmArgs(t *testing.T) {
+	defaults := DisasmArgs{Filename: "test.dsk", User: AnyUser, Track: -1, Sector: -1, Count: 1, Origin: -1, Start: -1, End: -1}
+	tests := []struct {
+		name        string
+		args        []string
+		expected    func(DisasmArgs) DisasmArgs
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:     "boot sector",
+			args:     []string{"disasm", "test.dsk", "--boot"},
+			expected: func(a DisasmArgs) DisasmArgs { a.Boot = true; return a },
+		},
+		{
+			name: "sectors with range",
+			args: []string{"disasm", "test.dsk", "--track", "2", "--side", "1", "--sector", "#C1", "--count", "3", "--origin", "&4000", "--start", "0x4010", "--end", "16416"},
+			expected: func(a DisasmArgs) DisasmArgs {
+				a.Track, a.Side, a.Sector, a.Count, a.Origin, a.Start, a.End = 2, 1, 0xC1, 3, 0x4000, 0x4010, 0x4020
+				return a
+			},
+		},
+		{
+			name:     "file",
+			args:     []string{"disasm", "test.dsk", "LOADER.BIN", "--user", "1"},
+			expected: func(a DisasmArgs) DisasmArgs { a.Name, a.User = "LOADER.BIN", 1; return a },
+		},
+		{
+			name:        "track without sector",
+			args:        []string{"disasm", "test.dsk", "--track", "0"},
+			expectError: true,
+			errorMsg:    "--track and --sector must be used together",
+		},
+		{
+			name:        "two sources",
+			args:        []string{"disasm", "test.dsk", "LOADER.BIN", "--boot"},
+			expectError: true,
+			errorMsg:    "only one of",
+		},
+		{
+			name:        "count without sector",
+			args:        []string{"disasm", "test.dsk", "--boot", "--count", "2"},
+			expectError: true,
+			errorMsg:    "--count can only be used",
+		},
+		{
+			name:        "bad sector ID",
+			args:        []string{"disasm", "test.dsk", "--track", "0", "--sector", "#100"},
+			expectError: true,
+			errorMsg:    "invalid sector ID",
+		},
+		{
+			name:        "end before start",
+			args:        []string{"disasm", "test.dsk", "--boot", "--start", "#FE20", "--end", "#FE10"},
+			expectError: true,
+			errorMsg:    "--end must not be before --start",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseDisasmArgs(tt.args)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+					return
+				}
+				if tt.errorMsg != "" && !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("expected error message to contain '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if expected := tt.expected(defaults); !reflect.DeepEqual(result, expected) {
+				t.Errorf("expected %+v, got %+v", expected, result)
+			}
+		})
+	}
+}
+
+func TestParseScreenArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expected    ScreenArgs
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:     "render with palette",
+			args:     []string{"screen", "loading.scr", "--mode", "0", "--palette", "0,26", "--output", "loading.png"},
+			expected: ScreenArgs{Filename: "loading.scr", OutputFile: "loading.png", User: AnyUser, Mode: 0, Palette: []int{0, 26, 20, 6, 26, 0, 2, 8, 10, 12, 14, 16, 18, 22, 1, 16}},
+		},
+		{
+			name:     "export from disk",
+			args:     []string{"screen", "test.dsk", "*.SCR", "--user", "0", "--output", "screens"},
+			expected: ScreenArgs{Filename: "test.dsk", Pattern: "*.SCR", OutputFile: "screens", User: 0, Mode: 1},
+		},
+		{
+			name:     "convert png",
+			args:     []string{"screen", "title.png", "TITLE.SCR", "--no-header", "--output", "title.scr"},
+			expected: ScreenArgs{Filename: "title.png", Pattern: "TITLE.SCR", OutputFile: "title.scr", User: AnyUser, Mode: 1, Headerless: true},
+		},
+		{
+			name:        "missing output",
+			args:        []string{"screen", "loading.scr"},
+			expectError: true,
+			errorMsg:    "--output is required",
+		},
+		{
+			name:        "invalid mode",
+			args:        []string{"screen", "loading.scr", "--mode", "3", "--output", "a.png"},
+			expectError: true,
+			errorMsg:    "invalid mode '3'",
+		},
+		{
+			name:        "too many inks",
+			args:        []string{"screen", "loading.scr", "--mode", "2", "--palette", "0,1,2", "--output", "a.png"},
+			expectError: true,
+			errorMsg:    "mode 2 has 2 pens",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseScreenArgs(tt.args)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+					return
+				}
+				if tt.errorMsg != "" && !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("expected error message to contain '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// offsetinfo.go - EDSK Offset-Info block giving the exact file offset of each track
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// OffsetInfoSignature starts the Offset-Info block some dumping tools write after the last track.
+// It is followed by a 32-bit little-endian file offset for every entry of the track size table,
+// 0 for unformatted tracks, so tracks need not be a whole number of 256-byte units.
+const OffsetInfoSignature = "Offset-Info\r\n"
+
+// findOffsetInfo returns the track offsets from the Offset-Info block and where the block starts,
+// or nil and the file length when there is no complete block. The block is only looked for where
+// the tracks in the size table end, allowing each to be up to 255 bytes short of its table size,
+// so the signature in sector data is not taken for it.
+func findOffsetInfo(data []byte, header *DiskHeader, totalBlocks int) ([]int, int) {
+	tracksEnd, formatted := HeaderSize, 0
+	for i := 0; i < totalBlocks; i++ {
+		if size := int(header.TrackSizeTable[i]) * 256; size > 0 {
+			tracksEnd += size
+			formatted++
+		}
+	}
+	earliest := max(HeaderSize, tracksEnd-255*formatted)
+	if earliest >= len(data) {
+		return nil, len(data)
+	}
+	index := bytes.LastIndex(data[earliest:min(len(data), tracksEnd+len(OffsetInfoSignature))], []byte(OffsetInfoSignature))
+	if index < 0 {
+		return nil, len(data)
+	}
+	start := earliest + index
+	table := data[start+len(OffsetInfoSignature):]
+	if len(table) < totalBlocks*4 {
+		return nil, len(data)
+	}
+
+	offsets := make([]int, totalBlocks)
+	for i := range offsets {
+		offsets[i] = int(binary.LittleEndian.Uint32(table[i*4:]))
+	}
+	return offsets, start
+}
+
+// validateOffsetInfo checks the Offset-Info offsets against the track size table, returning
+// the problems that mean the offsets cannot be used and warnings for tracks whose size
+// disagrees with the table
+func validateOffsetInfo(header *DiskHeader, offsets []int, blockStart int) (problems []string, warnings []string) {
+	previous := HeaderSize
+	for i, offset := range offsets {
+		size := int(header.TrackSizeTable[i]) * 256
+		switch {
+		case size == 0 && offset != 0:
+			warnings = append(warnings, fmt.Sprintf("track %d is unformatted in the size table but has offset %d", i, offset))
+		case size == 0:
+		case offset < previous || offset >= blockStart:
+			problems = append(problems, fmt.Sprintf("track %d offset %d is outside %d-%d", i, offset, previous, blockStart-1))
+		default:
+			previous = offset
+		}
+	}
+	if len(problems) > 0 {
+		return problems, warnings
+	}
+
+	// Each formatted track runs to the next one (or the block) and should round up to its table size
+	for i, offset := range offsets {
+		size := int(header.TrackSizeTable[i]) * 256
+		if size == 0 {
+			continue
+		}
+		end := blockStart
+		for _, next := range offsets[i+1:] {
+			if next > offset {
+				end = next
+				break
+			}
+		}
+		if span := end - offset; span > size || span <= size-256 {
+			warnings = append(warnings, fmt.Sprintf("track %d spans %d bytes but the size table gives %d", i, span, size))
+		}
+	}
+	return nil, warnings
+}
+
+// offsetInfoBytes builds an Offset-Info block for tracks written at the given offsets
+func offsetInfoBytes(offsets []int) []byte {
+	var out bytes.Buffer
+	out.WriteString(OffsetInfoSignature)
+	for _, offset := range offsets {
+		binary.Write(&out, binary.LittleEndian, uint32(offset))
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,173 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// offsetinfo_test.go - Unit tests for the EDSK Offset-Info block
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newUnpaddedDSKData builds an extended image whose tracks are not padded to 256 bytes, so it can
+// only be read with the Offset-Info block that follows them
+func newUnpaddedDSKData(t *testing.T) ([]byte, []int) {
+	dsk := newTestDSK(FormatExtended, 3, 1, 0xC1)
+	for c := 0; c < 3; c++ {
+		// 82 gap bytes leave each track 82 bytes past a 256 byte boundary
+		sector := &dsk.GetTrack(c, 0).Sectors[0]
+		sector.Data = append(sector.Data, bytes.Repeat([]byte{byte(c)}, 82)...)
+	}
+
+	header := dsk.Header
+	copy(header.SignatureString[:], "EXTENDED CPC DSK File\r\nDisk-Info\r\n")
+	var tracks bytes.Buffer
+	offsets := make([]int, 3)
+	for c := 0; c < 3; c++ {
+		trackData, err := dsk.GetTrack(c, 0).extendedBytes()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		offsets[c] = HeaderSize + tracks.Len()
+		header.TrackSizeTable[c] = uint8((len(trackData) + 0xFF) / 256)
+		tracks.Write(trackData)
+	}
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, &header)
+	out.Write(tracks.Bytes())
+	out.Write(offsetInfoBytes(offsets))
+	return out.Bytes(), offsets
+}
+
+func TestParseOffsetInfo(t *testing.T) {
+	data, offsets := newUnpaddedDSKData(t)
+	dsk, err := ParseDSKData(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(dsk.TrackOffsets, offsets) {
+		t.Errorf("expected offsets %v, got %v", offsets, dsk.TrackOffsets)
+	}
+	for c := 0; c < 3; c++ {
+		track := dsk.GetTrack(c, 0)
+		if track == nil || track.Sectors[0].Kind() != SectorGap || track.Sectors[0].Data[593] != byte(c) {
+			t.Fatalf("track %d: expected a gap sector ending in %d", c, c)
+		}
+		if sector := track.GetSector(0xC9); sector == nil || !bytes.Equal(sector.Data, bytes.Repeat([]byte{0xE5}, 512)) {
+			t.Errorf("track %d: last sector read from the wrong place", c)
+		}
+	}
+
+	// Written back out the tracks are padded and the block gives the new offsets
+	written, err := dsk.Bytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	found, start := findOffsetInfo(written, &dsk.Header, 3)
+	if start != len(written)-len(OffsetInfoSignature)-12 || !reflect.DeepEqual(found, []int{0x100, 0x100 + 0x1400, 0x100 + 0x2800}) {
+		t.Errorf("expected padded offsets at the end, got %v at %d", found, start)
+	}
+
+	dsk.TrackOffsets = nil
+	if written, _ = dsk.Bytes(); bytes.Contains(written, []byte(OffsetInfoSignature)) {
+		t.Errorf("expected no Offset-Info block without offsets")
+	}
+}
+
+func TestOffsetInfoSignatureInSectorData(t *testing.T) {
+	// A file holding the signature and a plausible offset is not an Offset-Info block
+	dsk := newTestDSK(FormatExtended, 1, 1, 0xC1)
+	sector := dsk.GetTrack(0, 0).GetSector(0xC1)
+	copy(sector.Data, offsetInfoBytes([]int{HeaderSize}))
+	data, err := dsk.Bytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if offsets, start := findOffsetInfo(data, &dsk.Header, 1); offsets != nil || start != len(data) {
+		t.Errorf("expected no Offset-Info block, got %v at %d", offsets, start)
+	}
+	parsed, err := ParseDSKData(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed.TrackOffsets != nil || len(parsed.GetTrack(0, 0).Sectors) != 9 {
+		t.Errorf("expected the track size table to be used, got offsets %v", parsed.TrackOffsets)
+	}
+}
+
+func TestValidateOffsetInfo(t *testing.T) {
+	header := &DiskHeader{}
+	header.TrackSizeTable[0], header.TrackSizeTable[1] = 0x14, 0x14
+
+	tests := []struct {
+		name       string
+		offsets    []int
+		blockStart int
+		problems   int
+		warnings   int
+	}{
+		{"padded", []int{0x100, 0x1500, 0}, 0x2900, 0, 0},
+		{"unpadded", []int{0x100, 0x1452, 0}, 0x27A4, 0, 0},
+		{"too long for the table", []int{0x100, 0x1600, 0}, 0x2A00, 0, 1},
+		{"unformatted with an offset", []int{0x100, 0x1500, 0x2900}, 0x2900, 0, 1},
+		{"backwards", []int{0x1500, 0x100, 0}, 0x2900, 1, 0},
+		{"past the block", []int{0x100, 0x3000, 0}, 0x2900, 1, 0},
+	}
+	for _, tt := range tests {
+		problems, warnings := validateOffsetInfo(header, tt.offsets, tt.blockStart)
+		if len(problems) != tt.problems || len(warnings) != tt.warnings {
+			t.Errorf("%s: expected %d problems and %d warnings, got %v %v", tt.name, tt.problems, tt.warnings, problems, warnings)
+		}
+	}
+
+	// Unusable offsets fall back to the size table
+	data := newTestDSK(FormatExtended, 2, 1, 0xC1)
+	data.TrackOffsets = []int{}
+	written, _ := data.Bytes()
+	binary.LittleEndian.PutUint32(written[len(written)-8:], 0xFFFFFF)
+	dsk, err := ParseDSKData(written)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dsk.TrackOffsets != nil || len(dsk.Tracks) != 2 {
+		t.Errorf("expected the size table to be used, got offsets %v", dsk.TrackOffsets)
+	}
+}
+
+func TestOffsetInfoUnpackPack(t *testing.T) {
+	data, _ := newUnpaddedDSKData(t)
+	dsk, err := ParseDSKData(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := dsk.Unpack("offsets.dsk", dir, "binary"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	packed, err := ReadUnpacked(filepath.Join(dir, "offsets"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if packed.TrackOffsets == nil {
+		t.Errorf("expected the Offset-Info block to be kept")
+	}
+
+	// Packing with --offset-info adds the block to images without one
+	plain := newTestDSK(FormatExtended, 1, 1, 0xC1)
+	if err := plain.Unpack("plain.dsk", dir, "binary"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	output := filepath.Join(dir, "plain-offsets.dsk")
+	if err := Pack(filepath.Join(dir, "plain"), output, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result, err := ParseDSK(output); err != nil || !reflect.DeepEqual(result.TrackOffsets, []int{0x100}) {
+		t.Errorf("expected an Offset-Info block with one track, got %v, %v", result.TrackOffsets, err)
+	}
+}
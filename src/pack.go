@@ -5,27 +5,44 @@
 package main
 
 import (
-	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
 // Pack reconstructs a DSK file from an unpacked directory structure
-func Pack(unpackedDir string, outputFilename string) error {
+// offsetInfo adds an Offset-Info block to extended images even if the original had none
+func Pack(unpackedDir string, outputFilename string, offsetInfo bool) error {
+	dsk, err := ReadUnpacked(unpackedDir)
+	if err != nil {
+		return err
+	}
+	if offsetInfo && dsk.TrackOffsets == nil {
+		dsk.TrackOffsets = []int{}
+	}
+
+	if err := dsk.Save(outputFilename); err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully packed DSK to: %s\n", outputFilename)
+	return nil
+}
+
+// ReadUnpacked rebuilds a DSK structure from an unpacked directory structure
+func ReadUnpacked(unpackedDir string) (*DSK, error) {
 	// Read disk-image.meta
 	diskMetaPath := filepath.Join(unpackedDir, "disk-image.meta")
 	diskMetaJSON, err := os.ReadFile(diskMetaPath)
 	if err != nil {
-		return fmt.Errorf("failed to read disk metadata: %v", err)
+		return nil, fmt.Errorf("failed to read disk metadata: %v", err)
 	}
 
 	var diskMeta map[string]interface{}
 	if err := json.Unmarshal(diskMetaJSON, &diskMeta); err != nil {
-		return fmt.Errorf("failed to parse disk metadata: %v", err)
+		return nil, fmt.Errorf("failed to parse disk metadata: %v", err)
 	}
 
 	// Reconstruct DiskHeader
@@ -33,12 +50,12 @@ func Pack(unpackedDir string, outputFilename string) error {
 	
 	// Set default signature based on format (if available)
 	// Default to extended format signature
+	format := FormatExtended
 	sigBytes := []byte("EXTENDED CPC DSK File\r\nDisk-Info\r\n")
 	if formatStr, ok := diskMeta["format"].(string); ok && formatStr == "standard" {
 		// Standard format signature (must start with "MV - CPC" and be 34 bytes)
-		sigBytes = make([]byte, 34)
-		copy(sigBytes, "MV - CPCEMU Disk-File")
-		// Rest is padded with zeros
+		format = FormatStandard
+		sigBytes = []byte("MV - CPCEMU Disk-File\r\nDisk-Info\r\n")
 	}
 	// Ensure signature is exactly 34 bytes
 	copy(header.SignatureString[:], sigBytes)
@@ -49,27 +66,40 @@ func Pack(unpackedDir string, outputFilename string) error {
 	// Creator
 	creatorStr, ok := diskMeta["creator"].(string)
 	if !ok {
-		return fmt.Errorf("invalid creator in disk metadata")
+		return nil, fmt.Errorf("invalid creator in disk metadata")
 	}
 	copy(header.CreatorString[:], []byte(creatorStr))
 	
 	// Tracks and Sides
 	tracksFloat, ok := diskMeta["tracks"].(float64)
 	if !ok {
-		return fmt.Errorf("invalid tracks in disk metadata")
+		return nil, fmt.Errorf("invalid tracks in disk metadata")
 	}
 	header.Tracks = uint8(tracksFloat)
 	
 	sidesFloat, ok := diskMeta["sides"].(float64)
 	if !ok {
-		return fmt.Errorf("invalid sides in disk metadata")
+		return nil, fmt.Errorf("invalid sides in disk metadata")
 	}
 	header.Sides = uint8(sidesFloat)
 	
-	// TrackSizeTable - CRITICAL for reconstruction
+	dsk := &DSK{
+		Format: format,
+	}
+
+	// Standard format has a single fixed track size instead of a table
+	if format == FormatStandard {
+		trackSizeFloat, ok := diskMeta["track_size"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("missing track_size in disk metadata - cannot reconstruct file")
+		}
+		dsk.StandardTrackSize = uint16(trackSizeFloat)
+	}
+
+	// TrackSizeTable - CRITICAL for reconstruction of extended format
 	trackSizeTableInterface, ok := diskMeta["track_size_table"]
-	if !ok {
-		return fmt.Errorf("missing track_size_table in disk metadata - cannot reconstruct file")
+	if !ok && format == FormatExtended {
+		return nil, fmt.Errorf("missing track_size_table in disk metadata - cannot reconstruct file")
 	}
 	
 	// Handle different JSON unmarshaling types
@@ -87,7 +117,7 @@ func Pack(unpackedDir string, outputFilename string) error {
 			case int:
 				trackSizeTableValues[i] = uint8(num)
 			default:
-				return fmt.Errorf("invalid track_size_table entry type at index %d: %T", i, val)
+				return nil, fmt.Errorf("invalid track_size_table entry type at index %d: %T", i, val)
 			}
 		}
 	case []float64:
@@ -99,65 +129,24 @@ func Pack(unpackedDir string, outputFilename string) error {
 	case []uint8:
 		// JSON unmarshaled as []uint8 (unlikely but possible)
 		trackSizeTableValues = v
+	case nil:
+		// Standard format - no table
 	default:
-		return fmt.Errorf("invalid track_size_table format in disk metadata: expected array, got %T", trackSizeTableInterface)
+		return nil, fmt.Errorf("invalid track_size_table format in disk metadata: expected array, got %T", trackSizeTableInterface)
 	}
 	
 	if len(trackSizeTableValues) > len(header.TrackSizeTable) {
-		return fmt.Errorf("track_size_table too large: %d > %d", len(trackSizeTableValues), len(header.TrackSizeTable))
+		return nil, fmt.Errorf("track_size_table too large: %d > %d", len(trackSizeTableValues), len(header.TrackSizeTable))
 	}
 	copy(header.TrackSizeTable[:], trackSizeTableValues)
+	dsk.Header = header
 
-	// Create output file
-	outFile, err := os.Create(outputFilename)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %v", err)
-	}
-	defer outFile.Close()
-
-	// Write header manually to ensure correct structure
-	// Write signature (34 bytes)
-	if _, err := outFile.Write(header.SignatureString[:]); err != nil {
-		return fmt.Errorf("failed to write signature: %v", err)
-	}
-	
-	// Write creator (14 bytes)
-	if _, err := outFile.Write(header.CreatorString[:]); err != nil {
-		return fmt.Errorf("failed to write creator: %v", err)
-	}
-	
-	// Write tracks (1 byte)
-	if _, err := outFile.Write([]byte{header.Tracks}); err != nil {
-		return fmt.Errorf("failed to write tracks: %v", err)
-	}
-	
-	// Write sides (1 byte)
-	if _, err := outFile.Write([]byte{header.Sides}); err != nil {
-		return fmt.Errorf("failed to write sides: %v", err)
-	}
-	
-	// Write 2 bytes of padding (unused)
-	if _, err := outFile.Write([]byte{0, 0}); err != nil {
-		return fmt.Errorf("failed to write padding: %v", err)
-	}
-	
-	// Write track size table - only write tracks * sides entries
-	trackTableSize := int(header.Tracks) * int(header.Sides)
-	if trackTableSize > len(header.TrackSizeTable) {
-		return fmt.Errorf("track table size %d exceeds maximum %d", trackTableSize, len(header.TrackSizeTable))
-	}
-	if _, err := outFile.Write(header.TrackSizeTable[:trackTableSize]); err != nil {
-		return fmt.Errorf("failed to write track size table: %v", err)
-	}
-	
-	// Pad the rest of the header to 256 bytes (0x100)
-	// We've written: 34 + 14 + 1 + 1 + 2 + trackTableSize = 52 + trackTableSize bytes
-	// Need to pad to 256 bytes
-	bytesWritten := 52 + trackTableSize
-	if bytesWritten < HeaderSize {
-		padding := make([]byte, HeaderSize-bytesWritten)
-		if _, err := outFile.Write(padding); err != nil {
-			return fmt.Errorf("failed to write header padding: %v", err)
+	// Offset-Info is rebuilt from where the tracks are written so only its presence matters
+	if offsetInfo, ok := diskMeta["offset_info"].([]interface{}); ok {
+		dsk.TrackOffsets = make([]int, len(offsetInfo))
+		for i, v := range offsetInfo {
+			offset, _ := v.(float64)
+			dsk.TrackOffsets[i] = int(offset)
 		}
 	}
 
@@ -166,6 +155,9 @@ func Pack(unpackedDir string, outputFilename string) error {
 	
 	for i := 0; i < totalBlocks; i++ {
 		trackSize := int(header.TrackSizeTable[i]) * 256
+		if format == FormatStandard {
+			trackSize = int(dsk.StandardTrackSize)
+		}
 		
 		// Calculate track number and side from position index
 		trackNum := i / int(header.Sides)
@@ -192,7 +184,7 @@ func Pack(unpackedDir string, outputFilename string) error {
 				continue
 			} else {
 				// Unexpected - track should exist but doesn't
-				return fmt.Errorf("track %d (track %d, side %d) should exist but directory not found", i, trackNum, sideNum)
+				return nil, fmt.Errorf("track %d (track %d, side %d) should exist but directory not found", i, trackNum, sideNum)
 			}
 		}
 		
@@ -206,12 +198,17 @@ func Pack(unpackedDir string, outputFilename string) error {
 		trackMetaPath := filepath.Join(trackDir, "track.meta")
 		trackMetaJSON, err := os.ReadFile(trackMetaPath)
 		if err != nil {
-			return fmt.Errorf("failed to read track metadata for track %d: %v", i, err)
+			return nil, fmt.Errorf("failed to read track metadata for track %d: %v", i, err)
 		}
 
 		var trackMeta map[string]interface{}
 		if err := json.Unmarshal(trackMetaJSON, &trackMeta); err != nil {
-			return fmt.Errorf("failed to parse track metadata for track %d: %v", i, err)
+			return nil, fmt.Errorf("failed to parse track metadata for track %d: %v", i, err)
+		}
+
+		// Standard format stores every track so unformatted ones are only flagged in the metadata
+		if formatted, ok := trackMeta["formatted"].(bool); ok && !formatted {
+			continue
 		}
 
 		// Reconstruct TrackHeader
@@ -243,15 +240,21 @@ func Pack(unpackedDir string, outputFilename string) error {
 		sideNumMeta, _ := trackMeta["side_number"].(float64)
 		trackHeader.SideNum = uint8(sideNumMeta)
 		
-		// Unused2
-		unused2Array, ok := trackMeta["unused2"].([]interface{})
-		if ok {
-			for j, v := range unused2Array {
-				if j >= len(trackHeader.Unused2) {
-					break
-				}
-				val, _ := v.(float64)
-				trackHeader.Unused2[j] = uint8(val)
+		// Data rate and recording mode, by name or number (older metas have them as an unused2 array)
+		if unused2Array, ok := trackMeta["unused2"].([]interface{}); ok && len(unused2Array) == 2 {
+			dataRate, _ := unused2Array[0].(float64)
+			trackHeader.DataRate = uint8(dataRate)
+			recordingMode, _ := unused2Array[1].(float64)
+			trackHeader.RecordingMode = uint8(recordingMode)
+		}
+		if value, ok := trackMeta["data_rate"]; ok {
+			if trackHeader.DataRate, err = parseTrackFieldMeta(value, ParseDataRate); err != nil {
+				return nil, fmt.Errorf("invalid data_rate for track %d: %v", i, err)
+			}
+		}
+		if value, ok := trackMeta["recording_mode"]; ok {
+			if trackHeader.RecordingMode, err = parseTrackFieldMeta(value, ParseRecordingMode); err != nil {
+				return nil, fmt.Errorf("invalid recording_mode for track %d: %v", i, err)
 			}
 		}
 		
@@ -267,12 +270,7 @@ func Pack(unpackedDir string, outputFilename string) error {
 		fillerByte, _ := trackMeta["filler_byte"].(float64)
 		trackHeader.FillerByte = uint8(fillerByte)
 
-		// Write track header
-		if err := binary.Write(outFile, binary.LittleEndian, &trackHeader); err != nil {
-			return fmt.Errorf("failed to write track header %d: %v", i, err)
-		}
-
-		// Read and write sectors
+		// Read sectors
 		// Read sector files in order
 		sectorInfos := make([]SectorInfo, 0, trackHeader.SectorCount)
 		sectorDataMap := make(map[uint8][]byte)
@@ -280,7 +278,7 @@ func Pack(unpackedDir string, outputFilename string) error {
 		// Read all sector files
 		entries, err := os.ReadDir(trackDir)
 		if err != nil {
-			return fmt.Errorf("failed to read track directory: %v", err)
+			return nil, fmt.Errorf("failed to read track directory: %v", err)
 		}
 		
 		for _, entry := range entries {
@@ -295,12 +293,12 @@ func Pack(unpackedDir string, outputFilename string) error {
 				sectorMetaPath := filepath.Join(trackDir, entry.Name())
 				sectorMetaJSON, err := os.ReadFile(sectorMetaPath)
 				if err != nil {
-					return fmt.Errorf("failed to read sector metadata: %v", err)
+					return nil, fmt.Errorf("failed to read sector metadata: %v", err)
 				}
 				
 				var sectorMeta map[string]interface{}
 				if err = json.Unmarshal(sectorMetaJSON, &sectorMeta); err != nil {
-					return fmt.Errorf("failed to parse sector metadata: %v", err)
+					return nil, fmt.Errorf("failed to parse sector metadata: %v", err)
 				}
 				
 				sectorInfo := SectorInfo{}
@@ -312,32 +310,46 @@ func Pack(unpackedDir string, outputFilename string) error {
 				sectorInfo.R = uint8(sectorID)
 				sectorSize, _ := sectorMeta["sector_size"].(float64)
 				sectorInfo.N = uint8(sectorSize)
-				fdcStatus1, _ := sectorMeta["fdc_status1"].(float64)
-				sectorInfo.FDCStatus1 = uint8(fdcStatus1)
-				fdcStatus2, _ := sectorMeta["fdc_status2"].(float64)
-				sectorInfo.FDCStatus2 = uint8(fdcStatus2)
+				if sectorInfo.FDCStatus1, err = parseFDCStatusMeta(sectorMeta["fdc_status1"], FDCStatus1Flags); err != nil {
+					return nil, fmt.Errorf("invalid fdc_status1 for sector %d in track %d: %v", sectorNum, i, err)
+				}
+				if sectorInfo.FDCStatus2, err = parseFDCStatusMeta(sectorMeta["fdc_status2"], FDCStatus2Flags); err != nil {
+					return nil, fmt.Errorf("invalid fdc_status2 for sector %d in track %d: %v", sectorNum, i, err)
+				}
 				dataLength, _ := sectorMeta["data_length"].(float64)
 				sectorInfo.DataLength = uint16(dataLength)
 				
 				sectorInfos = append(sectorInfos, sectorInfo)
 				
-				// Detect format and get file path
-				dataFormat, sectorDataPath, err := DetectFormatFromFile(trackDir, sectorNum)
-				if err != nil {
-					return fmt.Errorf("failed to detect format for sector %d in track %d: %v", sectorNum, i, err)
+				// Weak sectors have a file per copy, joined back together in order
+				var sectorData []byte
+				copies, _ := sectorMeta["copies"].(float64)
+				if copies > 1 {
+					for k := 1; k <= int(copies); k++ {
+						copyData, err := readSectorDataFile(trackDir, sectorNum, k)
+						if err != nil {
+							return nil, fmt.Errorf("failed to read copy %d of sector %d in track %d: %v", k, sectorNum, i, err)
+						}
+						sectorData = append(sectorData, copyData...)
+					}
+					if sectorInfo.DataLength != 0 && len(sectorData) != int(sectorInfo.DataLength) {
+						return nil, fmt.Errorf("copies of sector %d in track %d total %d bytes but data_length is %d", sectorNum, i, len(sectorData), sectorInfo.DataLength)
+					}
+				} else {
+					sectorData, err = readSectorDataFile(trackDir, sectorNum, 0)
+					if err != nil {
+						return nil, fmt.Errorf("failed to read sector %d in track %d: %v", sectorNum, i, err)
+					}
 				}
-				
-				// Get the appropriate reader function and read sector data
-				reader, err := GetFormatReader(dataFormat)
-				if err != nil {
-					return fmt.Errorf("failed to get format reader for sector %d: %v", sectorNum, err)
-				}
-				
-				sectorData, err := reader(sectorDataPath)
-				if err != nil {
-					return fmt.Errorf("failed to read sector data for sector %d: %v", sectorNum, err)
+
+				// The kind is worked out from the data but must agree with the meta when given
+				if kind, ok := sectorMeta["kind"].(string); ok {
+					sector := LogicalSector{Info: sectorInfo, Data: sectorData}
+					if actual := sector.Kind(); string(actual) != kind {
+						return nil, fmt.Errorf("sector %d in track %d has %d bytes of data making it %s but its kind is %s", sectorNum, i, len(sectorData), actual, kind)
+					}
 				}
-				
+
 				sectorDataMap[sectorNum] = sectorData
 			}
 		}
@@ -347,42 +359,39 @@ func Pack(unpackedDir string, outputFilename string) error {
 		// For now, we'll write them in the order they appear in the directory
 		// which should match the original order if unpack preserved it
 		
-		// Write sector info list
-		for _, sectorInfo := range sectorInfos {
-			if err := binary.Write(outFile, binary.LittleEndian, &sectorInfo); err != nil {
-				return fmt.Errorf("failed to write sector info: %v", err)
-			}
+		logicalTrack := LogicalTrack{
+			Header:  trackHeader,
+			Sectors: make([]LogicalSector, 0, len(sectorInfos)),
 		}
-		
-		// Write sector data in the same order
 		for _, sectorInfo := range sectorInfos {
-			sectorData := sectorDataMap[sectorInfo.R]
-			if _, err := outFile.Write(sectorData); err != nil {
-				return fmt.Errorf("failed to write sector data: %v", err)
-			}
-		}
-		
-		// Pad track to the expected size if necessary
-		currentPos, _ := outFile.Seek(0, io.SeekCurrent)
-		trackStartPos := int64(HeaderSize)
-		for j := 0; j < i; j++ {
-			trackStartPos += int64(header.TrackSizeTable[j]) * 256
-		}
-		bytesWritten := currentPos - trackStartPos
-		if bytesWritten < int64(trackSize) {
-			padding := make([]byte, int64(trackSize)-bytesWritten)
-			// Fill with filler byte if specified, otherwise zeros
-			filler := trackHeader.FillerByte
-			for j := range padding {
-				padding[j] = filler
-			}
-			if _, err := outFile.Write(padding); err != nil {
-				return fmt.Errorf("failed to pad track: %v", err)
-			}
+			logicalTrack.Sectors = append(logicalTrack.Sectors, LogicalSector{
+				Info: sectorInfo,
+				Data: sectorDataMap[sectorInfo.R],
+			})
 		}
+		dsk.Tracks = append(dsk.Tracks, logicalTrack)
 	}
 
-	fmt.Printf("Successfully packed DSK to: %s\n", outputFilename)
-	return nil
+	dsk.Specification = dsk.readSpecification()
+
+	return dsk, nil
 }
 
+// readSectorDataFile reads a sector data file in whichever format it was unpacked in
+// copyNum selects a copy of a weak sector, or 0 for the sector-N file of a normal sector
+func readSectorDataFile(trackDir string, sectorNum uint8, copyNum int) ([]byte, error) {
+	dataFormat, sectorDataPath, err := DetectFormatFromFile(trackDir, sectorNum)
+	if copyNum > 0 {
+		dataFormat, sectorDataPath, err = DetectCopyFormatFromFile(trackDir, sectorNum, copyNum)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect format: %v", err)
+	}
+
+	reader, err := GetFormatReader(dataFormat)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get format reader: %v", err)
+	}
+
+	return reader(sectorDataPath)
+}
@@ -28,6 +28,11 @@ func ParseDSK(filename string) (*DSK, error) {
 		return nil, err
 	}
 
+	return ParseDSKData(data)
+}
+
+// ParseDSKData parses an in-memory DSK image and returns a DSK structure
+func ParseDSKData(data []byte) (*DSK, error) {
 	if len(data) < HeaderSize {
 		return nil, fmt.Errorf("file too small to contain header")
 	}
@@ -73,6 +78,22 @@ func parseExtendedDSK(data []byte) (*DSK, error) {
 	// because track sizes are variable.
 	currentOffset := int64(HeaderSize)
 
+	// An Offset-Info block after the last track gives the exact offset of each track instead
+	offsets, blockStart := findOffsetInfo(data, &dsk.Header, totalBlocks)
+	if offsets != nil {
+		problems, warnings := validateOffsetInfo(&dsk.Header, offsets, blockStart)
+		for _, warning := range warnings {
+			fmt.Printf("Warning: Offset-Info %s\n", warning)
+		}
+		for _, problem := range problems {
+			fmt.Printf("Warning: Offset-Info %s, using the track size table instead\n", problem)
+		}
+		if len(problems) > 0 {
+			offsets, blockStart = nil, len(data)
+		}
+		dsk.TrackOffsets = offsets
+	}
+
 	for i := 0; i < totalBlocks; i++ {
 		// Calculate Track Size: table entry * 256
 		trackSize := int(dsk.Header.TrackSizeTable[i]) * 256
@@ -83,11 +104,16 @@ func parseExtendedDSK(data []byte) (*DSK, error) {
 		}
 
 		// Create a reader specifically for this track block
-		if int64(len(data)) < currentOffset+int64(trackSize) {
+		// With Offset-Info the last track may stop short of its table size where the block starts
+		if offsets != nil {
+			currentOffset = int64(offsets[i])
+		}
+		trackEnd := min(currentOffset+int64(trackSize), int64(blockStart))
+		if int64(len(data)) < currentOffset+int64(trackSize) && offsets == nil {
 			return nil, fmt.Errorf("file unexpected EOF at track %d", i)
 		}
 
-		trackData := data[currentOffset : currentOffset+int64(trackSize)]
+		trackData := data[currentOffset:trackEnd]
 		trackReader := bytes.NewReader(trackData)
 
 		// Parse Track Header
@@ -118,17 +144,21 @@ func parseExtendedDSK(data []byte) (*DSK, error) {
 		}
 
 		// Parse Sector Data
-		// Sector data follows the Sector Info list.
+		// Sector data starts after the Track-Info block which is 0x100 bytes
+		// (or rounded up to the next 0x100 boundary for tracks with many sectors).
 		// Note: In extended DSK, DataLength in SectorInfo dictates size.
-		// If DataLength is 0, use calculated size: 128 * 2^N.
+		// If DataLength is 0 the sector has an ID field but no data field (see SectorIDOnly).
+		sectorDataOffset := trackInfoBlockSize(int(tHeader.SectorCount))
+		if len(trackData) < sectorDataOffset {
+			return nil, fmt.Errorf("track %d too small for sector data (size: %d, need offset %d)", i, len(trackData), sectorDataOffset)
+		}
+		trackReader = bytes.NewReader(trackData[sectorDataOffset:])
+
 		for _, sInfo := range sectorInfos {
 			secLen := int(sInfo.DataLength)
-			if secLen == 0 {
-				secLen = 128 * (1 << sInfo.N)
-			}
 
 			secData := make([]byte, secLen)
-			if _, err := trackReader.Read(secData); err != nil {
+			if _, err := trackReader.Read(secData); err != nil && secLen > 0 {
 				// If we run out of data in the block, it might be a short dump or protection scheme
 				fmt.Printf("Warning: Short read on Track %d Sector %d\n", tHeader.TrackNum, sInfo.R)
 			}
@@ -143,9 +173,22 @@ func parseExtendedDSK(data []byte) (*DSK, error) {
 		currentOffset += int64(trackSize)
 	}
 
+	dsk.Specification = dsk.readSpecification()
+
 	return dsk, nil
 }
 
+// trackInfoBlockSize returns the size of the Track-Info block for a track with the given sector count
+// This is 0x100 bytes unless the header and sector info list overflow it, in which case it is
+// rounded up to the next 0x100 boundary
+func trackInfoBlockSize(sectorCount int) int {
+	size := 0x18 + sectorCount*8
+	if size <= 0x100 {
+		return 0x100
+	}
+	return (size + 0xFF) &^ 0xFF
+}
+
 // parseStandardDSK parses a Standard DSK file
 func parseStandardDSK(data []byte) (*DSK, error) {
 	reader := bytes.NewReader(data)
@@ -159,9 +202,9 @@ func parseStandardDSK(data []byte) (*DSK, error) {
 		return nil, fmt.Errorf("failed to read header: %v", err)
 	}
 
-	// For standard format, read the fixed track size from offset 32-33
+	// For standard format, read the fixed track size from offset 0x32-0x33
 	// This is stored in the padding field of DiskHeader
-	dsk.StandardTrackSize = binary.LittleEndian.Uint16(data[32:34])
+	dsk.StandardTrackSize = binary.LittleEndian.Uint16(data[0x32:0x34])
 	
 	// Validate header values
 	if dsk.Header.Tracks == 0 || dsk.Header.Tracks > 85 {
@@ -209,7 +252,7 @@ func parseStandardDSK(data []byte) (*DSK, error) {
 		// Check Track Signature (should be "Track-Info\r\n" - 13 bytes)
 		// Be lenient - allow null bytes after \r\n
 		expectedSig := []byte("Track-Info\r\n")
-		sigValid := len(tHeader.Signature) >= 13 && bytes.Equal(tHeader.Signature[:13], expectedSig)
+		sigValid := bytes.Equal(tHeader.Signature[:len(expectedSig)], expectedSig)
 		
 		// If signature is invalid, this might be an unformatted track
 		// In standard format, all tracks exist but may be unformatted (filled with 0xE5 or similar)
@@ -264,13 +307,13 @@ func parseStandardDSK(data []byte) (*DSK, error) {
 		}
 
 		// Parse Sector Information List
-		// In standard format, sector info is only 6 bytes (not 8 like extended format)
+		// In standard format, sector info entries are still 8 bytes but only 6 are meaningful
 		// Layout: C, H, R, N, FDCStatus1, FDCStatus2 (bytes 06-07 are unused/0)
 		// The sector info list starts immediately after the track header (offset 0x18)
 		sectorInfos := make([]SectorInfo, tHeader.SectorCount)
 		for s := 0; s < int(tHeader.SectorCount); s++ {
-			// Read 6 bytes for standard format sector info
-			sectorInfoBytes := make([]byte, 6)
+			// Read the 8 byte entry, ignoring the unused trailing 2 bytes
+			sectorInfoBytes := make([]byte, 8)
 			if _, err := trackReader.Read(sectorInfoBytes); err != nil {
 				return nil, fmt.Errorf("failed to read sector info: %v", err)
 			}
@@ -358,6 +401,8 @@ func parseStandardDSK(data []byte) (*DSK, error) {
 		currentOffset += int64(trackSize)
 	}
 
+	dsk.Specification = dsk.readSpecification()
+
 	return dsk, nil
 }
 
@@ -370,3 +415,13 @@ func (d *DSK) GetTrack(cylinder int, head int) *LogicalTrack {
 	}
 	return nil
 }
+
+// GetSector returns a pointer to a LogicalSector if found (by sector ID)
+func (t *LogicalTrack) GetSector(id uint8) *LogicalSector {
+	for i := range t.Sectors {
+		if t.Sectors[i].Info.R == id {
+			return &t.Sectors[i]
+		}
+	}
+	return nil
+}
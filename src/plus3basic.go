@@ -0,0 +1,565 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// plus3basic.go - Sinclair +3 BASIC detokenizer and tokenizer
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sinclairKeywords are the tokens &A3-&FF of 128K/+3 BASIC (&A3 and &A4 are UDGs T and U on the 48K)
+var sinclairKeywords = [0x5D]string{
+	"SPECTRUM", "PLAY", "RND", "INKEY$", "PI", "FN", "POINT", "SCREEN$", "ATTR", "AT", "TAB", "VAL$", "CODE", // &A3
+	"VAL", "LEN", "SIN", "COS", "TAN", "ASN", "ACS", "ATN", "LN", "EXP", "INT", "SQR", "SGN", "ABS", "PEEK", "IN", // &B0
+	"USR", "STR$", "CHR$", "NOT", "BIN", "OR", "AND", "<=", ">=", "<>", "LINE", "THEN", "TO", "STEP", "DEF FN", "CAT", // &C0
+	"FORMAT", "MOVE", "ERASE", "OPEN #", "CLOSE #", "MERGE", "VERIFY", "BEEP", "CIRCLE", "INK", "PAPER", "FLASH", // &D0
+	"BRIGHT", "INVERSE", "OVER", "OUT", // &DC
+	"LPRINT", "LLIST", "STOP", "READ", "DATA", "RESTORE", "NEW", "BORDER", "CONTINUE", "DIM", "REM", "FOR", "GO TO", // &E0
+	"GO SUB", "INPUT", "LOAD", "LIST", "LET", "PAUSE", "NEXT", "POKE", "PRINT", "PLOT", "RUN", "SAVE", "RANDOMIZE", // &ED
+	"IF", "CLS", "DRAW", "CLEAR", "RETURN", "COPY", // &FA
+}
+
+// Sinclair BASIC codes
+const (
+	sinclairFirstToken   = 0xA3
+	sinclairFirstUDG     = 0x90
+	sinclairFirstBlock   = 0x80
+	sinclairNumber       = 0x0E // Followed by the 5-byte form of the number just listed
+	sinclairEndOfLine    = 0x0D
+	sinclairCopyright    = 0x7F
+	sinclairPound        = 0x60
+	sinclairTokenBIN     = 0xC4
+	sinclairTokenDEFFN   = 0xCE
+	sinclairTokenREM     = 0xEA
+	sinclairMaxLine      = 9999
+	sinclairNumberLength = 5
+)
+
+// sinclairControlCodes are the names of the colour and position control codes &10-&17,
+// written as \{name n} in listings
+var sinclairControlCodes = []string{"ink", "paper", "flash", "bright", "inverse", "over", "at", "tab"}
+
+// sinclairBlockChars are the characters used for each quarter pair of the block graphics &80-&8F
+// (space for neither, ' for the top, . for the bottom and : for both), written as \ followed by the
+// left and right halves
+const sinclairBlockChars = " '.:"
+
+// sinclairTokenSpacing returns whether LIST puts a space before and after a token
+// Functions up to BIN and the comparison operators have no space in front, RND, INKEY$ and PI none after
+func sinclairTokenSpacing(token byte) (leading bool, trailing bool) {
+	keyword := sinclairKeywords[token-sinclairFirstToken]
+	index := int(token) - 0xA5 // RND is the first entry of the ROM's token table
+	last := keyword[len(keyword)-1]
+	leading = index >= 0x20 && keyword[0] >= 'A' && keyword[0] <= 'Z' || index < 0
+	trailing = (last == '$' || last >= 'A' && last <= 'Z') && (index >= 3 || index < 0)
+	return leading, trailing
+}
+
+// Plus3BASICProgram returns the tokenized program from a file, removing the +3DOS header (if present)
+// and the variables saved after the program
+func Plus3BASICProgram(data []byte) ([]byte, error) {
+	header := ParsePlus3DOSHeader(data)
+	if header == nil {
+		return data, nil
+	}
+	if header.Type != Plus3TypeProgram {
+		return nil, fmt.Errorf("not a BASIC program (+3DOS %s)", header.Summary())
+	}
+
+	program := data[Plus3DOSHeaderSize:]
+	length := header.Length
+	if int(header.Param2) < length {
+		length = int(header.Param2)
+	}
+	if length <= len(program) {
+		program = program[:length]
+	}
+	return program, nil
+}
+
+// DetokenizePlus3BASIC converts a tokenized +3 BASIC program to a text listing
+// Keywords are spaced the way LIST shows them, the hidden 5-byte numbers are left out and
+// control codes, UDGs and block graphics are written as \ escapes
+func DetokenizePlus3BASIC(program []byte) (string, error) {
+	var listing strings.Builder
+	for offset := 0; offset+4 <= len(program); {
+		number := int(binary.BigEndian.Uint16(program[offset:]))
+		if number > sinclairMaxLine {
+			// The variables area follows the program
+			break
+		}
+		length := int(binary.LittleEndian.Uint16(program[offset+2:]))
+		if length < 1 || offset+4+length > len(program) {
+			return "", fmt.Errorf("line %d has an invalid length %d", number, length)
+		}
+		text, err := detokenizePlus3Line(program[offset+4 : offset+4+length])
+		if err != nil {
+			return "", fmt.Errorf("line %d: %v", number, err)
+		}
+		fmt.Fprintf(&listing, "%d %s\n", number, text)
+		offset += 4 + length
+	}
+	return listing.String(), nil
+}
+
+// detokenizePlus3Line converts the bytes of a single line (after the number and length) to text
+func detokenizePlus3Line(line []byte) (string, error) {
+	var text strings.Builder
+	spaced := true    // The space after the line number stands in for a keyword's leading space
+	trailing := false // The last character is the space after a keyword
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == sinclairEndOfLine:
+			if trailing {
+				return strings.TrimSuffix(text.String(), " "), nil
+			}
+			return text.String(), nil
+
+		case c == sinclairNumber:
+			if i+sinclairNumberLength >= len(line) {
+				return "", fmt.Errorf("hidden number is truncated")
+			}
+			i += sinclairNumberLength
+			continue
+
+		case c >= sinclairFirstToken:
+			var leading bool
+			leading, trailing = sinclairTokenSpacing(c)
+			if leading && !spaced {
+				text.WriteByte(' ')
+			}
+			text.WriteString(sinclairKeywords[c-sinclairFirstToken])
+			spaced = trailing
+			if trailing {
+				text.WriteByte(' ')
+			}
+			continue
+
+		case c >= 0x10 && c <= 0x17:
+			// Colour controls take one parameter, AT and TAB two
+			name := sinclairControlCodes[c-0x10]
+			switch {
+			case c <= 0x15 && i+1 < len(line):
+				fmt.Fprintf(&text, "\\{%s %d}", name, line[i+1])
+				i++
+			case c == 0x16 && i+2 < len(line):
+				fmt.Fprintf(&text, "\\{%s %d,%d}", name, line[i+1], line[i+2])
+				i += 2
+			case c == 0x17 && i+2 < len(line):
+				fmt.Fprintf(&text, "\\{%s %d}", name, int(line[i+1])|int(line[i+2])<<8)
+				i += 2
+			default:
+				return "", fmt.Errorf("control code &%02X is truncated", c)
+			}
+
+		case c >= sinclairFirstUDG:
+			fmt.Fprintf(&text, "\\%c", 'a'+c-sinclairFirstUDG)
+
+		case c >= sinclairFirstBlock:
+			left := c>>1&0x01 | c>>2&0x02
+			right := c&0x01 | c>>1&0x02
+			text.WriteByte('\\')
+			text.WriteByte(sinclairBlockChars[left])
+			text.WriteByte(sinclairBlockChars[right])
+
+		case c == sinclairCopyright:
+			text.WriteString("\\*")
+
+		case c == '\\':
+			text.WriteString("\\\\")
+
+		case c == sinclairPound:
+			text.WriteString("£")
+
+		case c >= 0x20:
+			text.WriteByte(c)
+
+		default:
+			fmt.Fprintf(&text, "\\{%d}", c)
+		}
+		spaced = c == ' '
+		trailing = false
+	}
+	return "", fmt.Errorf("line has no end marker")
+}
+
+// encodeSpectrumNumber converts a number to the 5-byte form, using the small integer form
+// (0, sign, little-endian value, 0) for whole numbers up to 65535
+func encodeSpectrumNumber(number float64) ([]byte, error) {
+	if number == math.Trunc(number) && math.Abs(number) <= 65535 {
+		value, sign := int(number), byte(0)
+		if value < 0 {
+			value, sign = value+65536, 0xFF
+		}
+		return []byte{0, sign, byte(value), byte(value >> 8), 0}, nil
+	}
+
+	fraction, exponent := math.Frexp(math.Abs(number))
+	mantissa := uint64(math.Round(math.Ldexp(fraction, 32)))
+	if mantissa > math.MaxUint32 {
+		mantissa >>= 1
+		exponent++
+	}
+	if exponent+128 > 255 {
+		return nil, fmt.Errorf("number %g is too large", number)
+	}
+	if exponent+128 <= 0 {
+		return make([]byte, sinclairNumberLength), nil
+	}
+
+	encoded := make([]byte, sinclairNumberLength)
+	encoded[0] = uint8(exponent + 128)
+	binary.BigEndian.PutUint32(encoded[1:], uint32(mantissa)&0x7FFFFFFF)
+	if number < 0 {
+		encoded[1] |= 0x80
+	}
+	return encoded, nil
+}
+
+// decodeSpectrumNumber converts the 5-byte form of a number
+func decodeSpectrumNumber(encoded []byte) float64 {
+	if encoded[0] == 0 {
+		value := int(binary.LittleEndian.Uint16(encoded[2:]))
+		if encoded[1] == 0xFF {
+			value -= 65536
+		}
+		return float64(value)
+	}
+	mantissa := binary.BigEndian.Uint32(encoded[1:]) | 0x80000000
+	number := math.Ldexp(float64(mantissa), int(encoded[0])-128-32)
+	if encoded[1]&0x80 != 0 {
+		number = -number
+	}
+	return number
+}
+
+// TokenizePlus3BASIC converts a text listing to a tokenized +3 BASIC program
+// Lines must start with a line number (1-9999) and be in ascending order
+func TokenizePlus3BASIC(listing string) ([]byte, error) {
+	program := make([]byte, 0, len(listing))
+	previous := 0
+
+	for i, line := range strings.Split(listing, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		line = strings.TrimLeft(line, " \t")
+		digits := 0
+		for digits < len(line) && line[digits] >= '0' && line[digits] <= '9' {
+			digits++
+		}
+		number, err := strconv.Atoi(line[:digits])
+		if err != nil || number < 1 || number > sinclairMaxLine {
+			return nil, fmt.Errorf("line %d: expected a line number 1-%d", i+1, sinclairMaxLine)
+		}
+		if number <= previous {
+			return nil, fmt.Errorf("line %d: line number %d is not after %d", i+1, number, previous)
+		}
+		previous = number
+
+		tokens, err := tokenizePlus3Line(strings.TrimPrefix(line[digits:], " "))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		tokens = append(tokens, sinclairEndOfLine)
+		program = binary.BigEndian.AppendUint16(program, uint16(number))
+		program = binary.LittleEndian.AppendUint16(program, uint16(len(tokens)))
+		program = append(program, tokens...)
+	}
+	return program, nil
+}
+
+// sinclairKeywordTokens maps keywords to their tokens
+var sinclairKeywordTokens = func() map[string]byte {
+	tokens := make(map[string]byte)
+	for i, keyword := range sinclairKeywords {
+		tokens[keyword] = byte(sinclairFirstToken + i)
+	}
+	return tokens
+}()
+
+// sinclairKeywordPairs are the keywords of two parts, which may be typed with or without a space
+// (GOTO, GOSUB and DEFFN as one word)
+var sinclairKeywordPairs = [][2]string{{"GO", "TO"}, {"GO", "SUB"}, {"DEF", "FN"}, {"OPEN", "#"}, {"CLOSE", "#"}}
+
+// isLetter reports whether c is an ASCII letter
+func isLetter(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+// matchSinclairKeyword returns the token and length of the keyword at the start of text, if any
+func matchSinclairKeyword(text string) (byte, int) {
+	end := 0
+	for end < len(text) && (isLetter(text[end]) || text[end] >= '0' && text[end] <= '9') {
+		end++
+	}
+	word := strings.ToUpper(text[:end])
+
+	for _, pair := range sinclairKeywordPairs {
+		if word == pair[0]+pair[1] {
+			return sinclairKeywordTokens[pair[0]+" "+pair[1]], end
+		}
+		if word != pair[0] {
+			continue
+		}
+		rest := strings.TrimLeft(text[end:], " ")
+		if second := rest[:min(len(pair[1]), len(rest))]; strings.EqualFold(second, pair[1]) {
+			length := len(text) - len(rest) + len(second)
+			if pair[1] == "#" || length == len(text) || !isLetter(text[length]) {
+				return sinclairKeywordTokens[pair[0]+" "+pair[1]], length
+			}
+		}
+	}
+
+	if end < len(text) && text[end] == '$' {
+		if token, found := sinclairKeywordTokens[word+"$"]; found {
+			return token, end + 1
+		}
+	}
+	if token, found := sinclairKeywordTokens[word]; found {
+		return token, end
+	}
+	return 0, 0
+}
+
+// parseSinclairEscape converts the \ escape at the start of text to its codes, returning the
+// codes and the length of the escape
+func parseSinclairEscape(text string) ([]byte, int, error) {
+	if len(text) < 2 {
+		return []byte{'\\'}, 1, nil
+	}
+	switch c := text[1]; {
+	case c == '\\':
+		return []byte{'\\'}, 2, nil
+	case c == '*':
+		return []byte{sinclairCopyright}, 2, nil
+	case c >= 'a' && c <= 's':
+		return []byte{sinclairFirstUDG + c - 'a'}, 2, nil
+	case c == '{':
+		end := strings.IndexByte(text, '}')
+		if end < 0 {
+			return nil, 0, fmt.Errorf("unterminated escape '%s'", text)
+		}
+		codes, err := parseSinclairControl(text[2:end])
+		return codes, end + 1, err
+	case strings.IndexByte(sinclairBlockChars, c) >= 0 && len(text) > 2 && strings.IndexByte(sinclairBlockChars, text[2]) >= 0:
+		left := strings.IndexByte(sinclairBlockChars, c)
+		right := strings.IndexByte(sinclairBlockChars, text[2])
+		return []byte{sinclairFirstBlock | byte(left&0x01)<<1 | byte(left&0x02)<<2 | byte(right&0x01) | byte(right&0x02)<<1}, 3, nil
+	}
+	return []byte{'\\'}, 1, nil
+}
+
+// parseSinclairControl converts the contents of a \{} escape: a character code or a control code name
+// and its parameters, e.g. \{16}, \{ink 2} or \{at 10,5}
+func parseSinclairControl(escape string) ([]byte, error) {
+	fields := strings.Fields(escape)
+	if len(fields) == 1 {
+		code, err := strconv.ParseUint(fields[0], 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid character code '\\{%s}'", escape)
+		}
+		return []byte{byte(code)}, nil
+	}
+
+	for i, name := range sinclairControlCodes {
+		if len(fields) != 2 || !strings.EqualFold(fields[0], name) {
+			continue
+		}
+		values := make([]int, 0, 2)
+		for _, value := range strings.Split(fields[1], ",") {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid control code '\\{%s}'", escape)
+			}
+			values = append(values, n)
+		}
+		switch {
+		case i <= 5 && len(values) == 1 && values[0] <= 0xFF:
+			return []byte{byte(0x10 + i), byte(values[0])}, nil
+		case name == "at" && len(values) == 2 && values[0] <= 0xFF && values[1] <= 0xFF:
+			return []byte{0x16, byte(values[0]), byte(values[1])}, nil
+		case name == "tab" && len(values) == 1 && values[0] <= 0xFFFF:
+			return []byte{0x17, byte(values[0]), byte(values[0] >> 8)}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid control code '\\{%s}'", escape)
+}
+
+// tokenizePlus3Line converts the text of a single line (after the line number) to tokens
+func tokenizePlus3Line(line string) ([]byte, error) {
+	tokens := make([]byte, 0, len(line))
+	inString := false
+	afterBIN := false    // Numbers after BIN are binary
+	defFnParameters := 0 // 1 after DEF FN, 2 inside the parameter list
+	skipSpace := false   // The space after a keyword that LIST adds
+
+	for i := 0; i < len(line); {
+		c := line[i]
+		if skipSpace && c == ' ' {
+			skipSpace = false
+			i++
+			continue
+		}
+		skipSpace = false
+
+		switch {
+		case c == '\\':
+			codes, length, err := parseSinclairEscape(line[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, codes...)
+			i += length
+
+		case strings.HasPrefix(line[i:], "£"):
+			tokens = append(tokens, sinclairPound)
+			i += len("£")
+
+		case c == '"':
+			tokens = append(tokens, c)
+			inString = !inString
+			i++
+
+		case inString:
+			tokens = append(tokens, c)
+			i++
+
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9':
+			encoded, length, err := tokenizeSpectrumNumber(line[i:], afterBIN)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, line[i:i+length]...)
+			tokens = append(tokens, sinclairNumber)
+			tokens = append(tokens, encoded...)
+			i += length
+			afterBIN = false
+
+		case isLetter(c) || c == '<' || c == '>':
+			token, length := matchSinclairKeyword(line[i:])
+			if length == 0 && (c == '<' || c == '>') {
+				for _, operator := range []string{"<=", ">=", "<>"} {
+					if strings.HasPrefix(line[i:], operator) {
+						token, length = sinclairKeywordTokens[operator], len(operator)
+					}
+				}
+			}
+			if length == 0 {
+				// A variable name, or a < or > comparison
+				end := i + 1
+				for end < len(line) && isLetter(c) && (isLetter(line[end]) || line[end] >= '0' && line[end] <= '9') {
+					end++
+				}
+				if end < len(line) && line[end] == '$' && isLetter(c) {
+					end++
+				}
+				tokens = append(tokens, line[i:end]...)
+				i = end
+				if defFnParameters == 2 && isLetter(c) {
+					// DEF FN parameters have space for their value when the function is called
+					tokens = append(tokens, sinclairNumber, 0, 0, 0, 0, 0)
+				}
+				break
+			}
+
+			leading, trailing := sinclairTokenSpacing(token)
+			if leading && len(tokens) > 0 && tokens[len(tokens)-1] == ' ' {
+				tokens = tokens[:len(tokens)-1]
+			}
+			tokens = append(tokens, token)
+			i += length
+			skipSpace = trailing
+			afterBIN = token == sinclairTokenBIN
+			if token == sinclairTokenDEFFN {
+				defFnParameters = 1
+			}
+			if token == sinclairTokenREM {
+				// The rest of the line is a comment, though escapes still apply
+				if strings.HasPrefix(line[i:], " ") {
+					i++
+				}
+				for i < len(line) {
+					if line[i] == '\\' {
+						codes, length, err := parseSinclairEscape(line[i:])
+						if err != nil {
+							return nil, err
+						}
+						tokens = append(tokens, codes...)
+						i += length
+						continue
+					}
+					if strings.HasPrefix(line[i:], "£") {
+						tokens = append(tokens, sinclairPound)
+						i += len("£")
+						continue
+					}
+					tokens = append(tokens, line[i])
+					i++
+				}
+			}
+
+		default:
+			switch {
+			case c == '(' && defFnParameters == 1:
+				defFnParameters = 2
+			case c == ')' || c == '=' || c == ':':
+				defFnParameters = 0
+			}
+			tokens = append(tokens, c)
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// tokenizeSpectrumNumber encodes the number at the start of text, returning its 5-byte form
+// and the number of characters used
+func tokenizeSpectrumNumber(text string, binaryDigits bool) ([]byte, int, error) {
+	end := 0
+	if binaryDigits {
+		for end < len(text) && (text[end] == '0' || text[end] == '1') {
+			end++
+		}
+		value, err := strconv.ParseUint(text[:end], 2, 16)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid binary number '%s'", text[:end])
+		}
+		encoded, err := encodeSpectrumNumber(float64(value))
+		return encoded, end, err
+	}
+
+	for end < len(text) && text[end] >= '0' && text[end] <= '9' {
+		end++
+	}
+	if end < len(text) && text[end] == '.' {
+		for end++; end < len(text) && text[end] >= '0' && text[end] <= '9'; end++ {
+		}
+	}
+	if end < len(text) && (text[end] == 'E' || text[end] == 'e') {
+		exponent := end + 1
+		if exponent < len(text) && (text[exponent] == '+' || text[exponent] == '-') {
+			exponent++
+		}
+		if exponent < len(text) && text[exponent] >= '0' && text[exponent] <= '9' {
+			for end = exponent; end < len(text) && text[end] >= '0' && text[end] <= '9'; end++ {
+			}
+		}
+	}
+
+	number, err := strconv.ParseFloat(text[:end], 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid number '%s'", text[:end])
+	}
+	encoded, err := encodeSpectrumNumber(number)
+	return encoded, end, err
+}
@@ -0,0 +1,205 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// plus3basic_test.go - Unit tests for the Sinclair +3 BASIC detokenizer and tokenizer
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestTokenizePlus3BASIC(t *testing.T) {
+	program, err := TokenizePlus3BASIC("10 PRINT AT 1,2;\"HI\": GO TO 10\r\n20 LET b=BIN 101\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []byte{
+		0x00, 0x0A, 0x21, 0x00, // Line 10 and length
+		0xF5, 0xAC, '1', 0x0E, 0x00, 0x00, 0x01, 0x00, 0x00, ',', '2', 0x0E, 0x00, 0x00, 0x02, 0x00, 0x00,
+		';', '"', 'H', 'I', '"', ':', 0xEC, '1', '0', 0x0E, 0x00, 0x00, 0x0A, 0x00, 0x00, 0x0D,
+		0x00, 0x14, 0x0E, 0x00, // Line 20 and length
+		0xF1, 'b', '=', 0xC4, '1', '0', '1', 0x0E, 0x00, 0x00, 0x05, 0x00, 0x00, 0x0D,
+	}
+	if !bytes.Equal(program, expected) {
+		t.Errorf("expected\n% X\ngot\n% X", expected, program)
+	}
+}
+
+func TestTokenizePlus3BASICErrors(t *testing.T) {
+	tests := map[string]string{
+		"no line number": "PRINT 1",
+		"out of order":   "20 PRINT\n10 PRINT",
+		"line too high":  "10000 PRINT",
+		"bad escape":     "10 PRINT \"\\{ink}\"",
+		"unterminated":   "10 PRINT \"\\{ink 2\"",
+	}
+	for name, listing := range tests {
+		if _, err := TokenizePlus3BASIC(listing); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPlus3BASICRoundTrip(t *testing.T) {
+	listing := `10 REM \{ink 2}Title\a\s £1
+20 BORDER 0: PAPER 7: CLS : LET a=INT (RND*6)+1
+30 IF a>=3 AND a<>5 THEN PRINT AT 1,2; INK 2;"Hi £\{bright 1}there\::\ '\*";a;\{at 3,4}
+40 DEF FN s(x,y)=x*y: PRINT FN s(3,4)
+50 LET b=BIN 1010: LET c=3.14159: LET d=1E10: LET e=.5
+60 FOR i=1 TO 10 STEP 2: NEXT i: GO SUB 100: GO TO 10
+70 PRINT CHR$ 65;STR$ b;INKEY$;PI;CODE "a"; OPEN #4;"\\"
+100 RETURN
+`
+	program, err := TokenizePlus3BASIC(listing)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, err := DetokenizePlus3BASIC(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != listing {
+		t.Errorf("expected\n%s\ngot\n%s", listing, result)
+	}
+
+	// \t and \u would be the SPECTRUM and PLAY tokens so are left as a backslash
+	if !bytes.Contains(program, []byte{0x90, 0xA2, ' ', sinclairPound, '1', 0x0D}) {
+		t.Errorf("expected UDGs and a pound sign in\n% X", program)
+	}
+	escapes, err := TokenizePlus3BASIC("10 REM \\t\\u\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Contains(escapes, []byte{sinclairTokenREM, '\\', 't', '\\', 'u'}) {
+		t.Errorf("expected \\t and \\u to stay as text in\n% X", escapes)
+	}
+
+	// The DEF FN parameters have space reserved for their values
+	defFn := []byte{'(', 'x', 0x0E, 0, 0, 0, 0, 0, ',', 'y', 0x0E, 0, 0, 0, 0, 0, ')', '='}
+	if !bytes.Contains(program, defFn) {
+		t.Errorf("expected DEF FN parameters % X in\n% X", defFn, program)
+	}
+}
+
+func TestTokenizeUnspacedSinclairKeywords(t *testing.T) {
+	spaced, err := TokenizePlus3BASIC("10 GO TO 20: GO SUB 30: DEF FN a(x)=x\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unspaced, err := TokenizePlus3BASIC("10 GOTO 20: gosub 30: DEFFN a(x)=x\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(spaced, unspaced) {
+		t.Errorf("expected GOTO, GOSUB and DEFFN to tokenize as GO TO, GO SUB and DEF FN\n% X\n% X", spaced, unspaced)
+	}
+
+	// Longer words starting the same way are still variables
+	variable, err := TokenizePlus3BASIC("10 LET gotox=1\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Contains(variable, []byte("gotox")) {
+		t.Errorf("expected gotox to stay a variable in % X", variable)
+	}
+}
+
+func TestDetokenizePlus3BASIC(t *testing.T) {
+	program := []byte{
+		0x00, 0x0A, 0x11, 0x00, // Line 10 and length
+		0xF5, '"', 0x90, 0x11, 0x05, 0x83, 0x7F, '"', ';', '1', 0x0E, 0x00, 0x00, 0x01, 0x00, 0x00, 0x0D,
+		0x61, 0x00, 0x00, 0x00, // The variables area after the program
+	}
+	listing, err := DetokenizePlus3BASIC(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "10 PRINT \"\\a\\{paper 5}\\''\\*\";1\n"
+	if listing != expected {
+		t.Errorf("expected %q, got %q", expected, listing)
+	}
+
+	if _, err := DetokenizePlus3BASIC([]byte{0x00, 0x0A, 0x40, 0x00, 0xF5}); err == nil {
+		t.Errorf("expected an error for a truncated line")
+	}
+}
+
+func TestSpectrumNumbers(t *testing.T) {
+	tests := []struct {
+		number  float64
+		encoded []byte
+	}{
+		{0, []byte{0x00, 0x00, 0x00, 0x00, 0x00}},
+		{10, []byte{0x00, 0x00, 0x0A, 0x00, 0x00}},
+		{65535, []byte{0x00, 0x00, 0xFF, 0xFF, 0x00}},
+		{-1, []byte{0x00, 0xFF, 0xFF, 0xFF, 0x00}},
+		{65536, []byte{0x91, 0x00, 0x00, 0x00, 0x00}},
+		{0.5, []byte{0x80, 0x00, 0x00, 0x00, 0x00}},
+		{-0.5, []byte{0x80, 0x80, 0x00, 0x00, 0x00}},
+		{math.Pi, []byte{0x82, 0x49, 0x0F, 0xDA, 0xA2}},
+	}
+	for _, tt := range tests {
+		encoded, err := encodeSpectrumNumber(tt.number)
+		if err != nil {
+			t.Errorf("%g: unexpected error: %v", tt.number, err)
+			continue
+		}
+		if !bytes.Equal(encoded, tt.encoded) {
+			t.Errorf("%g: expected % X, got % X", tt.number, tt.encoded, encoded)
+		}
+		if decoded := decodeSpectrumNumber(encoded); math.Abs(decoded-tt.number) > 1e-9 {
+			t.Errorf("% X: expected %g, got %g", encoded, tt.number, decoded)
+		}
+	}
+
+	if _, err := encodeSpectrumNumber(1e40); err == nil {
+		t.Errorf("expected an error for a number out of range")
+	}
+}
+
+func TestPlus3BASICFile(t *testing.T) {
+	listing := "10 PRINT \"+3\"\n20 GO TO 10\n"
+	data, err := TokenizeBASIC(listing, DialectPlus3, "", 10, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	header := ParsePlus3DOSHeader(data)
+	if header == nil || !header.ChecksumValid {
+		t.Fatalf("expected a valid +3DOS header")
+	}
+	programLength := len(data) - Plus3DOSHeaderSize
+	if header.Type != Plus3TypeProgram || header.Length != programLength || header.Param1 != 10 ||
+		int(header.Param2) != programLength {
+		t.Errorf("unexpected header %+v for a %d byte program", header, programLength)
+	}
+	if !IsBASICFile(data) || BASICDialect(data) != DialectPlus3 {
+		t.Errorf("expected a +3 BASIC file")
+	}
+
+	// Variables saved with the program are not listed
+	data = append(data, 0x61, 0x00, 0x00, 0x05, 0x00, 0x00)
+	result, err := BASICListing(data, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != listing {
+		t.Errorf("expected\n%s\ngot\n%s", listing, result)
+	}
+
+	code, _ := BuildPlus3DOSHeader(Plus3TypeCode, 1, 32768, 0)
+	if _, err := BASICListing(append(code, 0xC9), ""); err == nil {
+		t.Errorf("expected an error listing CODE")
+	}
+
+	unstarted, err := TokenizeBASIC(listing, DialectPlus3, "", 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if header := ParsePlus3DOSHeader(unstarted); header.Param1 != Plus3NoAutostart {
+		t.Errorf("expected no autostart, got %d", header.Param1)
+	}
+}
@@ -0,0 +1,131 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// plus3dos.go - +3DOS file header handling
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Plus3DOSHeaderSize is the size of the header +3DOS places at the start of files
+const Plus3DOSHeaderSize = 128
+
+// plus3DOSSignature starts every +3DOS header (followed by a soft-EOF byte 0x1A)
+var plus3DOSSignature = []byte("PLUS3DOS\x1A")
+
+// HasPlus3DOSHeader reports whether data starts with a +3DOS header with a valid checksum
+func HasPlus3DOSHeader(data []byte) bool {
+	if len(data) < Plus3DOSHeaderSize || !bytes.HasPrefix(data, plus3DOSSignature) {
+		return false
+	}
+	return plus3DOSChecksum(data) == data[127]
+}
+
+// plus3DOSChecksum returns the 8-bit sum of header bytes 0-126
+func plus3DOSChecksum(header []byte) uint8 {
+	return SectorSum(header[:127])
+}
+
+// plus3DOSFileLength returns the total file length (including the header) stored at bytes 11-14
+func plus3DOSFileLength(header []byte) int {
+	return int(binary.LittleEndian.Uint32(header[11:15]))
+}
+
+// +3 BASIC file types stored at byte 15 of the header
+const (
+	Plus3TypeProgram        = 0
+	Plus3TypeNumberArray    = 1
+	Plus3TypeCharacterArray = 2
+	Plus3TypeCode           = 3
+)
+
+// Plus3NoAutostart is the autostart line value meaning the program does not run automatically
+const Plus3NoAutostart = 0x8000
+
+// BuildPlus3DOSHeader creates a +3DOS header for a file of the given +3 BASIC type and length
+// For programs param1 is the autostart line and param2 the offset of the variables area,
+// for code param1 is the load address
+func BuildPlus3DOSHeader(fileType uint8, length int, param1 uint16, param2 uint16) ([]byte, error) {
+	if length > 0xFFFF {
+		return nil, fmt.Errorf("file too large for +3DOS header: %d bytes", length)
+	}
+
+	header := make([]byte, Plus3DOSHeaderSize)
+	copy(header, plus3DOSSignature)
+	header[9] = 1  // Issue
+	header[10] = 0 // Version
+	binary.LittleEndian.PutUint32(header[11:15], uint32(Plus3DOSHeaderSize+length))
+	header[15] = fileType
+	binary.LittleEndian.PutUint16(header[16:18], uint16(length))
+	binary.LittleEndian.PutUint16(header[18:20], param1)
+	binary.LittleEndian.PutUint16(header[20:22], param2)
+	header[127] = plus3DOSChecksum(header)
+	return header, nil
+}
+
+// Plus3DOSHeader is a decoded +3DOS file header
+type Plus3DOSHeader struct {
+	Issue         uint8
+	Version       uint8
+	FileLength    int // Whole file including the header
+	Type          uint8
+	Length        int // Length of the BASIC data or code
+	Param1        uint16
+	Param2        uint16
+	Checksum      uint8
+	ChecksumValid bool
+}
+
+// ParsePlus3DOSHeader decodes the +3DOS header at the start of data
+// The signature alone is enough to recognise a header so ones with a bad checksum are still decoded
+func ParsePlus3DOSHeader(data []byte) *Plus3DOSHeader {
+	if len(data) < Plus3DOSHeaderSize || !bytes.HasPrefix(data, plus3DOSSignature) {
+		return nil
+	}
+	return &Plus3DOSHeader{
+		Issue:         data[9],
+		Version:       data[10],
+		FileLength:    plus3DOSFileLength(data),
+		Type:          data[15],
+		Length:        int(binary.LittleEndian.Uint16(data[16:18])),
+		Param1:        binary.LittleEndian.Uint16(data[18:20]),
+		Param2:        binary.LittleEndian.Uint16(data[20:22]),
+		Checksum:      data[127],
+		ChecksumValid: HasPlus3DOSHeader(data),
+	}
+}
+
+// Summary returns the +3 BASIC header of the file in the form it is shown by the BASIC
+// LOAD and SAVE commands, e.g. "Program LINE 10", "CODE 32768,6912" or "Number array a()"
+func (h *Plus3DOSHeader) Summary() string {
+	// Array variable names are stored in the high byte of param1 with the type in the top bits
+	arrayName := string(rune('a' + (h.Param1 >> 8 & 0x1F) - 1))
+
+	switch h.Type {
+	case Plus3TypeProgram:
+		if h.Param1 >= Plus3NoAutostart {
+			return fmt.Sprintf("Program length %d", h.Length)
+		}
+		return fmt.Sprintf("Program LINE %d length %d", h.Param1, h.Length)
+	case Plus3TypeNumberArray:
+		return fmt.Sprintf("Number array %s() length %d", arrayName, h.Length)
+	case Plus3TypeCharacterArray:
+		return fmt.Sprintf("Character array %s$() length %d", arrayName, h.Length)
+	case Plus3TypeCode:
+		return fmt.Sprintf("CODE %d,%d", h.Param1, h.Length)
+	default:
+		return fmt.Sprintf("type %d length %d", h.Type, h.Length)
+	}
+}
+
+// String describes the header in a single line for listings
+func (h *Plus3DOSHeader) String() string {
+	description := fmt.Sprintf("+3DOS %s", h.Summary())
+	if !h.ChecksumValid {
+		description += " (bad checksum)"
+	}
+	return description
+}
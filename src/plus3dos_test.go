@@ -0,0 +1,56 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// plus3dos_test.go - Unit tests for +3DOS header handling
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParsePlus3DOSHeader(t *testing.T) {
+	program := make([]byte, 50)
+	header, err := BuildPlus3DOSHeader(Plus3TypeProgram, len(program), 10, uint16(len(program)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := append(header, program...)
+
+	decoded := ParsePlus3DOSHeader(data)
+	if decoded == nil {
+		t.Fatalf("expected a header")
+	}
+	if decoded.Issue != 1 || decoded.Version != 0 || decoded.FileLength != 178 || decoded.Type != Plus3TypeProgram ||
+		decoded.Length != 50 || decoded.Param1 != 10 || decoded.Param2 != 50 || !decoded.ChecksumValid {
+		t.Errorf("unexpected header %+v", decoded)
+	}
+
+	// The signature is enough to recognise a damaged header
+	data[127]++
+	if decoded = ParsePlus3DOSHeader(data); decoded == nil || decoded.ChecksumValid {
+		t.Errorf("expected a header with a bad checksum, got %+v", decoded)
+	}
+
+	if decoded = ParsePlus3DOSHeader(make([]byte, 200)); decoded != nil {
+		t.Errorf("expected no header, got %+v", decoded)
+	}
+}
+
+func TestPlus3DOSHeaderDescription(t *testing.T) {
+	tests := []struct {
+		header   Plus3DOSHeader
+		expected string
+	}{
+		{Plus3DOSHeader{Type: Plus3TypeProgram, Length: 120, Param1: 10, ChecksumValid: true}, "+3DOS Program LINE 10 length 120"},
+		{Plus3DOSHeader{Type: Plus3TypeProgram, Length: 120, Param1: Plus3NoAutostart, ChecksumValid: true}, "+3DOS Program length 120"},
+		{Plus3DOSHeader{Type: Plus3TypeCode, Length: 6912, Param1: 16384, ChecksumValid: true}, "+3DOS CODE 16384,6912"},
+		{Plus3DOSHeader{Type: Plus3TypeNumberArray, Length: 56, Param1: 0x8100, ChecksumValid: true}, "+3DOS Number array a() length 56"},
+		{Plus3DOSHeader{Type: Plus3TypeCharacterArray, Length: 14, Param1: 0xC200}, "+3DOS Character array b$() length 14 (bad checksum)"},
+	}
+
+	for _, tt := range tests {
+		if description := tt.header.String(); description != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, description)
+		}
+	}
+}
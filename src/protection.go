@@ -0,0 +1,408 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// protection.go - Copy-protection scheme identification for CPC and +3 disks
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// ProtectionFeature is a disk feature that copy-protection schemes rely on
+type ProtectionFeature string
+
+// Features found by scanning the tracks and sectors of a disk
+const (
+	FeatureOversized ProtectionFeature = "oversized sectors" // N of 6 or more, bigger than a track
+	FeatureDuplicate ProtectionFeature = "duplicate sector IDs"
+	FeatureWeak      ProtectionFeature = "weak sectors" // Several copies of the data that read differently
+	FeatureDataCRC   ProtectionFeature = "data CRC errors"
+	FeatureOddSize   ProtectionFeature = "odd sector sizes" // N other than the one most of the disk uses
+)
+
+// protectionExamples is how many locations are listed for each feature
+const protectionExamples = 3
+
+// protectionFeatureOrder is the order features are reported in
+var protectionFeatureOrder = []ProtectionFeature{FeatureOversized, FeatureDuplicate, FeatureWeak, FeatureDataCRC, FeatureOddSize}
+
+// ProtectionScheme describes how to recognise a copy-protection scheme
+type ProtectionScheme struct {
+	Name       string
+	Signatures []string                      // Loader text, upper case, compared ignoring case and bit 7
+	Layout     func(d *DSK) (string, string) // Returns the evidence and version when the track layout matches
+	Needs      []ProtectionFeature           // Features the loader checks for, without which it fails
+}
+
+// ProtectionMatch is a scheme found on a disk
+type ProtectionMatch struct {
+	Scheme   string
+	Version  string
+	Evidence []string            // Signatures, layouts and the protection features the scheme needs
+	Missing  []ProtectionFeature // Features the scheme needs that the image does not have
+	Possible bool                // Only the track layout matched, there is no loader signature
+}
+
+// Complete reports whether the image has everything the scheme needs to load
+func (m *ProtectionMatch) Complete() bool {
+	return len(m.Missing) == 0
+}
+
+// ProtectionSchemes are the schemes DetectProtection looks for
+var ProtectionSchemes = []ProtectionScheme{
+	{
+		Name:       "Speedlock",
+		Signatures: []string{"SPEEDLOCK"},
+		Layout:     speedlockLayout,
+		Needs:      []ProtectionFeature{FeatureWeak, FeatureDataCRC},
+	},
+	{
+		Name:       "Hexagon",
+		Signatures: []string{"HEXAGON DISK PROTECTION"},
+		Needs:      []ProtectionFeature{FeatureDataCRC},
+	},
+	{
+		Name:       "Alkatraz",
+		Signatures: []string{"ALKATRAZ PROTECTION SYSTEM"},
+		Needs:      []ProtectionFeature{FeatureOddSize},
+	},
+	{
+		Name:       "Paul Owens",
+		Signatures: []string{"PAUL OWENS"},
+		Layout:     paulOwensLayout,
+		Needs:      []ProtectionFeature{FeatureOddSize},
+	},
+	{
+		Name:       "Frontier",
+		Signatures: []string{"NEW FRONTIER SOFT"},
+		Needs:      []ProtectionFeature{FeatureDataCRC},
+	},
+	{
+		Name:       "Three Inch Loader",
+		Signatures: []string{"THREE INCH SOFTWARE"},
+		Needs:      []ProtectionFeature{FeatureOversized},
+	},
+	{
+		Name:       "ERE/Remi HERBULOT",
+		Signatures: []string{"REMI HERBULOT", "ERE INFORMATIQUE"},
+		Needs:      []ProtectionFeature{FeatureDuplicate},
+	},
+	{
+		Name:   "KBI",
+		Layout: kbiLayout,
+		Needs:  []ProtectionFeature{FeatureDataCRC},
+	},
+	{
+		Name:       "Amsoft/EXOPAL",
+		Signatures: []string{"EXOPAL"},
+		Needs:      []ProtectionFeature{FeatureOddSize},
+	},
+}
+
+// DetectProtection looks for the loader signatures and track layouts of the known
+// copy-protection schemes and checks the image has the features each one needs.
+// A layout without a loader signature is easily matched by a damaged ordinary disk, so it is
+// only reported, as possible, when the image also has every feature the scheme needs.
+func DetectProtection(d *DSK) []ProtectionMatch {
+	features := d.protectionFeatures()
+	matches := make([]ProtectionMatch, 0)
+
+	for _, scheme := range ProtectionSchemes {
+		match := ProtectionMatch{Scheme: scheme.Name}
+		for _, signature := range scheme.Signatures {
+			if location, version := d.findSignature(signature); location != "" {
+				match.Evidence = append(match.Evidence, fmt.Sprintf("signature \"%s\" at %s", signature, location))
+				if match.Version == "" {
+					match.Version = version
+				}
+			}
+		}
+		match.Possible = len(match.Evidence) == 0
+		if scheme.Layout != nil {
+			if evidence, version := scheme.Layout(d); evidence != "" {
+				match.Evidence = append(match.Evidence, "layout: "+evidence)
+				if match.Version == "" {
+					match.Version = version
+				}
+			}
+		}
+		if len(match.Evidence) == 0 {
+			continue
+		}
+
+		for _, feature := range scheme.Needs {
+			if len(features[feature]) == 0 {
+				match.Missing = append(match.Missing, feature)
+			}
+		}
+		if match.Possible && !match.Complete() {
+			continue
+		}
+		for _, feature := range scheme.Needs {
+			if locations := features[feature]; len(locations) > 0 {
+				match.Evidence = append(match.Evidence, describeFeature(feature, locations))
+			}
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// Name returns the scheme and version, marked when only the layout matched
+func (m *ProtectionMatch) Name() string {
+	name := strings.TrimSpace(m.Scheme + " " + m.Version)
+	if m.Possible {
+		name += " (possible)"
+	}
+	return name
+}
+
+// DescribeProtectionFeatures lists every protection feature found on the disk with the first
+// few places it was found
+func (d *DSK) DescribeProtectionFeatures() []string {
+	features := d.protectionFeatures()
+	descriptions := make([]string, 0)
+	for _, feature := range protectionFeatureOrder {
+		if locations := features[feature]; len(locations) > 0 {
+			descriptions = append(descriptions, describeFeature(feature, locations))
+		}
+	}
+	return descriptions
+}
+
+// describeFeature lists the first few places a feature was found
+func describeFeature(feature ProtectionFeature, locations []string) string {
+	shown := locations
+	if len(shown) > protectionExamples {
+		shown = shown[:protectionExamples]
+	}
+	text := fmt.Sprintf("%s: %s", feature, strings.Join(shown, ", "))
+	if len(locations) > len(shown) {
+		text += fmt.Sprintf(" and %d more", len(locations)-len(shown))
+	}
+	return text
+}
+
+// sectorLocationName names a sector for reports
+func sectorLocationName(track *LogicalTrack, sector *LogicalSector) string {
+	return fmt.Sprintf("track %d side %d sector #%02X", track.Header.TrackNum, track.Header.SideNum, sector.Info.R)
+}
+
+// protectionFeatures finds the sectors with each protection feature
+func (d *DSK) protectionFeatures() map[ProtectionFeature][]string {
+	sizes := make(map[int]int)
+	for _, track := range d.Tracks {
+		for _, sector := range track.Sectors {
+			sizes[int(sector.Info.N)]++
+		}
+	}
+	commonSize := mostCommon(sizes)
+
+	features := make(map[ProtectionFeature][]string)
+	for t := range d.Tracks {
+		track := &d.Tracks[t]
+		seen := make(map[uint8]bool)
+		for s := range track.Sectors {
+			sector := &track.Sectors[s]
+			location := sectorLocationName(track, sector)
+			switch {
+			case sector.Info.N >= 6:
+				features[FeatureOversized] = append(features[FeatureOversized], location)
+			case int(sector.Info.N) != commonSize:
+				features[FeatureOddSize] = append(features[FeatureOddSize], fmt.Sprintf("%s (%d bytes)", location, 128<<sector.Info.N))
+			}
+			if seen[sector.Info.R] {
+				features[FeatureDuplicate] = append(features[FeatureDuplicate], location)
+			}
+			seen[sector.Info.R] = true
+			if copies := sector.CopyCount(); copies > 1 {
+				features[FeatureWeak] = append(features[FeatureWeak], fmt.Sprintf("%s (%d copies)", location, copies))
+			}
+			if hasDataCRCError(sector) {
+				features[FeatureDataCRC] = append(features[FeatureDataCRC], location)
+			}
+		}
+	}
+	return features
+}
+
+// hasDataCRCError reports whether the FDC flagged a CRC error in the sector's data field
+func hasDataCRCError(sector *LogicalSector) bool {
+	return sector.Info.FDCStatus1&FDCStatus1DataError != 0 && sector.Info.FDCStatus2&FDCStatus2DataErrorInDataField != 0
+}
+
+// findSignature searches the sector data for loader text, returning where it was found and
+// the version given by the text that follows it
+func (d *DSK) findSignature(signature string) (string, string) {
+	for t := range d.Tracks {
+		track := &d.Tracks[t]
+		for s := range track.Sectors {
+			sector := &track.Sectors[s]
+			text := signatureText(sector.Copies()[0])
+			if index := bytes.Index(text, []byte(signature)); index >= 0 {
+				return sectorLocationName(track, sector), signatureVersion(text[index+len(signature):])
+			}
+		}
+	}
+	return "", ""
+}
+
+// signatureText folds data to upper case 7-bit characters for comparing with signatures
+func signatureText(data []byte) []byte {
+	text := make([]byte, len(data))
+	for i, b := range data {
+		b &= 0x7F
+		if b >= 'a' && b <= 'z' {
+			b -= 'a' - 'A'
+		}
+		text[i] = b
+	}
+	return text
+}
+
+// signatureVersion picks the copyright year, and whether it is the +3 version, out of the text
+// following a signature
+func signatureVersion(text []byte) string {
+	if len(text) > 80 {
+		text = text[:80]
+	}
+	version := ""
+	for i := 0; i+4 <= len(text); i++ {
+		year := string(text[i : i+4])
+		if year >= "1984" && year <= "1992" {
+			version = year
+			break
+		}
+	}
+	if bytes.Contains(text, []byte("+3")) {
+		version = strings.TrimSpace(version + " +3")
+	}
+	return version
+}
+
+// speedlockLayout matches a 9 sector track 0 with a 512 byte sector that has a data CRC error
+func speedlockLayout(d *DSK) (string, string) {
+	for _, track := range d.Tracks {
+		if track.Header.TrackNum != 0 || track.Header.SideNum != 0 || len(track.Sectors) != 9 {
+			continue
+		}
+		for s := range track.Sectors {
+			sector := &track.Sectors[s]
+			if sector.Info.N == 2 && hasDataCRCError(sector) {
+				return fmt.Sprintf("track 0 has 9 sectors with a data CRC error in sector #%02X", sector.Info.R), ""
+			}
+		}
+	}
+	return "", ""
+}
+
+// paulOwensLayout matches a 9 sector track 0 followed by tracks of 16 sectors of 256 bytes
+func paulOwensLayout(d *DSK) (string, string) {
+	first := d.GetTrack(0, 0)
+	if first == nil || len(first.Sectors) != 9 {
+		return "", ""
+	}
+	count := 0
+	for _, track := range d.Tracks {
+		if len(track.Sectors) != 16 {
+			continue
+		}
+		small := true
+		for _, sector := range track.Sectors {
+			small = small && sector.Info.N == 1
+		}
+		if small {
+			count++
+		}
+	}
+	if count == 0 {
+		return "", ""
+	}
+	return fmt.Sprintf("%d track(s) of 16 sectors of 256 bytes", count), ""
+}
+
+// kbiLayout matches the KBI-19 track of 19 sectors and the KBI-10 track of 10 sectors whose
+// last sector is 256 bytes with a data CRC error
+func kbiLayout(d *DSK) (string, string) {
+	tracks := make([]string, 0)
+	version := ""
+	for _, track := range d.Tracks {
+		switch len(track.Sectors) {
+		case 19:
+			tracks = append(tracks, fmt.Sprintf("track %d has 19 sectors", track.Header.TrackNum))
+			version = "19"
+		case 10:
+			last := &track.Sectors[9]
+			if last.Info.N == 1 && hasDataCRCError(last) {
+				tracks = append(tracks, fmt.Sprintf("track %d has 10 sectors ending in a 256 byte sector with a data CRC error", track.Header.TrackNum))
+				version = "10"
+			}
+		}
+	}
+	if len(tracks) > protectionExamples {
+		tracks = append(tracks[:protectionExamples], fmt.Sprintf("%d more", len(tracks)-protectionExamples))
+	}
+	return strings.Join(tracks, ", "), version
+}
+
+// PrintProtection writes the protection schemes found to the console, followed once by any
+// protection features on the disk that none of the schemes use
+func PrintProtection(matches []ProtectionMatch, features []string) {
+	if len(matches) == 0 {
+		fmt.Println("No known copy protection found")
+	}
+	shown := make(map[string]bool)
+	for i, match := range matches {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("Protection : %s\n", match.Name())
+		if match.Possible {
+			fmt.Println("  ? no loader signature, only the track layout matched")
+		}
+		for _, evidence := range match.Evidence {
+			fmt.Printf("  + %s\n", evidence)
+			shown[evidence] = true
+		}
+		if match.Complete() {
+			fmt.Println("Complete   : yes, the image has what the scheme needs")
+		} else {
+			missing := make([]string, len(match.Missing))
+			for j, feature := range match.Missing {
+				missing[j] = string(feature)
+			}
+			fmt.Printf("Complete   : no, missing %s so the loader will probably fail\n", strings.Join(missing, ", "))
+		}
+	}
+
+	others := make([]string, 0)
+	for _, feature := range features {
+		if !shown[feature] {
+			others = append(others, feature)
+		}
+	}
+	if len(others) > 0 {
+		fmt.Println()
+		fmt.Println("Disk       : other protection features found")
+		for _, feature := range others {
+			fmt.Printf("  ! %s\n", feature)
+		}
+	}
+}
+
+// ProtectionSummary returns a one line description of the schemes found for listing many disks
+func ProtectionSummary(matches []ProtectionMatch) string {
+	if len(matches) == 0 {
+		return "none"
+	}
+	names := make([]string, len(matches))
+	for i, match := range matches {
+		names[i] = match.Name()
+		if !match.Complete() {
+			names[i] += " (incomplete)"
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,165 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// protection_test.go - Unit tests for copy-protection scheme identification
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// findProtection returns the match for a scheme or nil
+func findProtection(matches []ProtectionMatch, scheme string) *ProtectionMatch {
+	for i := range matches {
+		if matches[i].Scheme == scheme {
+			return &matches[i]
+		}
+	}
+	return nil
+}
+
+func TestDetectProtectionNone(t *testing.T) {
+	if matches := DetectProtection(newTestDSK(FormatExtended, 40, 1, 0xC1)); len(matches) != 0 {
+		t.Errorf("expected no protection, got %s", ProtectionSummary(matches))
+	}
+}
+
+func TestDetectProtectionSpeedlock(t *testing.T) {
+	dsk := newPlus3TestDSK()
+	boot := dsk.GetTrack(0, 0).GetSector(0x01)
+	copy(boot.Data[0x40:], "Speedlock +3 disc protection system copyright 1989 Speedlock Associates")
+	sector := dsk.GetTrack(0, 0).GetSector(0x02)
+	sector.Info.FDCStatus1, sector.Info.FDCStatus2 = FDCStatus1DataError, FDCStatus2DataErrorInDataField
+
+	// Without the weak copies the loader cannot work
+	match := findProtection(DetectProtection(dsk), "Speedlock")
+	if match == nil {
+		t.Fatalf("expected Speedlock")
+	}
+	if match.Version != "1989 +3" {
+		t.Errorf("expected version 1989 +3, got '%s'", match.Version)
+	}
+	if match.Complete() || len(match.Missing) != 1 || match.Missing[0] != FeatureWeak {
+		t.Errorf("expected weak sectors to be missing, got %v", match.Missing)
+	}
+	evidence := strings.Join(match.Evidence, "\n")
+	for _, expected := range []string{"at track 0 side 0 sector #01", "layout: track 0 has 9 sectors", "data CRC errors: track 0 side 0 sector #02"} {
+		if !strings.Contains(evidence, expected) {
+			t.Errorf("expected evidence %q in %q", expected, evidence)
+		}
+	}
+
+	// Features the scheme does not use are left to the disk-level findings
+	oversized := &dsk.GetTrack(5, 0).Sectors[0]
+	oversized.Info.N = 6
+	if match := findProtection(DetectProtection(dsk), "Speedlock"); strings.Contains(strings.Join(match.Evidence, "\n"), string(FeatureOversized)) {
+		t.Errorf("expected no oversized sectors in the Speedlock evidence, got %v", match.Evidence)
+	}
+	if features := strings.Join(dsk.DescribeProtectionFeatures(), "\n"); !strings.Contains(features, "oversized sectors: track 5 side 0 sector #") {
+		t.Errorf("expected oversized sectors in the disk features, got %q", features)
+	}
+
+	sector.Data = append(sector.Data, bytes.Repeat([]byte{0x55}, 1024)...)
+	match = findProtection(DetectProtection(dsk), "Speedlock")
+	if !match.Complete() || !strings.Contains(strings.Join(match.Evidence, "\n"), "sector #02 (3 copies)") {
+		t.Errorf("expected a complete match with 3 copies, got %v missing %v", match.Evidence, match.Missing)
+	}
+}
+
+func TestDetectProtectionSignatures(t *testing.T) {
+	tests := []struct {
+		scheme    string
+		text      string
+		version   string
+		changeDSK func(d *DSK)
+	}{
+		{"Hexagon", "HEXAGON Disk Protection c 1989", "1989", nil},
+		{"Alkatraz", " THE ALKATRAZ PROTECTION SYSTEM   (C) 1987  Appleby Associates", "1987", func(d *DSK) {
+			d.GetTrack(3, 0).Sectors[0].Info.N = 3
+		}},
+		{"Frontier", "NEW DISK PROTECTION SYSTEM. (C) 1990 BY NEW FRONTIER SOFT.", "", nil},
+		{"Three Inch Loader", "Loader Copyright Three Inch Software 1988", "1988", nil},
+		{"ERE/Remi HERBULOT", "PROTECTION      Remi HERBULOT", "", func(d *DSK) {
+			d.GetTrack(2, 0).Sectors[1].Info.R = d.GetTrack(2, 0).Sectors[0].Info.R
+		}},
+		{"Amsoft/EXOPAL", "EXOPAL", "", nil},
+	}
+	for _, tt := range tests {
+		dsk := newTestDSK(FormatExtended, 40, 1, 0xC1)
+		data := dsk.GetTrack(1, 0).Sectors[4].Data
+		copy(data[100:], tt.text)
+		data[100+len(tt.text)-1] |= 0x80 // Loaders often mark the end of text with bit 7
+		if tt.changeDSK != nil {
+			tt.changeDSK(dsk)
+		}
+
+		matches := DetectProtection(dsk)
+		match := findProtection(matches, tt.scheme)
+		if match == nil {
+			t.Errorf("%s: not found, got %s", tt.scheme, ProtectionSummary(matches))
+			continue
+		}
+		if match.Version != tt.version {
+			t.Errorf("%s: expected version '%s', got '%s'", tt.scheme, tt.version, match.Version)
+		}
+		if complete := tt.changeDSK != nil; match.Complete() != complete {
+			t.Errorf("%s: expected complete %v, missing %v", tt.scheme, complete, match.Missing)
+		}
+	}
+}
+
+func TestDetectProtectionLayouts(t *testing.T) {
+	kbi := newTestDSK(FormatExtended, 40, 1, 0xC1)
+	track := kbi.GetTrack(39, 0)
+	track.Sectors = append(track.Sectors, LogicalSector{
+		Info: SectorInfo{C: 39, R: 0xCA, N: 1, FDCStatus1: FDCStatus1DataError, FDCStatus2: FDCStatus2DataErrorInDataField, DataLength: 256},
+		Data: make([]byte, 256),
+	})
+	if match := findProtection(DetectProtection(kbi), "KBI"); match == nil || match.Version != "10" || !match.Complete() || !match.Possible {
+		t.Errorf("expected a possible KBI 10, got %+v", match)
+	}
+	if summary := ProtectionSummary(DetectProtection(kbi)); summary != "KBI 10 (possible)" {
+		t.Errorf("expected the layout-only match to be marked possible, got %s", summary)
+	}
+
+	owens := newTestDSK(FormatExtended, 40, 1, 0xC1)
+	for c := 1; c < 40; c++ {
+		track := owens.GetTrack(c, 0)
+		track.Sectors = nil
+		for r := 0; r < 16; r++ {
+			track.Sectors = append(track.Sectors, LogicalSector{
+				Info: SectorInfo{C: uint8(c), R: uint8(r), N: 1, DataLength: 256},
+				Data: make([]byte, 256),
+			})
+		}
+	}
+	// Most of the disk is 256 byte sectors so the odd ones are the 512 byte sectors on track 0
+	if match := findProtection(DetectProtection(owens), "Paul Owens"); match == nil || !match.Complete() {
+		t.Errorf("expected a complete Paul Owens, got %+v", match)
+	}
+}
+
+func TestDetectProtectionDamagedDisks(t *testing.T) {
+	// An ordinary +3 dump with a bad sector on track 0 is not Speedlock without the loader
+	damaged := newPlus3TestDSK()
+	sector := damaged.GetTrack(0, 0).GetSector(0x02)
+	sector.Info.FDCStatus1, sector.Info.FDCStatus2 = FDCStatus1DataError, FDCStatus2DataErrorInDataField
+	if matches := DetectProtection(damaged); len(matches) != 0 {
+		t.Errorf("expected no protection on a damaged disk, got %s", ProtectionSummary(matches))
+	}
+
+	// Nor is a 19 sector track KBI without any data CRC errors
+	kbi := newTestDSK(FormatExtended, 40, 1, 0xC1)
+	track := kbi.GetTrack(39, 0)
+	for r := 0; r < 10; r++ {
+		track.Sectors = append(track.Sectors, LogicalSector{
+			Info: SectorInfo{C: 39, R: uint8(0xD0 + r), N: 1, DataLength: 256},
+			Data: make([]byte, 256),
+		})
+	}
+	if match := findProtection(DetectProtection(kbi), "KBI"); match != nil {
+		t.Errorf("expected no KBI without data CRC errors, got %+v", match)
+	}
+}
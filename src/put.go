@@ -0,0 +1,244 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// put.go - Writing host files into the CP/M filesystem
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// invalidCPMNameChars are characters CP/M does not allow in filenames
+const invalidCPMNameChars = "<>.,;:=?*[]%|()/\\\""
+
+// SplitCPMName validates a NAME.EXT filename and returns the upper-cased name and extension parts
+func SplitCPMName(name string) (string, string, error) {
+	name = strings.ToUpper(name)
+	base, ext := name, ""
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		base, ext = name[:dot], name[dot+1:]
+	}
+
+	if base == "" || len(base) > 8 || len(ext) > 3 {
+		return "", "", fmt.Errorf("invalid CP/M filename '%s': must be 1-8 characters with an optional 1-3 character extension", name)
+	}
+	for _, c := range base + ext {
+		if c <= ' ' || c > '~' || strings.ContainsRune(invalidCPMNameChars, c) {
+			return "", "", fmt.Errorf("invalid CP/M filename '%s': illegal character %q", name, c)
+		}
+	}
+	return base, ext, nil
+}
+
+// padCPMName returns the 11-byte space padded NAME + EXT used in directory entries and headers
+func padCPMName(base string, ext string) []byte {
+	return []byte(fmt.Sprintf("%-8s%-3s", base, ext))
+}
+
+// setName sets the name and extension of a directory entry preserving its attribute bits
+func (e *DirEntry) setName(base string, ext string) {
+	padded := padCPMName(base, ext)
+	for i, c := range padded {
+		e.Raw[1+i] = c | e.Raw[1+i]&0x80
+	}
+}
+
+// PutOptions controls how a host file is written to the CP/M filesystem
+type PutOptions struct {
+	User      uint8
+	Overwrite bool // Replace an existing file with the same name in the user area
+}
+
+// WriteFile stores data as a new file, allocating free blocks and directory entries
+// Nothing is changed if the disk or directory does not have room for the whole file
+func (fs *CPMFileSystem) WriteFile(name string, data []byte, options PutOptions) error {
+	base, ext, err := SplitCPMName(name)
+	if err != nil {
+		return err
+	}
+	if options.User > MaxUser {
+		return fmt.Errorf("invalid user %d. Must be 0-15", options.User)
+	}
+	fileName := base
+	if ext != "" {
+		fileName += "." + ext
+	}
+
+	entries, err := fs.ReadDirectory()
+	if err != nil {
+		return err
+	}
+
+	// Release the entries of an existing file with the same name
+	for i := range entries {
+		if entries[i].IsFile() && entries[i].User() == options.User && entries[i].FileName() == fileName {
+			if !options.Overwrite {
+				return fmt.Errorf("%d:%s already exists", options.User, fileName)
+			}
+			entries[i].Raw[0] = DeletedUser
+		}
+	}
+
+	// Work out what is needed before touching the disk
+	blockSize := fs.Format.BlockSize
+	blocksNeeded := (len(data) + blockSize - 1) / blockSize
+	pointersPerEntry := 16
+	if fs.Format.WideBlockPointers() {
+		pointersPerEntry = 8
+	}
+	entriesNeeded := (blocksNeeded + pointersPerEntry - 1) / pointersPerEntry
+	if entriesNeeded == 0 {
+		entriesNeeded = 1
+	}
+
+	free := fs.freeBlocks(entries)
+	if blocksNeeded > len(free) {
+		return fmt.Errorf("disk full: %s needs %dK but only %dK is free", fileName, blocksNeeded*blockSize/1024, len(free)*blockSize/1024)
+	}
+
+	freeEntries := make([]int, 0, entriesNeeded)
+	for i := range entries {
+		if entries[i].IsDeleted() && len(freeEntries) < entriesNeeded {
+			freeEntries = append(freeEntries, i)
+		}
+	}
+	if len(freeEntries) < entriesNeeded {
+		return fmt.Errorf("directory full: %s needs %d entries but only %d are free", fileName, entriesNeeded, len(freeEntries))
+	}
+
+	// Write the data padded to a whole record with ^Z
+	records := (len(data) + RecordSize - 1) / RecordSize
+	padded := make([]byte, records*RecordSize)
+	copy(padded, data)
+	for i := len(data); i < len(padded); i++ {
+		padded[i] = cpmEOF
+	}
+
+	blocks := free[:blocksNeeded]
+	for i, block := range blocks {
+		end := (i + 1) * blockSize
+		if end > len(padded) {
+			end = len(padded)
+		}
+		if err := fs.WriteBlock(block, padded[i*blockSize:end]); err != nil {
+			return err
+		}
+	}
+
+	// Create a directory entry for each group of blocks
+	extentsPerEntry := fs.Format.ExtentMask() + 1
+	recordsPerEntry := extentsPerEntry * 128
+	for i, index := range freeEntries {
+		entry := &entries[index]
+		entry.Raw = [DirEntrySize]byte{}
+		entry.Raw[0] = options.User
+		entry.setName(base, ext)
+
+		entryRecords := records - i*recordsPerEntry
+		if entryRecords > recordsPerEntry {
+			entryRecords = recordsPerEntry
+		}
+		extent := i * extentsPerEntry
+		recordCount := 0
+		if entryRecords > 0 {
+			extent += (entryRecords - 1) / 128
+			recordCount = entryRecords - (entryRecords-1)/128*128
+		}
+		entry.Raw[12] = uint8(extent % 32)
+		entry.Raw[14] = uint8(extent / 32)
+		entry.Raw[15] = uint8(recordCount)
+
+		first := i * pointersPerEntry
+		last := first + pointersPerEntry
+		if last > len(blocks) {
+			last = len(blocks)
+		}
+		for j, block := range blocks[first:last] {
+			if pointersPerEntry == 8 {
+				entry.Raw[16+j*2] = uint8(block)
+				entry.Raw[17+j*2] = uint8(block >> 8)
+			} else {
+				entry.Raw[16+j] = uint8(block)
+			}
+		}
+	}
+
+	return fs.WriteDirectory(entries)
+}
+
+// HeaderOptions describes the AMSDOS or +3DOS header to place in front of a file
+type HeaderOptions struct {
+	Kind        string // "amsdos" or "plus3dos"
+	Type        string // "binary" (CODE on the +3) or "basic"
+	LoadAddress uint16
+	ExecAddress uint16
+	Line        int // BASIC autostart line (+3DOS only), -1 for none
+}
+
+// AddFileHeader returns data with an AMSDOS or +3DOS header prepended
+func AddFileHeader(data []byte, name string, user uint8, options HeaderOptions) ([]byte, error) {
+	var header []byte
+	var err error
+
+	if HasPlus3DOSHeader(data) || HasAMSDOSHeader(data) {
+		return nil, fmt.Errorf("file already has a header")
+	}
+
+	switch options.Kind {
+	case "amsdos":
+		if options.Line >= 0 {
+			return nil, fmt.Errorf("AMSDOS headers have no autostart line")
+		}
+		fileType := uint8(AMSDOSTypeBinary)
+		loadAddress := options.LoadAddress
+		if options.Type == "basic" {
+			fileType = AMSDOSTypeBASIC
+			loadAddress = 0x0170 // Start of BASIC program area
+		}
+		header, err = BuildAMSDOSHeader(user, name, fileType, loadAddress, options.ExecAddress, len(data))
+
+	case "plus3dos":
+		if options.Type == "basic" {
+			line := uint16(Plus3NoAutostart)
+			if options.Line >= 0 {
+				if options.Line > 9999 {
+					return nil, fmt.Errorf("invalid autostart line %d. Must be 0-9999", options.Line)
+				}
+				line = uint16(options.Line)
+			}
+			header, err = BuildPlus3DOSHeader(Plus3TypeProgram, len(data), line, uint16(len(data)))
+		} else {
+			if options.Line >= 0 {
+				return nil, fmt.Errorf("autostart line only applies to BASIC files")
+			}
+			header, err = BuildPlus3DOSHeader(Plus3TypeCode, len(data), options.LoadAddress, 0x8000)
+		}
+
+	default:
+		return nil, fmt.Errorf("invalid header kind '%s'. Must be amsdos or plus3dos", options.Kind)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return append(header, data...), nil
+}
+
+// ParseAddress parses a 16-bit address in decimal or hex (&8000, #8000, $8000 or 0x8000)
+func ParseAddress(value string) (uint16, error) {
+	digits, base := value, 10
+	for _, prefix := range []string{"&", "#", "$", "0x", "0X"} {
+		if strings.HasPrefix(value, prefix) {
+			digits, base = value[len(prefix):], 16
+			break
+		}
+	}
+
+	address, err := strconv.ParseUint(digits, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address '%s'", value)
+	}
+	return uint16(address), nil
+}
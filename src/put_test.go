@@ -0,0 +1,168 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// put_test.go - Unit tests for writing files into the CP/M filesystem
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileRoundTrip(t *testing.T) {
+	dsk := newPlus3TestDSK()
+	fs, err := OpenCPMFileSystem(dsk)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 40000 bytes needs 40 blocks spread over 3 directory entries
+	content := make([]byte, 40000)
+	rand.New(rand.NewSource(1)).Read(content)
+	if err := fs.WriteFile("game.bin", content, PutOptions{User: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Serialize and parse back to make sure the data made it into the sectors
+	data, err := dsk.Bytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parsed, err := ParseDSKData(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fs, err = OpenCPMFileSystem(parsed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files, err := fs.MatchFiles("GAME.BIN", 1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d (%v)", len(files), err)
+	}
+	if len(files[0].Entries) != 3 {
+		t.Errorf("expected 3 directory entries, got %d", len(files[0].Entries))
+	}
+	if expected := (len(content) + 127) / 128; files[0].Records != expected {
+		t.Errorf("records: expected %d, got %d", expected, files[0].Records)
+	}
+	read, err := fs.ReadFile(&files[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(read[:len(content)], content) || read[len(content)] != cpmEOF {
+		t.Errorf("file content mismatch")
+	}
+}
+
+func TestWriteFileRefusals(t *testing.T) {
+	fs, err := OpenCPMFileSystem(newTestDSK(FormatExtended, 40, 1, 0xC1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := fs.WriteFile("FIRST.BIN", make([]byte, 100), PutOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := fs.WriteFile("FIRST.BIN", make([]byte, 100), PutOptions{}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected already exists error, got %v", err)
+	}
+	if err := fs.WriteFile("FIRST.BIN", make([]byte, 2000), PutOptions{Overwrite: true}); err != nil {
+		t.Errorf("unexpected error overwriting: %v", err)
+	}
+
+	before, _ := fs.ReadDirectory()
+	if err := fs.WriteFile("HUGE.BIN", make([]byte, 200*1024), PutOptions{}); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("expected disk full error, got %v", err)
+	}
+	after, _ := fs.ReadDirectory()
+	for i := range before {
+		if before[i].Raw != after[i].Raw {
+			t.Errorf("directory changed by refused write at entry %d", i)
+		}
+	}
+
+	// FIRST.BIN has 1 of the 64 entries so the 64th empty file will not fit
+	for i := 0; i < 64 && err == nil; i++ {
+		err = fs.WriteFile(fmt.Sprintf("FILE%02d", i), nil, PutOptions{})
+	}
+	if err == nil || !strings.Contains(err.Error(), "directory full") {
+		t.Errorf("expected directory full error, got %v", err)
+	}
+
+	for _, name := range []string{"TOOLONGNAME.BIN", "BAD*.BIN", "NAME.LONG", ".BIN"} {
+		if err := fs.WriteFile(name, nil, PutOptions{}); err == nil || !strings.Contains(err.Error(), "invalid CP/M filename") {
+			t.Errorf("%s: expected invalid filename error, got %v", name, err)
+		}
+	}
+}
+
+func TestAddFileHeader(t *testing.T) {
+	data := []byte{1, 2, 3, 4}
+
+	amsdos, err := AddFileHeader(data, "GAME.BIN", 0, HeaderOptions{Kind: "amsdos", Type: "binary", LoadAddress: 0x4000, ExecAddress: 0x4010, Line: -1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !HasAMSDOSHeader(amsdos) || amsdos[18] != AMSDOSTypeBinary || string(amsdos[1:12]) != "GAME    BIN" {
+		t.Errorf("invalid AMSDOS header")
+	}
+	if binary.LittleEndian.Uint16(amsdos[21:23]) != 0x4000 || binary.LittleEndian.Uint16(amsdos[26:28]) != 0x4010 {
+		t.Errorf("AMSDOS load/exec addresses not set")
+	}
+
+	plus3, err := AddFileHeader(data, "LOADER", 0, HeaderOptions{Kind: "plus3dos", Type: "basic", Line: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !HasPlus3DOSHeader(plus3) || plus3[15] != Plus3TypeProgram || binary.LittleEndian.Uint16(plus3[18:20]) != 10 {
+		t.Errorf("invalid +3DOS BASIC header")
+	}
+	if plus3DOSFileLength(plus3) != len(plus3) {
+		t.Errorf("+3DOS length: expected %d, got %d", len(plus3), plus3DOSFileLength(plus3))
+	}
+
+	if _, err := AddFileHeader(data, "GAME.BIN", 0, HeaderOptions{Kind: "amsdos", Line: 10}); err == nil {
+		t.Errorf("expected error for AMSDOS autostart line")
+	}
+
+	code, err := AddFileHeader(data, "SCREEN", 0, HeaderOptions{Kind: "plus3dos", Type: "binary", LoadAddress: 32768, Line: -1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !HasPlus3DOSHeader(code) || code[15] != Plus3TypeCode || binary.LittleEndian.Uint16(code[18:20]) != 32768 {
+		t.Errorf("invalid +3DOS CODE header")
+	}
+
+	if _, err := AddFileHeader(code, "SCREEN", 0, HeaderOptions{Kind: "plus3dos", Type: "binary", Line: -1}); err == nil {
+		t.Errorf("expected error for a file that already has a header")
+	}
+
+	// A SCREEN$ with a blank top third starts with 2048 zero bytes but has no header
+	screen := make([]byte, 6912)
+	screen[6144] = 0x38
+	screen, err = AddFileHeader(screen, "SCREEN$", 0, HeaderOptions{Kind: "plus3dos", Type: "binary", LoadAddress: 16384, Line: -1})
+	if err != nil {
+		t.Fatalf("unexpected error for zero-led data: %v", err)
+	}
+	if plus3DOSFileLength(screen) != Plus3DOSHeaderSize+6912 {
+		t.Errorf("+3DOS length: expected %d, got %d", Plus3DOSHeaderSize+6912, plus3DOSFileLength(screen))
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := map[string]uint16{"32768": 32768, "&8000": 0x8000, "#4000": 0x4000, "$C000": 0xC000, "0x170": 0x170}
+	for value, expected := range tests {
+		if address, err := ParseAddress(value); err != nil || address != expected {
+			t.Errorf("ParseAddress(%q): expected %04X, got %04X (%v)", value, expected, address, err)
+		}
+	}
+	if _, err := ParseAddress("&10000"); err == nil {
+		t.Errorf("expected error for out of range address")
+	}
+}
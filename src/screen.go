@@ -0,0 +1,457 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// screen.go - CPC and Spectrum screen conversion to and from PNG
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Screen memory sizes
+const (
+	CPCScreenSize      = 16384 // 80 bytes by 200 lines, interleaved in 8 blocks of 2K
+	SpectrumScreenSize = 6912  // 6144 bytes of bitmap then 768 attributes
+	cpcMinScreenSize   = 16336 // Screens are often saved without the final unused 48 bytes
+)
+
+// Screen kinds
+const (
+	ScreenCPC      = "cpc"
+	ScreenSpectrum = "spectrum"
+)
+
+// cpcDefaultInks are the firmware inks the CPC starts with for pens 0-15
+// (pens 14 and 15 flash, the first of their two inks is used)
+var cpcDefaultInks = []int{1, 24, 20, 6, 26, 0, 2, 8, 10, 12, 14, 16, 18, 22, 1, 16}
+
+// cpcModeWidths is the width in pixels of each screen mode
+var cpcModeWidths = []int{160, 320, 640}
+
+// cpcInkColor returns the colour of a firmware ink (0-26), which is 9 x green + 3 x red + blue
+// with each at off, half or full
+func cpcInkColor(ink int) color.RGBA {
+	levels := []uint8{0x00, 0x80, 0xFF}
+	return color.RGBA{R: levels[ink/3%3], G: levels[ink/9], B: levels[ink%3], A: 0xFF}
+}
+
+// CPCPens returns the number of pens available in a screen mode
+func CPCPens(mode int) int {
+	return []int{16, 4, 2}[mode]
+}
+
+// DefaultCPCPalette returns the inks the firmware gives the pens of a screen mode
+func DefaultCPCPalette(mode int) []int {
+	return append([]int{}, cpcDefaultInks[:CPCPens(mode)]...)
+}
+
+// ParseCPCPalette parses a comma separated list of firmware inks, one per pen of the mode
+func ParseCPCPalette(value string, mode int) ([]int, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) > CPCPens(mode) {
+		return nil, fmt.Errorf("mode %d has %d pens but %d inks were given", mode, CPCPens(mode), len(fields))
+	}
+	palette := DefaultCPCPalette(mode)
+	for i, field := range fields {
+		ink, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || ink < 0 || ink > 26 {
+			return nil, fmt.Errorf("invalid ink '%s'. Must be 0-26", field)
+		}
+		palette[i] = ink
+	}
+	return palette, nil
+}
+
+// formatCPCPalette returns a palette as a comma separated list of inks
+func formatCPCPalette(palette []int) string {
+	inks := make([]string, len(palette))
+	for i, ink := range palette {
+		inks[i] = strconv.Itoa(ink)
+	}
+	return strings.Join(inks, ",")
+}
+
+// ScreenKind reports whether a file is a CPC or Spectrum screen from its length (after removing
+// an AMSDOS or +3DOS header with a valid checksum), returning the screen data padded to the full
+// screen size. Headerless screens are kept whole, even when they start with zero bytes.
+func ScreenKind(data []byte) (string, []byte) {
+	screen := StripFileHeader(data)
+	switch {
+	case len(screen) == SpectrumScreenSize:
+		return ScreenSpectrum, screen
+	case len(screen) >= cpcMinScreenSize && len(screen) <= CPCScreenSize:
+		padded := make([]byte, CPCScreenSize)
+		copy(padded, screen)
+		return ScreenCPC, padded
+	}
+	return "", nil
+}
+
+// cpcLineOffset returns the offset of the first byte of a line of the CPC screen
+func cpcLineOffset(y int) int {
+	return y/8*80 + y%8*2048
+}
+
+// cpcPixelPen returns the pen of pixel i (counting from the left) within a screen byte
+func cpcPixelPen(b byte, mode int, i int) int {
+	switch mode {
+	case 0:
+		// Pixel 0 has bits 7, 3, 5 and 1 for pen bits 0-3, pixel 1 has bits 6, 2, 4 and 0
+		b <<= i
+		return int(b>>7&1 | b>>2&2 | b>>3&4 | b<<2&8)
+	case 1:
+		// Bits 7-4 are bit 0 of pixels 0-3, bits 3-0 bit 1
+		return int(b>>(7-i)&1 | b>>(3-i)<<1&2)
+	default:
+		return int(b >> (7 - i) & 1)
+	}
+}
+
+// cpcPixelBits returns the bits of a screen byte that put a pen in pixel i
+func cpcPixelBits(pen int, mode int, i int) byte {
+	switch mode {
+	case 0:
+		bits := byte(pen&1<<7 | pen&2<<2 | pen&4<<3 | pen&8>>2)
+		return bits >> i
+	case 1:
+		return byte(pen&1<<(7-i) | pen&2>>1<<(3-i))
+	default:
+		return byte(pen & 1 << (7 - i))
+	}
+}
+
+// RenderCPCScreen converts CPC screen memory to an image in a mode (160, 320 or 640 pixels wide
+// and 200 high) using the palette's firmware inks
+func RenderCPCScreen(screen []byte, mode int, palette []int) (*image.Paletted, error) {
+	if len(screen) < CPCScreenSize {
+		return nil, fmt.Errorf("CPC screen must be %d bytes, got %d", CPCScreenSize, len(screen))
+	}
+	if len(palette) != CPCPens(mode) {
+		return nil, fmt.Errorf("mode %d needs %d inks, got %d", mode, CPCPens(mode), len(palette))
+	}
+
+	colors := make(color.Palette, len(palette))
+	for i, ink := range palette {
+		colors[i] = cpcInkColor(ink)
+	}
+	width := cpcModeWidths[mode]
+	perByte := width / 80
+	img := image.NewPaletted(image.Rect(0, 0, width, 200), colors)
+	for y := 0; y < 200; y++ {
+		line := screen[cpcLineOffset(y):]
+		for x := 0; x < width; x++ {
+			img.SetColorIndex(x, y, uint8(cpcPixelPen(line[x/perByte], mode, x%perByte)))
+		}
+	}
+	return img, nil
+}
+
+// spectrumColors are the 8 normal then 8 bright Spectrum colours
+var spectrumColors = func() color.Palette {
+	colors := make(color.Palette, 16)
+	for i := range colors {
+		level := uint8(0xD7)
+		if i >= 8 {
+			level = 0xFF
+		}
+		c := color.RGBA{A: 0xFF}
+		if i&1 != 0 {
+			c.B = level
+		}
+		if i&2 != 0 {
+			c.R = level
+		}
+		if i&4 != 0 {
+			c.G = level
+		}
+		colors[i] = c
+	}
+	return colors
+}()
+
+// spectrumPixelOffset returns the offset of the bitmap byte holding a pixel of the Spectrum screen
+func spectrumPixelOffset(x int, y int) int {
+	return y&0xC0<<5 | y&0x07<<8 | y&0x38<<2 | x/8
+}
+
+// spectrumAttributeOffset returns the offset of the attribute for a pixel of the Spectrum screen
+func spectrumAttributeOffset(x int, y int) int {
+	return 6144 + y/8*32 + x/8
+}
+
+// RenderSpectrumScreen converts a SCREEN$ to a 256x192 image (flashing cells are shown unflashed)
+func RenderSpectrumScreen(screen []byte) (*image.Paletted, error) {
+	if len(screen) < SpectrumScreenSize {
+		return nil, fmt.Errorf("SCREEN$ must be %d bytes, got %d", SpectrumScreenSize, len(screen))
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, 256, 192), spectrumColors)
+	for y := 0; y < 192; y++ {
+		for x := 0; x < 256; x++ {
+			attribute := screen[spectrumAttributeOffset(x, y)]
+			bright := attribute >> 3 & 0x08
+			index := attribute>>3&0x07 | bright
+			if screen[spectrumPixelOffset(x, y)]<<(x%8)&0x80 != 0 {
+				index = attribute&0x07 | bright
+			}
+			img.SetColorIndex(x, y, index)
+		}
+	}
+	return img, nil
+}
+
+// RenderScreen converts a CPC screen (in the given mode and palette) or SCREEN$ to an image
+func RenderScreen(kind string, screen []byte, mode int, palette []int) (image.Image, error) {
+	if kind == ScreenSpectrum {
+		return RenderSpectrumScreen(screen)
+	}
+	return RenderCPCScreen(screen, mode, palette)
+}
+
+// colorDistance returns the squared distance between two colours
+func colorDistance(a color.Color, b color.Color) int {
+	ar, ag, ab, _ := a.RGBA()
+	br, bg, bb, _ := b.RGBA()
+	dr, dg, db := int(ar>>8)-int(br>>8), int(ag>>8)-int(bg>>8), int(ab>>8)-int(bb>>8)
+	return dr*dr + dg*dg + db*db
+}
+
+// nearestColor returns the index of the closest colour among the candidates
+func nearestColor(c color.Color, palette color.Palette, candidates []int) int {
+	best, bestDistance := candidates[0], -1
+	for _, candidate := range candidates {
+		if distance := colorDistance(c, palette[candidate]); bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// mostFrequent returns up to n keys of counts, most frequent first (lowest key first on ties)
+func mostFrequent(counts map[int]int, n int) []int {
+	keys := make([]int, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys[:min(n, len(keys))]
+}
+
+// EncodeCPCScreen converts an image (160, 320 or 640 pixels wide for modes 0, 1 and 2, and 200 high)
+// to CPC screen memory, returning the palette of firmware inks used
+// Without a palette the most used inks are chosen and other colours take the nearest of them
+func EncodeCPCScreen(img image.Image, mode int, palette []int) ([]byte, []int, error) {
+	bounds := img.Bounds()
+	width := cpcModeWidths[mode]
+	if bounds.Dx() != width || bounds.Dy() != 200 {
+		return nil, nil, fmt.Errorf("mode %d images must be %dx200, got %dx%d", mode, width, bounds.Dx(), bounds.Dy())
+	}
+
+	inks := make(color.Palette, 27)
+	allInks := make([]int, 27)
+	for i := range inks {
+		inks[i] = cpcInkColor(i)
+		allInks[i] = i
+	}
+	if palette == nil {
+		counts := make(map[int]int)
+		for y := 0; y < 200; y++ {
+			for x := 0; x < width; x++ {
+				counts[nearestColor(img.At(bounds.Min.X+x, bounds.Min.Y+y), inks, allInks)]++
+			}
+		}
+		palette = mostFrequent(counts, CPCPens(mode))
+		sort.Ints(palette)
+	}
+
+	colors := make(color.Palette, len(palette))
+	pens := make([]int, len(palette))
+	for i, ink := range palette {
+		colors[i] = cpcInkColor(ink)
+		pens[i] = i
+	}
+	screen := make([]byte, CPCScreenSize)
+	perByte := width / 80
+	for y := 0; y < 200; y++ {
+		line := screen[cpcLineOffset(y):]
+		for x := 0; x < width; x++ {
+			pen := nearestColor(img.At(bounds.Min.X+x, bounds.Min.Y+y), colors, pens)
+			line[x/perByte] |= cpcPixelBits(pen, mode, x%perByte)
+		}
+	}
+	return screen, palette, nil
+}
+
+// EncodeSpectrumScreen converts a 256x192 image to a SCREEN$, choosing the two most used colours
+// of each 8x8 cell as its paper and ink (the most used is the paper)
+func EncodeSpectrumScreen(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	if bounds.Dx() != 256 || bounds.Dy() != 192 {
+		return nil, fmt.Errorf("Spectrum images must be 256x192, got %dx%d", bounds.Dx(), bounds.Dy())
+	}
+
+	allColors := make([]int, len(spectrumColors))
+	for i := range allColors {
+		allColors[i] = i
+	}
+	screen := make([]byte, SpectrumScreenSize)
+	for row := 0; row < 24; row++ {
+		for column := 0; column < 32; column++ {
+			counts := make(map[int]int)
+			for y := row * 8; y < row*8+8; y++ {
+				for x := column * 8; x < column*8+8; x++ {
+					counts[nearestColor(img.At(bounds.Min.X+x, bounds.Min.Y+y), spectrumColors, allColors)]++
+				}
+			}
+
+			// Bright applies to both colours of the cell, so use the most common brightness
+			// (black looks the same either way)
+			brightness := 0
+			for index, count := range counts {
+				if index > 8 {
+					brightness += count
+				} else if index > 0 && index < 8 {
+					brightness -= count
+				}
+			}
+			bright := 0
+			if brightness > 0 {
+				bright = 8
+			}
+			cellCounts := make(map[int]int)
+			for index, count := range counts {
+				cellCounts[index&0x07] += count
+			}
+			chosen := mostFrequent(cellCounts, 2)
+			paper, ink := chosen[0], chosen[0]
+			if len(chosen) > 1 {
+				ink = chosen[1]
+			}
+
+			screen[6144+row*32+column] = byte(bright<<3 | paper<<3 | ink)
+			for y := row * 8; y < row*8+8; y++ {
+				for x := column * 8; x < column*8+8; x++ {
+					c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+					if ink != paper && nearestColor(c, spectrumColors, []int{paper | bright, ink | bright}) == ink|bright {
+						screen[spectrumPixelOffset(x, y)] |= 0x80 >> (x % 8)
+					}
+				}
+			}
+		}
+	}
+	return screen, nil
+}
+
+// ScreenFile adds the header that lets the screen be loaded on its machine: an AMSDOS binary
+// header loading at &C000 or a +3DOS CODE 16384,6912 header
+func ScreenFile(kind string, screen []byte, name string) ([]byte, error) {
+	var header []byte
+	var err error
+	if kind == ScreenSpectrum {
+		header, err = BuildPlus3DOSHeader(Plus3TypeCode, len(screen), 0x4000, 0)
+	} else {
+		header, err = BuildAMSDOSHeader(0, name, AMSDOSTypeBinary, 0xC000, 0, len(screen))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(header, screen...), nil
+}
+
+// WritePNG saves an image as a PNG file
+func WritePNG(filename string, img image.Image) error {
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, buffer.Bytes(), 0644)
+}
+
+// ReadPNG loads a PNG file
+func ReadPNG(filename string) (image.Image, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return img, nil
+}
+
+// isPNGFile reports whether data starts with the PNG signature
+func isPNGFile(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n"))
+}
+
+// ExportScreens writes a PNG of every screen-sized file matching pattern to outputDir, rendering
+// CPC screens in the given mode and palette
+func (fs *CPMFileSystem) ExportScreens(pattern string, user int, outputDir string, mode int, palette []int) ([]CPMFile, []string, error) {
+	files, err := fs.MatchFiles(pattern, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exported := make([]CPMFile, 0)
+	kinds := make([]string, 0)
+	for i := range files {
+		data, err := fs.ReadFile(&files[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if size := files[i].Size(); size < len(data) {
+			data = data[:size]
+		}
+		kind, screen := ScreenKind(data)
+		if kind == "" {
+			continue
+		}
+		img, err := RenderScreen(kind, screen, mode, palette)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", files[i].Name, err)
+		}
+
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return nil, nil, fmt.Errorf("failed to create output directory: %v", err)
+		}
+		name := hostFileName(files[i].Name) + ".png"
+		if user == AnyUser && files[i].User != 0 {
+			name = fmt.Sprintf("user-%d-%s", files[i].User, name)
+		}
+		if err := WritePNG(filepath.Join(outputDir, name), img); err != nil {
+			return nil, nil, fmt.Errorf("failed to write %s: %v", name, err)
+		}
+		exported = append(exported, files[i])
+		kinds = append(kinds, kind)
+	}
+	return exported, kinds, nil
+}
+
+// ScreenFormatForImage returns the kind of screen (and CPC mode) an image's size suits
+func ScreenFormatForImage(img image.Image) (string, int) {
+	bounds := img.Bounds()
+	if bounds.Dx() == 256 && bounds.Dy() == 192 {
+		return ScreenSpectrum, 0
+	}
+	for mode, width := range cpcModeWidths {
+		if bounds.Dx() == width && bounds.Dy() == 200 {
+			return ScreenCPC, mode
+		}
+	}
+	return "", 0
+}
@@ -0,0 +1,273 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// screen_test.go - Unit tests for CPC and Spectrum screen conversion
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// randomScreen returns a screen of random bytes
+func randomScreen(size int, seed int64) []byte {
+	random := rand.New(rand.NewSource(seed))
+	screen := make([]byte, size)
+	random.Read(screen)
+	return screen
+}
+
+// sameColors reports whether two images have the same colour at every pixel
+func sameColors(a image.Image, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	for y := a.Bounds().Min.Y; y < a.Bounds().Max.Y; y++ {
+		for x := a.Bounds().Min.X; x < a.Bounds().Max.X; x++ {
+			if colorDistance(a.At(x, y), b.At(x, y)) != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestCPCInkColor(t *testing.T) {
+	tests := map[int]color.RGBA{
+		0:  {0x00, 0x00, 0x00, 0xFF},
+		1:  {0x00, 0x00, 0x80, 0xFF},
+		6:  {0xFF, 0x00, 0x00, 0xFF},
+		13: {0x80, 0x80, 0x80, 0xFF},
+		20: {0x00, 0xFF, 0xFF, 0xFF},
+		24: {0xFF, 0xFF, 0x00, 0xFF},
+		26: {0xFF, 0xFF, 0xFF, 0xFF},
+	}
+	for ink, expected := range tests {
+		if c := cpcInkColor(ink); c != expected {
+			t.Errorf("ink %d: expected %v, got %v", ink, expected, c)
+		}
+	}
+}
+
+func TestCPCPixels(t *testing.T) {
+	// Mode 0 pixel 0 uses bits 7, 3, 5 and 1 for pen bits 0-3
+	if pen := cpcPixelPen(0x80|0x20, 0, 0); pen != 5 {
+		t.Errorf("expected pen 5, got %d", pen)
+	}
+	if pen := cpcPixelPen(0x01, 0, 1); pen != 8 {
+		t.Errorf("expected pen 8, got %d", pen)
+	}
+	// Mode 1 pixel 3 uses bits 4 and 0
+	if pen := cpcPixelPen(0x11, 1, 3); pen != 3 {
+		t.Errorf("expected pen 3, got %d", pen)
+	}
+
+	for mode := 0; mode <= 2; mode++ {
+		for pen := 0; pen < CPCPens(mode); pen++ {
+			for i := 0; i < 8>>(2-mode); i++ {
+				if got := cpcPixelPen(cpcPixelBits(pen, mode, i), mode, i); got != pen {
+					t.Errorf("mode %d pixel %d: expected pen %d, got %d", mode, i, pen, got)
+				}
+			}
+		}
+	}
+}
+
+func TestCPCScreenRoundTrip(t *testing.T) {
+	for mode := 0; mode <= 2; mode++ {
+		screen := randomScreen(CPCScreenSize, int64(mode))
+		palette := []int{0, 26, 6, 18, 1, 2, 3, 4, 5, 7, 8, 9, 10, 11, 12, 13}[:CPCPens(mode)]
+		img, err := RenderCPCScreen(screen, mode, palette)
+		if err != nil {
+			t.Fatalf("mode %d: unexpected error: %v", mode, err)
+		}
+		if kind, detected := ScreenFormatForImage(img); kind != ScreenCPC || detected != mode {
+			t.Errorf("mode %d: image detected as %s mode %d", mode, kind, detected)
+		}
+
+		encoded, _, err := EncodeCPCScreen(img, mode, palette)
+		if err != nil {
+			t.Fatalf("mode %d: unexpected error: %v", mode, err)
+		}
+		// The unused 48 bytes at the end of each 2K block are not part of the image
+		for block := 0; block < 8; block++ {
+			copy(screen[block*2048+2000:block*2048+2048], make([]byte, 48))
+		}
+		if !bytes.Equal(encoded, screen) {
+			t.Errorf("mode %d: screen changed by the round trip", mode)
+		}
+
+		// Without a palette the inks are chosen from the image
+		_, chosen, err := EncodeCPCScreen(img, mode, nil)
+		if err != nil {
+			t.Fatalf("mode %d: unexpected error: %v", mode, err)
+		}
+		if len(chosen) != CPCPens(mode) {
+			t.Errorf("mode %d: expected %d inks, got %v", mode, CPCPens(mode), chosen)
+		}
+	}
+}
+
+func TestEncodeCPCScreenPalette(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 200))
+	for x := 0; x < 640; x++ {
+		for y := 0; y < 200; y++ {
+			img.Set(x, y, color.RGBA{0x10, 0x10, 0x70, 0xFF}) // Closest to ink 1
+		}
+	}
+	img.Set(0, 0, color.RGBA{0xF0, 0xF0, 0x10, 0xFF}) // Closest to ink 24
+
+	screen, palette, err := EncodeCPCScreen(img, 2, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(palette, []int{1, 24}) {
+		t.Errorf("expected palette 1,24, got %v", palette)
+	}
+	if screen[0] != 0x80 || screen[1] != 0x00 {
+		t.Errorf("expected only the first pixel in pen 1, got % X", screen[:2])
+	}
+
+	if _, _, err := EncodeCPCScreen(img, 1, nil); err == nil {
+		t.Errorf("expected an error for the wrong image size")
+	}
+}
+
+func TestSpectrumScreenRoundTrip(t *testing.T) {
+	screen := randomScreen(SpectrumScreenSize, 1)
+	img, err := RenderSpectrumScreen(screen)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if kind, _ := ScreenFormatForImage(img); kind != ScreenSpectrum {
+		t.Errorf("expected a Spectrum image, got %s", kind)
+	}
+
+	encoded, err := EncodeSpectrumScreen(img)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, err := RenderSpectrumScreen(encoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameColors(img, result) {
+		t.Errorf("image changed by the round trip")
+	}
+
+	// A cell of INK 2 on PAPER 7 BRIGHT 1 with the top left pixel set
+	screen = make([]byte, SpectrumScreenSize)
+	screen[0] = 0x80
+	screen[6144] = 0x40 | 7<<3 | 2
+	img, _ = RenderSpectrumScreen(screen)
+	if c := img.At(0, 0); colorDistance(c, color.RGBA{0xFF, 0x00, 0x00, 0xFF}) != 0 {
+		t.Errorf("expected bright red, got %v", c)
+	}
+	if encoded, _ = EncodeSpectrumScreen(img); !bytes.Equal(encoded, screen) {
+		t.Errorf("expected % X % X, got % X % X", screen[0], screen[6144], encoded[0], encoded[6144])
+	}
+}
+
+func TestScreenKind(t *testing.T) {
+	// Headerless screens whose top is black start with zeros that must not be taken for a header
+	blankTop := func(data []byte) []byte {
+		clear(data[:2048])
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		kind string
+	}{
+		{"SCREEN$", randomScreen(SpectrumScreenSize, 4), ScreenSpectrum},
+		{"CPC", randomScreen(CPCScreenSize, 5), ScreenCPC},
+		{"CPC without the last gap", randomScreen(16336, 6), ScreenCPC},
+		{"too short", randomScreen(6000, 7), ""},
+		{"SCREEN$ with a blank top", blankTop(randomScreen(SpectrumScreenSize, 8)), ScreenSpectrum},
+		{"CPC with a blank top", blankTop(randomScreen(CPCScreenSize, 9)), ScreenCPC},
+	}
+	for _, tt := range tests {
+		if kind, screen := ScreenKind(tt.data); kind != tt.kind {
+			t.Errorf("%s: expected '%s', got '%s'", tt.name, tt.kind, kind)
+		} else if kind == ScreenCPC && len(screen) != CPCScreenSize {
+			t.Errorf("%s: expected the screen padded to %d bytes, got %d", tt.name, CPCScreenSize, len(screen))
+		} else if kind != "" && !bytes.Equal(screen[:len(tt.data)], tt.data) {
+			t.Errorf("%s: expected the screen data unchanged", tt.name)
+		}
+	}
+
+	file, err := ScreenFile(ScreenSpectrum, make([]byte, SpectrumScreenSize), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if header := ParsePlus3DOSHeader(file); header == nil || header.Summary() != "CODE 16384,6912" {
+		t.Errorf("expected a CODE 16384,6912 header")
+	}
+	if kind, _ := ScreenKind(file); kind != ScreenSpectrum {
+		t.Errorf("expected the file with a header to be a SCREEN$, got '%s'", kind)
+	}
+}
+
+func TestParseCPCPalette(t *testing.T) {
+	palette, err := ParseCPCPalette("0, 26", 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(palette, []int{0, 26, 20, 6}) {
+		t.Errorf("expected the remaining pens to keep their default inks, got %v", palette)
+	}
+	for _, value := range []string{"27", "a", "1,2,3"} {
+		if _, err := ParseCPCPalette(value, 2); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
+
+func TestExportScreens(t *testing.T) {
+	fs, err := OpenCPMFileSystem(newTestDSK(FormatExtended, 40, 1, 0xC1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loading, _ := ScreenFile(ScreenCPC, randomScreen(CPCScreenSize, 2), "LOADING.SCR")
+	for name, data := range map[string][]byte{
+		"LOADING.SCR": loading,
+		"ZX.SCR":      randomScreen(SpectrumScreenSize, 3),
+		"GAME.BIN":    make([]byte, 1000),
+	} {
+		if err := fs.WriteFile(name, data, PutOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	files, kinds, err := fs.ExportScreens("*.*", AnyUser, dir, 0, DefaultCPCPalette(0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 screens, got %d", len(files))
+	}
+	for i, file := range files {
+		expected := map[string]string{"LOADING.SCR": ScreenCPC, "ZX.SCR": ScreenSpectrum}[file.Name]
+		if kinds[i] != expected {
+			t.Errorf("%s: expected %s, got %s", file.Name, expected, kinds[i])
+		}
+		img, err := ReadPNG(filepath.Join(dir, file.Name+".png"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if kind, _ := ScreenFormatForImage(img); kind != expected {
+			t.Errorf("%s: expected a %s image, got %s", file.Name, expected, kind)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "GAME.BIN.png")); err == nil {
+		t.Errorf("expected GAME.BIN to be skipped")
+	}
+}
@@ -0,0 +1,137 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// sector.go - Sector sizes, kinds and the copies of weak sectors
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NaturalSize returns the size the sector's N gives (128 << N), with N capped at 8 like the FDC
+func (i SectorInfo) NaturalSize() int {
+	return 128 << min(i.N, 8)
+}
+
+// OversizedDataLimit is how much of an N=6 or N=7 sector is usually kept, as the rest would not fit on a track
+const OversizedDataLimit = 0x1800
+
+// SectorKind describes how a sector's data relates to its size
+type SectorKind string
+
+const (
+	SectorIDOnly    SectorKind = "id-only"   // ID field but no data field
+	SectorNormal    SectorKind = "normal"    // Exactly the natural size
+	SectorShort     SectorKind = "short"     // Less data than the natural size
+	SectorGap       SectorKind = "gap"       // Followed by GAP3/CRC bytes read past the end
+	SectorWeak      SectorKind = "weak"      // Several copies of the natural size
+	SectorOversized SectorKind = "oversized" // N=6 or more, usually truncated to OversizedDataLimit
+)
+
+// Kind works out the kind of the sector from its N and data length
+func (s *LogicalSector) Kind() SectorKind {
+	size := s.Info.NaturalSize()
+	switch {
+	case len(s.Data) == 0:
+		return SectorIDOnly
+	case s.Info.N >= 6:
+		return SectorOversized
+	case len(s.Data) == size:
+		return SectorNormal
+	case len(s.Data) < size:
+		return SectorShort
+	case s.IsWeak():
+		return SectorWeak
+	}
+	return SectorGap
+}
+
+// DescribeKind returns the kind of the sector with how its data differs from the natural size
+func (s *LogicalSector) DescribeKind() string {
+	size := s.Info.NaturalSize()
+	switch kind := s.Kind(); kind {
+	case SectorShort, SectorOversized:
+		return fmt.Sprintf("%s (%d of %d bytes)", kind, len(s.Data), size)
+	case SectorGap:
+		return fmt.Sprintf("%s (%d + %d gap bytes)", kind, size, len(s.Data)-size)
+	case SectorWeak:
+		return fmt.Sprintf("%s (%d copies)", kind, s.CopyCount())
+	default:
+		return string(kind)
+	}
+}
+
+// CopyCount returns how many reads of the sector the data holds
+// EDSK stores weak sectors, which read differently each time, as several copies one after
+// another so the data is a multiple of the natural size
+func (s *LogicalSector) CopyCount() int {
+	size := s.Info.NaturalSize()
+	if len(s.Data) <= size || len(s.Data)%size != 0 {
+		return 1
+	}
+	return len(s.Data) / size
+}
+
+// IsWeak reports whether the sector holds more than one copy of its data
+func (s *LogicalSector) IsWeak() bool {
+	return s.CopyCount() > 1
+}
+
+// Copies returns the data of each read of the sector
+func (s *LogicalSector) Copies() [][]byte {
+	count := s.CopyCount()
+	size := len(s.Data) / count
+	copies := make([][]byte, count)
+	for i := range copies {
+		copies[i] = s.Data[i*size : (i+1)*size]
+	}
+	return copies
+}
+
+// ByteRange is an inclusive range of offsets within a sector
+type ByteRange struct {
+	Start int
+	End   int
+}
+
+// String formats the range as hex offsets
+func (r ByteRange) String() string {
+	if r.Start == r.End {
+		return fmt.Sprintf("%04X", r.Start)
+	}
+	return fmt.Sprintf("%04X-%04X", r.Start, r.End)
+}
+
+// WeakRanges returns the ranges of offsets where the copies of a sector do not all agree
+func (s *LogicalSector) WeakRanges() []ByteRange {
+	copies := s.Copies()
+	ranges := make([]ByteRange, 0)
+	for offset := range copies[0] {
+		differs := false
+		for _, data := range copies[1:] {
+			differs = differs || data[offset] != copies[0][offset]
+		}
+		if !differs {
+			continue
+		}
+		if last := len(ranges) - 1; last >= 0 && ranges[last].End == offset-1 {
+			ranges[last].End = offset
+		} else {
+			ranges = append(ranges, ByteRange{offset, offset})
+		}
+	}
+	return ranges
+}
+
+// describeWeakRanges joins ranges for display
+func describeWeakRanges(ranges []ByteRange) string {
+	if len(ranges) == 0 {
+		return "none"
+	}
+	text := make([]string, len(ranges))
+	for i, r := range ranges {
+		text[i] = r.String()
+	}
+	return strings.Join(text, ", ")
+}
@@ -0,0 +1,168 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// sector_test.go - Unit tests for sector sizes and weak sector copies
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newWeakSector returns a 512 byte sector with three copies that differ at 0x100-0x103 and 0x1FF
+func newWeakSector(id uint8) LogicalSector {
+	data := bytes.Repeat([]byte{0xE5}, 3*512)
+	for k := 1; k < 3; k++ {
+		copy(data[k*512+0x100:], []byte{byte(k), byte(k), byte(k), byte(k)})
+		data[k*512+0x1FF] = byte(k)
+	}
+	return LogicalSector{
+		Info: SectorInfo{R: id, N: 2, FDCStatus1: 0x20, FDCStatus2: 0x20, DataLength: uint16(len(data))},
+		Data: data,
+	}
+}
+
+func TestSectorCopies(t *testing.T) {
+	weak := newWeakSector(0xC2)
+	if !weak.IsWeak() || weak.CopyCount() != 3 {
+		t.Fatalf("expected 3 copies, got %d", weak.CopyCount())
+	}
+	copies := weak.Copies()
+	if len(copies[0]) != 512 || copies[2][0x100] != 2 {
+		t.Errorf("expected 512 byte copies split in order")
+	}
+	if ranges := weak.WeakRanges(); !reflect.DeepEqual(ranges, []ByteRange{{0x100, 0x103}, {0x1FF, 0x1FF}}) {
+		t.Errorf("expected 0100-0103 and 01FF, got %s", describeWeakRanges(ranges))
+	}
+
+	tests := []struct {
+		name   string
+		n      uint8
+		length int
+		copies int
+	}{
+		{"normal", 2, 512, 1},
+		{"short", 2, 256, 1},
+		{"gap bytes", 2, 600, 1},
+		{"two copies", 1, 512, 2},
+		{"oversized", 6, 0x1800, 1},
+	}
+	for _, tt := range tests {
+		sector := LogicalSector{Info: SectorInfo{N: tt.n}, Data: make([]byte, tt.length)}
+		if copies := sector.CopyCount(); copies != tt.copies {
+			t.Errorf("%s: expected %d copies, got %d", tt.name, tt.copies, copies)
+		}
+	}
+}
+
+func TestWeakSectorUnpackPack(t *testing.T) {
+	dsk := newTestDSK(FormatExtended, 1, 1, 0xC1)
+	track := dsk.GetTrack(0, 0)
+	*track.GetSector(0xC2) = newWeakSector(0xC2)
+
+	dir := t.TempDir()
+	if err := dsk.Unpack("weak.dsk", dir, "hex"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	trackDir := filepath.Join(dir, "weak", "track-00")
+	for _, name := range []string{"sector-194.copy-1.hex", "sector-194.copy-3.hex"} {
+		if _, err := os.Stat(filepath.Join(trackDir, name)); err != nil {
+			t.Errorf("expected %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(trackDir, "sector-194.hex")); err == nil {
+		t.Errorf("expected no single data file for the weak sector")
+	}
+
+	packed, err := ReadUnpacked(filepath.Join(dir, "weak"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sector := packed.GetTrack(0, 0).GetSector(0xC2); !bytes.Equal(sector.Data, track.GetSector(0xC2).Data) {
+		t.Errorf("expected the copies joined back together, got %d bytes", len(sector.Data))
+	}
+
+	// Losing a copy no longer adds up to the data length
+	os.Remove(filepath.Join(trackDir, "sector-194.copy-3.hex"))
+	if _, err := ReadUnpacked(filepath.Join(dir, "weak")); err == nil {
+		t.Errorf("expected an error for a missing copy")
+	}
+}
+
+// newKindsTrack replaces a test track's sectors with one of each kind
+func newKindsTrack(track *LogicalTrack) {
+	track.Sectors = []LogicalSector{
+		{Info: SectorInfo{R: 0xC1, N: 2, FDCStatus1: 0x01, FDCStatus2: 0x01}, Data: []byte{}},
+		{Info: SectorInfo{R: 0xC2, N: 2}, Data: bytes.Repeat([]byte{0x11}, 512)},
+		{Info: SectorInfo{R: 0xC3, N: 2}, Data: bytes.Repeat([]byte{0x22}, 512+82)},
+		{Info: SectorInfo{R: 0xC4, N: 6}, Data: bytes.Repeat([]byte{0x33}, OversizedDataLimit)},
+		{Info: SectorInfo{R: 0xC5, N: 2}, Data: bytes.Repeat([]byte{0x44}, 1024)},
+		{Info: SectorInfo{R: 0xC6, N: 2}, Data: bytes.Repeat([]byte{0x55}, 100)},
+	}
+}
+
+var expectedKinds = []SectorKind{SectorIDOnly, SectorNormal, SectorGap, SectorOversized, SectorWeak, SectorShort}
+
+func TestSectorKinds(t *testing.T) {
+	dsk := newTestDSK(FormatExtended, 2, 1, 0xC1)
+	newKindsTrack(dsk.GetTrack(0, 0))
+
+	data, err := dsk.Bytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parsed, err := ParseDSKData(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The ID-only sector takes no room so the next sector's data is read from the right place
+	for i, sector := range parsed.GetTrack(0, 0).Sectors {
+		if kind := sector.Kind(); kind != expectedKinds[i] {
+			t.Errorf("sector %02X: expected %s, got %s", sector.Info.R, expectedKinds[i], kind)
+		}
+		if !bytes.Equal(sector.Data, dsk.GetTrack(0, 0).Sectors[i].Data) {
+			t.Errorf("sector %02X: data changed by the round trip", sector.Info.R)
+		}
+	}
+	if sector := parsed.GetTrack(1, 0).GetSector(0xC1); sector.Kind() != SectorNormal {
+		t.Errorf("expected the next track to be unaffected, got %s", sector.Kind())
+	}
+
+	if description := dsk.GetTrack(0, 0).Sectors[2].DescribeKind(); description != "gap (512 + 82 gap bytes)" {
+		t.Errorf("unexpected description '%s'", description)
+	}
+}
+
+func TestSectorKindsUnpackPack(t *testing.T) {
+	dsk := newTestDSK(FormatExtended, 1, 1, 0xC1)
+	newKindsTrack(dsk.GetTrack(0, 0))
+
+	dir := t.TempDir()
+	if err := dsk.Unpack("kinds.dsk", dir, "binary"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	packed, err := ReadUnpacked(filepath.Join(dir, "kinds"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, sector := range dsk.GetTrack(0, 0).Sectors {
+		result := packed.GetTrack(0, 0).GetSector(sector.Info.R)
+		if result.Kind() != expectedKinds[i] || !bytes.Equal(result.Data, sector.Data) {
+			t.Errorf("sector %02X: expected %s with %d bytes, got %s with %d", sector.Info.R,
+				expectedKinds[i], len(sector.Data), result.Kind(), len(result.Data))
+		}
+	}
+
+	// Data that no longer matches the kind in the meta is refused
+	trackDir := filepath.Join(dir, "kinds", "track-00")
+	if err := os.WriteFile(filepath.Join(trackDir, "sector-195.bin"), make([]byte, 512), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ReadUnpacked(filepath.Join(dir, "kinds")); err == nil {
+		t.Errorf("expected an error for a gap sector without its gap bytes")
+	}
+}
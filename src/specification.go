@@ -0,0 +1,233 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// specification.go - Disk specification block decoding
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import "fmt"
+
+// SpecificationLength is the number of bytes in the disk specification block
+const SpecificationLength = 16
+
+// Boot sector checksums (8-bit sum of every byte in the sector) recognised by the various ROMs
+const (
+	BootSumPlus3   = 0x03 // Spectrum +3
+	BootSumPCW9512 = 0x01 // PCW9512
+	BootSumPCW8256 = 0xFF // PCW8256/8512
+)
+
+// String returns a human-readable name for the specification format
+func (f SpecificationFormat) String() string {
+	switch f {
+	case SpecFormatPCW_SS:
+		return "PCW/+3 single-sided"
+	case SpecFormatCPC_System:
+		return "CPC system"
+	case SpecFormatCPC_Data:
+		return "CPC data"
+	case SpecFormatPCW_DS:
+		return "PCW double-sided"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(f))
+	}
+}
+
+// Key returns the short identifier used for the specification format in metadata
+func (f SpecificationFormat) Key() string {
+	switch f {
+	case SpecFormatPCW_SS:
+		return "pcw_ss"
+	case SpecFormatCPC_System:
+		return "cpc_system"
+	case SpecFormatCPC_Data:
+		return "cpc_data"
+	case SpecFormatPCW_DS:
+		return "pcw_ds"
+	default:
+		return "unknown"
+	}
+}
+
+// String returns a human-readable name for the side configuration
+func (s SpecificationSide) String() string {
+	switch s {
+	case SpecSideSingle:
+		return "single"
+	case SpecSideDoubleAlternate:
+		return "double (alternate)"
+	case SpecSideDoubleSuccessive:
+		return "double (successive)"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(s))
+	}
+}
+
+// Key returns the short identifier used for the side configuration in metadata
+func (s SpecificationSide) Key() string {
+	switch s {
+	case SpecSideSingle:
+		return "single"
+	case SpecSideDoubleAlternate:
+		return "double_alternate"
+	case SpecSideDoubleSuccessive:
+		return "double_successive"
+	default:
+		return "unknown"
+	}
+}
+
+// String returns a human-readable name for the track density
+func (t SpecificationTrack) String() string {
+	switch t {
+	case SpecTrackSingle:
+		return "single"
+	case SpecTrackDouble:
+		return "double"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(t))
+	}
+}
+
+// ParseSpecification decodes a disk specification block from the start of a boot sector
+// The whole sector should be passed so the 8-bit sector sum can be calculated
+func ParseSpecification(sector []byte) (*Specification, error) {
+	if len(sector) < SpecificationLength {
+		return nil, fmt.Errorf("sector too small for specification block: %d bytes", len(sector))
+	}
+
+	// A freshly formatted sector is filled with 0xE5 and has no specification
+	if sector[0] == 0xE5 && sector[1] == 0xE5 {
+		return nil, fmt.Errorf("no specification block (sector is unused)")
+	}
+
+	if sector[0] > byte(SpecFormatPCW_DS) {
+		return nil, fmt.Errorf("invalid specification format byte: %d", sector[0])
+	}
+	if sector[1]&0x03 > byte(SpecSideDoubleSuccessive) || sector[1]&0x7C != 0 {
+		return nil, fmt.Errorf("invalid specification side byte: 0x%02X", sector[1])
+	}
+	if sector[2] == 0 || sector[3] == 0 {
+		return nil, fmt.Errorf("invalid specification geometry: %d tracks, %d sectors", sector[2], sector[3])
+	}
+	if sector[4] > 7 {
+		return nil, fmt.Errorf("invalid specification sector size: %d", sector[4])
+	}
+
+	spec := &Specification{
+		Format:          SpecificationFormat(sector[0]),
+		Side:            SpecificationSide(sector[1] & 0x03),
+		Track:           SpecificationTrack(sector[1] >> 7),
+		TracksPerSide:   sector[2],
+		SectorsPerTrack: sector[3],
+		SectorSize:      128 << sector[4],
+		ReservedTracks:  sector[5],
+		BlockShift:      sector[6],
+		DirectoryBlocks: sector[7],
+		GapReadWrite:    sector[8],
+		GapFormat:       sector[9],
+		Checksum:        sector[15],
+		SectorSum:       SectorSum(sector),
+	}
+
+	return spec, nil
+}
+
+// SectorSum returns the 8-bit sum of all bytes in a sector
+func SectorSum(sector []byte) uint8 {
+	var sum uint8
+	for _, b := range sector {
+		sum += b
+	}
+	return sum
+}
+
+// BlockSize returns the CP/M allocation block size in bytes
+func (s *Specification) BlockSize() int {
+	return 128 << s.BlockShift
+}
+
+// BootType describes which machine (if any) would boot the sector based on its 8-bit sum
+func (s *Specification) BootType() string {
+	return bootTypeForSum(s.SectorSum)
+}
+
+func bootTypeForSum(sum uint8) string {
+	switch sum {
+	case BootSumPlus3:
+		return "Spectrum +3"
+	case BootSumPCW9512:
+		return "PCW9512"
+	case BootSumPCW8256:
+		return "PCW8256/8512"
+	default:
+		return ""
+	}
+}
+
+// bootSector returns the first (lowest ID) sector on track 0, side 0
+func (d *DSK) bootSector() *LogicalSector {
+	track := d.GetTrack(0, 0)
+	if track == nil || len(track.Sectors) == 0 {
+		return nil
+	}
+	first := &track.Sectors[0]
+	for i := range track.Sectors {
+		if track.Sectors[i].Info.R < first.Info.R {
+			first = &track.Sectors[i]
+		}
+	}
+	return first
+}
+
+// readSpecification decodes the specification block from the boot sector if one is present
+// CPC DATA (#C1) and SYSTEM (#41) disks have no specification block so nil is returned for them
+func (d *DSK) readSpecification() *Specification {
+	sector := d.bootSector()
+	if sector == nil || sector.Info.R == 0x41 || sector.Info.R == 0xC1 {
+		return nil
+	}
+
+	spec, err := ParseSpecification(sector.Data)
+	if err != nil {
+		return nil
+	}
+	return spec
+}
+
+// specificationMissingReason explains why no specification block was found
+func (d *DSK) specificationMissingReason() string {
+	sector := d.bootSector()
+	if sector == nil {
+		return "track 0 has no sectors"
+	}
+	switch sector.Info.R {
+	case 0x41:
+		return "CPC SYSTEM format (sector IDs #41)"
+	case 0xC1:
+		return "CPC DATA format (sector IDs #C1)"
+	}
+	if _, err := ParseSpecification(sector.Data); err != nil {
+		return err.Error()
+	}
+	return "unknown"
+}
+
+// specificationMeta converts the specification into a map suitable for JSON metadata
+func (s *Specification) specificationMeta() map[string]interface{} {
+	return map[string]interface{}{
+		"format":            s.Format.Key(),
+		"side":              s.Side.Key(),
+		"track":             s.Track.String(),
+		"tracks_per_side":   s.TracksPerSide,
+		"sectors_per_track": s.SectorsPerTrack,
+		"sector_size":       s.SectorSize,
+		"reserved_tracks":   s.ReservedTracks,
+		"block_shift":       s.BlockShift,
+		"block_size":        s.BlockSize(),
+		"directory_blocks":  s.DirectoryBlocks,
+		"gap_read_write":    s.GapReadWrite,
+		"gap_format":        s.GapFormat,
+		"checksum":          s.Checksum,
+		"sector_sum":        s.SectorSum,
+	}
+}
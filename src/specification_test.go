@@ -0,0 +1,71 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// specification_test.go - Unit tests for specification block decoding
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseSpecification(t *testing.T) {
+	sector := bytes.Repeat([]byte{0xE5}, 512)
+	copy(sector, []byte{0, 0, 40, 9, 2, 1, 3, 2, 0x2A, 0x52, 0, 0, 0, 0, 0, 0})
+
+	spec, err := ParseSpecification(sector)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if spec.Format != SpecFormatPCW_SS {
+		t.Errorf("Format: expected %v, got %v", SpecFormatPCW_SS, spec.Format)
+	}
+	if spec.Side != SpecSideSingle || spec.Track != SpecTrackSingle {
+		t.Errorf("Side/Track: expected single/single, got %v/%v", spec.Side, spec.Track)
+	}
+	if spec.TracksPerSide != 40 || spec.SectorsPerTrack != 9 || spec.SectorSize != 512 {
+		t.Errorf("geometry: expected 40x9x512, got %dx%dx%d", spec.TracksPerSide, spec.SectorsPerTrack, spec.SectorSize)
+	}
+	if spec.ReservedTracks != 1 || spec.BlockSize() != 1024 || spec.DirectoryBlocks != 2 {
+		t.Errorf("CP/M params: got reserved %d, block size %d, dir blocks %d", spec.ReservedTracks, spec.BlockSize(), spec.DirectoryBlocks)
+	}
+	if spec.GapReadWrite != 0x2A || spec.GapFormat != 0x52 {
+		t.Errorf("gaps: expected 2A/52, got %02X/%02X", spec.GapReadWrite, spec.GapFormat)
+	}
+	if spec.BootType() != "" {
+		t.Errorf("expected not bootable, got %q", spec.BootType())
+	}
+
+	// Adjust the checksum byte so the sector sums to 3
+	sector[15] = BootSumPlus3 - (SectorSum(sector) - sector[15])
+	spec, err = ParseSpecification(sector)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if spec.BootType() != "Spectrum +3" {
+		t.Errorf("expected Spectrum +3 bootable, got %q", spec.BootType())
+	}
+}
+
+func TestParseSpecificationMissing(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+	}{
+		{"unused sector", []byte{0xE5, 0xE5, 0xE5, 0xE5}},
+		{"invalid format", []byte{9, 0, 40, 9, 2}},
+		{"invalid side", []byte{0, 3, 40, 9, 2}},
+		{"zero tracks", []byte{0, 0, 0, 9, 2}},
+		{"invalid sector size", []byte{0, 0, 40, 9, 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sector := make([]byte, 512)
+			copy(sector, tt.header)
+			if _, err := ParseSpecification(sector); err == nil {
+				t.Errorf("expected error but got none")
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// track.go - Track data rate, recording mode and raw capacity
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Data rates in the track header (0 when the dumping tool did not record one)
+const (
+	DataRateUnknown = 0
+	DataRateDouble  = 1 // SD or DD, 250 kbps (300 kbps in a 360 RPM drive)
+	DataRateHigh    = 2 // HD, 500 kbps
+	DataRateExtra   = 3 // ED, 1 Mbps
+)
+
+// Recording modes in the track header (0 when the dumping tool did not record one)
+const (
+	RecordingModeUnknown = 0
+	RecordingModeFM      = 1
+	RecordingModeMFM     = 2
+)
+
+// DataRateNames are the names used in track meta and info for each data rate
+var DataRateNames = []string{"unknown", "SD/DD", "HD", "ED"}
+
+// RecordingModeNames are the names used in track meta and info for each recording mode
+var RecordingModeNames = []string{"unknown", "FM", "MFM"}
+
+// RPM is the speed of the 3" and 3.5" drives used by the CPC, +3 and PCW
+const RPM = 300
+
+// trackFieldName returns the name of a track header value or its number when it has none
+func trackFieldName(value uint8, names []string) string {
+	if int(value) < len(names) {
+		return names[value]
+	}
+	return strconv.Itoa(int(value))
+}
+
+// DataRateName returns the name of the track's data rate
+func (h TrackHeader) DataRateName() string {
+	return trackFieldName(h.DataRate, DataRateNames)
+}
+
+// RecordingModeName returns the name of the track's recording mode
+func (h TrackHeader) RecordingModeName() string {
+	return trackFieldName(h.RecordingMode, RecordingModeNames)
+}
+
+// ParseDataRate converts a data rate name (SD and DD are accepted for SD/DD) or number to its value
+func ParseDataRate(name string) (uint8, error) {
+	switch strings.ToUpper(name) {
+	case "SD", "DD":
+		return DataRateDouble, nil
+	}
+	return parseTrackField(name, DataRateNames, "data rate")
+}
+
+// ParseRecordingMode converts a recording mode name or number to its value
+func ParseRecordingMode(name string) (uint8, error) {
+	return parseTrackField(name, RecordingModeNames, "recording mode")
+}
+
+// parseTrackField converts a name from the list, in any case, or a number to a track header value
+func parseTrackField(name string, names []string, field string) (uint8, error) {
+	for i, known := range names {
+		if strings.EqualFold(name, known) {
+			return uint8(i), nil
+		}
+	}
+	if value, err := strconv.ParseUint(name, 10, 8); err == nil {
+		return uint8(value), nil
+	}
+	return 0, fmt.Errorf("unknown %s '%s'. Must be one of: %s", field, name, strings.Join(names, ", "))
+}
+
+// parseTrackFieldMeta reads a data rate or recording mode from track meta, which may be a name or number
+func parseTrackFieldMeta(value interface{}, parse func(string) (uint8, error)) (uint8, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		if v < 0 || v > 0xFF || v != math.Trunc(v) {
+			return 0, fmt.Errorf("expected a whole number from 0 to 255, got %v", v)
+		}
+		return uint8(v), nil
+	case string:
+		return parse(v)
+	}
+	return 0, fmt.Errorf("expected a name or number, got %v", value)
+}
+
+// BitRate returns the bits per second the track was recorded at, taking unknown as double density
+func (h TrackHeader) BitRate() int {
+	switch h.DataRate {
+	case DataRateHigh:
+		return 500000
+	case DataRateExtra:
+		return 1000000
+	}
+	return 250000
+}
+
+// RawCapacity returns how many bytes one revolution of the track holds at its data rate and
+// recording mode, 6250 for a double density MFM track. FM takes twice the bits per byte and
+// an unknown mode is taken as MFM.
+func (h TrackHeader) RawCapacity() int {
+	bytesPerRevolution := h.BitRate() * 60 / RPM / 8
+	if h.RecordingMode == RecordingModeFM {
+		return bytesPerRevolution / 2
+	}
+	return bytesPerRevolution
+}
@@ -0,0 +1,124 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// track_test.go - Unit tests for track data rate, recording mode and raw capacity
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrackFieldNames(t *testing.T) {
+	header := TrackHeader{DataRate: DataRateHigh, RecordingMode: RecordingModeFM}
+	if header.DataRateName() != "HD" || header.RecordingModeName() != "FM" {
+		t.Errorf("expected HD FM, got %s %s", header.DataRateName(), header.RecordingModeName())
+	}
+	if header := (TrackHeader{DataRate: 9}); header.DataRateName() != "9" {
+		t.Errorf("expected an unnamed value as a number, got %s", header.DataRateName())
+	}
+
+	tests := []struct {
+		name     string
+		parse    func(string) (uint8, error)
+		expected uint8
+	}{
+		{"sd/dd", ParseDataRate, DataRateDouble},
+		{"DD", ParseDataRate, DataRateDouble},
+		{"sd", ParseDataRate, DataRateDouble},
+		{"ED", ParseDataRate, DataRateExtra},
+		{"unknown", ParseDataRate, DataRateUnknown},
+		{"mfm", ParseRecordingMode, RecordingModeMFM},
+		{"7", ParseRecordingMode, 7},
+	}
+	for _, tt := range tests {
+		if value, err := tt.parse(tt.name); err != nil || value != tt.expected {
+			t.Errorf("%s: expected %d, got %d, %v", tt.name, tt.expected, value, err)
+		}
+	}
+	if _, err := ParseRecordingMode("GCR"); err == nil {
+		t.Errorf("expected an error for an unknown recording mode")
+	}
+
+	if value, err := parseTrackFieldMeta(float64(2), ParseDataRate); err != nil || value != DataRateHigh {
+		t.Errorf("expected %d, got %d, %v", DataRateHigh, value, err)
+	}
+	for _, value := range []interface{}{float64(256), float64(-1), 1.5, true} {
+		if _, err := parseTrackFieldMeta(value, ParseDataRate); err == nil {
+			t.Errorf("expected an error for %v", value)
+		}
+	}
+}
+
+func TestRawCapacity(t *testing.T) {
+	tests := []struct {
+		rate     uint8
+		mode     uint8
+		expected int
+	}{
+		{DataRateUnknown, RecordingModeUnknown, 6250},
+		{DataRateDouble, RecordingModeMFM, 6250},
+		{DataRateDouble, RecordingModeFM, 3125},
+		{DataRateHigh, RecordingModeMFM, 12500},
+		{DataRateExtra, RecordingModeMFM, 25000},
+	}
+	for _, tt := range tests {
+		header := TrackHeader{DataRate: tt.rate, RecordingMode: tt.mode}
+		if capacity := header.RawCapacity(); capacity != tt.expected {
+			t.Errorf("%s %s: expected %d, got %d", header.DataRateName(), header.RecordingModeName(), tt.expected, capacity)
+		}
+	}
+}
+
+func TestTrackFieldsUnpackPack(t *testing.T) {
+	dsk := newTestDSK(FormatExtended, 2, 1, 0xC1)
+	dsk.GetTrack(0, 0).Header.DataRate = DataRateDouble
+	dsk.GetTrack(0, 0).Header.RecordingMode = RecordingModeFM
+
+	data, _ := dsk.Bytes()
+	if parsed, err := ParseDSKData(data); err != nil || parsed.GetTrack(0, 0).Header.RecordingModeName() != "FM" {
+		t.Fatalf("expected the recording mode to be parsed, got %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := dsk.Unpack("fm.dsk", dir, "binary"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	metaPath := filepath.Join(dir, "fm", "track-00", "track.meta")
+	var meta map[string]interface{}
+	metaJSON, _ := os.ReadFile(metaPath)
+	if err := json.Unmarshal(metaJSON, &meta); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta["data_rate"] != "SD/DD" || meta["recording_mode"] != "FM" {
+		t.Errorf("expected SD/DD FM in the meta, got %v %v", meta["data_rate"], meta["recording_mode"])
+	}
+
+	// Edit the second track by name and the first back to the old unused2 form
+	secondPath := filepath.Join(dir, "fm", "track-01", "track.meta")
+	secondJSON, _ := os.ReadFile(secondPath)
+	var second map[string]interface{}
+	json.Unmarshal(secondJSON, &second)
+	second["data_rate"], second["recording_mode"] = "hd", "mfm"
+	secondJSON, _ = json.Marshal(second)
+	os.WriteFile(secondPath, secondJSON, 0644)
+
+	delete(meta, "data_rate")
+	delete(meta, "recording_mode")
+	meta["unused2"] = []int{1, 1}
+	metaJSON, _ = json.Marshal(meta)
+	os.WriteFile(metaPath, metaJSON, 0644)
+
+	packed, err := ReadUnpacked(filepath.Join(dir, "fm"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if header := packed.GetTrack(0, 0).Header; header.DataRate != DataRateDouble || header.RecordingMode != RecordingModeFM {
+		t.Errorf("expected SD/DD FM from unused2, got %s %s", header.DataRateName(), header.RecordingModeName())
+	}
+	if header := packed.GetTrack(1, 0).Header; header.DataRate != DataRateHigh || header.RecordingMode != RecordingModeMFM {
+		t.Errorf("expected HD MFM, got %s %s", header.DataRateName(), header.RecordingModeName())
+	}
+}
@@ -44,21 +44,23 @@ type SectorInfo struct {
 //   0d-0f: unused (3 bytes)
 //   10: track number (1 byte)
 //   11: side number (1 byte)
-//   12-13: unused (2 bytes)
+//   12: data rate (1 byte, 0 unknown, 1 SD/DD, 2 HD, 3 ED)
+//   13: recording mode (1 byte, 0 unknown, 1 FM, 2 MFM)
 //   14: sector size (1 byte)
 //   15: number of sectors (1 byte)
 //   16: GAP#3 length (1 byte)
 //   17: filler byte (1 byte)
 type TrackHeader struct {
-	Signature    [13]byte // "Track-Info\r\n" (13 bytes, not 12!)
-	Unused       [3]byte  // unused (3 bytes, not 4!)
-	TrackNum     uint8
-	SideNum      uint8
-	Unused2      [2]byte
-	SectorSize   uint8 // Defined sector size (N)
-	SectorCount  uint8
-	Gap3Length   uint8
-	FillerByte   uint8
+	Signature     [13]byte // "Track-Info\r\n" (13 bytes, not 12!)
+	Unused        [3]byte  // unused (3 bytes, not 4!)
+	TrackNum      uint8
+	SideNum       uint8
+	DataRate      uint8 // See DataRateNames
+	RecordingMode uint8 // See RecordingModeNames
+	SectorSize    uint8 // Defined sector size (N)
+	SectorCount   uint8
+	Gap3Length    uint8
+	FillerByte    uint8
 }
 
 // LogicalSector contains the metadata and the actual payload
@@ -147,6 +149,7 @@ type Specification struct {
 	GapReadWrite   uint8                // GAP#3 length for read/write
 	GapFormat      uint8                // GAP#3 length for format
 	Checksum       uint8                // Checksum byte
+	SectorSum      uint8                // 8-bit sum of the whole sector (3 = +3 bootable)
 }
 
 // DSK represents the parsed disk image
@@ -158,4 +161,7 @@ type DSK struct {
 	StandardTrackSize uint16
 	// Specification block (if present, typically in sector 0, track 0, side 0)
 	Specification *Specification
+	// Track offsets from the Offset-Info block (nil if there was none)
+	// When set the writer adds an Offset-Info block with the offsets the tracks are written at
+	TrackOffsets []int
 }
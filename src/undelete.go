@@ -0,0 +1,204 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// undelete.go - Recovery of erased CP/M directory entries
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DeletedFile is a file assembled from erased directory entries
+type DeletedFile struct {
+	CPMFile
+	ReusedBlocks   []int // Blocks now allocated to live files or the directory
+	MissingEntries int   // Directory entries for earlier extents that have been overwritten
+}
+
+// Recoverable reports whether the file is complete and none of its blocks have been reused
+func (f *DeletedFile) Recoverable() bool {
+	return len(f.ReusedBlocks) == 0 && f.MissingEntries == 0
+}
+
+// Status describes how much of the deleted file survives
+func (f *DeletedFile) Status() string {
+	if f.Recoverable() {
+		return "intact"
+	}
+	problems := make([]string, 0, 2)
+	if f.MissingEntries > 0 {
+		problems = append(problems, fmt.Sprintf("%d of %d entries overwritten", f.MissingEntries, f.MissingEntries+len(f.Entries)))
+	}
+	if len(f.ReusedBlocks) > 0 {
+		problems = append(problems, fmt.Sprintf("%d of %d blocks reused", len(f.ReusedBlocks), len(f.Blocks)))
+	}
+	return strings.Join(problems, ", ")
+}
+
+// isErased reports whether an unused entry still holds the name of an erased file
+// (never used entries are completely filled with 0xE5)
+func (e *DirEntry) isErased() bool {
+	if !e.IsDeleted() {
+		return false
+	}
+	for _, b := range e.Raw[1:12] {
+		if b != DeletedUser {
+			return true
+		}
+	}
+	return false
+}
+
+// DeletedFiles returns the erased files still described in the directory
+// Entries with the same name are grouped by extent; repeated extent numbers start another file
+func (fs *CPMFileSystem) DeletedFiles() ([]DeletedFile, error) {
+	entries, err := fs.ReadDirectory()
+	if err != nil {
+		return nil, err
+	}
+	used := fs.usedBlocks(entries)
+	wide := fs.Format.WideBlockPointers()
+	extentsPerEntry := fs.Format.ExtentMask() + 1
+
+	groups := make(map[string][][]DirEntry)
+	for _, entry := range entries {
+		if !entry.isErased() {
+			continue
+		}
+		name := entry.FileName()
+		placed := false
+		for i, group := range groups[name] {
+			if !hasExtent(group, entry.ExtentNumber()) {
+				groups[name][i] = append(group, entry)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			groups[name] = append(groups[name], []DirEntry{entry})
+		}
+	}
+
+	deleted := make([]DeletedFile, 0)
+	for _, nameGroups := range groups {
+		for _, extents := range nameGroups {
+			sort.SliceStable(extents, func(i, j int) bool {
+				return extents[i].ExtentNumber() < extents[j].ExtentNumber()
+			})
+			last := extents[len(extents)-1]
+			file := DeletedFile{
+				MissingEntries: last.ExtentNumber()/extentsPerEntry + 1 - len(extents),
+				CPMFile: CPMFile{
+					User:     DeletedUser,
+					Name:     extents[0].FileName(),
+					ReadOnly: extents[0].ReadOnly(),
+					System:   extents[0].System(),
+					Archive:  extents[0].Archive(),
+					Records:  last.ExtentNumber()*RecordSize + last.RecordCount(),
+				},
+			}
+			for _, extent := range extents {
+				for _, block := range extent.Blocks(wide) {
+					file.Blocks = append(file.Blocks, block)
+					if used[block] || block >= fs.Format.TotalBlocks() {
+						file.ReusedBlocks = append(file.ReusedBlocks, block)
+					}
+				}
+				file.Entries = append(file.Entries, extent.Index)
+			}
+			deleted = append(deleted, file)
+		}
+	}
+
+	sort.Slice(deleted, func(i, j int) bool {
+		if deleted[i].Name != deleted[j].Name {
+			return deleted[i].Name < deleted[j].Name
+		}
+		return deleted[i].Entries[0] < deleted[j].Entries[0]
+	})
+	return deleted, nil
+}
+
+// hasExtent reports whether a group of entries already contains the extent number
+func hasExtent(group []DirEntry, extent int) bool {
+	for _, entry := range group {
+		if entry.ExtentNumber() == extent {
+			return true
+		}
+	}
+	return false
+}
+
+// FindDeletedFile selects a deleted file by name, using the first directory entry index to
+// choose between several deleted files with the same name (entry < 0 means unspecified)
+func (fs *CPMFileSystem) FindDeletedFile(name string, entry int) (*DeletedFile, error) {
+	deleted, err := fs.DeletedFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.ToUpper(name)
+	matches := make([]DeletedFile, 0)
+	for _, file := range deleted {
+		if file.Name == name && (entry < 0 || file.Entries[0] == entry) {
+			matches = append(matches, file)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no deleted file named %s", name)
+	case 1:
+		return &matches[0], nil
+	default:
+		return nil, fmt.Errorf("%d deleted files named %s, choose one with --entry", len(matches), name)
+	}
+}
+
+// Undelete restores a deleted file into a user area
+// Files whose blocks have been reused are refused unless force is set
+func (fs *CPMFileSystem) Undelete(file *DeletedFile, user uint8, force bool) error {
+	if user > MaxUser {
+		return fmt.Errorf("invalid user %d. Must be 0-15", user)
+	}
+	if !file.Recoverable() && !force {
+		return fmt.Errorf("%s cannot be safely recovered: %s", file.Name, file.Status())
+	}
+
+	files, err := fs.Files()
+	if err != nil {
+		return err
+	}
+	for _, existing := range files {
+		if existing.User == user && existing.Name == file.Name {
+			return fmt.Errorf("%d:%s already exists", user, file.Name)
+		}
+	}
+
+	entries, err := fs.ReadDirectory()
+	if err != nil {
+		return err
+	}
+	for _, index := range file.Entries {
+		entries[index].Raw[0] = user
+	}
+
+	return fs.WriteDirectory(entries)
+}
+
+// ListDeletedFiles prints the deleted files still described in the directory
+func (fs *CPMFileSystem) ListDeletedFiles() error {
+	deleted, err := fs.DeletedFiles()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Entry Name          Records  Blocks  Status")
+	for _, file := range deleted {
+		fmt.Printf("%5d %-12s %8d %7d  %s\n", file.Entries[0], file.Name, file.Records, len(file.Blocks), file.Status())
+	}
+	fmt.Printf("%d deleted file(s)\n", len(deleted))
+	return nil
+}
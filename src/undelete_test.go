@@ -0,0 +1,105 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// undelete_test.go - Unit tests for recovering erased CP/M files
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestUndeleteIntactFile(t *testing.T) {
+	fs := newTestFileSystem(t, "KEEP.BIN")
+	data := bytes.Repeat([]byte{0x42}, 3000)
+	if err := fs.WriteFile("GAME.BIN", data, PutOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := fs.DeleteFiles("GAME.BIN", AnyUser, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deleted, err := fs.DeletedFiles()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0].Name != "GAME.BIN" || !deleted[0].Recoverable() {
+		t.Fatalf("expected intact GAME.BIN, got %+v", deleted)
+	}
+
+	file, err := fs.FindDeletedFile("game.bin", -1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := fs.Undelete(file, 2, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files, _ := fs.MatchFiles("GAME.BIN", 2)
+	if len(files) != 1 {
+		t.Fatalf("expected 2:GAME.BIN after undelete, got %v", files)
+	}
+	recovered, err := fs.ReadFile(&files[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(recovered[:len(data)], data) {
+		t.Errorf("recovered data does not match")
+	}
+}
+
+func TestUndeleteReusedBlocks(t *testing.T) {
+	fs, err := OpenCPMFileSystem(newTestDSK(FormatExtended, 40, 1, 0xC1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// NEW.BIN has been given the block OLD.BIN used to occupy
+	writeTestDirEntries(t, fs,
+		newTestDirEntry(0, "NEW", "BIN", 0, 1, 2),
+		newTestDirEntry(DeletedUser, "OLD", "BIN", 0, 1, 2),
+	)
+
+	file, err := fs.FindDeletedFile("OLD.BIN", -1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if file.Recoverable() || file.Status() != "1 of 1 blocks reused" {
+		t.Errorf("expected reused block, got %q", file.Status())
+	}
+	if err := fs.Undelete(file, 0, false); err == nil || !strings.Contains(err.Error(), "reused") {
+		t.Errorf("expected reused error, got %v", err)
+	}
+	if err := fs.Undelete(file, 1, true); err != nil {
+		t.Errorf("unexpected error with force: %v", err)
+	}
+}
+
+func TestDeletedFilesSameName(t *testing.T) {
+	fs, err := OpenCPMFileSystem(newTestDSK(FormatExtended, 40, 1, 0xC1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeTestDirEntries(t, fs,
+		newTestDirEntry(DeletedUser, "DATA", "DAT", 0, 8, 2),
+		newTestDirEntry(DeletedUser, "DATA", "DAT", 0, 8, 3),
+		newTestDirEntry(DeletedUser, "PART", "BIN", 1, 8, 4),
+	)
+
+	deleted, err := fs.DeletedFiles()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deleted) != 3 {
+		t.Fatalf("expected 3 deleted files, got %d", len(deleted))
+	}
+	if _, err := fs.FindDeletedFile("DATA.DAT", -1); err == nil || !strings.Contains(err.Error(), "--entry") {
+		t.Errorf("expected ambiguity error, got %v", err)
+	}
+	if file, err := fs.FindDeletedFile("DATA.DAT", 1); err != nil || file.Blocks[0] != 3 {
+		t.Errorf("expected entry 1 with block 3, got %v %v", file, err)
+	}
+	if deleted[2].Name != "PART.BIN" || deleted[2].MissingEntries != 1 {
+		t.Errorf("expected PART.BIN missing its first entry, got %+v", deleted[2])
+	}
+}
@@ -13,24 +13,26 @@ import (
 	"strings"
 )
 
-// Unpack extracts the DSK image to a directory structure
-// If outputDir is empty, creates a folder matching the DSK filename (minus extension) in the current directory
-// If outputDir is specified, creates the folder there
-// dataFormat can be "binary", "hex", "quoted" (quoted-printable), or "asciihex"
-func (d *DSK) Unpack(dskFilename string, outputDir string, dataFormat string) error {
+// UnpackRootDir returns the directory a DSK is unpacked to
+func UnpackRootDir(dskFilename string, outputDir string) string {
 	// Get base name without extension
 	baseName := strings.TrimSuffix(filepath.Base(dskFilename), filepath.Ext(dskFilename))
-	
-	// Determine root directory
-	var rootDir string
+
 	if outputDir != "" {
 		// Use specified output directory, creating the base name folder inside it
-		rootDir = filepath.Join(outputDir, baseName)
-	} else {
-		// Use current behavior: create folder in current directory
-		rootDir = baseName
+		return filepath.Join(outputDir, baseName)
 	}
-	
+	// Use current behavior: create folder in current directory
+	return baseName
+}
+
+// Unpack extracts the DSK image to a directory structure
+// If outputDir is empty, creates a folder matching the DSK filename (minus extension) in the current directory
+// If outputDir is specified, creates the folder there
+// dataFormat can be "binary", "hex", "quoted" (quoted-printable), or "asciihex"
+func (d *DSK) Unpack(dskFilename string, outputDir string, dataFormat string) error {
+	rootDir := UnpackRootDir(dskFilename, outputDir)
+
 	if err := os.MkdirAll(rootDir, 0755); err != nil {
 		return fmt.Errorf("failed to create root directory: %v", err)
 	}
@@ -56,6 +58,16 @@ func (d *DSK) Unpack(dskFilename string, outputDir string, dataFormat string) er
 		diskMeta["track_size_table"] = trackSizeTableSlice
 	}
 
+	// Offset-Info is informational too - pack writes the block again with the offsets it uses
+	if d.TrackOffsets != nil {
+		diskMeta["offset_info"] = d.TrackOffsets
+	}
+
+	// Specification block is informational only - pack rebuilds it from the sector data
+	if d.Specification != nil {
+		diskMeta["specification"] = d.Specification.specificationMeta()
+	}
+
 	diskMetaPath := filepath.Join(rootDir, "disk-image.meta")
 	diskMetaJSON, err := json.MarshalIndent(diskMeta, "", "  ")
 	if err != nil {
@@ -101,32 +113,32 @@ func (d *DSK) Unpack(dskFilename string, outputDir string, dataFormat string) er
 			// Convert byte arrays to slices for JSON
 			unusedSlice := make([]uint8, len(track.Header.Unused))
 			copy(unusedSlice, track.Header.Unused[:])
-			unused2Slice := make([]uint8, len(track.Header.Unused2))
-			copy(unused2Slice, track.Header.Unused2[:])
 			
 			trackMeta = map[string]interface{}{
-				"unused":       unusedSlice,
-				"track_number": track.Header.TrackNum,
-				"side_number":  track.Header.SideNum,
-				"unused2":      unused2Slice,
-				"sector_size":  track.Header.SectorSize,
-				"sector_count": track.Header.SectorCount,
-				"gap3_length":  track.Header.Gap3Length,
-				"filler_byte":  track.Header.FillerByte,
-				"formatted":    true,
+				"unused":         unusedSlice,
+				"track_number":   track.Header.TrackNum,
+				"side_number":    track.Header.SideNum,
+				"data_rate":      track.Header.DataRateName(),
+				"recording_mode": track.Header.RecordingModeName(),
+				"sector_size":    track.Header.SectorSize,
+				"sector_count":   track.Header.SectorCount,
+				"gap3_length":    track.Header.Gap3Length,
+				"filler_byte":    track.Header.FillerByte,
+				"formatted":      true,
 			}
 		} else {
 			// Unformatted track - create minimal metadata
 			trackMeta = map[string]interface{}{
-				"unused":       []uint8{0, 0, 0}, // 3 bytes per spec (not 4)
-				"track_number": uint8(trackNum),
-				"side_number":  uint8(sideNum),
-				"unused2":      []uint8{0, 0},
-				"sector_size":  uint8(0),
-				"sector_count": uint8(0),
-				"gap3_length":  uint8(0),
-				"filler_byte":  uint8(0),
-				"formatted":    false,
+				"unused":         []uint8{0, 0, 0}, // 3 bytes per spec (not 4)
+				"track_number":   uint8(trackNum),
+				"side_number":    uint8(sideNum),
+				"data_rate":      DataRateNames[DataRateUnknown],
+				"recording_mode": RecordingModeNames[RecordingModeUnknown],
+				"sector_size":    uint8(0),
+				"sector_count":   uint8(0),
+				"gap3_length":    uint8(0),
+				"filler_byte":    uint8(0),
+				"formatted":      false,
 			}
 		}
 
@@ -163,9 +175,19 @@ func (d *DSK) Unpack(dskFilename string, outputDir string, dataFormat string) er
 					ext = "bin"
 				}
 				
-				sectorDataPath := filepath.Join(trackDir, fmt.Sprintf("sector-%d.%s", sectorNum, ext))
-				if err := writer(sectorDataPath, sector.Data); err != nil {
-					return fmt.Errorf("failed to write sector data: %v", err)
+				if sector.IsWeak() {
+					// Each copy of a weak sector gets its own file
+					for k, data := range sector.Copies() {
+						sectorDataPath := filepath.Join(trackDir, fmt.Sprintf("sector-%d.copy-%d.%s", sectorNum, k+1, ext))
+						if err := writer(sectorDataPath, data); err != nil {
+							return fmt.Errorf("failed to write sector data: %v", err)
+						}
+					}
+				} else {
+					sectorDataPath := filepath.Join(trackDir, fmt.Sprintf("sector-%d.%s", sectorNum, ext))
+					if err := writer(sectorDataPath, sector.Data); err != nil {
+						return fmt.Errorf("failed to write sector data: %v", err)
+					}
 				}
 
 				// Create sector-n.meta
@@ -174,9 +196,13 @@ func (d *DSK) Unpack(dskFilename string, outputDir string, dataFormat string) er
 					"head":        sector.Info.H,
 					"sector_id":   sector.Info.R,
 					"sector_size": sector.Info.N,
-					"fdc_status1": sector.Info.FDCStatus1,
-					"fdc_status2": sector.Info.FDCStatus2,
+					"fdc_status1": FDCFlagNames(sector.Info.FDCStatus1, FDCStatus1Flags),
+					"fdc_status2": FDCFlagNames(sector.Info.FDCStatus2, FDCStatus2Flags),
 					"data_length": sector.Info.DataLength,
+					"kind":        sector.Kind(),
+				}
+				if sector.IsWeak() {
+					sectorMeta["copies"] = sector.CopyCount()
 				}
 
 				sectorMetaPath := filepath.Join(trackDir, fmt.Sprintf("sector-%d.meta", sectorNum))
@@ -0,0 +1,212 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// writer.go - DSK file serialization
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// Save writes the DSK to a file in its original format (Extended or Standard)
+func (d *DSK) Save(filename string) error {
+	data, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("failed to write DSK file: %v", err)
+	}
+
+	return nil
+}
+
+// Bytes serializes the DSK into its on-disk representation
+// Track sizes from the original header are kept wherever the track data still fits
+func (d *DSK) Bytes() ([]byte, error) {
+	if d.Header.Sides == 0 {
+		return nil, fmt.Errorf("invalid number of sides: 0")
+	}
+
+	totalBlocks := int(d.Header.Tracks) * int(d.Header.Sides)
+	if totalBlocks > len(d.Header.TrackSizeTable) {
+		return nil, fmt.Errorf("track table size %d exceeds maximum %d", totalBlocks, len(d.Header.TrackSizeTable))
+	}
+
+	// Map tracks to their position in the file (track_number * sides + side_number)
+	trackMap := make(map[int]*LogicalTrack)
+	for i := range d.Tracks {
+		posIdx := int(d.Tracks[i].Header.TrackNum)*int(d.Header.Sides) + int(d.Tracks[i].Header.SideNum)
+		if posIdx >= totalBlocks {
+			return nil, fmt.Errorf("track %d side %d is outside the disk geometry", d.Tracks[i].Header.TrackNum, d.Tracks[i].Header.SideNum)
+		}
+		if _, exists := trackMap[posIdx]; exists {
+			return nil, fmt.Errorf("duplicate track %d side %d", d.Tracks[i].Header.TrackNum, d.Tracks[i].Header.SideNum)
+		}
+		trackMap[posIdx] = &d.Tracks[i]
+	}
+
+	if d.Format == FormatStandard {
+		return d.standardBytes(trackMap, totalBlocks)
+	}
+	return d.extendedBytes(trackMap, totalBlocks)
+}
+
+// extendedBytes serializes the DSK in the Extended CPC DSK format
+func (d *DSK) extendedBytes(trackMap map[int]*LogicalTrack, totalBlocks int) ([]byte, error) {
+	header := d.Header
+	if !bytes.HasPrefix(header.SignatureString[:], []byte("EXTENDED")) {
+		header.SignatureString = [34]byte{}
+		copy(header.SignatureString[:], "EXTENDED CPC DSK File\r\nDisk-Info\r\n")
+	}
+
+	var tracks bytes.Buffer
+	offsets := make([]int, totalBlocks)
+	for i := 0; i < totalBlocks; i++ {
+		track, ok := trackMap[i]
+		if !ok {
+			// Unformatted track
+			header.TrackSizeTable[i] = 0
+			continue
+		}
+		offsets[i] = HeaderSize + tracks.Len()
+
+		trackData, err := track.extendedBytes()
+		if err != nil {
+			return nil, err
+		}
+
+		// Keep the original track size if the data still fits, padding with the filler byte
+		trackSize := int(header.TrackSizeTable[i]) * 256
+		if trackSize < len(trackData) {
+			trackSize = (len(trackData) + 0xFF) &^ 0xFF
+		}
+		if trackSize > 0xFF00 {
+			return nil, fmt.Errorf("track %d side %d too large: %d bytes", track.Header.TrackNum, track.Header.SideNum, trackSize)
+		}
+		header.TrackSizeTable[i] = uint8(trackSize / 256)
+
+		tracks.Write(trackData)
+		tracks.Write(bytes.Repeat([]byte{track.Header.FillerByte}, trackSize-len(trackData)))
+	}
+
+	var out bytes.Buffer
+	if err := binary.Write(&out, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to write header: %v", err)
+	}
+	out.Write(tracks.Bytes())
+	if d.TrackOffsets != nil {
+		out.Write(offsetInfoBytes(offsets))
+	}
+
+	return out.Bytes(), nil
+}
+
+// extendedBytes serializes a track block (Track-Info, sector info list and sector data) in Extended format
+func (t *LogicalTrack) extendedBytes() ([]byte, error) {
+	var out bytes.Buffer
+
+	header := t.Header
+	header.SectorCount = uint8(len(t.Sectors))
+	if err := binary.Write(&out, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to write track header %d: %v", header.TrackNum, err)
+	}
+
+	for _, sector := range t.Sectors {
+		info := sector.Info
+		info.DataLength = uint16(len(sector.Data))
+		if err := binary.Write(&out, binary.LittleEndian, &info); err != nil {
+			return nil, fmt.Errorf("failed to write sector info: %v", err)
+		}
+	}
+	out.Write(make([]byte, trackInfoBlockSize(len(t.Sectors))-out.Len()))
+
+	for _, sector := range t.Sectors {
+		out.Write(sector.Data)
+	}
+
+	return out.Bytes(), nil
+}
+
+// standardBytes serializes the DSK in the Standard CPC DSK format where every track has the same size
+func (d *DSK) standardBytes(trackMap map[int]*LogicalTrack, totalBlocks int) ([]byte, error) {
+	header := d.Header
+	if !bytes.HasPrefix(header.SignatureString[:], []byte("MV - CPC")) {
+		header.SignatureString = [34]byte{}
+		copy(header.SignatureString[:], "MV - CPCEMU Disk-File\r\nDisk-Info\r\n")
+	}
+	header.TrackSizeTable = [204]uint8{}
+
+	trackBlocks := make([][]byte, totalBlocks)
+	trackSize := int(d.StandardTrackSize)
+	for i := 0; i < totalBlocks; i++ {
+		track, ok := trackMap[i]
+		if !ok || len(track.Sectors) == 0 {
+			continue
+		}
+
+		trackData, err := track.standardBytes()
+		if err != nil {
+			return nil, err
+		}
+		if len(trackData) > trackSize {
+			trackSize = (len(trackData) + 0xFF) &^ 0xFF
+		}
+		trackBlocks[i] = trackData
+	}
+	if trackSize > 0xFFFF {
+		return nil, fmt.Errorf("track size too large for standard format: %d bytes", trackSize)
+	}
+
+	var out bytes.Buffer
+	if err := binary.Write(&out, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to write header: %v", err)
+	}
+	headerBytes := out.Bytes()
+	binary.LittleEndian.PutUint16(headerBytes[0x32:0x34], uint16(trackSize))
+
+	for _, trackData := range trackBlocks {
+		// Unformatted tracks are written as a blank block
+		out.Write(trackData)
+		out.Write(make([]byte, trackSize-len(trackData)))
+	}
+
+	return out.Bytes(), nil
+}
+
+// standardBytes serializes a track block in Standard format where every sector is the size given in the track header
+func (t *LogicalTrack) standardBytes() ([]byte, error) {
+	var out bytes.Buffer
+
+	header := t.Header
+	header.SectorCount = uint8(len(t.Sectors))
+	if err := binary.Write(&out, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to write track header %d: %v", header.TrackNum, err)
+	}
+
+	for _, sector := range t.Sectors {
+		info := sector.Info
+		info.DataLength = 0 // Unused in standard format
+		if err := binary.Write(&out, binary.LittleEndian, &info); err != nil {
+			return nil, fmt.Errorf("failed to write sector info: %v", err)
+		}
+	}
+	out.Write(make([]byte, trackInfoBlockSize(len(t.Sectors))-out.Len()))
+
+	secLen := 128 * (1 << header.SectorSize)
+	// For 8k sectors (N=6), only 1800h bytes is stored
+	if header.SectorSize == 6 {
+		secLen = 0x1800
+	}
+	for _, sector := range t.Sectors {
+		data := make([]byte, secLen)
+		copy(data, sector.Data)
+		out.Write(data)
+	}
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,104 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// writer_test.go - Unit tests for DSK serialization
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// newTestDSK builds a blank 9 sector, 512 byte per sector disk with sector IDs starting at firstID
+func newTestDSK(format DSKFormat, tracks int, sides int, firstID uint8) *DSK {
+	dsk := &DSK{Format: format}
+	copy(dsk.Header.CreatorString[:], "magneato test")
+	dsk.Header.Tracks = uint8(tracks)
+	dsk.Header.Sides = uint8(sides)
+	dsk.StandardTrackSize = 0x1300
+
+	for t := 0; t < tracks; t++ {
+		for s := 0; s < sides; s++ {
+			track := LogicalTrack{
+				Header: TrackHeader{
+					TrackNum:    uint8(t),
+					SideNum:     uint8(s),
+					SectorSize:  2,
+					SectorCount: 9,
+					Gap3Length:  0x4E,
+					FillerByte:  0xE5,
+				},
+			}
+			copy(track.Header.Signature[:], "Track-Info\r\n")
+			// Interleaved the same way AMSDOS formats
+			for _, offset := range []uint8{0, 5, 1, 6, 2, 7, 3, 8, 4} {
+				track.Sectors = append(track.Sectors, LogicalSector{
+					Info: SectorInfo{C: uint8(t), H: uint8(s), R: firstID + offset, N: 2, DataLength: 512},
+					Data: bytes.Repeat([]byte{0xE5}, 512),
+				})
+			}
+			dsk.Tracks = append(dsk.Tracks, track)
+			dsk.Header.TrackSizeTable[t*sides+s] = 0x13
+		}
+	}
+
+	return dsk
+}
+
+func TestDSKBytesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		format DSKFormat
+		sides  int
+	}{
+		{"extended single-sided", FormatExtended, 1},
+		{"extended double-sided", FormatExtended, 2},
+		{"standard single-sided", FormatStandard, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := newTestDSK(tt.format, 3, tt.sides, 0xC1)
+			original.Tracks[1].Sectors[2].Data[0] = 0x42
+
+			data, err := original.Bytes()
+			if err != nil {
+				t.Fatalf("unexpected error serializing: %v", err)
+			}
+			if expected := HeaderSize + 3*tt.sides*0x1300; len(data) != expected {
+				t.Errorf("size: expected %d, got %d", expected, len(data))
+			}
+
+			parsed, err := ParseDSKData(data)
+			if err != nil {
+				t.Fatalf("unexpected error parsing: %v", err)
+			}
+			if parsed.Format != tt.format {
+				t.Errorf("Format: expected %v, got %v", tt.format, parsed.Format)
+			}
+			if len(parsed.Tracks) != len(original.Tracks) {
+				t.Fatalf("Tracks: expected %d, got %d", len(original.Tracks), len(parsed.Tracks))
+			}
+			for i, track := range parsed.Tracks {
+				for j, sector := range track.Sectors {
+					want := original.Tracks[i].Sectors[j]
+					if sector.Info.R != want.Info.R {
+						t.Errorf("track %d sector %d: expected ID %02X, got %02X", i, j, want.Info.R, sector.Info.R)
+					}
+					if !bytes.Equal(sector.Data, want.Data) {
+						t.Errorf("track %d sector %02X: data mismatch", i, sector.Info.R)
+					}
+				}
+			}
+
+			// A second pass must produce identical output
+			again, err := parsed.Bytes()
+			if err != nil {
+				t.Fatalf("unexpected error re-serializing: %v", err)
+			}
+			if !bytes.Equal(data, again) {
+				t.Errorf("re-serialized image differs from original")
+			}
+		})
+	}
+}
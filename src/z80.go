@@ -0,0 +1,372 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// z80.go - Z80 instruction decoder including the undocumented instructions
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Z80Instruction is a single decoded Z80 instruction
+type Z80Instruction struct {
+	Address  uint16
+	Bytes    []byte
+	Mnemonic string
+	Operands string // An address operand is written as @ so it can be shown as a label
+	Target   int    // The address operand, -1 if there is none
+	Branch   bool   // The target is a jump or call destination
+}
+
+// Register and operation names indexed by the fields of the opcode
+// (see "Decoding Z80 Opcodes" - the x, y, z, p and q fields of each opcode byte)
+var (
+	z80Registers     = []string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+	z80RegisterPairs = []string{"BC", "DE", "HL", "SP"}
+	z80StackPairs    = []string{"BC", "DE", "HL", "AF"}
+	z80Conditions    = []string{"NZ", "Z", "NC", "C", "PO", "PE", "P", "M"}
+	z80ALU           = []string{"ADD A,", "ADC A,", "SUB ", "SBC A,", "AND ", "XOR ", "OR ", "CP "}
+	z80Rotations     = []string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SLL", "SRL"}
+	z80Accumulator   = []string{"RLCA", "RRCA", "RLA", "RRA", "DAA", "CPL", "SCF", "CCF"}
+	z80InterruptMode = []string{"0", "0/1", "1", "2", "0", "0/1", "1", "2"}
+	z80EDSpecial     = []string{"LD I,A", "LD R,A", "LD A,I", "LD A,R", "RRD", "RLD", "NOP", "NOP"}
+	z80Blocks        = [][]string{
+		{"LDI", "CPI", "INI", "OUTI"},
+		{"LDD", "CPD", "IND", "OUTD"},
+		{"LDIR", "CPIR", "INIR", "OTIR"},
+		{"LDDR", "CPDR", "INDR", "OTDR"},
+	}
+)
+
+// z80Decoder holds the state of the instruction being decoded
+type z80Decoder struct {
+	code         []byte
+	pos          int
+	truncated    bool
+	index        string // IX or IY after a DD or FD prefix
+	indexUsed    bool   // Whether the prefix changed the instruction
+	memory       bool   // The instruction uses (HL) so H and L are not replaced by the index halves
+	displacement string // +#nn or -#nn for (IX+d) and (IY+d)
+}
+
+// next returns the next byte of the instruction
+func (d *z80Decoder) next() byte {
+	if d.pos >= len(d.code) {
+		d.truncated = true
+		return 0
+	}
+	b := d.code[d.pos]
+	d.pos++
+	return b
+}
+
+// readDisplacement reads the signed index displacement
+func (d *z80Decoder) readDisplacement() {
+	offset := int8(d.next())
+	if offset < 0 {
+		d.displacement = fmt.Sprintf("-#%02X", -int(offset))
+	} else {
+		d.displacement = fmt.Sprintf("+#%02X", offset)
+	}
+}
+
+// register returns the name of an 8-bit register operand, applying any index prefix
+func (d *z80Decoder) register(i int) string {
+	if d.index != "" {
+		switch {
+		case i == 6:
+			d.indexUsed = true
+			return "(" + d.index + d.displacement + ")"
+		case (i == 4 || i == 5) && !d.memory:
+			d.indexUsed = true
+			return d.index + z80Registers[i]
+		}
+	}
+	return z80Registers[i]
+}
+
+// pair returns the name of a register pair, applying any index prefix to HL
+func (d *z80Decoder) pair(names []string, i int) string {
+	if i == 2 && d.index != "" {
+		d.indexUsed = true
+		return d.index
+	}
+	return names[i]
+}
+
+// hl returns HL or the index register replacing it
+func (d *z80Decoder) hl() string {
+	return d.pair(z80RegisterPairs, 2)
+}
+
+// DecodeZ80 decodes the instruction at the start of code, which is at address
+// Prefixes that have no effect, invalid ED instructions and instructions cut short by the end
+// of code are returned as DEFB
+func DecodeZ80(code []byte, address uint16) Z80Instruction {
+	d := &z80Decoder{code: code}
+	instruction := Z80Instruction{Address: address, Target: -1}
+
+	opcode := d.next()
+	if opcode == 0xDD || opcode == 0xFD {
+		d.index = map[byte]string{0xDD: "IX", 0xFD: "IY"}[opcode]
+		opcode = d.next()
+		if opcode == 0xDD || opcode == 0xFD || opcode == 0xED {
+			return defbInstruction(code[:1], address)
+		}
+	}
+
+	var text string
+	switch opcode {
+	case 0xCB:
+		text = d.decodeCB()
+	case 0xED:
+		text = d.decodeED(&instruction)
+	default:
+		text = d.decodeMain(opcode, &instruction)
+	}
+
+	switch {
+	case d.truncated:
+		return defbInstruction(code, address)
+	case d.index != "" && !d.indexUsed:
+		// The prefix does nothing, the next instruction runs as normal
+		return defbInstruction(code[:1], address)
+	case text == "":
+		return defbInstruction(code[:d.pos], address)
+	}
+
+	instruction.Bytes = code[:d.pos]
+	instruction.Mnemonic, instruction.Operands, _ = strings.Cut(text, " ")
+	return instruction
+}
+
+// defbInstruction returns bytes that are not an instruction as DEFB
+func defbInstruction(data []byte, address uint16) Z80Instruction {
+	values := make([]string, len(data))
+	for i, b := range data {
+		values[i] = fmt.Sprintf("#%02X", b)
+	}
+	return Z80Instruction{Address: address, Bytes: data, Mnemonic: "DEFB", Operands: strings.Join(values, ","), Target: -1}
+}
+
+// word reads a 16-bit operand as the instruction's target
+func (d *z80Decoder) word(instruction *Z80Instruction) string {
+	low := d.next()
+	instruction.Target = int(d.next())<<8 | int(low)
+	return "@"
+}
+
+// relative reads a relative jump as the instruction's target
+func (d *z80Decoder) relative(instruction *Z80Instruction) string {
+	offset := int8(d.next())
+	instruction.Target = (int(instruction.Address) + d.pos + int(offset)) & 0xFFFF
+	instruction.Branch = true
+	return "@"
+}
+
+// decodeMain decodes an unprefixed (or DD/FD prefixed) instruction
+func (d *z80Decoder) decodeMain(opcode byte, instruction *Z80Instruction) string {
+	x, y, z := int(opcode>>6), int(opcode>>3&7), int(opcode&7)
+	p, q := y>>1, y&1
+
+	// The displacement comes before any immediate operand
+	d.memory = x == 0 && z >= 4 && z <= 6 && y == 6 || x == 1 && (y == 6) != (z == 6) || x == 2 && z == 6
+	if d.memory && d.index != "" {
+		d.readDisplacement()
+	}
+
+	switch x {
+	case 0:
+		switch z {
+		case 0:
+			switch y {
+			case 0:
+				return "NOP"
+			case 1:
+				return "EX AF,AF'"
+			case 2:
+				return "DJNZ " + d.relative(instruction)
+			case 3:
+				return "JR " + d.relative(instruction)
+			default:
+				return "JR " + z80Conditions[y-4] + "," + d.relative(instruction)
+			}
+		case 1:
+			if q == 0 {
+				return "LD " + d.pair(z80RegisterPairs, p) + "," + d.word(instruction)
+			}
+			return "ADD " + d.hl() + "," + d.pair(z80RegisterPairs, p)
+		case 2:
+			switch y {
+			case 0:
+				return "LD (BC),A"
+			case 1:
+				return "LD A,(BC)"
+			case 2:
+				return "LD (DE),A"
+			case 3:
+				return "LD A,(DE)"
+			case 4:
+				return "LD (" + d.word(instruction) + ")," + d.hl()
+			case 5:
+				return "LD " + d.hl() + ",(" + d.word(instruction) + ")"
+			case 6:
+				return "LD (" + d.word(instruction) + "),A"
+			default:
+				return "LD A,(" + d.word(instruction) + ")"
+			}
+		case 3:
+			return []string{"INC ", "DEC "}[q] + d.pair(z80RegisterPairs, p)
+		case 4:
+			return "INC " + d.register(y)
+		case 5:
+			return "DEC " + d.register(y)
+		case 6:
+			target := d.register(y)
+			return fmt.Sprintf("LD %s,#%02X", target, d.next())
+		default:
+			return z80Accumulator[y]
+		}
+
+	case 1:
+		if y == 6 && z == 6 {
+			return "HALT"
+		}
+		return "LD " + d.register(y) + "," + d.register(z)
+
+	case 2:
+		return z80ALU[y] + d.register(z)
+
+	default:
+		switch z {
+		case 0:
+			return "RET " + z80Conditions[y]
+		case 1:
+			if q == 0 {
+				return "POP " + d.pair(z80StackPairs, p)
+			}
+			switch p {
+			case 0:
+				return "RET"
+			case 1:
+				return "EXX"
+			case 2:
+				return "JP (" + d.hl() + ")"
+			default:
+				return "LD SP," + d.hl()
+			}
+		case 2:
+			instruction.Branch = true
+			return "JP " + z80Conditions[y] + "," + d.word(instruction)
+		case 3:
+			switch y {
+			case 0:
+				instruction.Branch = true
+				return "JP " + d.word(instruction)
+			case 2:
+				return fmt.Sprintf("OUT (#%02X),A", d.next())
+			case 3:
+				return fmt.Sprintf("IN A,(#%02X)", d.next())
+			case 4:
+				return "EX (SP)," + d.hl()
+			case 5:
+				return "EX DE,HL"
+			case 6:
+				return "DI"
+			default:
+				return "EI"
+			}
+		case 4:
+			instruction.Branch = true
+			return "CALL " + z80Conditions[y] + "," + d.word(instruction)
+		case 5:
+			if q == 0 {
+				return "PUSH " + d.pair(z80StackPairs, p)
+			}
+			instruction.Branch = true
+			return "CALL " + d.word(instruction)
+		case 6:
+			return fmt.Sprintf("%s#%02X", z80ALU[y], d.next())
+		default:
+			instruction.Target = y * 8
+			instruction.Branch = true
+			return fmt.Sprintf("RST #%02X", y*8)
+		}
+	}
+}
+
+// decodeCB decodes a CB prefixed instruction, which with DD/FD has the displacement before the opcode
+// and (undocumented) also copies the result to a register when z is not 6
+func (d *z80Decoder) decodeCB() string {
+	if d.index != "" {
+		d.readDisplacement()
+		d.memory = true
+	}
+	opcode := d.next()
+	x, y, z := int(opcode>>6), int(opcode>>3&7), int(opcode&7)
+
+	operand := z80Registers[z]
+	if d.index != "" {
+		operand = d.register(6)
+		if z != 6 && x != 1 {
+			operand += "," + z80Registers[z]
+		}
+	}
+
+	switch x {
+	case 0:
+		return z80Rotations[y] + " " + operand
+	case 1:
+		return fmt.Sprintf("BIT %d,%s", y, operand)
+	case 2:
+		return fmt.Sprintf("RES %d,%s", y, operand)
+	default:
+		return fmt.Sprintf("SET %d,%s", y, operand)
+	}
+}
+
+// decodeED decodes an ED prefixed instruction, returning "" for the invalid ones
+func (d *z80Decoder) decodeED(instruction *Z80Instruction) string {
+	opcode := d.next()
+	x, y, z := int(opcode>>6), int(opcode>>3&7), int(opcode&7)
+	p, q := y>>1, y&1
+
+	if x == 2 && z <= 3 && y >= 4 {
+		return z80Blocks[y-4][z]
+	}
+	if x != 1 {
+		return ""
+	}
+
+	switch z {
+	case 0:
+		if y == 6 {
+			return "IN (C)"
+		}
+		return "IN " + z80Registers[y] + ",(C)"
+	case 1:
+		if y == 6 {
+			return "OUT (C),0"
+		}
+		return "OUT (C)," + z80Registers[y]
+	case 2:
+		return []string{"SBC HL,", "ADC HL,"}[q] + z80RegisterPairs[p]
+	case 3:
+		if q == 0 {
+			return "LD (" + d.word(instruction) + ")," + z80RegisterPairs[p]
+		}
+		return "LD " + z80RegisterPairs[p] + ",(" + d.word(instruction) + ")"
+	case 4:
+		return "NEG"
+	case 5:
+		if y == 1 {
+			return "RETI"
+		}
+		return "RETN"
+	case 6:
+		return "IM " + z80InterruptMode[y]
+	default:
+		return z80EDSpecial[y]
+	}
+}
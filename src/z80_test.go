@@ -0,0 +1,106 @@
+// Magneato by damieng - https://github.com/damieng/magneato
+// z80_test.go - Unit tests for the Z80 instruction decoder
+// Dual-licensed under MIT and Apache 2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// z80Text returns an instruction as text with its target address in hex
+func z80Text(instruction Z80Instruction) string {
+	text := strings.TrimSpace(instruction.Mnemonic + " " + instruction.Operands)
+	return strings.Replace(text, "@", fmt.Sprintf("#%04X", instruction.Target), 1)
+}
+
+func TestDecodeZ80(t *testing.T) {
+	tests := []struct {
+		code     []byte
+		expected string
+		length   int
+	}{
+		{[]byte{0x00}, "NOP", 1},
+		{[]byte{0x08}, "EX AF,AF'", 1},
+		{[]byte{0x10, 0xFE}, "DJNZ #8000", 2},
+		{[]byte{0x20, 0x10}, "JR NZ,#8012", 2},
+		{[]byte{0x21, 0x34, 0x12}, "LD HL,#1234", 3},
+		{[]byte{0x22, 0x00, 0xC0}, "LD (#C000),HL", 3},
+		{[]byte{0x36, 0x55}, "LD (HL),#55", 2},
+		{[]byte{0x3A, 0x00, 0x40}, "LD A,(#4000)", 3},
+		{[]byte{0x76}, "HALT", 1},
+		{[]byte{0x7E}, "LD A,(HL)", 1},
+		{[]byte{0x96}, "SUB (HL)", 1},
+		{[]byte{0xC2, 0x00, 0x80}, "JP NZ,#8000", 3},
+		{[]byte{0xCD, 0x5A, 0xBB}, "CALL #BB5A", 3},
+		{[]byte{0xD3, 0xFE}, "OUT (#FE),A", 2},
+		{[]byte{0xE9}, "JP (HL)", 1},
+		{[]byte{0xEE, 0x0F}, "XOR #0F", 2},
+		{[]byte{0xF5}, "PUSH AF", 1},
+		{[]byte{0xFF}, "RST #38", 1},
+
+		// CB prefix, including the undocumented SLL
+		{[]byte{0xCB, 0x07}, "RLC A", 2},
+		{[]byte{0xCB, 0x36}, "SLL (HL)", 2},
+		{[]byte{0xCB, 0x7E}, "BIT 7,(HL)", 2},
+		{[]byte{0xCB, 0xC1}, "SET 0,C", 2},
+
+		// ED prefix, including the undocumented forms
+		{[]byte{0xED, 0xB0}, "LDIR", 2},
+		{[]byte{0xED, 0x4B, 0x00, 0x90}, "LD BC,(#9000)", 4},
+		{[]byte{0xED, 0x70}, "IN (C)", 2},
+		{[]byte{0xED, 0x71}, "OUT (C),0", 2},
+		{[]byte{0xED, 0x4C}, "NEG", 2},
+		{[]byte{0xED, 0x5E}, "IM 2", 2},
+		{[]byte{0xED, 0x4D}, "RETI", 2},
+		{[]byte{0xED, 0x57}, "LD A,I", 2},
+		{[]byte{0xED, 0x00}, "DEFB #ED,#00", 2},
+
+		// DD and FD prefixes, including the undocumented index register halves
+		{[]byte{0xDD, 0x21, 0x00, 0x50}, "LD IX,#5000", 4},
+		{[]byte{0xDD, 0x7E, 0x05}, "LD A,(IX+#05)", 3},
+		{[]byte{0xFD, 0x36, 0xFE, 0x10}, "LD (IY-#02),#10", 4},
+		{[]byte{0xDD, 0x66, 0x01}, "LD H,(IX+#01)", 3},
+		{[]byte{0xDD, 0x6C}, "LD IXL,IXH", 2},
+		{[]byte{0xFD, 0x84}, "ADD A,IYH", 2},
+		{[]byte{0xDD, 0x29}, "ADD IX,IX", 2},
+		{[]byte{0xFD, 0xE9}, "JP (IY)", 2},
+		{[]byte{0xDD, 0xE3}, "EX (SP),IX", 2},
+		{[]byte{0xDD, 0xCB, 0x03, 0x46}, "BIT 0,(IX+#03)", 4},
+		{[]byte{0xFD, 0xCB, 0x80, 0xFE}, "SET 7,(IY-#80)", 4},
+		{[]byte{0xDD, 0xCB, 0x02, 0x00}, "RLC (IX+#02),B", 4},
+		{[]byte{0xFD, 0xCB, 0x02, 0x87}, "RES 0,(IY+#02),A", 4},
+
+		// Prefixes that do nothing and instructions cut short
+		{[]byte{0xDD, 0x00}, "DEFB #DD", 1},
+		{[]byte{0xDD, 0xFD, 0x21}, "DEFB #DD", 1},
+		{[]byte{0xDD, 0xEB}, "DEFB #DD", 1},
+		{[]byte{0xCD, 0x00}, "DEFB #CD,#00", 2},
+		{[]byte{0xDD}, "DEFB #DD", 1},
+	}
+
+	for _, tt := range tests {
+		instruction := DecodeZ80(tt.code, 0x8000)
+		if text := z80Text(instruction); text != tt.expected {
+			t.Errorf("% X: expected '%s', got '%s'", tt.code, tt.expected, text)
+		}
+		if len(instruction.Bytes) != tt.length {
+			t.Errorf("% X: expected length %d, got %d", tt.code, tt.length, len(instruction.Bytes))
+		}
+	}
+}
+
+func TestDecodeZ80AllOpcodes(t *testing.T) {
+	// Every opcode with every prefix decodes to something without running past the code
+	for _, prefix := range [][]byte{{}, {0xCB}, {0xED}, {0xDD}, {0xFD}, {0xDD, 0xCB, 0x00}, {0xFD, 0xCB, 0x00}} {
+		for opcode := 0; opcode < 0x100; opcode++ {
+			code := append(append([]byte{}, prefix...), byte(opcode), 0x34, 0x12, 0x00)
+			instruction := DecodeZ80(code, 0)
+			if instruction.Mnemonic == "" || len(instruction.Bytes) == 0 || len(instruction.Bytes) > 4 {
+				t.Errorf("% X: decoded as '%s' with %d bytes", code[:len(prefix)+1], z80Text(instruction), len(instruction.Bytes))
+			}
+		}
+	}
+}